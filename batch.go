@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"gopkg.in/yaml.v2"
+)
+
+// batchFile describes a set of export jobs run in one invocation.  Each job is a map of parameter values, keyed as on
+// the command line, applied over the batch file's defaults.
+type batchFile struct {
+	Defaults map[string]interface{}   `yaml:"defaults"`
+	Jobs     []map[string]interface{} `yaml:"jobs"`
+}
+
+// batchJob is one export from a batch file
+type batchJob struct {
+	Name     string
+	Settings map[string]string
+}
+
+// loadBatchFile reads a batch file and resolves the settings of each job.  The settings are checked against the
+// parameters registered on a scratch flag set, so that a typo is reported before any job has run.
+func loadBatchFile(path string) ([]batchJob, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch file: %w", err)
+	}
+
+	var batch batchFile
+	if err := yaml.UnmarshalStrict(data, &batch); err != nil {
+		return nil, fmt.Errorf("failed to parse batch file %s: %w", path, err)
+	}
+	if len(batch.Jobs) == 0 {
+		return nil, fmt.Errorf("batch file %s has no jobs", path)
+	}
+
+	var scratch cliOptions
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	registerFlags(fs, &scratch)
+
+	defaults := make(map[string]string)
+	if err := parseSettings(fs, path, batch.Defaults, defaults); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]batchJob, 0, len(batch.Jobs))
+	seen := make(map[string]bool)
+	for i, values := range batch.Jobs {
+		name := fmt.Sprintf("job %d", i+1)
+		if value, found := values["name"]; found {
+			name = fmt.Sprint(value)
+			delete(values, "name")
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("batch file %s: more than one job is named '%s'", path, name)
+		}
+		seen[name] = true
+
+		settings := make(map[string]string, len(defaults))
+		for key, value := range defaults {
+			settings[key] = value
+		}
+		if err := parseSettings(fs, path+" ("+name+")", values, settings); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, batchJob{Name: name, Settings: settings})
+	}
+
+	// As for a config file, tokens shouldn't be readable by other users
+	for _, job := range jobs {
+		if _, hasToken := job.Settings["token"]; hasToken {
+			if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+				mmuserlist.LogMessage(mmuserlist.WarningLevel, fmt.Sprintf("The batch file %s holds a token but can be read by other users - restrict it with 'chmod 600'", path))
+			}
+			break
+		}
+	}
+
+	return jobs, nil
+}
+
+// runBatchJob runs a single job from a batch file as if its settings had been given on the command line, returning its
+// exit code.  A panic is caught and reported as a failure of the job, so that it can't stop the rest of the batch.
+func runBatchJob(job batchJob, configFile string) (exitCode int) {
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("Batch job '%s' aborted: %v", job.Name, recovered))
+			exitCode = 2
+		}
+	}()
+
+	var opts cliOptions
+	fs := flag.NewFlagSet(job.Name, flag.ContinueOnError)
+	registerFlags(fs, &opts)
+
+	// Validation errors are logged, and the usage text would only clutter the batch log
+	fs.Usage = func() {}
+
+	if configFile != "" {
+		if err := fs.Set("config", configFile); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+			return 1
+		}
+	}
+	for name, value := range job.Settings {
+		if err := fs.Set(name, value); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("Batch job '%s': invalid value '%s' for '%s': %s", job.Name, value, name, err.Error()))
+			return 1
+		}
+	}
+
+	return runExport(fs, &opts)
+}
+
+// runBatchCommand implements the 'batch' subcommand, which runs every job in a batch file in turn, and returns the
+// process exit code.  A failed job is logged and the remaining jobs still run.
+func runBatchCommand(args []string) int {
+
+	var configFile string
+
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", "", "A YAML config file whose settings (and server profiles) are shared by every job")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Usage: mm-user-list batch [-config <file>] <batch file>")
+		return 1
+	}
+
+	jobs, err := loadBatchFile(fs.Arg(0))
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+
+	started := time.Now()
+	var failed []string
+	for i, job := range jobs {
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Batch job %d of %d: %s", i+1, len(jobs), job.Name))
+		if exitCode := runBatchJob(job, configFile); exitCode != 0 {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("Batch job '%s' failed with exit code %d", job.Name, exitCode))
+			failed = append(failed, job.Name)
+		}
+	}
+
+	mmuserlist.LogSummary(fmt.Sprintf("Batch complete - %d of %d jobs succeeded in %s", len(jobs)-len(failed), len(jobs), time.Since(started).Round(time.Second)))
+	if len(failed) > 0 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed jobs: "+strings.Join(failed, ", "))
+		return 2
+	}
+
+	return 0
+}
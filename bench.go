@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	defaultBenchPageSizes   = "60,100,200"
+	defaultBenchConcurrency = "1,2,4"
+	defaultBenchPages       = 20
+	maxServerPageSize       = 200
+)
+
+// benchResult holds the measurements for one combination of page size and concurrency
+type benchResult struct {
+	PageSize    int
+	Concurrency int
+	Requests    int
+	Errors      int
+	EmptyPages  int
+	Users       int
+	Elapsed     time.Duration
+	Latencies   []time.Duration
+}
+
+// PagesPerSecond returns the overall request throughput achieved
+func (r *benchResult) PagesPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// UsersPerSecond returns the overall user retrieval rate achieved
+func (r *benchResult) UsersPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Users) / r.Elapsed.Seconds()
+}
+
+// Percentile returns the given percentile of the request latencies, in milliseconds
+func (r *benchResult) Percentile(p float64) float64 {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(r.Latencies))
+	copy(sorted, r.Latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return float64(sorted[index].Microseconds()) / 1000
+}
+
+// parseIntList parses a comma-separated list of positive integers
+func parseIntList(value string, max int) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || (max > 0 && n > max) {
+			return nil, fmt.Errorf("invalid value '%s'", field)
+		}
+		values = append(values, n)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no values supplied")
+	}
+	return values, nil
+}
+
+// RunBenchmark fetches the requested number of user pages with the given page size and number of parallel workers,
+// timing each request.  If a team ID is supplied, the team membership endpoint is measured instead of the full user
+// list, since that is what a team export uses.
+func RunBenchmark(mmClient *model.Client4, teamID string, perPage int, concurrency int, pages int) *benchResult {
+
+	mmuserlist.DebugPrint(fmt.Sprintf("Benchmarking page size %d with concurrency %d", perPage, concurrency))
+
+	ctx := context.Background()
+	result := &benchResult{PageSize: perPage, Concurrency: concurrency}
+
+	jobs := make(chan int)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range jobs {
+				var users []*model.User
+				var err error
+
+				requestStart := time.Now()
+				if teamID != "" {
+					users, _, err = mmClient.GetUsersInTeam(ctx, teamID, page, perPage, "")
+				} else {
+					users, _, err = mmClient.GetUsers(ctx, page, perPage, "")
+				}
+				latency := time.Since(requestStart)
+
+				mutex.Lock()
+				result.Requests++
+				result.Latencies = append(result.Latencies, latency)
+				if err != nil {
+					mmuserlist.DebugPrint(fmt.Sprintf("Request for page %d failed: %s", page, err.Error()))
+					result.Errors++
+				} else {
+					result.Users += len(users)
+					if len(users) == 0 {
+						result.EmptyPages++
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for page := 0; page < pages; page++ {
+		jobs <- page
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.Elapsed = time.Since(start)
+
+	return result
+}
+
+// WriteBenchmarkReport writes the benchmark results to a CSV file
+func WriteBenchmarkReport(results []*benchResult, filePath string) error {
+
+	mmuserlist.DebugPrint("Writing benchmark report to: " + filePath)
+
+	file, err := mmuserlist.CreateOutput(filePath, false)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create file: "+filePath+" - "+err.Error())
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{
+		"Page Size", "Concurrency", "Requests", "Errors", "Empty Pages", "Users", "Elapsed Seconds",
+		"Pages Per Second", "Users Per Second", "P50 ms", "P90 ms", "P99 ms", "Max ms",
+	})
+	for _, result := range results {
+		writer.Write([]string{
+			strconv.Itoa(result.PageSize),
+			strconv.Itoa(result.Concurrency),
+			strconv.Itoa(result.Requests),
+			strconv.Itoa(result.Errors),
+			strconv.Itoa(result.EmptyPages),
+			strconv.Itoa(result.Users),
+			fmt.Sprintf("%.3f", result.Elapsed.Seconds()),
+			fmt.Sprintf("%.2f", result.PagesPerSecond()),
+			fmt.Sprintf("%.2f", result.UsersPerSecond()),
+			fmt.Sprintf("%.1f", result.Percentile(50)),
+			fmt.Sprintf("%.1f", result.Percentile(90)),
+			fmt.Sprintf("%.1f", result.Percentile(99)),
+			fmt.Sprintf("%.1f", result.Percentile(100)),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+// printBenchmarkResults writes a human-readable summary of the results to stdout
+func printBenchmarkResults(results []*benchResult) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "PAGE SIZE\tCONCURRENCY\tREQUESTS\tERRORS\tPAGES/SEC\tUSERS/SEC\tP50 MS\tP90 MS\tP99 MS\t")
+	for _, result := range results {
+		fmt.Fprintf(writer, "%d\t%d\t%d\t%d\t%.2f\t%.2f\t%.1f\t%.1f\t%.1f\t\n",
+			result.PageSize,
+			result.Concurrency,
+			result.Requests,
+			result.Errors,
+			result.PagesPerSecond(),
+			result.UsersPerSecond(),
+			result.Percentile(50),
+			result.Percentile(90),
+			result.Percentile(99))
+	}
+	writer.Flush()
+}
+
+// runBenchCommand implements the 'bench' subcommand and returns the process exit code
+func runBenchCommand(args []string) int {
+
+	var opts cliOptions
+	var pageSizesFlag string
+	var concurrencyFlag string
+	var pages int
+	var reportFile string
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	registerFlags(fs, &opts)
+	fs.StringVar(&pageSizesFlag, "page-sizes", defaultBenchPageSizes, "Comma-separated list of page sizes to measure (maximum "+strconv.Itoa(maxServerPageSize)+")")
+	fs.StringVar(&concurrencyFlag, "concurrency-levels", defaultBenchConcurrency, "Comma-separated list of parallel request counts to measure")
+	fs.IntVar(&pages, "pages", defaultBenchPages, "The number of pages to fetch for each combination")
+	fs.StringVar(&reportFile, "report", "", "Optional CSV file to which the benchmark results should be written")
+	fs.Parse(args)
+
+	if _, err := resolveConfig(fs); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	if err := applyLoggingOptions(&opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+
+	cliErrors := !opts.validateConnection()
+	pageSizes, err := parseIntList(pageSizesFlag, maxServerPageSize)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Invalid page sizes: "+err.Error())
+		cliErrors = true
+	}
+	concurrencyLevels, err := parseIntList(concurrencyFlag, 0)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Invalid concurrency levels: "+err.Error())
+		cliErrors = true
+	}
+	if pages < 1 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The number of pages must be at least 1")
+		cliErrors = true
+	}
+	if cliErrors {
+		fs.Usage()
+		return 1
+	}
+
+	mmClient, err := opts.connect()
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to connect to Mattermost.  Error: "+err.Error())
+		return 2
+	}
+
+	teamID := ""
+	if opts.MattermostTeam != "" {
+		team, err := mmuserlist.ResolveTeam(mmClient, opts.MattermostTeam)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to find team: "+err.Error())
+			return 2
+		}
+		teamID = team.Id
+	}
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, "Benchmark started - Version: "+Version)
+
+	var results []*benchResult
+	emptyPages := false
+	for _, pageSize := range pageSizes {
+		for _, concurrency := range concurrencyLevels {
+			result := RunBenchmark(mmClient, teamID, pageSize, concurrency, pages)
+			if result.EmptyPages > 0 {
+				emptyPages = true
+			}
+			results = append(results, result)
+		}
+	}
+
+	printBenchmarkResults(results)
+
+	if emptyPages {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "Some requests returned empty pages, which understates latency.  Reduce -pages or the page sizes for more representative figures.")
+	}
+
+	if reportFile != "" {
+		if err := WriteBenchmarkReport(results, reportFile); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to write benchmark report: "+err.Error())
+			return 4
+		}
+		mmuserlist.LogSummary("Benchmark report written to " + reportFile)
+	}
+
+	return 0
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// actionFlags are the parameters of the commands that act on the users once they have been exported
+var actionFlags = []string{"deactivate-after", "notify-inactive", "message-template", "dry-run", "yes"}
+
+// auditFlags are the parameters of the audit reports
+var auditFlags = []string{"name-audit", "name-rules", "domain-audit", "allowed-domains", "auth-audit", "scatter", "scatter-plot"}
+
+// reportKinds maps the kinds of report accepted by 'report' to the parameter that selects each one
+var reportKinds = map[string]string{
+	"names":   "name-audit",
+	"domains": "domain-audit",
+	"auth":    "auth-audit",
+	"scatter": "scatter",
+}
+
+// exportCommand is a subcommand that runs an export.  Each command accepts the parameters of the flat command line,
+// less those that belong to another command, and fixes the parameters that select what it does, so that a config file
+// shared between commands can't turn on another command's action.
+type exportCommand struct {
+	Name        string
+	Usage       string
+	Description string
+
+	// Excluded are the parameters the command doesn't accept
+	Excluded []string
+
+	// Arguments parses the command's leading arguments, if it takes any, returning the parameters they set
+	Arguments func(args []string) (map[string]string, error)
+	Fixed     map[string]string
+}
+
+// exportCommands are the subcommands that run an export, in the order they are listed by 'help'
+var exportCommands = []exportCommand{
+	{
+		Name:        "export",
+		Usage:       "export [options]",
+		Description: "Write a list of users to a file, or serve or schedule the export.",
+		Excluded:    append(append([]string{}, actionFlags...), auditFlags...),
+		Fixed:       map[string]string{"deactivate-after": "-1", "notify-inactive": "-1", "name-audit": "false", "domain-audit": "false", "auth-audit": "false", "scatter": "false"},
+	},
+	{
+		Name:        "report",
+		Usage:       "report <names|domains|auth|scatter> [options]",
+		Description: "Write a naming policy, email domain or auth method audit, or the account age vs activity data.",
+		Excluded:    append(append([]string{}, actionFlags...), "name-audit", "domain-audit", "auth-audit", "scatter"),
+		Arguments:   reportArguments,
+		Fixed:       map[string]string{"deactivate-after": "-1", "notify-inactive": "-1"},
+	},
+	{
+		Name:        "deactivate",
+		Usage:       "deactivate <days> [options]",
+		Description: "Export the users inactive for more than <days> days, then deactivate them.",
+		Excluded:    append([]string{"deactivate-after", "notify-inactive", "message-template"}, auditFlags...),
+		Arguments:   daysArgument("deactivate-after"),
+		Fixed:       map[string]string{"notify-inactive": "-1", "name-audit": "false", "domain-audit": "false", "auth-audit": "false", "scatter": "false"},
+	},
+	{
+		Name:        "notify",
+		Usage:       "notify <days> [options]",
+		Description: "Export the users inactive for more than <days> days, then send each of them a direct message.",
+		Excluded:    append([]string{"deactivate-after", "notify-inactive"}, auditFlags...),
+		Arguments:   daysArgument("notify-inactive"),
+		Fixed:       map[string]string{"deactivate-after": "-1", "name-audit": "false", "domain-audit": "false", "auth-audit": "false", "scatter": "false"},
+	},
+}
+
+// otherCommands describes the remaining subcommands for 'help'
+var otherCommands = [][2]string{
+	{"users search <term>", "Search for users by name, username or email address"},
+	{"offboard -user <user>", "Write the offboarding packet for a departing user"},
+	{"batch <batch file>", "Run every export job in a batch file"},
+	{"config show", "Show the resolved configuration and where each value came from"},
+	{"bench", "Measure the API throughput at different page sizes and concurrency levels"},
+	{"version", "Show version information"},
+	{"help [command]", "Show the commands, or the options of one of them"},
+}
+
+// reportArguments reads the kind of report requested of 'report'
+func reportArguments(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the kind of report is required")
+	}
+	flagName, found := reportKinds[args[0]]
+	if !found {
+		return nil, fmt.Errorf("unknown report '%s'", args[0])
+	}
+
+	settings := map[string]string{}
+	for _, name := range reportKinds {
+		settings[name] = strconv.FormatBool(name == flagName)
+	}
+	return settings, nil
+}
+
+// daysArgument returns a parser for a command's leading number of days, which sets the named parameter
+func daysArgument(name string) func(args []string) (map[string]string, error) {
+	return func(args []string) (map[string]string, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("the number of days is required")
+		}
+		days, err := strconv.Atoi(args[0])
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("'%s' is not a number of days", args[0])
+		}
+		return map[string]string{name: args[0]}, nil
+	}
+}
+
+// lookupExportCommand returns the export command with the given name
+func lookupExportCommand(name string) (exportCommand, bool) {
+	for _, command := range exportCommands {
+		if command.Name == name {
+			return command, true
+		}
+	}
+	return exportCommand{}, false
+}
+
+// excluded returns the set of parameters the command doesn't accept.  'version' is a command of its own.
+func (command exportCommand) excluded() map[string]bool {
+	excluded := map[string]bool{"version": true}
+	for _, name := range command.Excluded {
+		excluded[name] = true
+	}
+	return excluded
+}
+
+// printUsage describes the command and lists the options it accepts, with their defaults
+func (command exportCommand) printUsage(out io.Writer) {
+
+	var defaults cliOptions
+	all := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	registerFlags(all, &defaults)
+
+	excluded := command.excluded()
+	accepted := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	accepted.SetOutput(out)
+	all.VisitAll(func(f *flag.Flag) {
+		if !excluded[f.Name] {
+			accepted.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+
+	fmt.Fprintf(out, "Usage: mm-user-list %s\n\n%s\n\nOptions:\n", command.Usage, command.Description)
+	accepted.PrintDefaults()
+}
+
+// run implements the command, returning the process exit code
+func (command exportCommand) run(args []string) int {
+
+	var arguments map[string]string
+	if command.Arguments != nil {
+		var leading []string
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			leading, args = args[:1], args[1:]
+		}
+		var err error
+		if arguments, err = command.Arguments(leading); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error()+" - usage: mm-user-list "+command.Usage)
+			return 1
+		}
+	}
+
+	var opts cliOptions
+	fs := flag.NewFlagSet(command.Name, flag.ExitOnError)
+	registerFlags(fs, &opts)
+	fs.Usage = func() { command.printUsage(fs.Output()) }
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("Unexpected argument '%s' - options must come before any other arguments", fs.Arg(0)))
+		return 1
+	}
+
+	excluded := command.excluded()
+	var rejected []string
+	fs.Visit(func(f *flag.Flag) {
+		if excluded[f.Name] {
+			rejected = append(rejected, "-"+f.Name)
+		}
+	})
+	if len(rejected) > 0 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("'%s' doesn't accept %s - see 'mm-user-list help %s'", command.Name, strings.Join(rejected, ", "), command.Name))
+		return 1
+	}
+
+	for _, settings := range []map[string]string{command.Fixed, arguments} {
+		for name, value := range settings {
+			if err := fs.Set(name, value); err != nil {
+				mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+				return 1
+			}
+		}
+	}
+
+	return runParsedExport(fs, &opts)
+}
+
+// runParsedExport runs the export described by a parsed flag set, or serves or schedules it if requested
+func runParsedExport(fs *flag.FlagSet, opts *cliOptions) int {
+	if opts.Serve != "" {
+		return runServe(fs, opts.Serve)
+	}
+	if opts.Schedule != "" {
+		return runScheduled(fs, opts.Schedule)
+	}
+	return runExport(fs, opts)
+}
+
+// printVersion shows the version information
+func printVersion() {
+	fmt.Printf("\nmm-user-list - Version: %s\n\n", Version)
+}
+
+// runHelpCommand implements the 'help' subcommand, which lists the commands, or the options of the one named, and
+// returns the process exit code
+func runHelpCommand(args []string) int {
+
+	if len(args) > 0 {
+		command, found := lookupExportCommand(args[0])
+		if !found {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Options are only listed for 'export', 'report', 'deactivate' and 'notify' - try 'mm-user-list "+args[0]+" -h'")
+			return 1
+		}
+		command.printUsage(os.Stdout)
+		return 0
+	}
+
+	fmt.Println("Usage: mm-user-list <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, command := range exportCommands {
+		fmt.Printf("  %-46s %s\n", command.Usage, command.Description)
+	}
+	for _, command := range otherCommands {
+		fmt.Printf("  %-46s %s\n", command[0], command[1])
+	}
+	fmt.Println()
+	fmt.Println("Run 'mm-user-list help <command>' for the options of a command.  The options can also be given without a")
+	fmt.Println("command, as in earlier versions, in which case every option is accepted.")
+	return 0
+}
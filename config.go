@@ -0,0 +1,944 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	// The time zone database is embedded for systems without one (e.g. Windows), so that 'timezone' always works
+	_ "time/tzdata"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"golang.org/x/term"
+)
+
+// domainList collects the email domains supplied with a repeatable parameter such as '-email-domain'
+type domainList []string
+
+// String implements flag.Value
+func (domains *domainList) String() string {
+	if domains == nil {
+		return ""
+	}
+	return strings.Join(*domains, ",")
+}
+
+// Set implements flag.Value.  Each call adds to the list, so the parameter can be repeated.
+func (domains *domainList) Set(value string) error {
+	*domains = append(*domains, mmuserlist.ParseDomainList(value)...)
+	return nil
+}
+
+// cliOptions holds the values of the command line parameters once the configuration has been resolved
+type cliOptions struct {
+	ConfigFile             string
+	Profile                string
+	MattermostURL          string
+	MattermostPort         string
+	MattermostScheme       string
+	MattermostToken        string
+	TokenFile              string
+	LoginID                string
+	Password               string
+	MFACode                string
+	TokenRefreshCmd        string
+	Cloud                  bool
+	CACert                 string
+	ClientCert             string
+	ClientKey              string
+	InsecureSkipVerify     bool
+	Proxy                  string
+	MattermostTeam         string
+	Source                 string
+	Channel                string
+	NotInTeam              bool
+	AllTeams               bool
+	MergeTeams             bool
+	UseTeamDisplayName     bool
+	IncludeBots            bool
+	IncludeDeactivated     bool
+	Pagination             string
+	Concurrency            int
+	AdaptiveConcurrency    bool
+	SkipCountCheck         bool
+	Format                 string
+	PropsMode              string
+	Columns                string
+	Template               string
+	DateFormat             string
+	CSVDelimiter           string
+	CSVQuoteAll            bool
+	CSVCRLF                bool
+	CSVBOM                 bool
+	Timezone               string
+	SortBy                 string
+	SortDesc               bool
+	ClientUsage            bool
+	WithSessions           bool
+	Roles                  bool
+	Role                   string
+	AuthMethod             bool
+	AuthService            string
+	Guests                 bool
+	TeamJoinDate           bool
+	WithTeams              bool
+	WithChannelCounts      bool
+	WithLastPost           bool
+	WithPostCount          bool
+	PostsSince             string
+	GuestsOnly             bool
+	ExcludeGuests          bool
+	EmailDomains           domainList
+	MatchUsername          string
+	MatchEmail             string
+	MatchName              string
+	ExcludeEmailDomains    domainList
+	Scatter                bool
+	NameAudit              bool
+	NameRules              string
+	DomainAudit            bool
+	AllowedDomains         string
+	AuthAudit              bool
+	Tags                   tagList
+	Manifest               bool
+	AuditLog               string
+	ScatterPlot            string
+	InGroup                string
+	NotInGroup             string
+	InChannel              string
+	ChannelDetails         bool
+	RoleHistory            bool
+	NotInChannel           string
+	ExcludeFile            string
+	InactiveDays           int
+	NeverLoggedIn          bool
+	DeactivateAfter        int
+	NotifyInactive         int
+	MessageTemplate        string
+	DryRun                 bool
+	AssumeYes              bool
+	PreviewDiff            bool
+	ConfirmDiff            bool
+	Stream                 bool
+	ProgressJSON           string
+	EmailReport            bool
+	EmailSubject           string
+	SMTPHost               string
+	SMTPUsername           string
+	SMTPPassword           string
+	SMTPFrom               string
+	SMTPTo                 string
+	SMTPTLS                string
+	SMTPInsecureSkipVerify bool
+	Upload                 string
+	S3Endpoint             string
+	SFTPKey                string
+	SFTPKnownHosts         string
+	CSVFile                string
+	Compress               bool
+	Append                 bool
+	BrandingTitle          string
+	BrandingLogo           string
+	BrandingFooter         string
+	Estimate               bool
+	DebugFlag              bool
+	LogFormat              string
+	LogLevel               string
+	LogFile                string
+	NoColor                bool
+	LogSensitive           bool
+	NoDefaults             bool
+	VersionFlag            bool
+	Schedule               string
+	Serve                  string
+	TUI                    bool
+	ServeToken             string
+	MetricsAddress         string
+	MetricsDays            string
+	MetricsRefresh         int
+
+	branding       *mmuserlist.Branding
+	columns        []string
+	userTemplate   *template.Template
+	patterns       []userPattern
+	defaultFilters *cliOptions
+	s3             mmuserlist.S3Settings
+	sftp           mmuserlist.SFTPSettings
+	postPeriod     string
+	dates          mmuserlist.DateOptions
+	csv            mmuserlist.CSVOptions
+	gaps           *mmuserlist.PageGapLog
+	message        *template.Template
+	progress       *progressReporter
+	// sharedLogging is set for the exports run by 'serve' and 'schedule', which configure logging once when they
+	// start, so that an export doesn't reconfigure it while another is running
+	sharedLogging bool
+}
+
+// userPattern is a regular expression filter on one of the user fields
+type userPattern struct {
+	field   func(user *mmuserlist.User) string
+	pattern *regexp.Regexp
+}
+
+// output returns the options controlling the optional output columns
+func (opts *cliOptions) output() mmuserlist.OutputOptions {
+	return mmuserlist.OutputOptions{
+		Format:      opts.Format,
+		Columns:     opts.columns,
+		PropsMode:   opts.PropsMode,
+		ClientUsage: opts.ClientUsage,
+		Sessions:    opts.WithSessions,
+		Roles:       opts.Roles,
+		AuthMethod:  opts.AuthMethod,
+		Guests:      opts.Guests,
+		TeamJoined:  opts.TeamJoinDate,
+		Teams:       opts.WithTeams,
+		Channels:    opts.WithChannelCounts,
+		LastPost:    opts.WithLastPost,
+		PostCount:   opts.WithPostCount,
+		Channel:     opts.ChannelDetails,
+		RoleHistory: opts.RoleHistory,
+		Deactivated: opts.IncludeDeactivated,
+		Tags:        opts.Tags,
+		Dates:       opts.dates,
+		CSV:         opts.csv,
+		Branding:    opts.branding,
+		Template:    opts.userTemplate,
+		Append:      opts.Append,
+		Compress:    opts.Compress,
+	}
+}
+
+// crawl returns the options controlling how the users are fetched
+func (opts *cliOptions) crawl() mmuserlist.CrawlOptions {
+	crawl := mmuserlist.CrawlOptions{
+		IncludeBots:         opts.IncludeBots,
+		Pagination:          opts.Pagination,
+		VerifyCounts:        !opts.SkipCountCheck,
+		TeamDisplayNames:    opts.UseTeamDisplayName,
+		Concurrency:         opts.Concurrency,
+		AdaptiveConcurrency: opts.AdaptiveConcurrency,
+		Gaps:                opts.gaps,
+	}
+	if opts.progress != nil {
+		crawl.Progress = opts.progress.page
+	}
+	return crawl
+}
+
+// loadPatterns compiles the regular expressions given to the 'match' parameters
+func (opts *cliOptions) loadPatterns() error {
+	opts.patterns = nil
+	matches := []struct {
+		name  string
+		value string
+		field func(user *mmuserlist.User) string
+	}{
+		{"match-username", opts.MatchUsername, func(user *mmuserlist.User) string { return user.Username }},
+		{"match-email", opts.MatchEmail, func(user *mmuserlist.User) string { return user.Email }},
+		{"match-name", opts.MatchName, (*mmuserlist.User).FullName},
+	}
+	for _, match := range matches {
+		if match.value == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(match.value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid regular expression: %w", match.name, err)
+		}
+		opts.patterns = append(opts.patterns, userPattern{match.field, pattern})
+	}
+	return nil
+}
+
+// columnOptions are the parameters that retrieve the data shown by the columns that need more than the user records,
+// which are turned on when one of those columns is chosen
+var columnOptions = map[string]func(opts *cliOptions){
+	"last_post":            func(opts *cliOptions) { opts.WithLastPost = true },
+	"days_since_post":      func(opts *cliOptions) { opts.WithLastPost = true },
+	"total_posts":          func(opts *cliOptions) { opts.WithPostCount = true },
+	"team_join_date":       func(opts *cliOptions) { opts.TeamJoinDate = true },
+	"teams":                func(opts *cliOptions) { opts.WithTeams = true },
+	"channel_count":        func(opts *cliOptions) { opts.WithChannelCounts = true },
+	"roles":                func(opts *cliOptions) { opts.Roles = true },
+	"guest_channels":       func(opts *cliOptions) { opts.Guests = true },
+	"last_client":          func(opts *cliOptions) { opts.ClientUsage = true },
+	"last_client_version":  func(opts *cliOptions) { opts.ClientUsage = true },
+	"last_client_platform": func(opts *cliOptions) { opts.ClientUsage = true },
+	"active_sessions":      func(opts *cliOptions) { opts.WithSessions = true },
+	"last_session":         func(opts *cliOptions) { opts.WithSessions = true },
+	"session_clients":      func(opts *cliOptions) { opts.WithSessions = true },
+	"channel_role":         func(opts *cliOptions) { opts.ChannelDetails = true },
+	"channel_last_viewed":  func(opts *cliOptions) { opts.ChannelDetails = true },
+	"channel_msg_count":    func(opts *cliOptions) { opts.ChannelDetails = true },
+	"roles_changed_at":     func(opts *cliOptions) { opts.RoleHistory = true },
+	"roles_changed_by":     func(opts *cliOptions) { opts.RoleHistory = true },
+	"roles_changed_to":     func(opts *cliOptions) { opts.RoleHistory = true },
+}
+
+// loadColumns parses the 'columns' list for the output options, and turns on the parameters that retrieve the data the
+// chosen columns show
+func (opts *cliOptions) loadColumns() error {
+	opts.columns = nil
+	if opts.Columns == "" {
+		return nil
+	}
+	columns, err := mmuserlist.ParseColumns(opts.Columns)
+	if err != nil {
+		return err
+	}
+	for _, name := range columns {
+		if enable, found := columnOptions[name]; found {
+			enable(opts)
+		}
+	}
+	opts.columns = columns
+	return nil
+}
+
+// loadDefaultFilters prepares the default filters from a config file, which are applied to the users on top of the
+// export's own filters.  Bots and deactivated users are only dropped if the default filters say so.
+func (opts *cliOptions) loadDefaultFilters(config *configFile) error {
+
+	opts.defaultFilters = nil
+	if opts.NoDefaults || config == nil || len(config.DefaultFilters) == 0 {
+		return nil
+	}
+
+	defaults := &cliOptions{}
+	fs := flag.NewFlagSet(defaultFiltersKey, flag.ContinueOnError)
+	registerFlags(fs, defaults)
+	defaults.IncludeBots = true
+	defaults.IncludeDeactivated = true
+
+	var applied []string
+	for _, name := range sortedKeys(config.DefaultFilters) {
+		if err := fs.Set(name, config.DefaultFilters[name]); err != nil {
+			return fmt.Errorf("invalid value '%s' for default filter '%s' in %s: %w", config.DefaultFilters[name], name, config.Path, err)
+		}
+		applied = append(applied, name+"="+config.DefaultFilters[name])
+	}
+	if defaults.GuestsOnly && defaults.ExcludeGuests {
+		return fmt.Errorf("the default filters in %s can't include both 'guests-only' and 'exclude-guests'", config.Path)
+	}
+	if err := defaults.loadPatterns(); err != nil {
+		return fmt.Errorf("default filter in %s: %w", config.Path, err)
+	}
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, "Applying the default filters from "+config.Path+" (use -no-defaults to skip them): "+strings.Join(applied, ", "))
+	opts.defaultFilters = defaults
+	return nil
+}
+
+// loadBranding reads the organization's report template, if one is configured, for the output options
+func (opts *cliOptions) loadBranding() error {
+	branding, err := mmuserlist.LoadBranding(opts.BrandingTitle, opts.BrandingLogo, opts.BrandingFooter)
+	if err != nil {
+		return err
+	}
+	opts.branding = branding
+	return nil
+}
+
+// loadUserTemplate parses the 'template' for template output, which takes the place of the output format
+func (opts *cliOptions) loadUserTemplate() error {
+	opts.userTemplate = nil
+	if opts.Template == "" {
+		return nil
+	}
+	userTemplate, err := mmuserlist.ParseUserTemplate(opts.Template, opts.dates)
+	if err != nil {
+		return err
+	}
+	opts.Format = mmuserlist.FormatTemplate
+	opts.userTemplate = userTemplate
+	return nil
+}
+
+// loadNotificationTemplate prepares the direct message for 'notify-inactive', from the 'message-template' file or the
+// built-in message
+func (opts *cliOptions) loadNotificationTemplate() error {
+	var err error
+	if opts.MessageTemplate != "" {
+		opts.message, err = mmuserlist.LoadNotificationTemplate(opts.MessageTemplate)
+	} else {
+		opts.message, err = mmuserlist.ParseNotificationTemplate(mmuserlist.DefaultNotificationTemplate)
+	}
+	return err
+}
+
+// defaultTeam returns the team being exported when a single team was requested, for reports that show a team on
+// every row but are given rows without a team name
+func (opts *cliOptions) defaultTeam() string {
+	if opts.NotInTeam || opts.AllTeams {
+		return ""
+	}
+	return opts.MattermostTeam
+}
+
+// connection returns the Mattermost connection details from the resolved options
+func (opts *cliOptions) connection() mmuserlist.Connection {
+	return mmuserlist.Connection{
+		URL:             opts.MattermostURL,
+		Port:            opts.MattermostPort,
+		Scheme:          opts.MattermostScheme,
+		Token:           opts.MattermostToken,
+		TokenRefreshCmd: opts.TokenRefreshCmd,
+		Cloud:           opts.cloud(),
+	}
+}
+
+// tlsOptions returns the TLS settings for the connection
+func (opts *cliOptions) tlsOptions() mmuserlist.TLSOptions {
+	return mmuserlist.TLSOptions{
+		CACertFile:         opts.CACert,
+		ClientCertFile:     opts.ClientCert,
+		ClientKeyFile:      opts.ClientKey,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+}
+
+// cloud reports whether the target is a Mattermost Cloud workspace, either as configured or from the server address
+func (opts *cliOptions) cloud() bool {
+	return opts.Cloud || mmuserlist.IsCloudHost(opts.MattermostURL)
+}
+
+// applyLoggingOptions configures the logging layer from the resolved options
+func applyLoggingOptions(opts *cliOptions) error {
+	mmuserlist.DebugMode = opts.DebugFlag
+	mmuserlist.LogSensitive = opts.LogSensitive
+	mmuserlist.ColorOutput = mmuserlist.ColorOutput && !opts.NoColor
+
+	// When the output is streamed to stdout, the log messages must stay out of its way
+	mmuserlist.LogToStderr = opts.CSVFile == mmuserlist.StdoutPath
+
+	// 'debug' predates 'log-level', and still shows the debug messages whatever the level
+	level := opts.LogLevel
+	if opts.DebugFlag {
+		level = "debug"
+	}
+	return mmuserlist.ConfigureLogging(mmuserlist.LogSettings{Format: opts.LogFormat, Level: level, File: opts.LogFile})
+}
+
+// applyDateOptions sets how dates are rendered in the output from the resolved options, which must be done before the
+// template is loaded
+func applyDateOptions(opts *cliOptions) error {
+	layout, err := mmuserlist.ParseDateLayout(opts.DateFormat)
+	if err != nil {
+		return fmt.Errorf("the 'date-format' is not valid: %w", err)
+	}
+	location, err := time.LoadLocation(opts.Timezone)
+	if err != nil {
+		return fmt.Errorf("the 'timezone' is not valid: %w", err)
+	}
+	opts.dates = mmuserlist.DateOptions{Layout: layout, Location: location}
+	return nil
+}
+
+// applyCSVOptions sets the dialect of CSV output from the resolved options, which output() then passes on
+func applyCSVOptions(opts *cliOptions) error {
+	delimiter, err := mmuserlist.ParseCSVDelimiter(opts.CSVDelimiter)
+	if err != nil {
+		return fmt.Errorf("the 'csv-delimiter' is not valid: %w", err)
+	}
+	opts.csv = mmuserlist.CSVOptions{
+		Delimiter: delimiter,
+		QuoteAll:  opts.CSVQuoteAll,
+		CRLF:      opts.CSVCRLF,
+		BOM:       opts.CSVBOM,
+	}
+	return nil
+}
+
+// applyFileName fills in any date and time conversions in the output file name, such as %Y%m%d, with the time of the
+// run
+func applyFileName(opts *cliOptions) {
+	if opts.CSVFile != mmuserlist.StdoutPath {
+		opts.CSVFile = mmuserlist.ExpandFileName(opts.CSVFile, time.Now().In(opts.dates.Location))
+	}
+}
+
+// applyCompression adds the suffix that says the output is gzip-compressed to the output file name, if compression is
+// asked for.  A file whose name already ends in the suffix is compressed regardless.
+func applyCompression(opts *cliOptions) {
+	if opts.Compress && opts.CSVFile != "" && opts.CSVFile != mmuserlist.StdoutPath && !strings.HasSuffix(opts.CSVFile, mmuserlist.GzipSuffix) {
+		opts.CSVFile += mmuserlist.GzipSuffix
+	}
+}
+
+// outputName describes where the output is being written, for the summary messages
+func (opts *cliOptions) outputName() string {
+	if opts.CSVFile == mmuserlist.StdoutPath {
+		return "standard output"
+	}
+	return opts.CSVFile
+}
+
+// countTrue returns how many of the supplied conditions hold, for checking mutually exclusive parameters
+func countTrue(conditions ...bool) int {
+	count := 0
+	for _, condition := range conditions {
+		if condition {
+			count++
+		}
+	}
+	return count
+}
+
+// validateConnection checks that the parameters needed to reach Mattermost have been supplied, logging an error for
+// each one that is missing.  It returns true if the connection details are usable.
+func (opts *cliOptions) validateConnection() bool {
+	valid := true
+	if opts.MattermostURL == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
+		valid = false
+	}
+	if opts.MattermostScheme == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The Mattermost HTTP scheme must be supplied either on the command line of vie the MM_SCHEME environment variable")
+		valid = false
+	}
+	if opts.MattermostToken == "" && opts.LoginID == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The Mattermost auth token must be supplied either on the command line of vie the MM_TOKEN environment variable")
+		valid = false
+	}
+	if opts.MattermostToken != "" && opts.LoginID != "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Only one of 'token' and 'login-id' can be used to authenticate")
+		valid = false
+	}
+	if (opts.Password != "" || opts.MFACode != "") && opts.LoginID == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'password' and 'mfa-code' options can only be used with 'login-id'")
+		valid = false
+	}
+	return valid
+}
+
+// configSetting describes a single resolved configuration value and where that value came from
+type configSetting struct {
+	Name      string
+	Value     string
+	Source    string
+	Sensitive bool
+}
+
+const (
+	sourceDefault = "default"
+	sourceFlag    = "command line"
+	sourceEnv     = "environment"
+	sourceFile    = "config file"
+)
+
+// envSettings maps parameters to the environment variables that can supply them
+var envSettings = map[string]string{
+	"url":                  "MM_URL",
+	"port":                 "MM_PORT",
+	"scheme":               "MM_SCHEME",
+	"token":                "MM_TOKEN",
+	"token-file":           "MM_TOKEN_FILE",
+	"debug":                "MM_DEBUG",
+	"token-refresh-cmd":    "MM_TOKEN_REFRESH_CMD",
+	"cloud":                "MM_CLOUD",
+	"ca-cert":              "MM_CA_CERT",
+	"client-cert":          "MM_CLIENT_CERT",
+	"client-key":           "MM_CLIENT_KEY",
+	"insecure-skip-verify": "MM_INSECURE_SKIP_VERIFY",
+	"proxy":                "MM_PROXY",
+	"profile":              "MM_PROFILE",
+	"login-id":             "MM_LOGIN_ID",
+	"password":             "MM_PASSWORD",
+	"smtp-host":            "MM_SMTP_HOST",
+	"smtp-username":        "MM_SMTP_USERNAME",
+	"smtp-password":        "MM_SMTP_PASSWORD",
+	"log-format":           "MM_LOG_FORMAT",
+	"log-level":            "MM_LOG_LEVEL",
+	"log-file":             "MM_LOG_FILE",
+	"serve-token":          "MM_SERVE_TOKEN",
+	"date-format":          "MM_DATE_FORMAT",
+	"timezone":             "MM_TIMEZONE",
+	"csv-delimiter":        "MM_CSV_DELIMITER",
+	"upload":               "MM_UPLOAD",
+	"s3-endpoint":          "MM_S3_ENDPOINT",
+	"sftp-key":             "MM_SFTP_KEY",
+	"sftp-known-hosts":     "MM_SFTP_KNOWN_HOSTS",
+}
+
+// settingDefaults holds default values for parameters whose flag default is left empty so that an unset flag can
+// still fall through to the environment
+var settingDefaults = map[string]string{
+	"port":   mmuserlist.DefaultPort,
+	"scheme": mmuserlist.DefaultScheme,
+}
+
+// cloudDefaults replace the self-hosted defaults when the target is a Mattermost Cloud workspace
+var cloudDefaults = map[string]string{
+	"port":   mmuserlist.CloudPort,
+	"scheme": mmuserlist.CloudScheme,
+}
+
+// sensitiveSettings are never printed in full
+var sensitiveSettings = map[string]bool{
+	"token":         true,
+	"password":      true,
+	"mfa-code":      true,
+	"smtp-password": true,
+	"serve-token":   true,
+}
+
+// nonConfigFlags are command line switches that trigger an action rather than configure a run
+var nonConfigFlags = map[string]bool{
+	"version":     true,
+	"effective":   true,
+	"config":      true,
+	"no-defaults": true,
+	"schedule":    true,
+	"serve":       true,
+	"tui":         true,
+}
+
+// registerFlags defines the command line parameters on the supplied flag set
+func registerFlags(fs *flag.FlagSet, opts *cliOptions) {
+	fs.StringVar(&opts.ConfigFile, "config", "", "A YAML file of parameter values (default ~/"+defaultConfigFile+" if it exists), overridden by the environment and the command line")
+	fs.StringVar(&opts.Profile, "profile", "", "A named server profile from the config file, whose settings are applied over the file's top-level settings")
+	fs.StringVar(&opts.MattermostURL, "url", "", "The URL of the Mattermost instance (without the HTTP scheme), including the subpath if it is served under one")
+	fs.StringVar(&opts.MattermostPort, "port", "", "The TCP port used by Mattermost. [Default: "+mmuserlist.DefaultPort+"]")
+	fs.StringVar(&opts.MattermostScheme, "scheme", "", "The HTTP scheme to be used (http/https). [Default: "+mmuserlist.DefaultScheme+"]")
+	fs.StringVar(&opts.MattermostToken, "token", "", "The auth token used to connect to Mattermost")
+	fs.StringVar(&opts.TokenFile, "token-file", "", "A file holding the auth token, or '-' to read it from standard input, so that it isn't visible on the command line")
+	fs.StringVar(&opts.LoginID, "login-id", "", "A username or email address to log in with, in place of an auth token")
+	fs.StringVar(&opts.Password, "password", "", "With 'login-id', the password (prompted for if not supplied)")
+	fs.StringVar(&opts.MFACode, "mfa-code", "", "With 'login-id', the MFA code for accounts with multi-factor authentication (prompted for if needed)")
+	fs.BoolVar(&opts.Cloud, "cloud", false, "The server is a Mattermost Cloud workspace (detected automatically for *"+mmuserlist.CloudDomain+" addresses), so HTTPS on port "+mmuserlist.CloudPort+" is the default and requests are throttled")
+	fs.StringVar(&opts.CACert, "ca-cert", "", "A PEM file of CA certificates to trust, in addition to the system ones, e.g. for a server with an internal CA")
+	fs.StringVar(&opts.ClientCert, "client-cert", "", "A PEM client certificate to present to a server that requires mutual TLS (with 'client-key')")
+	fs.StringVar(&opts.ClientKey, "client-key", "", "The PEM private key for 'client-cert'")
+	fs.BoolVar(&opts.InsecureSkipVerify, "insecure-skip-verify", false, "Don't verify the server's TLS certificate.  For testing only")
+	fs.StringVar(&opts.Proxy, "proxy", "", "An HTTP(S) or SOCKS5 proxy to reach Mattermost through, e.g. proxy.example.com:3128, in place of any proxy set by HTTPS_PROXY/HTTP_PROXY")
+	fs.StringVar(&opts.TokenRefreshCmd, "token-refresh-cmd", "", "Optional command that outputs a fresh auth token, run if Mattermost rejects the current token part way through a run")
+	fs.StringVar(&opts.MattermostTeam, "team", "", "The Mattermost team, by name, display name or ID")
+	fs.StringVar(&opts.Channel, "channel", "", "Can be used in place of the 'team' parameter to list the members of a channel, public or private, given as <team>/<channel>")
+	fs.StringVar(&opts.Source, "source", "", "Can be used in place of the 'team' parameter to combine user sources with 'and'/'or', e.g. 'team:sales and channel:sales/town-square or list:vips.csv'")
+	fs.BoolVar(&opts.NotInTeam, "not-in-team", false, "Can be used in place of the 'team' parameter to only show users who are not allocated to a team.")
+	fs.BoolVar(&opts.AllTeams, "all-teams", false, "Can be used in place of the 'team' parameter to list the members of every team, with the team name on each row")
+	fs.BoolVar(&opts.MergeTeams, "merge-teams", false, "With 'all-teams' or a list of teams, list each user once with a comma-separated list of their teams, rather than once per team")
+	fs.BoolVar(&opts.UseTeamDisplayName, "use-team-display-name", false, "Show each user's team by its display name, rather than its name as used in the URL")
+	fs.BoolVar(&opts.IncludeBots, "include-bots", false, "Optional paramter to include bot accounts in the list")
+	fs.BoolVar(&opts.IncludeDeactivated, "include-deactivated", false, "Include deactivated users, with columns showing whether and when each account was deactivated")
+	fs.StringVar(&opts.InGroup, "in-group", "", "Only include users who are members of the named group")
+	fs.StringVar(&opts.NotInGroup, "not-in-group", "", "Only include users who are not members of the named group")
+	fs.StringVar(&opts.InChannel, "member-of-channel", "", "Only include users who are members of the named channel in the selected team")
+	fs.BoolVar(&opts.ChannelDetails, "channel-details", false, "With 'channel' or 'member-of-channel', add columns showing each member's channel role (member/admin/guest), when they last viewed the channel and its message count at that time")
+	fs.StringVar(&opts.NotInChannel, "not-member-of-channel", "", "Only include users who are not members of the named channel in the selected team")
+	fs.StringVar(&opts.ExcludeFile, "exclude-file", "", "A file of usernames, email addresses and/or user IDs to be excluded from the output")
+	fs.IntVar(&opts.InactiveDays, "inactive-days", -1, "Only include users whose last activity was more than this many days ago")
+	fs.BoolVar(&opts.NeverLoggedIn, "never-logged-in", false, "Only include users who have never logged in since their account was created")
+	fs.IntVar(&opts.DeactivateAfter, "deactivate-after", -1, "Deactivate the users whose last activity was more than this many days ago, after writing them to the output file")
+	fs.IntVar(&opts.NotifyInactive, "notify-inactive", -1, "Send a direct message to the users whose last activity was more than this many days ago, after writing them to the output file")
+	fs.StringVar(&opts.MessageTemplate, "message-template", "", "With 'notify-inactive', a file holding the message to send, as a Go template (e.g. 'Hi {{.Username}}'), in place of the built-in message")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "With 'deactivate-after' or 'notify-inactive', log the users that would be deactivated or messaged without changing anything")
+	fs.BoolVar(&opts.AssumeYes, "yes", false, "With 'deactivate-after' or 'notify-inactive', skip the confirmation prompt (for scheduled runs)")
+	fs.BoolVar(&opts.PreviewDiff, "preview-diff", false, "Before replacing an existing CSV or JSON output file, summarise the rows added, removed and modified")
+	fs.BoolVar(&opts.ConfirmDiff, "confirm-diff", false, "As 'preview-diff', but ask for confirmation before the output file is replaced")
+	fs.StringVar(&opts.Pagination, "pagination", mmuserlist.PaginationAuto, "Pagination method: 'offset', 'cursor' (newer servers only) or 'auto' to use the cursor API where available")
+	fs.IntVar(&opts.Concurrency, "concurrency", mmuserlist.DefaultConcurrency, fmt.Sprintf("The number of pages to fetch at once with offset pagination (1-%d).  Above 1, 'auto' pagination uses offset pagination", mmuserlist.MaxConcurrency))
+	fs.BoolVar(&opts.AdaptiveConcurrency, "adaptive-concurrency", false, "With 'concurrency' above 1, fetch fewer pages at once when requests are rate limited or slow down, then ramp back up as the server recovers")
+	fs.BoolVar(&opts.SkipCountCheck, "skip-count-check", false, "Don't cross-check the number of users fetched against the server's team and user statistics after each crawl")
+	fs.StringVar(&opts.Format, "format", mmuserlist.FormatCSV, "The output format: 'csv', 'json', 'ndjson' (one JSON object per line, written as the users are fetched), 'xlsx' (an Excel workbook), 'pdf' (a report with summary statistics and charts), 'sqlite' (a database table that each export adds to), 'parquet' (for data warehouses) or 'html' (a page with a sortable table)")
+	fs.StringVar(&opts.Columns, "columns", "", "A comma-separated list of the columns to write, in order, in place of the standard columns (e.g. username,email,days_inactive).  See the README for the column names")
+	fs.StringVar(&opts.Template, "template", "", "A Go template applied to each user to write one line per user, in place of 'format' (e.g. '{{.Username}}\\t{{.Email}}')")
+	fs.StringVar(&opts.CSVDelimiter, "csv-delimiter", ",", "The field delimiter for CSV output: a single character, e.g. ';' for Excel in locales that use a decimal comma, or 'tab' for TSV")
+	fs.BoolVar(&opts.CSVQuoteAll, "csv-quote-all", false, "Quote every field in CSV output, not just those that need it")
+	fs.BoolVar(&opts.CSVCRLF, "csv-crlf", false, "End the lines of CSV output with CRLF, as Windows tools expect")
+	fs.BoolVar(&opts.CSVBOM, "csv-bom", false, "Start CSV output with a UTF-8 byte order mark, so that Excel reads accented characters correctly")
+	fs.StringVar(&opts.DateFormat, "date-format", mmuserlist.DefaultDateLayout, "The Go time layout used for dates in CSV, PDF and template output (e.g. '02/01/2006' or '2006-01-02 15:04'), or 'epoch_ms' for milliseconds since the Unix epoch")
+	fs.StringVar(&opts.Timezone, "timezone", "UTC", "The time zone dates are shown in, as an IANA name (e.g. 'Europe/London') or 'Local' for this machine's zone")
+	fs.StringVar(&opts.SortBy, "sort-by", "", "Sort the output by 'username', 'email', 'last_activity', 'days_inactive' or 'created_at', rather than in the order the server returns the users")
+	fs.BoolVar(&opts.SortDesc, "sort-desc", false, "With 'sort-by', sort in descending order, e.g. the longest inactive users first with 'sort-by days_inactive'")
+	fs.StringVar(&opts.PropsMode, "props", mmuserlist.PropsNone, "Optionally export the user props map, either as one prefixed column per key ('columns') or as a single JSON column ('json')")
+	fs.BoolVar(&opts.ClientUsage, "client-usage", false, "Add columns showing the client (desktop, mobile or web) and version each user last connected with, from their sessions")
+	fs.BoolVar(&opts.WithSessions, "with-sessions", false, "Add columns showing each user's number of active sessions, when the latest was created, and the clients they were created with")
+	fs.BoolVar(&opts.Roles, "roles", false, "Add a column listing each user's system and team roles (e.g. system_admin, team_admin)")
+	fs.StringVar(&opts.Role, "role", "", "Only include users who hold one of these system or team roles, as a comma-separated list (e.g. system_admin,team_admin)")
+	fs.BoolVar(&opts.AuthMethod, "auth-method", false, "Add a column showing how each user signs in: 'email' for a password account, otherwise the SSO service (e.g. ldap, saml, gitlab)")
+	fs.StringVar(&opts.AuthService, "auth-service", "", "Only include users who sign in with one of these auth methods, as a comma-separated list (e.g. email,ldap)")
+	fs.BoolVar(&opts.Guests, "guests", false, "Add columns showing whether each user is a guest account and, for guests, the channels they are limited to")
+	fs.BoolVar(&opts.TeamJoinDate, "team-join-date", false, "Add a column showing the date each user joined the team on their row")
+	fs.BoolVar(&opts.WithTeams, "with-teams", false, "Add a column listing every team each user is a member of")
+	fs.BoolVar(&opts.WithChannelCounts, "with-channel-counts", false, "Add a column showing the number of channels each user is a member of in the team on their row")
+	fs.BoolVar(&opts.WithLastPost, "with-last-post", false, "Add columns showing when each user last posted, and how many days ago that was")
+	fs.BoolVar(&opts.WithPostCount, "with-post-count", false, "Add a column showing the number of posts each user has made")
+	fs.StringVar(&opts.PostsSince, "posts-since", "", "With 'with-post-count', a start date such as 2024-01-01 to count posts from, or the period to count them over: 'all_time' (the default), 'last_30_days', 'previous_month' or 'last_6_months'")
+	fs.BoolVar(&opts.GuestsOnly, "guests-only", false, "Only include guest accounts")
+	fs.BoolVar(&opts.ExcludeGuests, "exclude-guests", false, "Leave guest accounts out of the output")
+	fs.Var(&opts.EmailDomains, "email-domain", "Only include users whose email address is in this domain (e.g. example.com).  Can be repeated, or given as a comma-separated list")
+	fs.StringVar(&opts.MatchUsername, "match-username", "", "Only include users whose username matches this regular expression (e.g. '-svc$')")
+	fs.StringVar(&opts.MatchEmail, "match-email", "", "Only include users whose email address matches this regular expression")
+	fs.StringVar(&opts.MatchName, "match-name", "", "Only include users whose full name (first and last names) matches this regular expression")
+	fs.Var(&opts.ExcludeEmailDomains, "exclude-email-domain", "Leave out users whose email address is in this domain.  Can be repeated, or given as a comma-separated list")
+	fs.BoolVar(&opts.RoleHistory, "role-history", false, "Add columns showing when each user's system roles last changed, who changed them and the new roles, from the server's audit log")
+	fs.BoolVar(&opts.Scatter, "scatter", false, "Write the account age vs activity dataset (days since created, days since last activity, team) instead of the user list")
+	fs.StringVar(&opts.ScatterPlot, "scatter-plot", "", "With 'scatter', also render the dataset as an SVG scatter plot to this file")
+	fs.BoolVar(&opts.NameAudit, "name-audit", false, "Write a list of users whose names or nicknames break the naming policy, instead of the user list")
+	fs.StringVar(&opts.NameRules, "name-rules", "", "With 'name-audit', a file of naming policy rules to apply in place of the built-in rules")
+	fs.BoolVar(&opts.DomainAudit, "domain-audit", false, "Write a list of users whose email domain is outside the allowed domains, by team, instead of the user list")
+	fs.StringVar(&opts.AllowedDomains, "allowed-domains", "", "With 'domain-audit', a comma-separated list of allowed email domains to use in place of the server's setting")
+	fs.BoolVar(&opts.AuthAudit, "auth-audit", false, "Write a list of accounts with auth anomalies left by past migrations (e.g. SSO accounts without auth data), instead of the user list")
+	fs.Var(&opts.Tags, "tag", "A key=value pair (e.g. ticket=CHG-1234) recorded in the output file, manifest and audit log to attribute the export.  Can be repeated")
+	fs.BoolVar(&opts.Manifest, "manifest", false, "Write a manifest describing the run alongside the output file (always written when tags are supplied)")
+	fs.StringVar(&opts.AuditLog, "audit-log", "", "A file to which a JSON record of the run is appended")
+	fs.BoolVar(&opts.Stream, "stream", false, "Write each page of users as soon as it has been fetched, rather than holding every user in memory until the end")
+	fs.StringVar(&opts.ProgressJSON, "progress-json", "", "A file descriptor number (e.g. 3) or file to which progress events (stage, pages done, users fetched, ETA) are written as newline-delimited JSON")
+	fs.BoolVar(&opts.EmailReport, "email-report", false, "Once the output file has been written, email it as an attachment, with a summary of the run, through the 'smtp-host' mail server")
+	fs.StringVar(&opts.EmailSubject, "email-subject", "", "With 'email-report', the subject of the email, in place of one naming the output file and its row count")
+	fs.StringVar(&opts.SMTPHost, "smtp-host", "", "The mail server used by 'email-report', as host or host:port [Default port: "+mmuserlist.DefaultSMTPPort+"]")
+	fs.StringVar(&opts.SMTPUsername, "smtp-username", "", "The username to log in to the mail server with, if it needs one")
+	fs.StringVar(&opts.SMTPPassword, "smtp-password", "", "The password for 'smtp-username'")
+	fs.StringVar(&opts.SMTPFrom, "smtp-from", "", "The address the report is emailed from")
+	fs.StringVar(&opts.SMTPTo, "smtp-to", "", "A comma-separated list of the addresses the report is emailed to")
+	fs.StringVar(&opts.SMTPTLS, "smtp-tls", mmuserlist.SMTPStartTLS, "How the connection to the mail server is secured: 'starttls', 'tls' (usually port 465) or 'none'")
+	fs.BoolVar(&opts.SMTPInsecureSkipVerify, "smtp-insecure-skip-verify", false, "Don't verify the mail server's TLS certificate.  For testing only")
+	fs.StringVar(&opts.Upload, "upload", "", "Once the output file has been written, upload it to S3, e.g. 's3://bucket/reports/', or to an SFTP server, e.g. 'sftp://user@host/drop/'.  A destination ending in '/' keeps the file's name.  The AWS credentials are found as the AWS tools find them")
+	fs.StringVar(&opts.S3Endpoint, "s3-endpoint", "", "The address of an S3-compatible service to 'upload' to, such as MinIO, in place of AWS S3, e.g. 'https://minio.example.com:9000'")
+	fs.StringVar(&opts.SFTPKey, "sftp-key", "", "The private key to log in to the SFTP server with, which must not have a passphrase.  Keys held by the SSH agent are also tried [Default: ~/.ssh/id_ed25519, id_ecdsa or id_rsa]")
+	fs.StringVar(&opts.SFTPKnownHosts, "sftp-known-hosts", "", "The known_hosts file holding the SFTP server's host key [Default: ~/.ssh/known_hosts]")
+	fs.StringVar(&opts.CSVFile, "file", "", "*Required*  The name of the file to which the output should be written, or '-' for standard output.  The name can include the date and time of the run as strftime-style conversions, e.g. 'users-%Y%m%d.csv'")
+	fs.BoolVar(&opts.Append, "append", false, "Add the users to the rows of an existing CSV 'file', with a 'Run Timestamp' column recording when each was exported, rather than replacing it")
+	fs.BoolVar(&opts.Compress, "compress", false, "Write the output gzip-compressed, adding '.gz' to the file name.  A file name ending in '.gz' is always compressed")
+	fs.StringVar(&opts.BrandingTitle, "branding-title", "", "A title, such as the organization's name, shown at the top of XLSX, PDF and HTML reports")
+	fs.StringVar(&opts.BrandingLogo, "branding-logo", "", "A PNG, JPEG or GIF logo shown at the top of XLSX, PDF and HTML reports")
+	fs.StringVar(&opts.BrandingFooter, "branding-footer", "", "A line of text, e.g. a classification marking, shown at the foot of each page of XLSX, PDF and HTML reports")
+	fs.BoolVar(&opts.Estimate, "estimate", false, "Estimate the number of API calls and the time an export would take, without running it")
+	fs.BoolVar(&opts.DebugFlag, "debug", false, "Enable debug output")
+	fs.StringVar(&opts.LogFormat, "log-format", mmuserlist.LogFormatPlain, "The format of log messages: 'plain', 'text' (key=value pairs) or 'json' (one object per line, for log aggregators)")
+	fs.StringVar(&opts.LogLevel, "log-level", "info", "The least severe log messages to write: 'debug', 'info', 'warning' or 'error'")
+	fs.StringVar(&opts.LogFile, "log-file", "", "Append log messages to this file instead of writing them to stdout and stderr")
+	fs.BoolVar(&opts.LogSensitive, "log-sensitive", false, "Show auth tokens and email addresses in log output instead of masking them")
+	fs.BoolVar(&opts.NoColor, "no-color", false, "Disable colored output, even when writing to a terminal")
+	fs.BoolVar(&opts.NoDefaults, "no-defaults", false, "Don't apply the default filters from the config file's 'default-filters' section")
+	fs.StringVar(&opts.Schedule, "schedule", "", "Stay running and repeat the export on this cron schedule (e.g. '0 6 * * MON'), writing each report to a timestamped copy of the output file")
+	fs.StringVar(&opts.Serve, "serve", "", "Stay running and serve user lists over HTTP on this address (e.g. ':8080'), fetching them on each request")
+	fs.BoolVar(&opts.TUI, "tui", false, "Browse the users in a table that can be sorted and filtered, and export the current view to CSV, instead of writing an output file")
+	fs.StringVar(&opts.ServeToken, "serve-token", "", "A bearer token that requests to 'serve' must present in their Authorization header")
+	fs.StringVar(&opts.MetricsAddress, "metrics-address", "", "With 'schedule', serve Prometheus metrics of the user counts on this address (e.g. ':9100') at /metrics")
+	fs.StringVar(&opts.MetricsDays, "metrics-days", "90", "The comma-separated inactivity thresholds, in days, reported by the mm_team_inactive_users metric")
+	fs.IntVar(&opts.MetricsRefresh, "metrics-refresh", 60, "How old, in minutes, the user counts may be before a scrape of the metrics collects them again")
+	fs.BoolVar(&opts.VersionFlag, "version", false, "Show version information and exit")
+}
+
+// resolveConfig applies the precedence chain (command line > environment > config file > default) to every parameter
+// of a parsed flag set.  The winning value is stored back into the flag, so the variables bound to the flag set hold the
+// effective configuration.  The resolved settings are returned in name order.
+func resolveConfig(fs *flag.FlagSet) ([]configSetting, error) {
+	_, settings, err := resolveConfigFile(fs)
+	return settings, err
+}
+
+// resolveConfigFile resolves the configuration as resolveConfig does, also returning the config file it read (nil if
+// there was none), for its default filters
+func resolveConfigFile(fs *flag.FlagSet) (*configFile, []configSetting, error) {
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	config, err := loadConfigFile(fs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var settings []configSetting
+	var resolveErr error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if nonConfigFlags[f.Name] || resolveErr != nil {
+			return
+		}
+
+		setting := configSetting{
+			Name:      f.Name,
+			Value:     f.Value.String(),
+			Source:    sourceFlag,
+			Sensitive: sensitiveSettings[f.Name],
+		}
+
+		if !explicit[f.Name] {
+			envKey, hasEnv := envSettings[f.Name]
+			envValue, envSet := "", false
+			if hasEnv {
+				envValue, envSet = os.LookupEnv(envKey)
+			}
+
+			fileValue, fileSet := "", false
+			if config != nil {
+				fileValue, fileSet = config.Settings[f.Name]
+			}
+
+			if envSet {
+				setting.Value = envValue
+				setting.Source = sourceEnv + " (" + envKey + ")"
+			} else if fileSet {
+				setting.Value = fileValue
+				setting.Source = config.source(f.Name)
+			} else {
+				setting.Source = sourceDefault
+				if defaultValue, ok := settingDefaults[f.Name]; ok {
+					setting.Value = defaultValue
+				}
+			}
+
+			if err := f.Value.Set(setting.Value); err != nil {
+				resolveErr = fmt.Errorf("invalid value '%s' for '%s' from %s: %w", setting.Value, f.Name, setting.Source, err)
+				return
+			}
+		}
+
+		if setting.Sensitive {
+			mmuserlist.RegisterSecret(setting.Value)
+		}
+
+		settings = append(settings, setting)
+	})
+
+	if resolveErr == nil {
+		resolveErr = applyCloudDefaults(fs, settings)
+	}
+	if resolveErr == nil {
+		resolveErr = applyTokenFile(fs, settings)
+	}
+
+	return config, settings, resolveErr
+}
+
+// applyCloudDefaults swaps the self-hosted defaults for the Cloud ones when the resolved configuration points at a
+// Mattermost Cloud workspace.  Values supplied on the command line or in the environment are left alone.
+func applyCloudDefaults(fs *flag.FlagSet, settings []configSetting) error {
+
+	cloud := fs.Lookup("cloud")
+	url := fs.Lookup("url")
+	if cloud == nil || url == nil {
+		return nil
+	}
+	if cloud.Value.String() != "true" && !mmuserlist.IsCloudHost(url.Value.String()) {
+		return nil
+	}
+
+	for i := range settings {
+		defaultValue, ok := cloudDefaults[settings[i].Name]
+		if !ok || settings[i].Source != sourceDefault {
+			continue
+		}
+		if err := fs.Set(settings[i].Name, defaultValue); err != nil {
+			return err
+		}
+		settings[i].Value = defaultValue
+		settings[i].Source = sourceDefault + " (Mattermost Cloud)"
+	}
+
+	return nil
+}
+
+// applyTokenFile reads the auth token from the file named by the 'token-file' parameter, or from stdin if it is '-', so
+// that the token never has to appear on the command line.  The token found replaces the 'token' setting, which must not
+// be supplied as well.
+func applyTokenFile(fs *flag.FlagSet, settings []configSetting) error {
+
+	tokenFile := fs.Lookup("token-file")
+	token := fs.Lookup("token")
+	if tokenFile == nil || token == nil || tokenFile.Value.String() == "" {
+		return nil
+	}
+	path := tokenFile.Value.String()
+	if token.Value.String() != "" {
+		return errors.New("only one of 'token' and 'token-file' can be supplied")
+	}
+
+	var data []byte
+	var err error
+	source := "token file (" + path + ")"
+	if path == mmuserlist.StdoutPath {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.New("'token-file' is '-' but standard input is a terminal - pipe the token in instead")
+		}
+		source = "standard input"
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+		if info, statErr := os.Stat(path); statErr == nil && info.Mode().Perm()&0077 != 0 {
+			mmuserlist.LogMessage(mmuserlist.WarningLevel, fmt.Sprintf("The token file %s can be read by other users - restrict it with 'chmod 600'", path))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the auth token: %w", err)
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return fmt.Errorf("no auth token found in %s", source)
+	}
+	if err := token.Value.Set(value); err != nil {
+		return err
+	}
+	mmuserlist.RegisterSecret(value)
+
+	for i := range settings {
+		if settings[i].Name == token.Name {
+			settings[i].Value = value
+			settings[i].Source = source
+		}
+	}
+
+	return nil
+}
+
+// runConfigCommand implements the 'config' subcommand and returns the process exit code
+func runConfigCommand(args []string) int {
+
+	if len(args) == 0 || args[0] != "show" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Usage: mm-user-list config show [--effective] [options]")
+		return 1
+	}
+
+	var opts cliOptions
+	var effective bool
+
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	registerFlags(fs, &opts)
+	fs.BoolVar(&effective, "effective", false, "Show every resolved value, including defaults, rather than only those explicitly configured")
+	fs.Parse(args[1:])
+
+	config, settings, err := resolveConfigFile(fs)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SETTING\tVALUE\tSOURCE")
+	for _, setting := range settings {
+		if !effective && strings.HasPrefix(setting.Source, sourceDefault) {
+			continue
+		}
+		value := setting.Value
+		if setting.Sensitive {
+			value = mmuserlist.Redact(value)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", setting.Name, value, setting.Source)
+	}
+	writer.Flush()
+
+	if config != nil && len(config.DefaultFilters) > 0 {
+		fmt.Printf("\nDefault filters (%s), applied to every export unless -no-defaults is given:\n", config.Path)
+		for _, name := range sortedKeys(config.DefaultFilters) {
+			fmt.Printf("  %s=%s\n", name, config.DefaultFilters[name])
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigFile is looked for in the user's home directory when no config file is named
+const defaultConfigFile = ".mm-user-list.yaml"
+
+// profilesKey is the config file key holding the named server profiles
+const profilesKey = "profiles"
+
+// defaultFiltersKey is the config file key holding the organization-wide default filters
+const defaultFiltersKey = "default-filters"
+
+// defaultFilterSettings are the parameters that can be given as default filters.  Each selects users without needing
+// anything else from the command line.
+var defaultFilterSettings = map[string]bool{
+	"include-bots":         true,
+	"include-deactivated":  true,
+	"guests-only":          true,
+	"exclude-guests":       true,
+	"email-domain":         true,
+	"exclude-email-domain": true,
+	"match-username":       true,
+	"match-email":          true,
+	"match-name":           true,
+	"auth-service":         true,
+	"role":                 true,
+	"in-group":             true,
+	"not-in-group":         true,
+	"exclude-file":         true,
+}
+
+// configSections are config file keys that group related parameters.  Each key in a section is read as the parameter
+// named '<section>-<key>', so 'title' under 'branding' sets 'branding-title'.
+var configSections = map[string]bool{
+	"branding": true,
+}
+
+// configFile holds the settings read from a config file, keyed by parameter name, with those of the selected profile
+// (if any) applied over the top-level settings.  The default filters are held separately, since they are applied on
+// top of the export's own filters rather than as parameter values.
+type configFile struct {
+	Path           string
+	Profile        string
+	Settings       map[string]string
+	DefaultFilters map[string]string
+	profileKeys    map[string]bool
+}
+
+// source describes where a config file setting came from, for 'config show'
+func (c *configFile) source(name string) string {
+	if c.profileKeys[name] {
+		return sourceFile + " (" + c.Path + ", profile " + c.Profile + ")"
+	}
+	return sourceFile + " (" + c.Path + ")"
+}
+
+// configFilePath returns the config file to read: the one named by the 'config' parameter or MM_CONFIG, otherwise the
+// default file if it exists.  An empty path means there is no config file.
+func configFilePath(fs *flag.FlagSet) (path string, named bool) {
+
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		return f.Value.String(), true
+	}
+	if value, ok := os.LookupEnv("MM_CONFIG"); ok && value != "" {
+		return value, true
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	path = filepath.Join(home, defaultConfigFile)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, false
+}
+
+// profileName returns the profile requested by the 'profile' parameter or MM_PROFILE, if any
+func profileName(fs *flag.FlagSet) string {
+
+	if f := fs.Lookup("profile"); f != nil && f.Value.String() != "" {
+		return f.Value.String()
+	}
+	return os.Getenv("MM_PROFILE")
+}
+
+// loadConfigFile reads the YAML config file for a flag set.  Each key is a parameter name, as used on the command
+// line; a list value (e.g. for 'tag') is joined with commas.  A file named explicitly must exist.
+//
+// Named server profiles are held under the 'profiles' key, each with its own settings.  The profile named by the
+// 'profile' parameter, MM_PROFILE or the file's own 'profile' setting is applied over the top-level settings.
+func loadConfigFile(fs *flag.FlagSet) (*configFile, error) {
+
+	path, named := configFilePath(fs)
+	profile := profileName(fs)
+	if path == "" {
+		if profile != "" {
+			return nil, fmt.Errorf("profile '%s' was requested, but there is no config file", profile)
+		}
+		return nil, nil
+	}
+
+	mmuserlist.DebugPrint("Loading config file: " + path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !named && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	profiles, ok := values[profilesKey].(map[interface{}]interface{})
+	if _, found := values[profilesKey]; found && !ok {
+		return nil, fmt.Errorf("config file %s: '%s' must map each profile name to its settings", path, profilesKey)
+	}
+	delete(values, profilesKey)
+
+	defaultFilters, ok := values[defaultFiltersKey].(map[interface{}]interface{})
+	if _, found := values[defaultFiltersKey]; found && !ok && values[defaultFiltersKey] != nil {
+		return nil, fmt.Errorf("config file %s: '%s' must be a map of filter settings", path, defaultFiltersKey)
+	}
+	delete(values, defaultFiltersKey)
+
+	config := &configFile{Path: path, Settings: make(map[string]string), DefaultFilters: make(map[string]string)}
+	if err := parseSettings(fs, path, values, config.Settings); err != nil {
+		return nil, err
+	}
+
+	filterValues := make(map[string]interface{}, len(defaultFilters))
+	for key, value := range defaultFilters {
+		if !defaultFilterSettings[fmt.Sprint(key)] {
+			return nil, fmt.Errorf("config file %s: '%v' can't be used as a default filter", path, key)
+		}
+		filterValues[fmt.Sprint(key)] = value
+	}
+	if err := parseSettings(fs, path, filterValues, config.DefaultFilters); err != nil {
+		return nil, err
+	}
+
+	if profile == "" {
+		profile = config.Settings["profile"]
+	}
+	if profile != "" {
+		profileValues, found := profiles[profile]
+		if !found {
+			names := make([]string, 0, len(profiles))
+			for name := range profiles {
+				names = append(names, fmt.Sprint(name))
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("config file %s has no profile '%s' (profiles: %s)", path, profile, strings.Join(names, ", "))
+		}
+		settings, ok := profileValues.(map[interface{}]interface{})
+		if !ok && profileValues != nil {
+			return nil, fmt.Errorf("config file %s: profile '%s' must be a map of settings", path, profile)
+		}
+		profileSettings := make(map[string]interface{}, len(settings))
+		config.profileKeys = make(map[string]bool, len(settings))
+		for key, value := range settings {
+			profileSettings[fmt.Sprint(key)] = value
+			config.profileKeys[fmt.Sprint(key)] = value != nil
+			if section, ok := value.(map[interface{}]interface{}); ok && configSections[fmt.Sprint(key)] {
+				for sectionKey, sectionValue := range section {
+					config.profileKeys[fmt.Sprint(key)+"-"+fmt.Sprint(sectionKey)] = sectionValue != nil
+				}
+			}
+		}
+		if _, found := profileSettings["profile"]; found {
+			return nil, fmt.Errorf("config file %s: profile '%s' can't select another profile", path, profile)
+		}
+		mmuserlist.DebugPrint("Applying config file profile: " + profile)
+		if err := parseSettings(fs, path, profileSettings, config.Settings); err != nil {
+			return nil, err
+		}
+		config.Profile = profile
+	}
+
+	// Tokens shouldn't be readable by other users
+	if _, hasToken := config.Settings["token"]; hasToken {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			mmuserlist.LogMessage(mmuserlist.WarningLevel, fmt.Sprintf("The config file %s holds a token but can be read by other users - restrict it with 'chmod 600'", path))
+		}
+	}
+
+	return config, nil
+}
+
+// parseSettings converts the values read from a config file, or one of its profiles, to parameter values and stores
+// them in settings
+func parseSettings(fs *flag.FlagSet, path string, values map[string]interface{}, settings map[string]string) error {
+
+	for key, value := range values {
+		if configSections[key] {
+			section, ok := value.(map[interface{}]interface{})
+			if !ok && value != nil {
+				return fmt.Errorf("config file %s: '%s' must be a map of settings", path, key)
+			}
+			sectionValues := make(map[string]interface{}, len(section))
+			for sectionKey, sectionValue := range section {
+				sectionValues[key+"-"+fmt.Sprint(sectionKey)] = sectionValue
+			}
+			if err := parseSettings(fs, path, sectionValues, settings); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f := fs.Lookup(key)
+		if f == nil || nonConfigFlags[key] || key == "config" {
+			return fmt.Errorf("config file %s: unknown setting '%s'", path, key)
+		}
+
+		switch value := value.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			settings[key] = strings.Join(items, ",")
+		case map[interface{}]interface{}:
+			// A map is written as key=value pairs, in key order, for settings such as 'tag'
+			pairs := make([]string, 0, len(value))
+			for itemKey, itemValue := range value {
+				pairs = append(pairs, fmt.Sprintf("%v=%v", itemKey, itemValue))
+			}
+			sort.Strings(pairs)
+			settings[key] = strings.Join(pairs, ",")
+		default:
+			settings[key] = fmt.Sprint(value)
+		}
+	}
+
+	return nil
+}
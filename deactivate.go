@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// confirmAction asks the user to confirm a change by typing 'yes'.  Anything else, or a closed input, declines.
+func confirmAction(prompt string) bool {
+	// The prompt goes to stderr when the user list is being streamed to stdout
+	promptOutput := os.Stdout
+	if mmuserlist.LogToStderr {
+		promptOutput = os.Stderr
+	}
+	fmt.Fprintf(promptOutput, "%s Type 'yes' to continue: ", prompt)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(promptOutput)
+		return false
+	}
+
+	return strings.EqualFold(strings.TrimSpace(answer), "yes")
+}
+
+// deactivateInactiveUsers carries out the 'deactivate-after' action on the selected users, once confirmed, and
+// returns the process exit code
+func deactivateInactiveUsers(mmClient *model.Client4, users []*mmuserlist.User, opts *cliOptions) int {
+
+	if !opts.DryRun && !opts.AssumeYes {
+		prompt := fmt.Sprintf("About to deactivate %d users inactive for more than %d days (listed in %s).", len(users), opts.DeactivateAfter, opts.outputName())
+		if !confirmAction(prompt) {
+			mmuserlist.LogMessage(mmuserlist.WarningLevel, "Deactivation cancelled - no users were changed")
+			return 0
+		}
+	}
+
+	count, err := mmuserlist.DeactivateUsers(mmClient, users, opts.DryRun)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("Deactivation incomplete - %d users deactivated: %s", count, err.Error()))
+		return 2
+	}
+	if opts.DryRun {
+		mmuserlist.LogSummary(fmt.Sprintf("Dry run complete - %d users would be deactivated", count))
+	} else {
+		mmuserlist.LogSummary(fmt.Sprintf("Deactivation complete - %d users deactivated", count))
+	}
+
+	return 0
+}
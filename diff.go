@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// diffSampleSize is the number of usernames listed for each kind of change in a diff preview
+const diffSampleSize = 5
+
+// diffSample lists the first few of a set of usernames, noting how many more there are
+func diffSample(keys []string) string {
+	if len(keys) <= diffSampleSize {
+		return strings.Join(keys, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(keys[:diffSampleSize], ", "), len(keys)-diffSampleSize)
+}
+
+// previewReportDiff summarises how the users about to be written differ from the existing output file, if there is
+// one, and with 'confirm-diff' asks before the file is replaced.  It returns false if the file should be left as it is.
+func previewReportDiff(users []*mmuserlist.User, opts *cliOptions) bool {
+
+	if opts.CSVFile == mmuserlist.StdoutPath {
+		return true
+	}
+	if _, err := os.Stat(opts.CSVFile); errors.Is(err, os.ErrNotExist) {
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "Diff preview: "+opts.CSVFile+" doesn't exist yet, so there is nothing to compare")
+		return true
+	}
+
+	diff, err := mmuserlist.DiffReport(opts.CSVFile, users, opts.output())
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "Diff preview unavailable: "+err.Error())
+		return !opts.ConfirmDiff || confirmAction(fmt.Sprintf("About to replace %s, which couldn't be compared.", opts.CSVFile))
+	}
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Diff preview for %s: %d rows now, %d before - %d added, %d removed, %d modified",
+		opts.CSVFile, diff.NewRows, diff.ExistingRows, len(diff.Added), len(diff.Removed), len(diff.Modified)))
+	if len(diff.Added) > 0 {
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "  Added: "+diffSample(diff.Added))
+	}
+	if len(diff.Removed) > 0 {
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "  Removed: "+diffSample(diff.Removed))
+	}
+	if len(diff.Modified) > 0 {
+		var columns []string
+		for _, column := range diff.ChangedColumnNames() {
+			columns = append(columns, fmt.Sprintf("%s (%d)", column, diff.ChangedColumns[column]))
+		}
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "  Changed columns: "+strings.Join(columns, ", "))
+	}
+	if diff.Shrinks() {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, fmt.Sprintf("The report would shrink from %d to %d rows - check the team and filters if that isn't expected", diff.ExistingRows, diff.NewRows))
+	}
+
+	if opts.ConfirmDiff && !confirmAction(fmt.Sprintf("About to replace %s.", opts.CSVFile)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// smtpSettings returns the mail server settings used by 'email-report'
+func (opts *cliOptions) smtpSettings() mmuserlist.SMTPSettings {
+	return mmuserlist.SMTPSettings{
+		Host:               opts.SMTPHost,
+		Username:           opts.SMTPUsername,
+		Password:           opts.SMTPPassword,
+		From:               opts.SMTPFrom,
+		To:                 strings.FieldsFunc(opts.SMTPTo, func(r rune) bool { return r == ',' || r == ' ' }),
+		TLSMode:            opts.SMTPTLS,
+		InsecureSkipVerify: opts.SMTPInsecureSkipVerify,
+	}
+}
+
+// validateEmail checks the 'email-report' parameters, logging each problem, and reports whether they are valid
+func (opts *cliOptions) validateEmail() bool {
+
+	// The SMTP settings are often kept in the config file, so they're only checked when a report is to be sent
+	if !opts.EmailReport {
+		return true
+	}
+
+	valid := true
+
+	settings := opts.smtpSettings()
+	if settings.Host == "" || settings.From == "" || len(settings.To) == 0 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'email-report' option needs 'smtp-host', 'smtp-from' and 'smtp-to'")
+		valid = false
+	}
+	if settings.TLSMode != mmuserlist.SMTPStartTLS && settings.TLSMode != mmuserlist.SMTPTLS && settings.TLSMode != mmuserlist.SMTPNoTLS {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The SMTP TLS mode must be one of 'starttls', 'tls' or 'none'")
+		valid = false
+	}
+	if opts.CSVFile == mmuserlist.StdoutPath {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The report can't be emailed when it is written to standard output")
+		valid = false
+	}
+	return valid
+}
+
+// emailReport sends the output file to the 'smtp-to' recipients, with a summary of the run as the message body
+func emailReport(fs *flag.FlagSet, opts *cliOptions, started time.Time, rows int) error {
+
+	manifest := newRunManifest(fs, opts, started, rows)
+
+	subject := opts.EmailSubject
+	if subject == "" {
+		subject = fmt.Sprintf("Mattermost user report: %s (%d rows)", filepath.Base(opts.CSVFile), rows)
+	}
+
+	body := []string{
+		"The attached report was written by mm-user-list " + manifest.Version + ".",
+		"",
+		"Server:    " + manifest.Server,
+		"Rows:      " + fmt.Sprint(rows),
+		"Started:   " + manifest.StartedAt,
+		"Completed: " + manifest.CompletedAt,
+	}
+	if manifest.SHA256 != "" {
+		body = append(body, "SHA-256:   "+manifest.SHA256)
+	}
+	if len(opts.Tags) > 0 {
+		body = append(body, "Tags:      "+opts.Tags.String())
+	}
+	if len(opts.gaps.Gaps()) > 0 {
+		body = append(body, "", "INCOMPLETE DATA: some users couldn't be retrieved and are missing from the report - see the log for details.")
+	}
+
+	if err := mmuserlist.EmailReport(opts.smtpSettings(), subject, strings.Join(body, "\n")+"\n", opts.CSVFile); err != nil {
+		return err
+	}
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, "Report emailed to "+opts.SMTPTo)
+	return nil
+}
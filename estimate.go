@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// crawlEstimate is the predicted cost of an export
+type crawlEstimate struct {
+	Users        int64
+	UsersIsBound bool
+	PageSize     int
+	Pagination   string
+	Calls        int64
+	ExtraLookups bool
+	Latency      time.Duration
+}
+
+// Duration returns the estimated wall-clock time for the export, assuming requests are made one at a time
+func (e *crawlEstimate) Duration() time.Duration {
+	return time.Duration(e.Calls) * e.Latency
+}
+
+// pagesFor returns the number of pages required to retrieve count items at the given page size
+func pagesFor(count int64, perPage int) int64 {
+	if count <= 0 {
+		return 1
+	}
+	// A final, short (or empty) page is always needed to detect the end of the list
+	return count/int64(perPage) + 1
+}
+
+// EstimateCrawl uses the server's user and team statistics to predict how many API calls an export will make and
+// roughly how long it will take, without retrieving any users.  A single sample request is timed to estimate latency.
+func EstimateCrawl(mmClient *model.Client4, opts *cliOptions) (*crawlEstimate, error) {
+
+	mmuserlist.DebugPrint("In EstimateCrawl")
+
+	ctx := context.Background()
+	estimate := &crawlEstimate{PageSize: mmuserlist.PageSize, Pagination: mmuserlist.PaginationOffset}
+
+	teamID := ""
+	var teams []*model.Team
+	var err error
+
+	if opts.AllTeams {
+		teams, err = mmuserlist.GetAllTeams(mmClient)
+		estimate.Calls += pagesFor(int64(len(teams)), mmuserlist.PageSize)
+	} else if opts.MattermostTeam != "" {
+		teams, err = mmuserlist.ResolveTeams(mmClient, mmuserlist.SplitTeamNames(opts.MattermostTeam))
+		estimate.Calls += int64(len(teams))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var teamCounts []int64
+
+	if len(teams) > 0 {
+		start := time.Now()
+		for _, team := range teams {
+			stats, response, err := mmClient.GetTeamStats(ctx, team.Id, "")
+			if err != nil {
+				mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Error returned from GetTeamStats(): "+err.Error())
+				return nil, err
+			}
+			if response.StatusCode != 200 {
+				mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Bad HTTP response returned from GetTeamStats()")
+				return nil, errors.New("failed to retrieve data from Mattermost")
+			}
+			estimate.Users += stats.TotalMemberCount
+			teamCounts = append(teamCounts, stats.TotalMemberCount)
+		}
+		estimate.Latency = time.Since(start) / time.Duration(len(teams))
+
+		if len(teams) == 1 {
+			teamID = teams[0].Id
+		}
+	} else if !opts.AllTeams {
+		// There are no statistics for users without a team, so the total user count is an upper bound
+		start := time.Now()
+		stats, response, err := mmClient.GetTotalUsersStats(ctx, "")
+		estimate.Latency = time.Since(start)
+
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Error returned from GetTotalUsersStats(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Bad HTTP response returned from GetTotalUsersStats()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+		estimate.Users = stats.TotalUsersCount
+		estimate.UsersIsBound = true
+	}
+
+	// A one-user probe shows whether the cursor API will be used, and gives a second latency sample
+	if opts.Pagination != mmuserlist.PaginationOffset {
+		start := time.Now()
+		_, response, err := mmClient.GetUsersForReporting(ctx, &model.UserReportOptions{
+			ReportingBaseOptions: model.ReportingBaseOptions{
+				Direction:  "next",
+				PageSize:   1,
+				SortColumn: "Username",
+			},
+			Team:      teamID,
+			HasNoTeam: opts.NotInTeam,
+		})
+		if err == nil {
+			estimate.Latency = (estimate.Latency + time.Since(start)) / 2
+			estimate.Pagination = mmuserlist.PaginationCursor
+			estimate.PageSize = model.ReportingMaxPageSize
+		} else if response == nil || !mmuserlist.CursorAPIUnsupported(response.StatusCode) {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Error returned from GetUsersForReporting(): "+err.Error())
+			return nil, err
+		}
+	}
+
+	if len(teamCounts) > 0 {
+		// Each team is paged separately
+		for _, count := range teamCounts {
+			estimate.Calls += pagesFor(count, estimate.PageSize)
+		}
+	} else {
+		estimate.Calls += pagesFor(estimate.Users, estimate.PageSize)
+	}
+	estimate.Calls += (estimate.Users + mmuserlist.StatusBatchSize - 1) / mmuserlist.StatusBatchSize
+	estimate.ExtraLookups = opts.InGroup != "" || opts.NotInGroup != "" || opts.InChannel != "" || opts.NotInChannel != ""
+
+	return estimate, nil
+}
+
+// logEstimate reports a crawl estimate to the user
+func logEstimate(estimate *crawlEstimate) {
+	users := fmt.Sprintf("%d", estimate.Users)
+	if estimate.UsersIsBound {
+		users = fmt.Sprintf("up to %d", estimate.Users)
+	}
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Users to retrieve: %s", users))
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Pagination: %s (%d users per page)", estimate.Pagination, estimate.PageSize))
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Estimated API calls: %d", estimate.Calls))
+	if estimate.ExtraLookups {
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "Group and channel filters add further calls, one per page of their members")
+	}
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Measured latency: %s per request", estimate.Latency.Round(time.Millisecond)))
+	mmuserlist.LogSummary(fmt.Sprintf("Estimated duration: %s", estimate.Duration().Round(time.Second)))
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist/mmtest"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// testTeamSize is the number of members of the main test team, chosen to span several pages at the default page
+// size and to leave a short final page
+const testTeamSize = 150
+
+// exportScenario is one export run against the fake server, with the number of users it should write and,
+// optionally, a column that must be filled in on every row.  Config holds the contents of the config file, if any, and
+// Verify checks what the export did to the server.
+type exportScenario struct {
+	Name     string
+	Setup    func(server *mmtest.Server)
+	Config   string
+	Settings map[string]string
+	Expected int
+	Filled   string
+	Verify   func(server *mmtest.Server) error
+}
+
+// exportScenarios are the exports run by TestExportScenarios.  The fake server holds 150 members of 'sales',
+// 30 members of 'support' (10 of them also in 'sales'), 5 users without a team and a 'town-square' channel in 'sales'
+// with 20 members.
+var exportScenarios = []exportScenario{
+	{
+		Name:     "team, offset pagination",
+		Settings: map[string]string{"team": "sales", "pagination": mmuserlist.PaginationOffset},
+		Expected: testTeamSize,
+	},
+	{
+		Name:     "team, cursor pagination",
+		Setup:    func(server *mmtest.Server) { server.Cursor = true },
+		Settings: map[string]string{"team": "sales", "pagination": mmuserlist.PaginationCursor},
+		Expected: testTeamSize,
+	},
+	{
+		Name:     "team, auto pagination falling back to offset",
+		Settings: map[string]string{"team": "sales"},
+		Expected: testTeamSize,
+	},
+	{
+		Name:     "team, concurrent pages",
+		Settings: map[string]string{"team": "sales", "pagination": mmuserlist.PaginationOffset, "concurrency": "4"},
+		Expected: testTeamSize,
+	},
+	{
+		Name:     "team, rate limited",
+		Setup:    func(server *mmtest.Server) { server.RateLimitEvery = 3 },
+		Settings: map[string]string{"team": "sales", "pagination": mmuserlist.PaginationOffset},
+		Expected: testTeamSize,
+	},
+	{
+		Name:     "team, flaky page retried",
+		Setup:    func(server *mmtest.Server) { server.FailPage(1, mmuserlist.PageSize, 2) },
+		Settings: map[string]string{"team": "sales", "pagination": mmuserlist.PaginationOffset},
+		Expected: testTeamSize,
+	},
+	{
+		Name:     "team, failing user skipped",
+		Setup:    func(server *mmtest.Server) { server.FailUser(server.Users()[75]) },
+		Settings: map[string]string{"team": "sales", "pagination": mmuserlist.PaginationOffset},
+		Expected: testTeamSize - 1,
+	},
+	{
+		Name:     "users without a team",
+		Settings: map[string]string{"not-in-team": "true"},
+		Expected: 5,
+	},
+	{
+		Name: "users who never logged in",
+		Setup: func(server *mmtest.Server) {
+			server.AddUser("pending000", 0)
+			server.AddUser("pending001", 0)
+			server.AddSession(server.AddUser("pending002", 0))
+		},
+		Settings: map[string]string{"not-in-team": "true", "never-logged-in": "true"},
+		Expected: 2,
+	},
+	{
+		Name:     "channel members",
+		Settings: map[string]string{"channel": "sales/town-square"},
+		Expected: 20,
+	},
+	{
+		Name:     "all teams, merged, as JSON",
+		Settings: map[string]string{"all-teams": "true", "merge-teams": "true", "format": mmuserlist.FormatJSON},
+		Expected: testTeamSize + 20,
+	},
+	{
+		Name:     "email domain",
+		Settings: map[string]string{"all-teams": "true", "merge-teams": "true", "email-domain": "contractor.example"},
+		Expected: 5,
+	},
+	{
+		Name:     "excluded email domain",
+		Settings: map[string]string{"team": "support", "exclude-email-domain": "contractor.example"},
+		Expected: 25,
+	},
+	{
+		Name:     "username and name patterns",
+		Settings: map[string]string{"team": "support", "match-username": "^support01", "match-name": "(?i)^SUPPORT\\d+ test$"},
+		Expected: 10,
+	},
+	{
+		Name:     "default filters",
+		Config:   "default-filters:\n  exclude-email-domain: contractor.example\n",
+		Settings: map[string]string{"team": "support"},
+		Expected: 25,
+	},
+	{
+		Name:     "default filters skipped",
+		Config:   "default-filters:\n  exclude-email-domain: contractor.example\n",
+		Settings: map[string]string{"team": "support", "no-defaults": "true"},
+		Expected: 30,
+	},
+	{
+		Name:     "team join dates",
+		Settings: map[string]string{"team": "sales", "team-join-date": "true"},
+		Expected: testTeamSize,
+		Filled:   "Team Join Date",
+	},
+	{
+		Name:     "team join dates from join messages",
+		Setup:    func(server *mmtest.Server) { server.HideJoinTimes = true },
+		Settings: map[string]string{"channel": "sales/town-square", "team-join-date": "true"},
+		Expected: 20,
+		Filled:   "Team Join Date",
+	},
+	{
+		Name:     "inactive users",
+		Settings: map[string]string{"team": "support", "inactive-days": "30"},
+		Expected: 15,
+	},
+	{
+		Name:     "inactive users notified",
+		Settings: map[string]string{"team": "support", "notify-inactive": "30", "yes": "true"},
+		Expected: 15,
+		Verify:   verifyDirectMessages(15),
+	},
+	{
+		Name:     "inactive users notified, dry run",
+		Settings: map[string]string{"team": "support", "notify-inactive": "30", "dry-run": "true"},
+		Expected: 15,
+		Verify:   verifyDirectMessages(0),
+	},
+}
+
+// populateTestServer loads the fake server with the data the scenarios expect.  Everyone is active except for 15
+// of the 'support' members, who were last active 90 days ago.  The other 5 'support' members are contractors, with
+// email addresses in a different domain.
+func populateTestServer(server *mmtest.Server) {
+
+	sales := server.AddTeam("sales")
+	support := server.AddTeam("support")
+
+	now := time.Now()
+	recent := now.AddDate(0, 0, -1).UnixMilli()
+	stale := now.AddDate(0, 0, -90).UnixMilli()
+
+	var townSquare []*model.User
+	for i := 0; i < testTeamSize; i++ {
+		teams := []*model.Team{sales}
+		if i < 10 {
+			teams = append(teams, support)
+		}
+		user := server.AddUser(fmt.Sprintf("sales%03d", i), recent, teams...)
+		if i < 20 {
+			townSquare = append(townSquare, user)
+		}
+	}
+	server.AddChannel(sales, "town-square", townSquare...)
+
+	for i := 0; i < 20; i++ {
+		lastActivity := recent
+		if i < 15 {
+			lastActivity = stale
+		}
+		user := server.AddUser(fmt.Sprintf("support%03d", i), lastActivity, support)
+		if i >= 15 {
+			user.Email = user.Username + "@Contractor.example"
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		server.AddUser(fmt.Sprintf("nobody%03d", i), recent)
+	}
+}
+
+// runExportScenario starts a fake server, runs one scenario's export against it and returns an error if the export
+// fails or writes the wrong number of users
+func runExportScenario(scenario exportScenario, dir string) error {
+
+	server := mmtest.NewServer()
+	defer server.Close()
+
+	populateTestServer(server)
+	if scenario.Setup != nil {
+		scenario.Setup(server)
+	}
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		return err
+	}
+
+	format := scenario.Settings["format"]
+	if format == "" {
+		format = mmuserlist.FormatCSV
+	}
+	outputFile := filepath.Join(dir, "users."+format)
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte(scenario.Config), 0600); err != nil {
+		return err
+	}
+
+	settings := map[string]string{
+		"config": configFile,
+		"url":    serverURL.Hostname(),
+		"port":   serverURL.Port(),
+		"scheme": serverURL.Scheme,
+		"token":  "test",
+		"file":   outputFile,
+	}
+	for name, value := range scenario.Settings {
+		settings[name] = value
+	}
+	fs, opts, err := newExportFlagSet("test", settings)
+	if err != nil {
+		return err
+	}
+
+	if exitCode := runExport(fs, opts); exitCode != 0 {
+		return fmt.Errorf("export failed with exit code %d", exitCode)
+	}
+
+	count, err := countOutputUsers(outputFile, format, scenario.Filled)
+	if err != nil {
+		return err
+	}
+	if count != scenario.Expected {
+		return fmt.Errorf("expected %d users, but %d were written", scenario.Expected, count)
+	}
+
+	if scenario.Verify != nil {
+		return scenario.Verify(server)
+	}
+	return nil
+}
+
+// verifyDirectMessages returns a check that the export sent one direct message to each of the expected number of users
+func verifyDirectMessages(expected int) func(server *mmtest.Server) error {
+	return func(server *mmtest.Server) error {
+		messages := server.DirectMessages()
+		if len(messages) != expected {
+			return fmt.Errorf("expected %d users to be messaged, but %d were", expected, len(messages))
+		}
+		for username, sent := range messages {
+			if len(sent) != 1 || !strings.Contains(sent[0], username) {
+				return fmt.Errorf("expected one message naming %s, but got %q", username, sent)
+			}
+		}
+		return nil
+	}
+}
+
+// countOutputUsers returns the number of users in a CSV or JSON output file, checking that the named CSV column, if
+// any, is filled in on every row
+func countOutputUsers(path string, format string, filled string) (int, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if format == mmuserlist.FormatJSON {
+		var records []map[string]interface{}
+		if err := json.NewDecoder(file).Decode(&records); err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		return len(records), nil
+	}
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	if filled != "" {
+		column := -1
+		for i, header := range rows[0] {
+			if header == filled {
+				column = i
+			}
+		}
+		if column < 0 {
+			return 0, fmt.Errorf("there is no '%s' column", filled)
+		}
+		for _, row := range rows[1:] {
+			if row[column] == "" {
+				return 0, fmt.Errorf("'%s' is blank for %s", filled, row[0])
+			}
+		}
+	}
+	return len(rows) - 1, nil
+}
+
+// TestExportScenarios runs each of the exportScenarios against a fake Mattermost server, checking what it writes
+func TestExportScenarios(t *testing.T) {
+
+	// The fake server fails pages immediately, so there's no need to wait long before retrying them
+	retryDelay := mmuserlist.PageRetryDelay
+	mmuserlist.PageRetryDelay = 10 * time.Millisecond
+	defer func() { mmuserlist.PageRetryDelay = retryDelay }()
+
+	for _, scenario := range exportScenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			if err := runExportScenario(scenario, t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+	"golang.org/x/term"
+)
+
+// connect creates the Mattermost client, with any TLS and proxy settings, and, when a login ID was supplied in place of
+// a token, logs in.  A password or MFA code that wasn't supplied is prompted for, on stderr, if stdin is a terminal.
+func (opts *cliOptions) connect() (*model.Client4, error) {
+
+	connection := opts.connection()
+	tlsConfig, err := mmuserlist.LoadTLSConfig(opts.tlsOptions())
+	if err != nil {
+		return nil, err
+	}
+	connection.TLSConfig = tlsConfig
+	connection.Proxy, err = mmuserlist.ParseProxyURL(opts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	mmClient := mmuserlist.NewClient(connection)
+	if opts.LoginID == "" {
+		return mmClient, nil
+	}
+
+	password := opts.Password
+	if password == "" {
+		password, err = promptInput("Password for "+opts.LoginID+": ", true)
+		if err != nil {
+			return nil, fmt.Errorf("no password supplied: %w", err)
+		}
+		mmuserlist.RegisterSecret(password)
+	}
+
+	err = mmuserlist.Login(mmClient, opts.LoginID, password, opts.MFACode)
+	if errors.Is(err, mmuserlist.ErrMFARequired) {
+		code, promptErr := promptInput("MFA code: ", false)
+		if promptErr != nil {
+			return nil, fmt.Errorf("%w: %w", err, promptErr)
+		}
+		err = mmuserlist.Login(mmClient, opts.LoginID, password, code)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+
+	return mmClient, nil
+}
+
+// promptInput asks for a value on the terminal, without echoing it if it is secret
+func promptInput(prompt string, secret bool) (string, error) {
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errors.New("standard input is not a terminal to prompt on")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+
+	var value string
+	var err error
+	if secret {
+		var input []byte
+		input, err = term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		value = string(input)
+	} else {
+		value, err = bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+	if err != nil {
+		return "", err
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("nothing was entered")
+	}
+	return value, nil
+}
@@ -1,32 +1,19 @@
 package main
 
 import (
-	"context"
-	"encoding/csv"
-	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"golang.org/x/term"
 )
 
 var Version = "development" // Default value - overwritten during bild process
 
-var debugMode bool = false
-
-// LogLevel is used to refer to the type of message that will be written using the logging code.
-type LogLevel string
-
-type mmConnection struct {
-	mmURL    string
-	mmPort   string
-	mmScheme string
-	mmToken  string
-}
-
 type User struct {
 	UserID                string
 	Username              string
@@ -36,401 +23,541 @@ type User struct {
 	DaysSinceLastActivity int
 }
 
-type MMUser struct {
-	UserID                string
-	Username              string
-	Email                 string
-	FirstName             string
-	LastName              string
-	Nickname              string
-	IsBotAccount          bool
-	UserCreatedAt         time.Time
-	LastActivityAt        time.Time
-	DaysSinceLastActivity int
-	TeamName              string
-}
-
-const (
-	debugLevel   LogLevel = "DEBUG"
-	infoLevel    LogLevel = "INFO"
-	warningLevel LogLevel = "WARNING"
-	errorLevel   LogLevel = "ERROR"
-)
+func main() {
 
-const (
-	defaultPort   = "8065"
-	defaultScheme = "http"
-	pageSize      = 60
-	maxErrors     = 3
-)
+	// Parse Command Line
+	mmuserlist.DebugPrint("Parsing command line")
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(runConfigCommand(os.Args[2:]))
+		case "bench":
+			os.Exit(runBenchCommand(os.Args[2:]))
+		case "users":
+			os.Exit(runUsersCommand(os.Args[2:]))
+		case "offboard":
+			os.Exit(runOffboardCommand(os.Args[2:]))
+		case "batch":
+			os.Exit(runBatchCommand(os.Args[2:]))
+		case "version":
+			printVersion()
+			os.Exit(0)
+		case "help":
+			os.Exit(runHelpCommand(os.Args[2:]))
+		}
+		if command, found := lookupExportCommand(os.Args[1]); found {
+			os.Exit(command.run(os.Args[2:]))
+		}
+	}
 
-// Logging functions
+	var opts cliOptions
+	registerFlags(flag.CommandLine, &opts)
 
-// LogMessage logs a formatted message to stdout or stderr
-func LogMessage(level LogLevel, message string) {
-	if level == errorLevel {
-		log.SetOutput(os.Stderr)
-	} else {
-		log.SetOutput(os.Stdout)
-	}
-	log.SetFlags(log.Ldate | log.Ltime)
-	log.Printf("[%s] %s\n", level, message)
-}
+	flag.Parse()
 
-// DebugPrint allows us to add debug messages into our code, which are only printed if we're running in debug more.
-// Note that the command line parameter '-debug' can be used to enable this at runtime.
-func DebugPrint(message string) {
-	if debugMode {
-		LogMessage(debugLevel, message)
+	if opts.VersionFlag {
+		printVersion()
+		os.Exit(0)
 	}
-}
 
-// getEnvWithDefaults allows us to retrieve Environment variables, and to return either the current value or a supplied default
-func getEnvWithDefault(key string, defaultValue interface{}) interface{} {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		return defaultValue
-	}
-	return value
+	os.Exit(runParsedExport(flag.CommandLine, &opts))
 }
 
-// GetUsersNotInTeam returns a list of all Mattermost users who are without a team assignment
-func GetUsersNotInTeam(mmClient *model.Client4, includeBots bool) ([]*MMUser, error) {
-
-	DebugPrint("In GetUsersNotInTeam")
-
-	ctx := context.Background()
-	page := 0
-	perPage := pageSize
-	etag := ""
-
-	var allUsers []*model.User
+// runExport resolves the configuration for a parsed flag set, then runs the export it describes, returning the process
+// exit code
+func runExport(fs *flag.FlagSet, opts *cliOptions) (exitCode int) {
 
-	for {
-		users, response, err := mmClient.GetUsersWithoutTeam(ctx, page, perPage, etag)
-
-		if err != nil {
-			LogMessage(errorLevel, "Error returned from GetUsersWithoutTeam(): "+err.Error())
-			return nil, err
-		}
-		if response.StatusCode != 200 {
-			errMsg := fmt.Sprintf("Bad HTTP response returned from GetUsersWithoutTeam() (page %d)", page)
-			LogMessage(errorLevel, errMsg)
-			return nil, errors.New("failed to retrieve data from Mattermost")
-		}
-
-		if len(users) < perPage {
-			allUsers = append(allUsers, users...)
-			break
+	// Resolve each parameter from the command line, the envrionment or the defaults, in that order of precedence
+	config, _, err := resolveConfigFile(fs)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		fs.Usage()
+		return 1
+	}
+	if !opts.sharedLogging {
+		if err := applyLoggingOptions(opts); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+			fs.Usage()
+			return 1
 		}
-
-		allUsers = append(allUsers, users...)
-
-		page++
 	}
 
-	var userList []*MMUser
+	DebugMessage := fmt.Sprintf("Parameters: \n  MattermostURL=%s\n  MattermostPort=%s\n  MattermostScheme=%s\n  MattermostToken=%s\n  Team=%s\n  CSV File=%s",
+		opts.MattermostURL,
+		opts.MattermostPort,
+		opts.MattermostScheme,
+		opts.MattermostToken,
+		opts.MattermostTeam,
+		opts.CSVFile)
+	mmuserlist.DebugPrint(DebugMessage)
+	if opts.NotInTeam {
+		mmuserlist.DebugPrint("'Not In Team' flag is set")
+	}
+	if opts.IncludeBots {
+		mmuserlist.DebugPrint("'Include Bots' flag is set")
+	}
 
-	for _, mmUser := range allUsers {
-		if mmUser.IsBot && !includeBots {
-			continue
+	// Validate required parameters
+	mmuserlist.DebugPrint("Validating parameters")
+	var cliErrors bool = !opts.validateConnection()
+	// if MattermostTeam == "" {
+	// 	mmuserlist.LogMessage(mmuserlist.ErrorLevel, "A Mattermost team name is required to use this utility.")
+	// 	cliErrors = true
+	// }
+	if opts.CSVFile == "" && !opts.Estimate && !opts.TUI {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "A CSV output file must be specified")
+		cliErrors = true
+	}
+	if opts.Pagination != mmuserlist.PaginationAuto && opts.Pagination != mmuserlist.PaginationOffset && opts.Pagination != mmuserlist.PaginationCursor {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The pagination method must be one of 'auto', 'offset' or 'cursor'")
+		cliErrors = true
+	}
+	if opts.Concurrency < 1 || opts.Concurrency > mmuserlist.MaxConcurrency {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("The concurrency must be between 1 and %d", mmuserlist.MaxConcurrency))
+		cliErrors = true
+	}
+	if opts.Concurrency > 1 && opts.Pagination == mmuserlist.PaginationCursor {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Cursor pagination fetches one page at a time, so 'concurrency' can only be used with 'offset' or 'auto' pagination")
+		cliErrors = true
+	}
+	if opts.AdaptiveConcurrency && opts.Concurrency < 2 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "'adaptive-concurrency' needs a 'concurrency' above 1 to adjust")
+		cliErrors = true
+	}
+	if err := applyDateOptions(opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		cliErrors = true
+	}
+	if opts.Template != "" && opts.Format != mmuserlist.FormatCSV && opts.Format != mmuserlist.FormatTemplate {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'template' option takes the place of 'format', so it can't be combined with 'format "+opts.Format+"'")
+		cliErrors = true
+	} else if err := opts.loadUserTemplate(); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'template' is not valid: "+err.Error())
+		cliErrors = true
+	}
+	if opts.Format == mmuserlist.FormatTemplate && opts.Template == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Template output needs a 'template'")
+		cliErrors = true
+	}
+	if _, found := mmuserlist.LookupWriter(opts.Format); !found {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The output format must be 'csv', 'json', 'ndjson', 'xlsx', 'pdf', 'sqlite', 'parquet' or 'html'")
+		cliErrors = true
+	}
+	if (opts.Format == mmuserlist.FormatXLSX || opts.Format == mmuserlist.FormatPDF || opts.Format == mmuserlist.FormatTemplate || opts.Format == mmuserlist.FormatSQLite || opts.Format == mmuserlist.FormatParquet || opts.Format == mmuserlist.FormatHTML || opts.Format == mmuserlist.FormatNDJSON) && (opts.NameAudit || opts.DomainAudit || opts.AuthAudit || opts.Scatter) {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'name-audit', 'domain-audit', 'auth-audit' and 'scatter' reports can only be written as 'csv' or 'json'")
+		cliErrors = true
+	}
+	if opts.Append {
+		if opts.Format != mmuserlist.FormatCSV {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'append' option can only add to CSV files")
+			cliErrors = true
 		}
-		userCreatedTime := time.Unix(0, mmUser.CreateAt*int64(time.Millisecond))
-		lastActivityTime := time.Unix(0, mmUser.UpdateAt*int64(time.Millisecond))
-		daysSinceLastActivity := int(time.Since(lastActivityTime).Hours() / 24)
-
-		user := &MMUser{
-			UserID:                mmUser.Id,
-			Username:              mmUser.Username,
-			Email:                 mmUser.Email,
-			FirstName:             mmUser.FirstName,
-			LastName:              mmUser.LastName,
-			Nickname:              mmUser.Nickname,
-			IsBotAccount:          mmUser.IsBot,
-			UserCreatedAt:         userCreatedTime,
-			LastActivityAt:        lastActivityTime,
-			DaysSinceLastActivity: daysSinceLastActivity,
-			TeamName:              "",
+		if opts.CSVFile == mmuserlist.StdoutPath {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'append' option needs an output file to add to")
+			cliErrors = true
+		}
+		if opts.PreviewDiff || opts.ConfirmDiff || opts.NameAudit || opts.DomainAudit || opts.AuthAudit || opts.Scatter {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'append' option cannot be combined with 'preview-diff', 'confirm-diff', 'name-audit', 'domain-audit', 'auth-audit' or 'scatter'")
+			cliErrors = true
 		}
-
-		userList = append(userList, user)
 	}
-
-	return userList, nil
-}
-
-// GetUsersNotInTeam returns a list of all Mattermost users who are without a team assignment
-func GetUsersInTeam(mmClient *model.Client4, team string, includeBots bool) ([]*MMUser, error) {
-
-	DebugPrint("In GetUsersInTeam, for team: " + team)
-
-	ctx := context.Background()
-	page := 0
-	perPage := pageSize
-	etag := ""
-
-	// First we need the team ID
-	teams, response, err := mmClient.GetTeamByName(ctx, team, etag)
-
-	if err != nil {
-		LogMessage(errorLevel, "Error returned from GetTeamByName(): "+err.Error())
-		return nil, err
+	if opts.SortBy != "" && !slices.Contains(mmuserlist.SortFields, opts.SortBy) {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The sort field must be one of '"+strings.Join(mmuserlist.SortFields, "', '")+"'")
+		cliErrors = true
 	}
-	if response.StatusCode != 200 {
-		LogMessage(errorLevel, "Bad HTTP response returned from GetTeamByName()")
-		return nil, errors.New("failed to retrieve data from Mattermost")
+	if opts.SortDesc && opts.SortBy == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'sort-desc' option can only be used with 'sort-by'")
+		cliErrors = true
 	}
-
-	// There should only ever be one team retrieved
-	teamID := teams.Id
-
-	var allUsers []*model.User
-
-	for {
-		users, response, err := mmClient.GetUsersInTeam(ctx, teamID, page, perPage, etag)
-
+	applyFileName(opts)
+	applyCompression(opts)
+	if err := applyCSVOptions(opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		cliErrors = true
+	}
+	if err := opts.loadColumns(); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'columns' list is not valid: "+err.Error())
+		cliErrors = true
+	}
+	opts.postPeriod = mmuserlist.PostPeriods[0]
+	if opts.PostsSince != "" {
+		period, err := mmuserlist.ParsePostPeriod(opts.PostsSince)
 		if err != nil {
-			LogMessage(errorLevel, "Error returned from GetUsersInTeam(): "+err.Error())
-			return nil, err
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'posts-since' value is not valid: "+err.Error())
+			cliErrors = true
 		}
-		if response.StatusCode != 200 {
-			errMsg := fmt.Sprintf("Bad HTTP response returned from GetUsersWithoutTeam() (page %d)", page)
-			LogMessage(errorLevel, errMsg)
-			return nil, errors.New("failed to retrieve data from Mattermost")
+		if !opts.WithPostCount {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'posts-since' option can only be used with 'with-post-count'")
+			cliErrors = true
 		}
-
-		if len(users) < perPage {
-			allUsers = append(allUsers, users...)
-			break
+		opts.postPeriod = period
+	}
+	if opts.Columns != "" && (opts.Format == mmuserlist.FormatPDF || opts.Format == mmuserlist.FormatTemplate || opts.NameAudit || opts.DomainAudit || opts.AuthAudit || opts.Scatter) {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'columns' option only applies to the user list, written as 'csv', 'json' or 'xlsx'")
+		cliErrors = true
+	}
+	if opts.PropsMode != mmuserlist.PropsNone && opts.PropsMode != mmuserlist.PropsColumns && opts.PropsMode != mmuserlist.PropsJSON {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The props export mode must be either 'columns' or 'json'")
+		cliErrors = true
+	}
+	if countTrue(opts.MattermostTeam != "", opts.NotInTeam, opts.AllTeams, opts.Channel != "", opts.Source != "") > 1 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Only one of 'team', 'not-in-teams', 'all-teams', 'channel' or 'source' can be specified")
+		cliErrors = true
+	}
+	if opts.Channel != "" {
+		if team, channel := opts.channelPath(); team == "" || channel == "" {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'channel' parameter must be given as <team>/<channel>")
+			cliErrors = true
+		}
+		if opts.Estimate {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'estimate' option cannot be combined with 'channel'")
+			cliErrors = true
 		}
-
-		allUsers = append(allUsers, users...)
-		page++
 	}
-
-	var userList []*MMUser
-
-	for _, mmUser := range allUsers {
-		if mmUser.IsBot && !includeBots {
-			continue
+	if opts.Source != "" {
+		if _, err := mmuserlist.ParseUserSource(opts.Source, opts.crawl()); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'source' expression is not valid: "+err.Error())
+			cliErrors = true
 		}
-		userCreatedTime := time.Unix(0, mmUser.CreateAt*int64(time.Millisecond))
-		lastActivityTime := time.Unix(0, mmUser.UpdateAt*int64(time.Millisecond))
-		daysSinceLastActivity := int(time.Since(lastActivityTime).Hours() / 24)
-
-		user := &MMUser{
-			UserID:                mmUser.Id,
-			Username:              mmUser.Username,
-			Email:                 mmUser.Email,
-			FirstName:             mmUser.FirstName,
-			LastName:              mmUser.LastName,
-			Nickname:              mmUser.Nickname,
-			IsBotAccount:          mmUser.IsBot,
-			UserCreatedAt:         userCreatedTime,
-			LastActivityAt:        lastActivityTime,
-			DaysSinceLastActivity: daysSinceLastActivity,
-			TeamName:              "",
+		if opts.Estimate {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'estimate' option cannot be combined with 'source'")
+			cliErrors = true
 		}
-
-		userList = append(userList, user)
 	}
-
-	return userList, nil
-}
-
-func WriteUsersToCSV(users []*MMUser, filePath string) error {
-
-	DebugPrint("Writing data to CSV file: " + filePath)
-
-	file, err := os.Create(filePath)
-	if err != nil {
-		LogMessage(errorLevel, "Failed to create file: "+filePath+" - "+err.Error())
-		return err
-	}
-	defer file.Close()
-
-	// Create a CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// Write the CSV header
-	writer.Write([]string{
-		"Username", "Email", "First Name", "Last Name", "Nickname", "Is Bot Account", "User Created Date",
-		"Last Activity Date", "Days Since Last Activity", "Team Name",
-	})
-
-	// Iterate over the user data and write each record to the CSV file
-	for _, user := range users {
-		errorCount := 0
-		record := []string{
-			user.Username,
-			user.Email,
-			user.FirstName,
-			user.LastName,
-			user.Nickname,
-			fmt.Sprintf("%v", user.IsBotAccount),          // Convert boolean to string.
-			user.UserCreatedAt.Format("2006-01-02"),       // Format the time as a string.
-			user.LastActivityAt.Format("2006-01-02"),      // Format the time as a string.
-			fmt.Sprintf("%d", user.DaysSinceLastActivity), // Convert int to string.
-			user.TeamName,
-		}
-
-		// Write the record to the CSV file
-		if err := writer.Write(record); err != nil {
-			LogMessage(warningLevel, "Failed to write record for user '"+user.Username+"' to CSV file")
-			errorCount++
-			if errorCount > maxErrors {
-				LogMessage(errorLevel, "Too many errors writing to CSV file.  Aborting.")
-				return err
-			}
+	multipleTeams := len(mmuserlist.SplitTeamNames(opts.MattermostTeam)) > 1
+	if opts.MergeTeams && !opts.AllTeams && !multipleTeams {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'merge-teams' option can only be used with 'all-teams' or a list of teams")
+		cliErrors = true
+	}
+	if opts.TeamJoinDate && (opts.MergeTeams || opts.NotInTeam || opts.Source != "") {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'team-join-date' option cannot be combined with 'merge-teams', 'not-in-team' or 'source', since each row must be for a single team")
+		cliErrors = true
+	}
+	if opts.WithChannelCounts && (opts.MergeTeams || opts.NotInTeam || opts.Source != "") {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'with-channel-counts' option cannot be combined with 'merge-teams', 'not-in-team' or 'source', since each row must be for a single team")
+		cliErrors = true
+	}
+	if (opts.PreviewDiff || opts.ConfirmDiff) && (opts.NameAudit || opts.DomainAudit || opts.AuthAudit || opts.Scatter) {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'preview-diff' and 'confirm-diff' options cannot be combined with 'name-audit', 'domain-audit', 'auth-audit' or 'scatter'")
+		cliErrors = true
+	}
+	if (opts.DryRun || opts.AssumeYes) && opts.DeactivateAfter < 0 && opts.NotifyInactive < 0 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'dry-run' and 'yes' options can only be used with 'deactivate-after' or 'notify-inactive'")
+		cliErrors = true
+	}
+	if opts.DeactivateAfter >= 0 && (opts.NameAudit || opts.DomainAudit || opts.AuthAudit || opts.Scatter || opts.Estimate) {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'deactivate-after' option cannot be combined with 'name-audit', 'domain-audit', 'auth-audit', 'scatter' or 'estimate'")
+		cliErrors = true
+	}
+	if opts.NotifyInactive >= 0 && (opts.DeactivateAfter >= 0 || opts.NameAudit || opts.DomainAudit || opts.AuthAudit || opts.Scatter || opts.Estimate) {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'notify-inactive' option cannot be combined with 'deactivate-after', 'name-audit', 'domain-audit', 'auth-audit', 'scatter' or 'estimate'")
+		cliErrors = true
+	}
+	if opts.MessageTemplate != "" && opts.NotifyInactive < 0 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'message-template' option can only be used with 'notify-inactive'")
+		cliErrors = true
+	}
+	if opts.NotifyInactive >= 0 {
+		if err := opts.loadNotificationTemplate(); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The message template could not be loaded: "+err.Error())
+			cliErrors = true
 		}
 	}
-
-	return nil
-}
-
-func main() {
-
-	// Parse Command Line
-	DebugPrint("Parsing command line")
-
-	var MattermostURL string
-	var MattermostPort string
-	var MattermostScheme string
-	var MattermostToken string
-	var MattermostTeam string
-	var NotInTeam bool
-	var IncludeBots bool
-	var CSVFile string
-	var DebugFlag bool
-	var VersionFlag bool
-
-	flag.StringVar(&MattermostURL, "url", "", "The URL of the Mattermost instance (without the HTTP scheme)")
-	flag.StringVar(&MattermostPort, "port", "", "The TCP port used by Mattermost. [Default: "+defaultPort+"]")
-	flag.StringVar(&MattermostScheme, "scheme", "", "The HTTP scheme to be used (http/https). [Default: "+defaultScheme+"]")
-	flag.StringVar(&MattermostToken, "token", "", "The auth token used to connect to Mattermost")
-	flag.StringVar(&MattermostTeam, "team", "", "The name of the Mattermost team")
-	flag.BoolVar(&NotInTeam, "not-in-team", false, "Can be used in place of the 'team' parameter to only show users who are not allocated to a team.")
-	flag.BoolVar(&IncludeBots, "include-bots", false, "Optional paramter to include bot accounts in the list")
-	flag.StringVar(&CSVFile, "file", "", "*Required*  The name of the CSV file to which the output should be written")
-	flag.BoolVar(&DebugFlag, "debug", false, "Enable debug output")
-	flag.BoolVar(&VersionFlag, "version", false, "Show version information and exit")
-
-	flag.Parse()
-
-	if VersionFlag {
-		fmt.Printf("\nmm-user-list - Version: %s\n\n", Version)
-		os.Exit(0)
+	if opts.NameRules != "" && !opts.NameAudit {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'name-rules' option can only be used with 'name-audit'")
+		cliErrors = true
 	}
-
-	// If information not supplied on the command line, check whether it's available as an envrionment variable
-	if MattermostURL == "" {
-		MattermostURL = getEnvWithDefault("MM_URL", "").(string)
+	if opts.AllowedDomains != "" && !opts.DomainAudit {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'allowed-domains' option can only be used with 'domain-audit'")
+		cliErrors = true
 	}
-	if MattermostPort == "" {
-		MattermostPort = getEnvWithDefault("MM_PORT", defaultPort).(string)
+	if countTrue(opts.NameAudit, opts.DomainAudit, opts.AuthAudit, opts.Scatter) > 1 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Only one of 'name-audit', 'domain-audit', 'auth-audit' and 'scatter' can be used")
+		cliErrors = true
 	}
-	if MattermostScheme == "" {
-		MattermostScheme = getEnvWithDefault("MM_SCHEME", defaultScheme).(string)
+	if opts.ScatterPlot != "" && !opts.Scatter {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'scatter-plot' option can only be used with 'scatter'")
+		cliErrors = true
 	}
-	if MattermostToken == "" {
-		MattermostToken = getEnvWithDefault("MM_TOKEN", "").(string)
+	if opts.ScatterPlot == mmuserlist.StdoutPath && opts.CSVFile == mmuserlist.StdoutPath {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Only one of 'file' and 'scatter-plot' can be written to standard output")
+		cliErrors = true
 	}
-	if !DebugFlag {
-		DebugFlag = getEnvWithDefault("MM_DEBUG", debugMode).(bool)
+	if (opts.InChannel != "" || opts.NotInChannel != "") && (opts.MattermostTeam == "" || multipleTeams) {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Channel membership filters can only be used with the 'team' parameter, for a single team")
+		cliErrors = true
 	}
-
-	DebugMessage := fmt.Sprintf("Parameters: \n  MattermostURL=%s\n  MattermostPort=%s\n  MattermostScheme=%s\n  MattermostToken=%s\n  Team=%s\n  CSV File=%s",
-		MattermostURL,
-		MattermostPort,
-		MattermostScheme,
-		MattermostToken,
-		MattermostTeam,
-		CSVFile)
-	DebugPrint(DebugMessage)
-	if NotInTeam {
-		DebugPrint("'Not In Team' flag is set")
+	if opts.Stream {
+		if incompatible := opts.streamIncompatible(); len(incompatible) > 0 {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'stream' option cannot be combined with "+strings.Join(incompatible, ", "))
+			cliErrors = true
+		}
+	} else if opts.Format == mmuserlist.FormatNDJSON && !opts.TUI && len(opts.streamIncompatible()) == 0 {
+		// NDJSON is written a record at a time, so it is streamed unless an option needs the whole user list
+		opts.Stream = true
+	}
+	if opts.TUI {
+		if incompatible := opts.tuiIncompatible(); len(incompatible) > 0 {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'tui' option cannot be combined with "+strings.Join(incompatible, ", "))
+			cliErrors = true
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'tui' option needs a terminal for its input and output")
+			cliErrors = true
+		}
 	}
-	if IncludeBots {
-		DebugPrint("'Include Bots' flag is set")
+	if opts.GuestsOnly && opts.ExcludeGuests {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Only one of 'guests-only' and 'exclude-guests' can be used")
+		cliErrors = true
 	}
-
-	// Validate required parameters
-	DebugPrint("Validating parameters")
-	var cliErrors bool = false
-	if MattermostURL == "" {
-		LogMessage(errorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
+	if opts.ChannelDetails && opts.InChannel == "" && opts.Channel == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'channel-details' option can only be used with 'channel' or 'member-of-channel'")
 		cliErrors = true
 	}
-	if MattermostScheme == "" {
-		LogMessage(errorLevel, "The Mattermost HTTP scheme must be supplied either on the command line of vie the MM_SCHEME environment variable")
+	if err := opts.loadPatterns(); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
 		cliErrors = true
 	}
-	if MattermostToken == "" {
-		LogMessage(errorLevel, "The Mattermost auth token must be supplied either on the command line of vie the MM_TOKEN environment variable")
+	if err := opts.loadDefaultFilters(config); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
 		cliErrors = true
 	}
-	// if MattermostTeam == "" {
-	// 	LogMessage(errorLevel, "A Mattermost team name is required to use this utility.")
-	// 	cliErrors = true
-	// }
-	if CSVFile == "" {
-		LogMessage(errorLevel, "A CSV output file must be specified")
+	if err := opts.loadBranding(); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The report branding could not be loaded: "+err.Error())
 		cliErrors = true
 	}
-	if MattermostTeam != "" && NotInTeam {
-		LogMessage(errorLevel, "Only one of 'team' or 'not-in-teams' can be specified")
+	if !opts.validateEmail() {
+		cliErrors = true
+	}
+	if !opts.validateUpload() {
+		cliErrors = true
+	}
+	if opts.ProgressJSON == "1" && opts.CSVFile == mmuserlist.StdoutPath {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The progress events can't be written to standard output while the output file is")
 		cliErrors = true
 	}
 	if cliErrors {
-		flag.Usage()
-		os.Exit(1)
+		fs.Usage()
+		return 1
+	}
+
+	if opts.ProgressJSON != "" {
+		opts.progress, err = newProgressReporter(opts.ProgressJSON)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to open the progress stream: "+err.Error())
+			return 1
+		}
+		defer func() {
+			opts.progress.finish(exitCode)
+		}()
 	}
 
-	debugMode = DebugFlag
+	mmClient, err := opts.connect()
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to connect to Mattermost.  Error: "+err.Error())
+		return 2
+	}
 
-	mattermostConenction := mmConnection{
-		mmURL:    MattermostURL,
-		mmPort:   MattermostPort,
-		mmScheme: MattermostScheme,
-		mmToken:  MattermostToken,
+	started := time.Now()
+	opts.gaps = &mmuserlist.PageGapLog{}
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, "Processing started - Version: "+Version)
+	if len(opts.Tags) > 0 {
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "Run tags: "+opts.Tags.String())
 	}
 
-	mmTarget := fmt.Sprintf("%s://%s:%s", mattermostConenction.mmScheme, mattermostConenction.mmURL, mattermostConenction.mmPort)
+	if opts.Estimate {
+		if opts.MattermostTeam == "" && !opts.NotInTeam && !opts.AllTeams {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Mattermost team is required!")
+			fs.Usage()
+			return 3
+		}
+		estimate, err := EstimateCrawl(mmClient, opts)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Estimate failed.  Error: "+err.Error())
+			return 2
+		}
+		logEstimate(estimate)
+		return 0
+	}
 
-	DebugPrint("Full target for Mattermost: " + mmTarget)
-	mmClient := model.NewAPIv4Client(mmTarget)
-	mmClient.SetToken(mattermostConenction.mmToken)
-	DebugPrint("Connected to Mattermost")
+	if opts.MattermostTeam == "" && !opts.NotInTeam && !opts.AllTeams && opts.Channel == "" && opts.Source == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Mattermost team is required!")
+		fs.Usage()
+		return 3
+	}
 
-	LogMessage(infoLevel, "Processing started - Version: "+Version)
+	if opts.progress != nil {
+		opts.progress.setExpected(expectedCrawlUsers(mmClient, opts))
+	}
+	opts.progress.setStage(stageFetching)
 
-	var users []*MMUser
-	var err error
+	if opts.Stream {
+		return runStreamedExport(fs, mmClient, opts, started)
+	}
 
-	if NotInTeam {
-		users, err = GetUsersNotInTeam(mmClient, IncludeBots)
-	} else {
-		if MattermostTeam == "" {
-			LogMessage(errorLevel, "Mattermost team is required!")
-			flag.Usage()
-			os.Exit(3)
-		}
-		users, err = GetUsersInTeam(mmClient, MattermostTeam, IncludeBots)
+	source, err := opts.userSource()
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Processing failed.  Error: "+err.Error())
+		return 2
 	}
+	mmuserlist.DebugPrint("User source: " + source.String())
+
+	users, err := source.Users(mmClient)
 	if err != nil {
-		LogMessage(errorLevel, "Processing failed.  Error: "+err.Error())
-		os.Exit(2)
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Processing failed.  Error: "+err.Error())
+		return 2
+	}
+
+	// Enrichments are only applied to the users that remain after filtering
+	opts.progress.setStage(stageFiltering)
+	users, err = selectUsers(mmClient, users, opts)
+	if err == nil {
+		opts.progress.setStage(stageEnriching)
+		err = mmuserlist.ApplyEnrichments(mmClient, users, opts.enrichments())
 	}
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Processing failed.  Error: "+err.Error())
+		return 2
+	}
+
+	if opts.SortBy != "" {
+		if err := mmuserlist.SortUsers(users, opts.SortBy, opts.SortDesc); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Processing failed.  Error: "+err.Error())
+			return 2
+		}
+	}
+
+	if opts.TUI {
+		return browseUsers(users, opts)
+	}
+
+	opts.progress.setStage(stageWriting)
+	rows := 0
+	if opts.DomainAudit && len(users) > 0 {
+		allowed := mmuserlist.ParseDomainList(opts.AllowedDomains)
+		if opts.AllowedDomains == "" {
+			allowed, err = mmuserlist.GetAllowedDomains(mmClient)
+			if err != nil {
+				if opts.cloud() {
+					mmuserlist.LogMessage(mmuserlist.WarningLevel, "Mattermost Cloud restricts access to parts of the server configuration")
+				}
+				mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to read the allowed email domains from the server.  Supply them with 'allowed-domains' instead.  Error: "+err.Error())
+				return 2
+			}
+		}
+		if len(allowed) == 0 {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The server doesn't restrict email domains - supply the allowed domains with 'allowed-domains'")
+			return 2
+		}
+		mmuserlist.DebugPrint("Allowed email domains: " + strings.Join(allowed, ", "))
 
-	if len(users) > 0 {
-		err := WriteUsersToCSV(users, CSVFile)
+		violations := mmuserlist.FilterDomainViolations(users, allowed)
+		if err := mmuserlist.WriteDomainViolations(violations, opts.CSVFile, opts.output(), opts.defaultTeam()); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+			return 4
+		}
+		mmuserlist.LogSummary(fmt.Sprintf("Domain audit complete - %d users outside the allowed domains written to %s", len(violations), opts.outputName()))
+		rows = len(violations)
+	} else if opts.NameAudit && len(users) > 0 {
+		policy := mmuserlist.DefaultNamePolicy()
+		if opts.NameRules != "" {
+			policy, err = mmuserlist.LoadNamePolicy(opts.NameRules)
+			if err != nil {
+				mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to load name policy.  Error: "+err.Error())
+				return 2
+			}
+		}
+		violations := policy.Check(users)
+		if err := mmuserlist.WriteNameViolations(violations, opts.CSVFile, opts.output()); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+			return 4
+		}
+		mmuserlist.LogSummary(fmt.Sprintf("Name audit complete - %d violations written to %s", len(violations), opts.outputName()))
+		rows = len(violations)
+	} else if opts.AuthAudit && len(users) > 0 {
+		settings, err := mmuserlist.GetAuthSettings(mmClient)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.WarningLevel, "Failed to read the sign-in settings from the server - only checking the auth data of each account")
+			settings = nil
+		}
+		anomalies := mmuserlist.CheckAuthAnomalies(users, settings)
+		if err := mmuserlist.WriteAuthAnomalies(anomalies, opts.CSVFile, opts.output()); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+			return 4
+		}
+		mmuserlist.LogSummary(fmt.Sprintf("Auth audit complete - %d anomalies written to %s", len(anomalies), opts.outputName()))
+		rows = len(anomalies)
+	} else if opts.Scatter && len(users) > 0 {
+		points := mmuserlist.BuildScatterData(users, opts.defaultTeam())
+		if err := mmuserlist.WriteScatterData(points, opts.CSVFile, opts.output()); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+			return 4
+		}
+		if opts.ScatterPlot != "" {
+			if err := mmuserlist.WriteScatterPlot(points, opts.ScatterPlot); err != nil {
+				mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create scatter plot file: "+err.Error())
+				return 4
+			}
+		}
+		mmuserlist.LogSummary(fmt.Sprintf("Processing complete - %d data points written to %s", len(points), opts.outputName()))
+		rows = len(points)
+	} else if len(users) > 0 {
+		if (opts.PreviewDiff || opts.ConfirmDiff) && !previewReportDiff(users, opts) {
+			mmuserlist.LogMessage(mmuserlist.WarningLevel, "Export cancelled - "+opts.CSVFile+" was left unchanged")
+			return 0
+		}
+		err := mmuserlist.WriteUsers(users, opts.CSVFile, opts.output())
 		if err != nil {
-			LogMessage(errorLevel, "Failed to create CSV file: "+err.Error())
-			os.Exit(4)
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+			return 4
 		}
+		mmuserlist.LogSummary(fmt.Sprintf("Processing complete - %d users written to %s", len(users), opts.outputName()))
+		warnPageGaps(opts.gaps)
+		rows = len(users)
 	} else {
-		LogMessage(warningLevel, "No users found to write to CSV!")
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "No users found to write to the output file!")
+		return 0
 	}
 
+	if err := recordRun(fs, opts, started, rows); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 4
+	}
+	if opts.EmailReport {
+		if err := emailReport(fs, opts, started, rows); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to email the report: "+err.Error())
+			return 4
+		}
+	}
+	if opts.Upload != "" {
+		if err := uploadReport(opts); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to upload the report: "+err.Error())
+			return 4
+		}
+	}
+
+	if opts.DeactivateAfter >= 0 {
+		opts.progress.setStage(stageDeactivating)
+		return deactivateInactiveUsers(mmClient, users, opts)
+	}
+	if opts.NotifyInactive >= 0 {
+		opts.progress.setStage(stageNotifying)
+		return notifyInactiveUsers(mmClient, users, opts)
+	}
+
+	return 0
+}
+
+// warnPageGaps repeats the warning for any users skipped by the crawl, so that it isn't lost in a long log
+func warnPageGaps(gapLog *mmuserlist.PageGapLog) {
+	gaps := gapLog.Gaps()
+	if len(gaps) == 0 {
+		return
+	}
+	skipped := 0
+	for _, gap := range gaps {
+		skipped += gap.Count
+	}
+	mmuserlist.LogMessage(mmuserlist.WarningLevel, fmt.Sprintf("INCOMPLETE DATA: %d users in %d ranges couldn't be retrieved and are missing from the output - see the warnings above", skipped, len(gaps)))
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// manifestSuffix is appended to the output file name to give the name of its manifest
+const manifestSuffix = ".manifest.json"
+
+// tagList collects the key=value pairs supplied with repeated '-tag' parameters
+type tagList map[string]string
+
+// String implements flag.Value
+func (tags *tagList) String() string {
+	if tags == nil || len(*tags) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(*tags))
+	for _, key := range sortedKeys(*tags) {
+		pairs = append(pairs, key+"="+(*tags)[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value.  Each call adds one tag, so the parameter can be repeated.  An empty value (as used when
+// resolving defaults) leaves the tags unchanged.
+func (tags *tagList) Set(value string) error {
+	if value == "" {
+		return nil
+	}
+	if *tags == nil {
+		*tags = make(tagList)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		key, tagValue, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return errors.New("tags must be given as key=value")
+		}
+		(*tags)[key] = strings.TrimSpace(tagValue)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the map in alphabetical order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runManifest records what an export contained and who it was for, so that every output file can be attributed
+type runManifest struct {
+	Tool        string            `json:"tool"`
+	Version     string            `json:"version"`
+	StartedAt   string            `json:"started_at"`
+	CompletedAt string            `json:"completed_at"`
+	Server      string            `json:"server"`
+	Parameters  map[string]string `json:"parameters"`
+	OutputFile  string            `json:"output_file"`
+	Rows        int               `json:"rows"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// newRunManifest describes a completed run.  Only the parameters given explicitly are recorded, and sensitive values
+// are redacted.
+func newRunManifest(fs *flag.FlagSet, opts *cliOptions, started time.Time, rows int) *runManifest {
+
+	manifest := &runManifest{
+		Tool:        "mm-user-list",
+		Version:     Version,
+		StartedAt:   started.UTC().Format(time.RFC3339),
+		CompletedAt: time.Now().UTC().Format(time.RFC3339),
+		Server:      mmuserlist.ServerURL(opts.connection()),
+		Parameters:  make(map[string]string),
+		OutputFile:  opts.CSVFile,
+		Rows:        rows,
+		Tags:        opts.Tags,
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "tag" {
+			return
+		}
+		value := f.Value.String()
+		if sensitiveSettings[f.Name] {
+			value = mmuserlist.Redact(value)
+		}
+		manifest.Parameters[f.Name] = value
+	})
+
+	// Output streamed to stdout can't be read back, so it goes without a checksum
+	if opts.CSVFile == mmuserlist.StdoutPath {
+		return manifest
+	}
+	if checksum, err := fileChecksum(opts.CSVFile); err == nil {
+		manifest.SHA256 = checksum
+	} else {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "Failed to calculate the checksum of the output file: "+err.Error())
+	}
+
+	return manifest
+}
+
+// fileChecksum returns the hex-encoded SHA-256 digest of a file's contents
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeManifest writes the manifest alongside the output file
+func writeManifest(manifest *runManifest) error {
+
+	filePath := manifest.OutputFile + manifestSuffix
+	mmuserlist.DebugPrint("Writing manifest to: " + filePath)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return mmuserlist.WriteOutputFile(filePath, append(data, '\n'), false)
+}
+
+// appendAuditLog adds the manifest to the audit log as a single line of JSON, creating the log if needed
+func appendAuditLog(manifest *runManifest, filePath string) error {
+
+	mmuserlist.DebugPrint("Appending to audit log: " + filePath)
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// recordRun writes the manifest and audit log entry for a run, as requested by the options
+func recordRun(fs *flag.FlagSet, opts *cliOptions, started time.Time, rows int) error {
+
+	if !opts.Manifest && opts.AuditLog == "" && len(opts.Tags) == 0 {
+		return nil
+	}
+
+	manifest := newRunManifest(fs, opts, started, rows)
+
+	if (opts.Manifest || len(opts.Tags) > 0) && opts.CSVFile == mmuserlist.StdoutPath {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "No manifest is written when the output goes to standard output")
+	} else if opts.Manifest || len(opts.Tags) > 0 {
+		if err := writeManifest(manifest); err != nil {
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "Manifest written to "+opts.CSVFile+manifestSuffix)
+	}
+
+	if opts.AuditLog != "" {
+		if err := appendAuditLog(manifest, opts.AuditLog); err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+
+	return nil
+}
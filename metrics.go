@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// metricsContentType is the media type of the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// userMetrics are the user counts from one collection
+type userMetrics struct {
+	teams       []string
+	teamUsers   map[string]int
+	inactive    map[string]map[int]int
+	withoutTeam int
+	collected   time.Time
+	duration    time.Duration
+}
+
+// metricsCollector gathers the user counts reported on /metrics.  Counting needs a crawl of every team, so a scrape is
+// answered from the last collection, and starts a new one in the background once that is older than the refresh
+// interval.  The first scrape only reports the state of the collector.
+type metricsCollector struct {
+	mutex      sync.Mutex
+	settings   map[string]string
+	days       []int
+	refresh    time.Duration
+	latest     *userMetrics
+	collecting bool
+	failures   int
+}
+
+// parseMetricsDays parses the comma-separated inactivity thresholds, in days, reported by mm_team_inactive_users
+func parseMetricsDays(value string) ([]int, error) {
+	var days []int
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		number, err := strconv.Atoi(item)
+		if err != nil || number < 0 {
+			return nil, fmt.Errorf("'%s' is not a number of days", item)
+		}
+		days = append(days, number)
+	}
+	if len(days) == 0 {
+		return nil, errors.New("at least one number of days is needed")
+	}
+	sort.Ints(days)
+	return days, nil
+}
+
+// newMetricsCollector prepares a collector for the server described by the settings, checking the metrics options
+func newMetricsCollector(settings map[string]string, opts *cliOptions) (*metricsCollector, error) {
+	days, err := parseMetricsDays(opts.MetricsDays)
+	if err != nil {
+		return nil, fmt.Errorf("the 'metrics-days' are not valid: %w", err)
+	}
+	if opts.MetricsRefresh < 1 {
+		return nil, errors.New("the 'metrics-refresh' interval must be at least 1 minute")
+	}
+	return &metricsCollector{
+		settings: settings,
+		days:     days,
+		refresh:  time.Duration(opts.MetricsRefresh) * time.Minute,
+	}, nil
+}
+
+// collect counts the active users in each team, those inactive for longer than each threshold, and the users without
+// a team
+func (c *metricsCollector) collect() (*userMetrics, error) {
+
+	started := time.Now()
+
+	// The settings are resolved afresh, as for each export, so that a rotated token is picked up
+	fs, opts, err := newExportFlagSet("metrics", c.settings)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := resolveConfigFile(fs); err != nil {
+		return nil, err
+	}
+	if !opts.validateConnection() {
+		return nil, errors.New("the connection settings are not valid")
+	}
+	mmClient, err := opts.connect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Mattermost: %w", err)
+	}
+
+	teams, err := mmuserlist.GetAllTeams(mmClient)
+	if err != nil {
+		return nil, err
+	}
+	teamUsers, err := mmuserlist.FetchUsersInTeamList(mmClient, teams, opts.crawl(), false)
+	if err != nil {
+		return nil, err
+	}
+	withoutTeam, err := mmuserlist.FetchUsersWithoutTeam(mmClient, opts.crawl())
+	if err != nil {
+		return nil, err
+	}
+	if !opts.IncludeDeactivated {
+		teamUsers = mmuserlist.FilterDeactivated(teamUsers)
+		withoutTeam = mmuserlist.FilterDeactivated(withoutTeam)
+	}
+	if err := mmuserlist.ApplyLastActivity(mmClient, teamUsers); err != nil {
+		return nil, fmt.Errorf("failed to retrieve last activity: %w", err)
+	}
+
+	metrics := &userMetrics{
+		teamUsers:   make(map[string]int),
+		inactive:    make(map[string]map[int]int),
+		withoutTeam: len(withoutTeam),
+		collected:   time.Now(),
+	}
+	for _, team := range teams {
+		label := mmuserlist.TeamLabel(team, opts.UseTeamDisplayName)
+		metrics.teams = append(metrics.teams, label)
+		metrics.inactive[label] = make(map[int]int)
+	}
+	sort.Strings(metrics.teams)
+	for _, user := range teamUsers {
+		metrics.teamUsers[user.TeamName]++
+		for _, days := range c.days {
+			if user.DaysSinceLastActivity > days {
+				metrics.inactive[user.TeamName][days]++
+			}
+		}
+	}
+	metrics.duration = time.Since(started)
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Metrics collected for %d teams in %s", len(teams), metrics.duration.Round(time.Second)))
+	return metrics, nil
+}
+
+// refreshIfStale starts a collection in the background if there is no recent one and none is already running
+func (c *metricsCollector) refreshIfStale() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.collecting || (c.latest != nil && time.Since(c.latest.collected) < c.refresh) {
+		return
+	}
+	c.collecting = true
+
+	go func() {
+		metrics, err := c.collect()
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.collecting = false
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to collect the metrics: "+err.Error())
+			c.failures++
+			return
+		}
+		c.latest = metrics
+	}()
+}
+
+// metricLabel escapes a label value for the exposition format
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// writeMetrics writes the latest counts, and the state of the collector, in the Prometheus text exposition format
+func (c *metricsCollector) writeMetrics(out io.Writer) error {
+	c.mutex.Lock()
+	metrics := c.latest
+	collecting := c.collecting
+	failures := c.failures
+	c.mutex.Unlock()
+
+	var text strings.Builder
+	metric := func(name string, kind string, help string) {
+		fmt.Fprintf(&text, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	if metrics != nil {
+		metric("mm_team_users_total", "gauge", "Users in each team")
+		for _, team := range metrics.teams {
+			fmt.Fprintf(&text, "mm_team_users_total{team=\"%s\"} %d\n", metricLabel(team), metrics.teamUsers[team])
+		}
+		metric("mm_team_inactive_users", "gauge", "Users in each team whose last activity was more than the given number of days ago")
+		for _, team := range metrics.teams {
+			for _, days := range c.days {
+				fmt.Fprintf(&text, "mm_team_inactive_users{team=\"%s\",days=\"%d\"} %d\n", metricLabel(team), days, metrics.inactive[team][days])
+			}
+		}
+		metric("mm_users_without_team", "gauge", "Users who aren't in any team")
+		fmt.Fprintf(&text, "mm_users_without_team %d\n", metrics.withoutTeam)
+		metric("mm_user_metrics_last_success_timestamp_seconds", "gauge", "When the user counts were last collected")
+		fmt.Fprintf(&text, "mm_user_metrics_last_success_timestamp_seconds %d\n", metrics.collected.Unix())
+		metric("mm_user_metrics_collection_duration_seconds", "gauge", "How long the last collection of the user counts took")
+		fmt.Fprintf(&text, "mm_user_metrics_collection_duration_seconds %.3f\n", metrics.duration.Seconds())
+	}
+
+	running := 0
+	if collecting {
+		running = 1
+	}
+	metric("mm_user_metrics_collecting", "gauge", "Whether the user counts are being collected")
+	fmt.Fprintf(&text, "mm_user_metrics_collecting %d\n", running)
+	metric("mm_user_metrics_collection_failures_total", "counter", "Collections of the user counts that failed")
+	fmt.Fprintf(&text, "mm_user_metrics_collection_failures_total %d\n", failures)
+
+	_, err := io.WriteString(out, text.String())
+	return err
+}
+
+// ServeHTTP answers a scrape with the latest counts, starting a new collection if they are out of date
+func (c *metricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.refreshIfStale()
+	w.Header().Set("Content-Type", metricsContentType)
+	if err := c.writeMetrics(w); err != nil {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "Failed to send the metrics: "+err.Error())
+	}
+}
+
+// serveMetrics serves the metrics on their own address, for modes without an HTTP server of their own.  It returns a
+// function that stops the server.
+func serveMetrics(address string, collector *metricsCollector) (func(), error) {
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", collector)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 30 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Stopped serving the metrics: "+err.Error())
+		}
+	}()
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, "Serving metrics on "+address+"/metrics")
+
+	return func() { server.Close() }, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// notifyInactiveUsers carries out the 'notify-inactive' action on the selected users, once confirmed, and returns the
+// process exit code
+func notifyInactiveUsers(mmClient *model.Client4, users []*mmuserlist.User, opts *cliOptions) int {
+
+	if !opts.DryRun && !opts.AssumeYes {
+		prompt := fmt.Sprintf("About to message %d users inactive for more than %d days (listed in %s).", len(users), opts.NotifyInactive, opts.outputName())
+		if !confirmAction(prompt) {
+			mmuserlist.LogMessage(mmuserlist.WarningLevel, "Notification cancelled - no messages were sent")
+			return 0
+		}
+	}
+
+	count, err := mmuserlist.NotifyUsers(mmClient, users, opts.message, opts.DryRun)
+	if opts.DryRun {
+		mmuserlist.LogSummary(fmt.Sprintf("Dry run complete - %d users would be messaged", count))
+	} else {
+		mmuserlist.LogSummary(fmt.Sprintf("Notification complete - %d users messaged", count))
+	}
+	if err != nil {
+		return 2
+	}
+
+	return 0
+}
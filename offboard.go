@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// runOffboardCommand implements the 'offboard' subcommand, which writes the offboarding packet for a single user, and
+// returns the process exit code
+func runOffboardCommand(args []string) int {
+
+	var opts cliOptions
+	var user string
+
+	fs := flag.NewFlagSet("offboard", flag.ExitOnError)
+	registerFlags(fs, &opts)
+	fs.StringVar(&user, "user", "", "*Required*  The departing user, by username, email address or ID")
+	fs.Parse(args)
+
+	if _, err := resolveConfig(fs); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	if err := applyLoggingOptions(&opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+
+	// A packet has no CSV form, so the default format gives JSON
+	if opts.Format == mmuserlist.FormatCSV {
+		opts.Format = mmuserlist.FormatJSON
+	}
+
+	cliErrors := !opts.validateConnection()
+	if user == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The user to offboard must be supplied with 'user'")
+		cliErrors = true
+	}
+	if opts.CSVFile == "" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "An output file must be specified")
+		cliErrors = true
+	}
+	if opts.Format != mmuserlist.FormatJSON && opts.Format != mmuserlist.FormatPDF {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "An offboarding packet can only be written as 'json' or 'pdf'")
+		cliErrors = true
+	}
+	if err := applyDateOptions(&opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		cliErrors = true
+	}
+	applyFileName(&opts)
+	applyCompression(&opts)
+	if err := opts.loadBranding(); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The report branding could not be loaded: "+err.Error())
+		cliErrors = true
+	}
+	if cliErrors {
+		fs.Usage()
+		return 1
+	}
+
+	mmClient, err := opts.connect()
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to connect to Mattermost.  Error: "+err.Error())
+		return 2
+	}
+
+	packet, err := mmuserlist.GetOffboardPacket(mmClient, user, opts.dates)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Offboarding packet failed.  Error: "+err.Error())
+		return 2
+	}
+
+	if err := mmuserlist.WriteOffboardPacket(packet, opts.CSVFile, opts.output()); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+		return 4
+	}
+	mmuserlist.LogSummary(fmt.Sprintf("Offboarding packet for %s written to %s - %d teams, %d channels, %d owned bots, %d sessions",
+		packet.User.Username, opts.outputName(), len(packet.Teams), len(packet.Channels), len(packet.OwnedBots), len(packet.Sessions)))
+
+	return 0
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// userSource returns the source of the users to export: the 'source' expression if one was given, otherwise the
+// channel, team, teams or teamless users requested
+func (opts *cliOptions) userSource() (mmuserlist.UserSource, error) {
+	switch {
+	case opts.Source != "":
+		return mmuserlist.ParseUserSource(opts.Source, opts.crawl())
+	case opts.Channel != "":
+		team, channel := opts.channelPath()
+		return mmuserlist.ChannelSource{Team: team, Channel: channel, IncludeBots: opts.IncludeBots}, nil
+	case opts.NotInTeam:
+		return mmuserlist.NoTeamSource{Crawl: opts.crawl()}, nil
+	case opts.AllTeams:
+		return mmuserlist.AllTeamsSource{Crawl: opts.crawl(), Merge: opts.MergeTeams}, nil
+	default:
+		return mmuserlist.TeamSource{Team: opts.MattermostTeam, Crawl: opts.crawl(), Merge: opts.MergeTeams}, nil
+	}
+}
+
+// channelPath splits the 'channel' parameter into its team and channel names
+func (opts *cliOptions) channelPath() (team string, channel string) {
+	team, channel, _ = strings.Cut(opts.Channel, "/")
+	return team, channel
+}
+
+// joinDateTeam returns the team whose join dates and channel counts are reported for rows without a team name: the
+// channel's team for a channel export, otherwise the team being exported
+func (opts *cliOptions) joinDateTeam() string {
+	if opts.Channel != "" {
+		team, _ := opts.channelPath()
+		return team
+	}
+	return opts.defaultTeam()
+}
+
+// filtersNeedActivity reports whether any of the requested filters select users by their last activity, in which
+// case last activity has to be retrieved before filtering rather than deferred with the other enrichments
+func (opts *cliOptions) filtersNeedActivity() bool {
+	return opts.InactiveDays >= 0 || opts.DeactivateAfter >= 0 || opts.NotifyInactive >= 0 || opts.NeverLoggedIn
+}
+
+// filtersNeedRoles reports whether the users' roles have to be retrieved before filtering
+func (opts *cliOptions) filtersNeedRoles() bool {
+	return opts.Role != ""
+}
+
+// enrichments returns the enrichments to apply once the users have been filtered
+func (opts *cliOptions) enrichments() []mmuserlist.Enrichment {
+	var enrichments []mmuserlist.Enrichment
+	if !opts.filtersNeedActivity() {
+		enrichments = append(enrichments, mmuserlist.LastActivityEnrichment)
+	}
+	if opts.Roles && !opts.filtersNeedRoles() {
+		enrichments = append(enrichments, mmuserlist.TeamRolesEnrichment)
+	}
+	if opts.Guests {
+		enrichments = append(enrichments, mmuserlist.GuestChannelsEnrichment)
+	}
+	if opts.TeamJoinDate {
+		enrichments = append(enrichments, mmuserlist.TeamJoinDateEnrichment(opts.joinDateTeam()))
+	}
+	if opts.WithTeams {
+		enrichments = append(enrichments, mmuserlist.UserTeamsEnrichment(opts.UseTeamDisplayName))
+	}
+	if opts.WithChannelCounts {
+		enrichments = append(enrichments, mmuserlist.ChannelCountEnrichment(opts.joinDateTeam()))
+	}
+	if opts.WithLastPost {
+		enrichments = append(enrichments, mmuserlist.LastPostEnrichment())
+	}
+	if opts.WithPostCount {
+		enrichments = append(enrichments, mmuserlist.PostCountEnrichment(opts.postPeriod))
+	}
+	if opts.ClientUsage {
+		enrichments = append(enrichments, mmuserlist.ClientUsageEnrichment)
+	}
+	if opts.WithSessions {
+		enrichments = append(enrichments, mmuserlist.SessionsEnrichment)
+	}
+	if opts.RoleHistory {
+		enrichments = append(enrichments, mmuserlist.RoleHistoryEnrichment)
+	}
+	if opts.ChannelDetails && opts.Channel != "" {
+		enrichments = append(enrichments, mmuserlist.ChannelMembershipEnrichment(opts.channelPath()))
+	} else if opts.ChannelDetails {
+		enrichments = append(enrichments, mmuserlist.ChannelMembershipEnrichment(opts.MattermostTeam, opts.InChannel))
+	}
+	return enrichments
+}
+
+// userFilter applies the requested filters to a list of users
+type userFilter func(users []*mmuserlist.User) ([]*mmuserlist.User, error)
+
+// newUserFilter prepares the requested filters, after any default filters from the config file.  Filters that need no
+// API calls run first, then those that check a list of members, then the role filter and finally the activity filters,
+// which need a lookup for every user still remaining.  The exclusions and member lists are loaded once, here, so that
+// a streamed crawl can apply the filter page by page.
+func newUserFilter(mmClient *model.Client4, opts *cliOptions) (userFilter, error) {
+
+	var exclusions map[string]bool
+	if opts.ExcludeFile != "" {
+		var err error
+		exclusions, err = mmuserlist.LoadExclusions(opts.ExcludeFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load exclusion file: %w", err)
+		}
+	}
+
+	type memberFilter struct {
+		members map[string]bool
+		keep    bool
+	}
+	var memberFilters []memberFilter
+
+	groups := []struct {
+		name string
+		keep bool
+	}{{opts.InGroup, true}, {opts.NotInGroup, false}}
+	for _, group := range groups {
+		if group.name == "" {
+			continue
+		}
+		members, err := mmuserlist.GetGroupMemberIDs(mmClient, group.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply group filter: %w", err)
+		}
+		memberFilters = append(memberFilters, memberFilter{members, group.keep})
+	}
+
+	channels := []struct {
+		name string
+		keep bool
+	}{{opts.InChannel, true}, {opts.NotInChannel, false}}
+	for _, channel := range channels {
+		if channel.name == "" {
+			continue
+		}
+		members, err := mmuserlist.GetChannelMemberIDs(mmClient, opts.MattermostTeam, channel.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply channel filter: %w", err)
+		}
+		memberFilters = append(memberFilters, memberFilter{members, channel.keep})
+	}
+
+	// The config file's default filters run first, since they often drop many users cheaply
+	var defaultFilter userFilter
+	if opts.defaultFilters != nil {
+		filter, err := newUserFilter(mmClient, opts.defaultFilters)
+		if err != nil {
+			return nil, err
+		}
+		defaultFilter = filter
+	}
+
+	return func(users []*mmuserlist.User) ([]*mmuserlist.User, error) {
+
+		if defaultFilter != nil {
+			filtered, err := defaultFilter(users)
+			if err != nil {
+				return nil, err
+			}
+			users = filtered
+		}
+
+		if !opts.IncludeDeactivated {
+			users = mmuserlist.FilterDeactivated(users)
+		}
+		if !opts.IncludeBots {
+			users = mmuserlist.FilterBots(users)
+		}
+		if opts.AuthService != "" {
+			users = mmuserlist.FilterByAuthMethod(users, mmuserlist.ParseNameList(opts.AuthService))
+		}
+		if opts.GuestsOnly || opts.ExcludeGuests {
+			users = mmuserlist.FilterGuests(users, opts.GuestsOnly)
+		}
+		if len(opts.EmailDomains) > 0 {
+			users = mmuserlist.FilterByEmailDomain(users, opts.EmailDomains, true)
+		}
+		if len(opts.ExcludeEmailDomains) > 0 {
+			users = mmuserlist.FilterByEmailDomain(users, opts.ExcludeEmailDomains, false)
+		}
+		for _, match := range opts.patterns {
+			users = mmuserlist.FilterByPattern(users, match.field, match.pattern)
+		}
+		if exclusions != nil {
+			users = mmuserlist.FilterExcluded(users, exclusions)
+		}
+
+		for _, filter := range memberFilters {
+			users = mmuserlist.FilterUsers(users, func(user *mmuserlist.User) bool {
+				return filter.members[user.UserID] == filter.keep
+			})
+		}
+
+		if opts.filtersNeedRoles() {
+			if err := mmuserlist.ApplyTeamRoles(mmClient, users); err != nil {
+				return nil, fmt.Errorf("failed to retrieve team roles: %w", err)
+			}
+			users = mmuserlist.FilterByRole(users, mmuserlist.ParseNameList(opts.Role))
+		}
+
+		if opts.filtersNeedActivity() {
+			if err := mmuserlist.ApplyLastActivity(mmClient, users); err != nil {
+				return nil, fmt.Errorf("failed to retrieve last activity: %w", err)
+			}
+			if opts.InactiveDays >= 0 {
+				users = mmuserlist.FilterInactive(users, opts.InactiveDays)
+			}
+			if opts.DeactivateAfter >= 0 {
+				users = mmuserlist.FilterInactive(users, opts.DeactivateAfter)
+			}
+			if opts.NotifyInactive >= 0 {
+				users = mmuserlist.FilterInactive(users, opts.NotifyInactive)
+			}
+			if opts.NeverLoggedIn {
+				filtered, err := mmuserlist.FilterNeverLoggedIn(mmClient, users)
+				if err != nil {
+					return nil, fmt.Errorf("failed to retrieve sessions: %w", err)
+				}
+				users = filtered
+			}
+		}
+
+		return users, nil
+	}, nil
+}
+
+// selectUsers applies the requested filters to the fetched users
+func selectUsers(mmClient *model.Client4, users []*mmuserlist.User, opts *cliOptions) ([]*mmuserlist.User, error) {
+
+	filter, err := newUserFilter(mmClient, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter(users)
+}
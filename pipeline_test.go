@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// filterTestUsers returns the users the filter tests select from: a regular user, a bot, a deactivated user, a guest
+// from a contractor, an LDAP user and a user from another domain
+func filterTestUsers() []*mmuserlist.User {
+	return []*mmuserlist.User{
+		{UserID: "id-alice", Username: "alice", Email: "alice@example.com", FirstName: "Alice", LastName: "Jones"},
+		{UserID: "id-bob", Username: "bob", Email: "bob@example.com", IsBotAccount: true},
+		{UserID: "id-carol", Username: "carol", Email: "carol@example.com", DeactivatedAt: time.Now()},
+		{UserID: "id-dave", Username: "dave", Email: "dave@contractor.example", IsGuest: true},
+		{UserID: "id-erin", Username: "erin", Email: "erin@Example.com", AuthService: "ldap"},
+		{UserID: "id-frank", Username: "frank", Email: "frank@other.example", FirstName: "Frank", LastName: "Smith"},
+	}
+}
+
+// filterUsernames applies the filter for the settings to the test users, returning the usernames it keeps
+func filterUsernames(t *testing.T, settings map[string]string, config *configFile) string {
+	t.Helper()
+
+	_, opts, err := newExportFlagSet("test", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.loadPatterns(); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.loadDefaultFilters(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// None of the filters tested need to call the server
+	filter, err := newUserFilter(nil, opts)
+	if err != nil {
+		t.Fatalf("newUserFilter() returned an error: %v", err)
+	}
+	users, err := filter(filterTestUsers())
+	if err != nil {
+		t.Fatalf("the filter returned an error: %v", err)
+	}
+
+	var usernames []string
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	return strings.Join(usernames, ",")
+}
+
+func TestUserFilter(t *testing.T) {
+
+	excludeFile := filepath.Join(t.TempDir(), "exclude.csv")
+	if err := os.WriteFile(excludeFile, []byte("ALICE\nfrank@other.example\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		expected string
+	}{
+		{"no filters", nil, "alice,dave,erin,frank"},
+		{"bots included", map[string]string{"include-bots": "true"}, "alice,bob,dave,erin,frank"},
+		{"deactivated users included", map[string]string{"include-deactivated": "true"}, "alice,carol,dave,erin,frank"},
+		{"auth method", map[string]string{"auth-service": "LDAP"}, "erin"},
+		{"email auth method", map[string]string{"auth-service": "email"}, "alice,dave,frank"},
+		{"guests only", map[string]string{"guests-only": "true"}, "dave"},
+		{"guests excluded", map[string]string{"exclude-guests": "true"}, "alice,erin,frank"},
+		{"email domain", map[string]string{"email-domain": "example.com"}, "alice,erin"},
+		{"excluded email domains", map[string]string{"exclude-email-domain": "example.com,contractor.example"}, "frank"},
+		{"username pattern", map[string]string{"match-username": "^[a-d]"}, "alice,dave"},
+		{"email pattern", map[string]string{"match-email": `\.example$`}, "dave,frank"},
+		{"name pattern", map[string]string{"match-name": "(?i)^frank smith$"}, "frank"},
+		{"exclusion file", map[string]string{"exclude-file": excludeFile}, "dave,erin"},
+		{"filters combined", map[string]string{"email-domain": "example.com", "auth-service": "email"}, "alice"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := filterUsernames(t, test.settings, nil); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestUserFilterDefaults(t *testing.T) {
+
+	config := &configFile{
+		Path:           "config.yaml",
+		DefaultFilters: map[string]string{"exclude-email-domain": "other.example"},
+	}
+
+	// The default filters apply on top of the export's own, but don't drop bots unless they say so
+	if got := filterUsernames(t, map[string]string{"include-bots": "true"}, config); got != "alice,bob,dave,erin" {
+		t.Errorf("expected the default filters to apply, got %q", got)
+	}
+	if got := filterUsernames(t, map[string]string{"no-defaults": "true"}, config); got != "alice,dave,erin,frank" {
+		t.Errorf("expected the default filters to be skipped, got %q", got)
+	}
+
+	config.DefaultFilters = map[string]string{"guests-only": "true", "exclude-guests": "true"}
+	_, opts, err := newExportFlagSet("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.loadDefaultFilters(config); err == nil {
+		t.Errorf("expected contradictory default filters to be rejected")
+	}
+}
@@ -0,0 +1,59 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// DeactivateUsers deactivates each of the users, logging every change.  With dryRun set, the users that would be
+// deactivated are logged but nothing is changed.  Users that are already deactivated are skipped.  It returns the
+// number of users deactivated (or, for a dry run, that would have been), and an error if any user couldn't be.
+func DeactivateUsers(mmClient *model.Client4, users []*User, dryRun bool) (int, error) {
+
+	DebugPrint(fmt.Sprintf("In DeactivateUsers, for %d users", len(users)))
+
+	ctx := context.Background()
+	seen := make(map[string]bool)
+	deactivated := 0
+	errorCount := 0
+
+	for _, user := range users {
+		if seen[user.UserID] || !user.DeactivatedAt.IsZero() {
+			continue
+		}
+		seen[user.UserID] = true
+
+		description := fmt.Sprintf("'%s' (%s), inactive for %d days", user.Username, user.UserID, user.DaysSinceLastActivity)
+
+		if dryRun {
+			LogMessage(InfoLevel, "Dry run - would deactivate user "+description)
+			deactivated++
+			continue
+		}
+
+		response, err := mmClient.DeleteUser(ctx, user.UserID)
+		if err == nil && response.StatusCode != 200 {
+			err = errors.New("bad HTTP response returned from Mattermost")
+		}
+		if err != nil {
+			LogMessage(WarningLevel, "Failed to deactivate user "+description+" - "+err.Error())
+			errorCount++
+			if errorCount > maxErrors {
+				LogMessage(ErrorLevel, "Too many errors deactivating users.  Aborting.")
+				return deactivated, err
+			}
+			continue
+		}
+
+		LogMessage(InfoLevel, "Deactivated user "+description)
+		deactivated++
+	}
+
+	if errorCount > 0 {
+		return deactivated, fmt.Errorf("failed to deactivate %d users", errorCount)
+	}
+	return deactivated, nil
+}
@@ -0,0 +1,46 @@
+package mmuserlist
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestDeactivateUsersReportsFailures(t *testing.T) {
+
+	// The server deactivates every user except bob
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if r.Method != http.MethodDelete || id == "bob" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"status_code": 403}`))
+			return
+		}
+		deleted = append(deleted, id)
+		w.Write([]byte(`{"status": "OK"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	users := []*User{{UserID: "alice", Username: "alice"}, {UserID: "bob", Username: "bob"}, {UserID: "carol", Username: "carol"}}
+	count, err := DeactivateUsers(model.NewAPIv4Client(server.URL), users, false)
+	if err == nil {
+		t.Fatalf("expected an error when a user can't be deactivated")
+	}
+	if count != 2 || len(deleted) != 2 {
+		t.Errorf("expected the other 2 users to be deactivated, got %d (%v)", count, deleted)
+	}
+
+	// A dry run changes nothing, and so can't fail
+	deleted = nil
+	count, err = DeactivateUsers(model.NewAPIv4Client(server.URL), users, true)
+	if err != nil {
+		t.Fatalf("DeactivateUsers() returned an error for a dry run: %v", err)
+	}
+	if count != 3 || len(deleted) != 0 {
+		t.Errorf("expected 3 users to be counted and none deactivated, got %d (%v)", count, deleted)
+	}
+}
@@ -0,0 +1,74 @@
+package mmuserlist
+
+import (
+	"fmt"
+	"time"
+)
+
+// With CrawlOptions.AdaptiveConcurrency, a batch of pages taking more than AdaptiveLatencyFactor times as long as the
+// fastest batch so far is taken as a sign that the server is under pressure
+var AdaptiveLatencyFactor = 2.0
+
+// concurrencyController sets the number of pages fetched in each batch.  It follows the usual additive increase,
+// multiplicative decrease scheme: the concurrency halves under pressure and rises by one after each healthy batch.
+type concurrencyController struct {
+	adaptive   bool
+	max        int
+	current    int
+	fastest    time.Duration
+	rateLimits int64
+}
+
+// newConcurrencyController returns a controller for up to max pages at once.  Without adaptive concurrency, it always
+// uses max.
+func newConcurrencyController(max int, adaptive bool) *concurrencyController {
+	if max < 1 {
+		max = 1
+	}
+	return &concurrencyController{
+		adaptive:   adaptive,
+		max:        max,
+		current:    max,
+		rateLimits: rateLimitCount.Load(),
+	}
+}
+
+// workers returns the number of pages to fetch in the next batch
+func (c *concurrencyController) workers() int {
+	return c.current
+}
+
+// observe records how long a batch took, and adjusts the concurrency for the next one
+func (c *concurrencyController) observe(elapsed time.Duration) {
+
+	if !c.adaptive {
+		return
+	}
+
+	rateLimits := rateLimitCount.Load()
+	rateLimited := rateLimits > c.rateLimits
+	c.rateLimits = rateLimits
+
+	slow := c.fastest > 0 && float64(elapsed) > AdaptiveLatencyFactor*float64(c.fastest)
+	if c.fastest == 0 || elapsed < c.fastest {
+		c.fastest = elapsed
+	}
+
+	previous := c.current
+	switch {
+	case rateLimited || slow:
+		c.current = max(1, c.current/2)
+	case c.current < c.max:
+		c.current++
+	}
+
+	if c.current != previous {
+		reason := "ramping up"
+		if rateLimited {
+			reason = "rate limited"
+		} else if slow {
+			reason = fmt.Sprintf("batch took %s", elapsed.Round(time.Millisecond))
+		}
+		DebugPrint(fmt.Sprintf("Adaptive concurrency: %d -> %d (%s)", previous, c.current, reason))
+	}
+}
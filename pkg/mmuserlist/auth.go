@@ -0,0 +1,170 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// tokenRefreshTransport wraps an HTTP transport so that a request rejected with 401 Unauthorized triggers the
+// configured token refresh command, after which the request is retried once with the new token.  This allows long
+// exports to survive short-lived session tokens expiring part way through.  Once refreshed, the token is held here and
+// set on every request, rather than on the client, which concurrent page fetches are reading.
+type tokenRefreshTransport struct {
+	base    http.RoundTripper
+	command string
+
+	mutex sync.Mutex
+	token string
+}
+
+// currentToken returns the refreshed token, or an empty string if the token hasn't been refreshed
+func (t *tokenRefreshTransport) currentToken() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.token
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *tokenRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if token := t.currentToken(); token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(model.HeaderAuth, model.HeaderBearer+" "+token)
+	}
+
+	response, err := t.base.RoundTrip(req)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	// A request body can only be replayed if it can be recreated
+	if req.Body != nil && req.GetBody == nil {
+		return response, nil
+	}
+
+	usedToken := strings.TrimSpace(strings.TrimPrefix(req.Header.Get(model.HeaderAuth), model.HeaderBearer))
+	newToken, refreshErr := t.refresh(usedToken)
+	if refreshErr != nil {
+		LogMessage(ErrorLevel, "Token refresh failed: "+refreshErr.Error())
+		return response, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return response, nil
+		}
+		retry.Body = body
+	}
+	retry.Header.Set(model.HeaderAuth, model.HeaderBearer+" "+newToken)
+
+	response.Body.Close()
+	DebugPrint("Retrying request with refreshed token: " + req.URL.Path)
+
+	return t.base.RoundTrip(retry)
+}
+
+// refresh obtains a new token, unless another request has already refreshed the token that was rejected
+func (t *tokenRefreshTransport) refresh(rejectedToken string) (string, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.token != "" && t.token != rejectedToken {
+		return t.token, nil
+	}
+
+	LogMessage(WarningLevel, "Mattermost rejected the auth token - running the token refresh command")
+
+	token, err := runTokenCommand(t.command)
+	if err != nil {
+		return "", err
+	}
+
+	RegisterSecret(token)
+	t.token = token
+
+	return token, nil
+}
+
+// runTokenCommand executes a command through the system shell and returns its trimmed standard output as the token
+func runTokenCommand(command string) (string, error) {
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", errors.New("the token refresh command did not output a token")
+	}
+
+	return token, nil
+}
+
+// enableTokenRefresh installs the token refresh transport on the client's HTTP client
+func enableTokenRefresh(mmClient *model.Client4, command string) {
+	base := mmClient.HTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	mmClient.HTTPClient.Transport = &tokenRefreshTransport{
+		base:    base,
+		command: command,
+	}
+}
+
+// mfaErrorID is the server error returned when a login needs a valid MFA code
+const mfaErrorID = "api.user.check_user_mfa.bad_code.app_error"
+
+// ErrMFARequired is returned by Login when the account has MFA enabled and no MFA code was supplied
+var ErrMFARequired = errors.New("the account requires an MFA code")
+
+// Login authenticates with a login ID (username or email address) and password, plus an MFA code for accounts that
+// need one, and switches the client to the session token the server issues.  It is an alternative to a personal
+// access token, for servers where those are disabled.
+func Login(mmClient *model.Client4, loginID string, password string, mfaCode string) error {
+
+	DebugPrint("Logging in as: " + loginID)
+
+	_, response, err := mmClient.LoginWithMFA(context.Background(), loginID, password, mfaCode)
+
+	if err != nil {
+		var appErr *model.AppError
+		if mfaCode == "" && errors.As(err, &appErr) && appErr.Id == mfaErrorID {
+			return ErrMFARequired
+		}
+		LogMessage(ErrorLevel, "Error returned from Login(): "+err.Error())
+		return err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from Login()")
+		return errors.New("failed to log in to Mattermost")
+	}
+
+	RegisterSecret(mmClient.AuthToken)
+	DebugPrint("Logged in as: " + loginID)
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package mmuserlist
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// newTokenServer starts a server that only accepts the given token, echoing the body of each request it accepts
+func newTokenServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(model.HeaderAuth) != model.HeaderBearer+" "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTokenRefreshTransport(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the refresh command is a POSIX shell command")
+	}
+
+	server := newTokenServer(t, "fresh-token")
+	runs := filepath.Join(t.TempDir(), "runs")
+	transport := &tokenRefreshTransport{
+		base:    http.DefaultTransport,
+		command: "echo run >> " + runs + "; echo fresh-token",
+	}
+	client := &http.Client{Transport: transport}
+
+	// Concurrent requests rejected with the expired token refresh it once between them, and are each retried
+	var wait sync.WaitGroup
+	errs := make(chan string, 8)
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			request, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("body"))
+			request.Header.Set(model.HeaderAuth, model.HeaderBearer+" expired-token")
+			response, err := client.Do(request)
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != http.StatusOK || string(body) != "body" {
+				errs <- response.Status + " " + string(body)
+			}
+		}()
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("expected the request, with its body, to be retried with the new token: %s", err)
+	}
+
+	ran, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(string(ran), "run"); count != 1 {
+		t.Errorf("expected the refresh command to run once, but it ran %d times", count)
+	}
+
+	// Later requests use the new token straight away
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request.Header.Set(model.HeaderAuth, model.HeaderBearer+" expired-token")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected the refreshed token to be used, got %s", response.Status)
+	}
+	if ran, _ := os.ReadFile(runs); strings.Count(string(ran), "run") != 1 {
+		t.Errorf("expected the token not to be refreshed again")
+	}
+}
+
+func TestTokenRefreshTransportCommandFails(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the refresh command is a POSIX shell command")
+	}
+
+	server := newTokenServer(t, "fresh-token")
+	client := &http.Client{Transport: &tokenRefreshTransport{base: http.DefaultTransport, command: "exit 1"}}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request.Header.Set(model.HeaderAuth, model.HeaderBearer+" expired-token")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the original 401 response when the refresh fails, got %s", response.Status)
+	}
+}
@@ -0,0 +1,150 @@
+package mmuserlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Auth anomaly rules, as reported against each anomaly
+const (
+	ruleMissingAuthData = "missing-auth-data"
+	rulePasswordOnSSO   = "password-on-sso-server"
+	rulePasswordReset   = "password-reset-required"
+)
+
+// AuthSettings holds the parts of the server configuration that the auth audit checks accounts against
+type AuthSettings struct {
+	// PasswordSignIn is false when both email and username sign-in are disabled, leaving SSO as the only way in
+	PasswordSignIn bool
+	// MaximumLoginAttempts is the number of failed attempts after which an account is locked until its password is reset
+	MaximumLoginAttempts int
+}
+
+// AuthAnomaly records a single auth anomaly found on a user's account
+type AuthAnomaly struct {
+	User   *User
+	Rule   string
+	Detail string
+}
+
+// GetAuthSettings reads the sign-in settings from the server configuration
+func GetAuthSettings(mmClient *model.Client4) (*AuthSettings, error) {
+
+	DebugPrint("In GetAuthSettings")
+
+	config, response, err := mmClient.GetConfig(context.Background())
+
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetConfig(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetConfig()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	settings := &AuthSettings{PasswordSignIn: true}
+	if config.EmailSettings.EnableSignInWithEmail != nil && config.EmailSettings.EnableSignInWithUsername != nil {
+		settings.PasswordSignIn = *config.EmailSettings.EnableSignInWithEmail || *config.EmailSettings.EnableSignInWithUsername
+	}
+	if config.ServiceSettings.MaximumLoginAttempts != nil {
+		settings.MaximumLoginAttempts = *config.ServiceSettings.MaximumLoginAttempts
+	}
+
+	return settings, nil
+}
+
+// isPasswordAccount reports whether a user signs in with a password held by Mattermost
+func isPasswordAccount(user *User) bool {
+	return user.AuthService == "" || user.AuthService == model.UserAuthServiceEmail
+}
+
+// CheckAuthAnomalies returns every auth anomaly found on the users' accounts.  Without the server settings, only the
+// checks that need nothing but the user records are made.
+//
+// Servers that sanitise the auth data of every user make the missing auth data check meaningless, so it is only made
+// when at least one SSO account has auth data.
+func CheckAuthAnomalies(users []*User, settings *AuthSettings) []AuthAnomaly {
+
+	authDataReturned := false
+	for _, user := range users {
+		if !isPasswordAccount(user) && user.AuthData != "" {
+			authDataReturned = true
+			break
+		}
+	}
+	if !authDataReturned {
+		LogMessage(WarningLevel, "The server didn't return auth data for any SSO account - skipping the '"+ruleMissingAuthData+"' check")
+	}
+
+	var anomalies []AuthAnomaly
+
+	for _, user := range users {
+		if authDataReturned && !isPasswordAccount(user) && user.AuthData == "" {
+			anomalies = append(anomalies, AuthAnomaly{User: user, Rule: ruleMissingAuthData, Detail: "Auth service '" + user.AuthService + "' is set but the auth data is empty"})
+		}
+		if settings == nil || !isPasswordAccount(user) || user.IsBotAccount {
+			continue
+		}
+		if !settings.PasswordSignIn {
+			anomalies = append(anomalies, AuthAnomaly{User: user, Rule: rulePasswordOnSSO, Detail: "Password account on a server where only SSO sign-in is enabled"})
+		}
+		if settings.MaximumLoginAttempts > 0 && user.FailedAttempts >= settings.MaximumLoginAttempts {
+			anomalies = append(anomalies, AuthAnomaly{User: user, Rule: rulePasswordReset, Detail: fmt.Sprintf("Locked after %d failed sign-in attempts until the password is reset", user.FailedAttempts)})
+		}
+	}
+
+	return anomalies
+}
+
+// WriteAuthAnomalies writes the clean-up list, with one row per anomaly, in the requested format
+func WriteAuthAnomalies(anomalies []AuthAnomaly, filePath string, output OutputOptions) error {
+
+	DebugPrint("Writing auth anomalies to: " + filePath)
+
+	file, err := CreateOutput(filePath, output.Compress)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to create file: "+filePath+" - "+err.Error())
+		return err
+	}
+	defer file.Close()
+
+	if output.Format == FormatJSON {
+		type jsonAnomaly struct {
+			UserID      string `json:"user_id"`
+			Username    string `json:"username"`
+			Email       string `json:"email"`
+			AuthService string `json:"auth_service"`
+			Rule        string `json:"rule"`
+			Detail      string `json:"detail"`
+		}
+		records := make([]jsonAnomaly, 0, len(anomalies))
+		for _, anomaly := range anomalies {
+			user := anomaly.User
+			records = append(records, jsonAnomaly{user.UserID, user.Username, user.Email, user.AuthService, anomaly.Rule, anomaly.Detail})
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return file.Commit()
+	}
+
+	writer := newCSVWriter(file, output.CSV)
+	writer.Write([]string{"Username", "Email", "Auth Service", "Rule", "Detail"})
+	for _, anomaly := range anomalies {
+		user := anomaly.User
+		writer.Write([]string{user.Username, user.Email, user.AuthService, anomaly.Rule, anomaly.Detail})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
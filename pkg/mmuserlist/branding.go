@@ -0,0 +1,70 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+)
+
+// logoTypes maps the image types accepted for a logo to the file extension the XLSX and PDF writers expect
+var logoTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// Branding is an organization's report template: a logo and title at the top of each report and a line of footer
+// text at the bottom, so that reports can be handed to stakeholders as they are.  It is applied to the XLSX, PDF and
+// HTML outputs; the CSV and JSON outputs are left as plain data.
+type Branding struct {
+	Title  string
+	Footer string
+
+	logo          []byte
+	logoExtension string
+	logoWidth     int
+	logoHeight    int
+}
+
+// LoadBranding prepares a report template, reading the logo (a PNG, JPEG or GIF image) if a file is named.  It
+// returns nil if no branding is configured.
+func LoadBranding(title string, logoFile string, footer string) (*Branding, error) {
+
+	if title == "" && logoFile == "" && footer == "" {
+		return nil, nil
+	}
+
+	branding := &Branding{Title: title, Footer: footer}
+
+	if logoFile != "" {
+		DebugPrint("Loading branding logo from: " + logoFile)
+		data, err := os.ReadFile(logoFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read logo: %w", err)
+		}
+		extension, found := logoTypes[http.DetectContentType(data)]
+		if !found {
+			return nil, fmt.Errorf("the logo %s is not a PNG, JPEG or GIF image", logoFile)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || config.Width == 0 || config.Height == 0 {
+			return nil, fmt.Errorf("the logo %s could not be read as an image", logoFile)
+		}
+		branding.logo = data
+		branding.logoExtension = extension
+		branding.logoWidth = config.Width
+		branding.logoHeight = config.Height
+	}
+
+	return branding, nil
+}
+
+// hasLogo reports whether the template includes a logo
+func (b *Branding) hasLogo() bool {
+	return b != nil && len(b.logo) > 0
+}
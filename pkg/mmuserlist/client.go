@@ -0,0 +1,177 @@
+package mmuserlist
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Connection holds the details needed to reach a Mattermost server
+type Connection struct {
+	URL             string
+	Port            string
+	Scheme          string
+	Token           string
+	TokenRefreshCmd string
+	Cloud           bool
+	// TLSConfig, if set, replaces the default TLS configuration, e.g. to trust an internal CA.  See LoadTLSConfig.
+	TLSConfig *tls.Config
+	// Proxy, if set, is used for every request in place of any proxy named by HTTP_PROXY/HTTPS_PROXY.  See
+	// ParseProxyURL.
+	Proxy *url.URL
+}
+
+// Connection defaults, and the number of users requested per page with offset pagination
+const (
+	DefaultPort   = "8065"
+	DefaultScheme = "http"
+	PageSize      = 60
+	maxErrors     = 3
+)
+
+// Limits on the number of pages fetched at once with offset pagination.  A serial crawl is the default, so that the
+// server isn't loaded any more than it was before concurrency was requested.
+const (
+	DefaultConcurrency = 1
+	MaxConcurrency     = 8
+)
+
+// Mattermost Cloud workspaces are only reachable over HTTPS on the standard port, and apply stricter rate limits than
+// a typical self-hosted server, so requests to them are spaced out
+const (
+	CloudDomain          = ".cloud.mattermost.com"
+	CloudPort            = "443"
+	CloudScheme          = "https"
+	cloudRequestInterval = 100 * time.Millisecond
+)
+
+// Pagination modes.  The cursor mode uses the user reporting API, which pages by the last username/ID returned rather
+// than by offset, and is only available on newer servers.
+const (
+	PaginationAuto   = "auto"
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+)
+
+// ErrCursorAPIUnavailable is returned when cursor pagination is requested from a server without the user reporting API
+var ErrCursorAPIUnavailable = errors.New("the cursor-based user reporting API is not available on this server")
+
+// IsCloudHost reports whether a server address belongs to a Mattermost Cloud workspace
+func IsCloudHost(url string) bool {
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(url), "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	host, _, _ = strings.Cut(host, ":")
+	return strings.HasSuffix(host, CloudDomain)
+}
+
+// ServerURL returns the base URL of the server.  The URL may include a subpath, for servers published under a path
+// by a reverse proxy (e.g. intranet.example.com/mattermost), which is kept after the host and port so that API routes
+// are built beneath it.  A port given in the URL itself takes the place of the configured port.  The port is left out
+// when it is the scheme's standard port, as it is for Cloud workspaces, which don't accept an explicit port in every
+// case.
+func ServerURL(connection Connection) string {
+	address := strings.TrimPrefix(strings.TrimPrefix(connection.URL, "https://"), "http://")
+	host, path, _ := strings.Cut(address, "/")
+	path = strings.Trim(path, "/")
+	if path != "" {
+		path = "/" + path
+	}
+
+	port := connection.Port
+	if hostname, urlPort, found := strings.Cut(host, ":"); found && urlPort != "" {
+		host, port = hostname, urlPort
+	}
+
+	if (connection.Scheme == "https" && port == "443") || (connection.Scheme == "http" && port == "80") {
+		return fmt.Sprintf("%s://%s%s", connection.Scheme, host, path)
+	}
+	return fmt.Sprintf("%s://%s:%s%s", connection.Scheme, host, port, path)
+}
+
+// throttleTransport wraps an HTTP transport so that requests are started no more often than the given interval
+type throttleTransport struct {
+	base     http.RoundTripper
+	interval time.Duration
+
+	mutex sync.Mutex
+	next  time.Time
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	t.mutex.Lock()
+	now := time.Now()
+	wait := t.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	t.next = now.Add(wait + t.interval)
+	t.mutex.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// enableThrottle installs the throttling transport on the client's HTTP client
+func enableThrottle(mmClient *model.Client4, interval time.Duration) {
+	base := mmClient.HTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	mmClient.HTTPClient.Transport = &throttleTransport{
+		base:     base,
+		interval: interval,
+	}
+}
+
+// enableTransport installs a transport with the connection's TLS configuration and proxy on the client's HTTP client,
+// in place of the default transport.  Without a proxy of its own, the transport still uses the proxy environment
+// variables.
+func enableTransport(mmClient *model.Client4, connection Connection) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if connection.TLSConfig != nil {
+		DebugPrint("Custom TLS configuration in use")
+		transport.TLSClientConfig = connection.TLSConfig
+	}
+	if connection.Proxy != nil {
+		DebugPrint("Using proxy: " + connection.Proxy.Redacted())
+		transport.Proxy = proxyFunc(connection.Proxy)
+	}
+	mmClient.HTTPClient.Transport = transport
+}
+
+// NewClient creates an API client for the Mattermost instance described by the connection details
+func NewClient(connection Connection) *model.Client4 {
+
+	mmTarget := ServerURL(connection)
+
+	DebugPrint("Full target for Mattermost: " + mmTarget)
+	mmClient := model.NewAPIv4Client(mmTarget)
+	if connection.TLSConfig != nil || connection.Proxy != nil {
+		enableTransport(mmClient, connection)
+	}
+	mmClient.SetToken(connection.Token)
+	enableRateLimitRetry(mmClient)
+	if connection.TokenRefreshCmd != "" {
+		DebugPrint("Token refresh command configured")
+		enableTokenRefresh(mmClient, connection.TokenRefreshCmd)
+	}
+	if connection.Cloud {
+		DebugPrint("Mattermost Cloud workspace - throttling requests")
+		enableThrottle(mmClient, cloudRequestInterval)
+	}
+	DebugPrint("Connected to Mattermost")
+
+	return mmClient
+}
@@ -0,0 +1,218 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Client types, as reported in the client usage columns
+const (
+	clientDesktop = "Desktop"
+	clientMobile  = "Mobile"
+	clientWeb     = "Web"
+)
+
+// desktopAppBrowser is the browser name the server records for sessions created by the desktop app
+const desktopAppBrowser = "Desktop App"
+
+// clientUsage describes the client used for a single session
+type clientUsage struct {
+	Client   string
+	Version  string
+	Platform string
+}
+
+// classifySession works out which client created a session from its device ID and props.  Access token, OAuth and bot
+// sessions are not interactive clients, so they are ignored.
+func classifySession(session *model.Session) (clientUsage, bool) {
+
+	if session.IsUserAccessToken() || session.IsOAuth || session.IsBotUser() {
+		return clientUsage{}, false
+	}
+
+	usage := clientUsage{Platform: session.Props[model.SessionPropOs]}
+	if usage.Platform == "" {
+		usage.Platform = session.Props[model.SessionPropPlatform]
+	}
+
+	browser := session.Props[model.SessionPropBrowser]
+	name, version, _ := strings.Cut(browser, "/")
+
+	switch {
+	case session.IsMobileApp():
+		usage.Client = clientMobile
+		usage.Version = session.Props[model.SessionPropMobileVersion]
+	case name == desktopAppBrowser:
+		usage.Client = clientDesktop
+		usage.Version = version
+	case browser != "":
+		usage.Client = clientWeb
+		usage.Version = browser
+	default:
+		return clientUsage{}, false
+	}
+
+	return usage, true
+}
+
+// ApplyClientUsage records the client each user last connected with, taken from their most recently active session.
+// Reading another user's sessions needs the system admin permission, so if the token is refused the columns are left
+// blank with a warning rather than failing the export.
+func ApplyClientUsage(mmClient *model.Client4, users []*User) error {
+
+	DebugPrint(fmt.Sprintf("Retrieving client usage for %d users", len(users)))
+
+	ctx := context.Background()
+	lastClient := make(map[string]clientUsage)
+
+	for _, userID := range uniqueUserIDs(users) {
+		sessions, response, err := mmClient.GetSessions(ctx, userID, "")
+
+		if response != nil && response.StatusCode == http.StatusForbidden {
+			LogMessage(WarningLevel, "The auth token does not permit reading user sessions - client usage will not be reported")
+			return nil
+		}
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetSessions(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetSessions()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		var lastActivity int64
+		for _, session := range sessions {
+			usage, ok := classifySession(session)
+			if ok && session.LastActivityAt > lastActivity {
+				lastActivity = session.LastActivityAt
+				lastClient[userID] = usage
+			}
+		}
+	}
+
+	for _, user := range users {
+		usage := lastClient[user.UserID]
+		user.LastClient = usage.Client
+		user.LastClientVersion = usage.Version
+		user.LastClientPlatform = usage.Platform
+	}
+
+	logClientUsage(users)
+
+	return nil
+}
+
+// logClientUsage summarises how many users last connected with each client and version
+func logClientUsage(users []*User) {
+
+	counts := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, user := range users {
+		if seen[user.UserID] {
+			continue
+		}
+		seen[user.UserID] = true
+
+		key := "No active session"
+		if user.LastClient != "" {
+			key = strings.TrimSpace(user.LastClient + " " + user.LastClientVersion)
+		}
+		counts[key]++
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		LogMessage(InfoLevel, fmt.Sprintf("Client usage - %s: %d users", key, counts[key]))
+	}
+}
+
+// sessionClient describes the client that created a session, with its platform where known, e.g. 'Mobile (iOS)'
+func sessionClient(usage clientUsage) string {
+	if usage.Platform == "" {
+		return usage.Client
+	}
+	return usage.Client + " (" + usage.Platform + ")"
+}
+
+// ApplySessions records each user's active sessions: how many there are, when the most recent was created, and the
+// clients that created them.  As for client usage, access token, OAuth and bot sessions are left out, and if the token
+// isn't permitted to read other users' sessions, the columns are left blank with a warning.
+func ApplySessions(mmClient *model.Client4, users []*User) error {
+
+	DebugPrint(fmt.Sprintf("Retrieving sessions for %d users", len(users)))
+
+	ctx := context.Background()
+	type sessionSummary struct {
+		count   int
+		created time.Time
+		clients []string
+	}
+	summaries := make(map[string]sessionSummary)
+
+	for _, userID := range uniqueUserIDs(users) {
+		sessions, response, err := mmClient.GetSessions(ctx, userID, "")
+
+		if response != nil && response.StatusCode == http.StatusForbidden {
+			LogMessage(WarningLevel, "The auth token does not permit reading user sessions - sessions will not be reported")
+			return nil
+		}
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetSessions(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetSessions()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		var summary sessionSummary
+		for _, session := range sessions {
+			usage, ok := classifySession(session)
+			if !ok || session.IsExpired() {
+				continue
+			}
+			summary.count++
+			if created := time.UnixMilli(session.CreateAt); created.After(summary.created) {
+				summary.created = created
+			}
+			if client := sessionClient(usage); !slices.Contains(summary.clients, client) {
+				summary.clients = append(summary.clients, client)
+			}
+		}
+		sort.Strings(summary.clients)
+		summaries[userID] = summary
+	}
+
+	mobileOnly := 0
+	for _, summary := range summaries {
+		if summary.count > 0 && !slices.ContainsFunc(summary.clients, func(client string) bool {
+			return !strings.HasPrefix(client, clientMobile)
+		}) {
+			mobileOnly++
+		}
+	}
+	LogMessage(InfoLevel, fmt.Sprintf("Sessions - %d users only have sessions from the mobile app", mobileOnly))
+
+	for _, user := range users {
+		summary := summaries[user.UserID]
+		user.SessionCount = summary.count
+		user.LastSessionAt = summary.created
+		user.SessionClients = summary.clients
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// namedColumn is a column that can be chosen with OutputOptions.Columns, by the name also used as its JSON key
+type namedColumn struct {
+	Name string
+	column
+}
+
+// namedColumns are the columns that can be chosen for output, in the order they are documented.  Those after the
+// standard columns are only filled in when the data they show has been retrieved (e.g. roles with TeamRolesEnrichment).
+var namedColumns = []namedColumn{
+	{"user_id", column{"User ID", func(user *User) interface{} { return user.UserID }}},
+	{"username", column{"Username", func(user *User) interface{} { return user.Username }}},
+	{"email", column{"Email", func(user *User) interface{} { return user.Email }}},
+	{"first_name", column{"First Name", func(user *User) interface{} { return user.FirstName }}},
+	{"last_name", column{"Last Name", func(user *User) interface{} { return user.LastName }}},
+	{"full_name", column{"Full Name", func(user *User) interface{} { return user.FullName() }}},
+	{"nickname", column{"Nickname", func(user *User) interface{} { return user.Nickname }}},
+	{"position", column{"Position", func(user *User) interface{} { return user.Position }}},
+	{"locale", column{"Locale", func(user *User) interface{} { return user.Locale }}},
+	{"timezone", column{"Timezone", func(user *User) interface{} { return user.Timezone }}},
+	{"is_bot_account", column{"Is Bot Account", func(user *User) interface{} { return user.IsBotAccount }}},
+	{"created_at", column{"User Created Date", func(user *User) interface{} { return user.UserCreatedAt }}},
+	{"last_activity", column{"Last Activity Date", func(user *User) interface{} { return user.LastActivityAt }}},
+	{"days_inactive", column{"Days Since Last Activity", func(user *User) interface{} { return user.DaysSinceLastActivity }}},
+	{"last_login", column{"Last Login Date", func(user *User) interface{} { return user.LastLoginAt }}},
+	{"team_name", column{"Team Name", func(user *User) interface{} { return user.TeamName }}},
+	{"deactivated", column{"Deactivated", func(user *User) interface{} { return !user.DeactivatedAt.IsZero() }}},
+	{"deactivated_at", column{"Deactivated Date", func(user *User) interface{} { return user.DeactivatedAt }}},
+	{"system_roles", column{"System Roles", func(user *User) interface{} { return strings.Join(strings.Fields(user.SystemRoles), ", ") }}},
+	{"auth_method", column{"Auth Method", func(user *User) interface{} { return user.AuthMethod() }}},
+	{"failed_attempts", column{"Failed Login Attempts", func(user *User) interface{} { return user.FailedAttempts }}},
+	{"is_guest", column{"Is Guest", func(user *User) interface{} { return user.IsGuest }}},
+	{"last_post", column{"Last Post Date", func(user *User) interface{} { return user.LastPostAt }}},
+	{"days_since_post", column{"Days Since Last Post", func(user *User) interface{} { return user.DaysSinceLastPost }}},
+	{"total_posts", column{"Total Posts", func(user *User) interface{} { return user.TotalPosts }}},
+	{"team_join_date", column{"Team Join Date", func(user *User) interface{} { return user.TeamJoinedAt }}},
+	{"teams", column{"Teams", func(user *User) interface{} { return strings.Join(user.Teams, ", ") }}},
+	{"channel_count", column{"Channel Count", func(user *User) interface{} { return user.ChannelCount }}},
+	{"roles", column{"Roles", func(user *User) interface{} { return strings.Join(user.Roles, ", ") }}},
+	{"guest_channels", column{"Guest Channels", func(user *User) interface{} { return strings.Join(user.GuestChannels, ", ") }}},
+	{"last_client", column{"Last Client", func(user *User) interface{} { return user.LastClient }}},
+	{"last_client_version", column{"Last Client Version", func(user *User) interface{} { return user.LastClientVersion }}},
+	{"last_client_platform", column{"Last Client Platform", func(user *User) interface{} { return user.LastClientPlatform }}},
+	{"active_sessions", column{"Active Sessions", func(user *User) interface{} { return user.SessionCount }}},
+	{"last_session", column{"Last Session Date", func(user *User) interface{} { return user.LastSessionAt }}},
+	{"session_clients", column{"Session Clients", func(user *User) interface{} { return strings.Join(user.SessionClients, ", ") }}},
+	{"channel_role", column{"Channel Role", func(user *User) interface{} { return user.ChannelRole }}},
+	{"channel_last_viewed", column{"Channel Last Viewed Date", func(user *User) interface{} { return user.ChannelLastViewedAt }}},
+	{"channel_msg_count", column{"Channel Message Count", func(user *User) interface{} { return user.ChannelMsgCount }}},
+	{"roles_changed_at", column{"Roles Last Changed Date", func(user *User) interface{} { return user.RolesChangedAt }}},
+	{"roles_changed_by", column{"Roles Changed By", func(user *User) interface{} { return user.RolesChangedBy }}},
+	{"roles_changed_to", column{"Roles Changed To", func(user *User) interface{} { return user.RolesChangedTo }}},
+}
+
+// ColumnNames returns the names of the columns that can be chosen with OutputOptions.Columns
+func ColumnNames() []string {
+	names := make([]string, len(namedColumns))
+	for i, named := range namedColumns {
+		names[i] = named.Name
+	}
+	return names
+}
+
+// lookupColumn returns the column with the given name
+func lookupColumn(name string) (column, bool) {
+	for _, named := range namedColumns {
+		if named.Name == name {
+			return named.column, true
+		}
+	}
+	return column{}, false
+}
+
+// ParseColumns parses a comma-separated list of column names, as accepted by OutputOptions.Columns, checking that
+// each one is known and appears only once
+func ParseColumns(value string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, found := lookupColumn(name); !found {
+			return nil, fmt.Errorf("unknown column '%s'", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column '%s' is listed more than once", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no columns are listed")
+	}
+	return names, nil
+}
+
+// selectedColumns returns the named columns, in the order given.  Unknown names are skipped, as ParseColumns has
+// already reported them.
+func selectedColumns(names []string) []column {
+	var columns []column
+	for _, name := range names {
+		if selected, found := lookupColumn(name); found {
+			columns = append(columns, selected)
+		}
+	}
+	return columns
+}
+
+// selectedRecord is the JSON representation of a user with the chosen columns, which keeps the fields in the order
+// the columns were given
+type selectedRecord struct {
+	names  []string
+	values []interface{}
+}
+
+// newSelectedRecord builds the JSON representation of a user from the chosen columns, and any props and tags
+func newSelectedRecord(user *User, output OutputOptions) selectedRecord {
+	record := selectedRecord{names: output.Columns}
+	for _, selected := range selectedColumns(output.Columns) {
+		value := selected.Value(user)
+		if t, isTime := value.(time.Time); isTime {
+			value = output.Dates.timestamp(t)
+		}
+		record.values = append(record.values, value)
+	}
+	if output.PropsMode != PropsNone && len(user.Props) > 0 {
+		record.names = append(record.names[:len(record.names):len(record.names)], "props")
+		record.values = append(record.values, user.Props)
+	}
+	if len(output.Tags) > 0 {
+		record.names = append(record.names[:len(record.names):len(record.names)], "tags")
+		record.values = append(record.values, output.Tags)
+	}
+	return record
+}
+
+// MarshalJSON implements json.Marshaler
+func (r selectedRecord) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, name := range r.names {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// nonIdentifier matches the characters that are left out of a column identifier
+var nonIdentifier = regexp.MustCompile(`[^a-z0-9]+`)
+
+// columnIdentifiers returns names for the columns that suit a database: their headers in snake case, e.g.
+// days_since_last_activity, or prop_customstatus for a prop.  A name that would be repeated, or that is reserved for
+// another column, is numbered.
+func columnIdentifiers(columns []column, reserved ...string) []string {
+	names := make([]string, len(columns))
+	seen := make(map[string]bool)
+	for _, name := range reserved {
+		seen[name] = true
+	}
+	for i, column := range columns {
+		name := strings.Trim(nonIdentifier.ReplaceAllString(strings.ToLower(column.Header), "_"), "_")
+		if name == "" {
+			name = "column"
+		}
+		unique := name
+		for n := 2; seen[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[unique] = true
+		names[i] = unique
+	}
+	return names
+}
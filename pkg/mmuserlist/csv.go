@@ -0,0 +1,234 @@
+package mmuserlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark that tells Excel a CSV file is UTF-8
+const utf8BOM = "\ufeff"
+
+// CSVRunColumn is the column added with OutputOptions.Append, recording when each row was exported
+const CSVRunColumn = "Run Timestamp"
+
+// CSVOptions is the dialect of CSV output.  The zero value gives standard comma-separated output, with fields
+// quoted only where needed and LF line endings.
+type CSVOptions struct {
+	Delimiter rune // ',' if not set; e.g. ';' for Excel in locales with a decimal comma, or '\t' for TSV
+	QuoteAll  bool // quote every field, not just those that need it
+	CRLF      bool // end lines with CRLF, as Windows tools expect
+	BOM       bool // start the file with a UTF-8 byte order mark, so that Excel detects the encoding
+}
+
+// ParseCSVDelimiter reads a CSV delimiter, given as a single character or as 'tab'
+func ParseCSVDelimiter(value string) (rune, error) {
+	if value == "tab" || value == `\t` {
+		return '\t', nil
+	}
+	delimiter, size := utf8.DecodeRuneInString(value)
+	if size == 0 || size != len(value) || delimiter == utf8.RuneError {
+		return 0, fmt.Errorf("the delimiter must be a single character or 'tab', not '%s'", value)
+	}
+	if delimiter == '"' || delimiter == '\r' || delimiter == '\n' {
+		return 0, fmt.Errorf("'%s' can't be used as the delimiter", value)
+	}
+	return delimiter, nil
+}
+
+// delimiter returns the field delimiter of the dialect
+func (options CSVOptions) delimiter() rune {
+	if options.Delimiter == 0 {
+		return ','
+	}
+	return options.Delimiter
+}
+
+// csvWriter writes CSV records in a dialect.  It has the same methods as csv.Writer, which does the work unless
+// every field has to be quoted.
+type csvWriter struct {
+	out     *bufio.Writer
+	csv     *csv.Writer
+	options CSVOptions
+	err     error
+}
+
+// newCSVWriter starts CSV output in the dialect, beginning with the byte order mark if one is wanted
+func newCSVWriter(out io.Writer, options CSVOptions) *csvWriter {
+	writer := &csvWriter{out: bufio.NewWriter(out), options: options}
+	writer.csv = csv.NewWriter(writer.out)
+	writer.csv.Comma = options.delimiter()
+	writer.csv.UseCRLF = options.CRLF
+	if options.BOM {
+		_, writer.err = writer.out.WriteString(utf8BOM)
+	}
+	return writer
+}
+
+// lineEnding returns the line ending of the dialect
+func (options CSVOptions) lineEnding() string {
+	if options.CRLF {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// Write writes one record
+func (w *csvWriter) Write(record []string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.options.QuoteAll {
+		return w.csv.Write(record)
+	}
+
+	var line strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			line.WriteRune(w.options.delimiter())
+		}
+		field = strings.ReplaceAll(field, `"`, `""`)
+		if w.options.CRLF {
+			// As with csv.Writer, line breaks within a field follow the line endings
+			field = strings.ReplaceAll(strings.ReplaceAll(field, "\r\n", "\n"), "\n", "\r\n")
+		}
+		line.WriteString(`"` + field + `"`)
+	}
+	if w.options.CRLF {
+		line.WriteString("\r\n")
+	} else {
+		line.WriteString("\n")
+	}
+	_, w.err = w.out.WriteString(line.String())
+	return w.err
+}
+
+// Flush writes any buffered records to the underlying writer
+func (w *csvWriter) Flush() {
+	w.csv.Flush()
+	if err := w.out.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Error reports any error from a previous Write or Flush
+func (w *csvWriter) Error() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.csv.Error()
+}
+
+// readCSV reads every record of CSV written in the dialect
+func readCSV(in io.Reader, options CSVOptions) ([][]string, error) {
+	buffered := bufio.NewReader(in)
+	if start, err := buffered.Peek(len(utf8BOM)); err == nil && bytes.Equal(start, []byte(utf8BOM)) {
+		buffered.Discard(len(utf8BOM))
+	}
+	reader := csv.NewReader(buffered)
+	reader.Comma = options.delimiter()
+	return reader.ReadAll()
+}
+
+// csvColumns returns the columns of CSV user output: those of userColumns, followed with output.Append by the time of
+// the run, so that the rows added by each run can be told apart
+func csvColumns(users []*User, output OutputOptions) []column {
+	columns := userColumns(users, output)
+	if output.Append {
+		runAt := output.Dates.timestamp(time.Now())
+		columns = append(columns, column{CSVRunColumn, func(user *User) interface{} { return runAt }})
+	}
+	return columns
+}
+
+// startCSV starts CSV output of the columns with a header row.  With output.Append, the rows already in the
+// output.Existing file are copied to the output in place of the header, so that the new rows follow them.
+func startCSV(out io.Writer, columns []column, output OutputOptions) (*csvWriter, error) {
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Header
+	}
+
+	if output.Append && output.Existing != "" && output.Existing != StdoutPath {
+		copied, err := copyExistingCSV(out, output.Existing, header, output.CSV)
+		if err != nil {
+			return nil, err
+		}
+		if copied {
+			// The existing file already starts with any byte order mark
+			options := output.CSV
+			options.BOM = false
+			return newCSVWriter(out, options), nil
+		}
+	}
+
+	writer := newCSVWriter(out, output.CSV)
+	return writer, writer.Write(header)
+}
+
+// copyExistingCSV copies an existing CSV file to the output, so that rows can be added to it, and reports whether there
+// was anything to copy.  The file must have the same header as the rows being added, in the same dialect.
+func copyExistingCSV(out io.Writer, filePath string, header []string, options CSVOptions) (bool, error) {
+
+	existing, err := OpenReport(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer existing.Close()
+
+	// The header is read through a copy of everything read from the file, which is then written out ahead of the rest
+	var read bytes.Buffer
+	reader := csv.NewReader(io.TeeReader(existing, &read))
+	reader.Comma = options.delimiter()
+	existingHeader, err := reader.Read()
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read the existing file %s: %w", filePath, err)
+	}
+	existingHeader[0] = strings.TrimPrefix(existingHeader[0], utf8BOM)
+	if !slices.Equal(existingHeader, header) {
+		DebugPrint("Existing columns: " + strings.Join(existingHeader, ", "))
+		return false, fmt.Errorf("%s has different columns, so the new rows can't be added to it - use the same column options as the runs that wrote it, or a new file", filePath)
+	}
+
+	DebugPrint("Adding to the rows already in " + filePath)
+	copied := &endWriter{Writer: out}
+	if _, err := copied.Write(read.Bytes()); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(copied, existing); err != nil {
+		return false, err
+	}
+	if copied.last != '\n' {
+		if _, err := io.WriteString(out, options.lineEnding()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// endWriter remembers the last byte written, so that a missing line ending can be added
+type endWriter struct {
+	io.Writer
+	last byte
+}
+
+// Write implements io.Writer
+func (w *endWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.last = p[len(p)-1]
+	}
+	return w.Writer.Write(p)
+}
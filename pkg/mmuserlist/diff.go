@@ -0,0 +1,211 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrDiffUnsupported is returned by DiffReport for output formats that can't be compared
+var ErrDiffUnsupported = errors.New("only CSV, JSON and NDJSON reports can be compared")
+
+// diffIgnoredFields are the fields left out of a comparison because they change on every run
+var diffIgnoredFields = map[string]bool{
+	"Days Since Last Activity": true,
+	"days_since_last_activity": true,
+}
+
+// ReportDiff summarises how a new report differs from the existing file it would replace.  Rows are matched by
+// username and team name.
+type ReportDiff struct {
+	ExistingRows   int
+	NewRows        int
+	Added          []string
+	Removed        []string
+	Modified       []string
+	ChangedColumns map[string]int
+}
+
+// Shrinks reports whether the new report has fewer rows than the existing file
+func (d *ReportDiff) Shrinks() bool {
+	return d.NewRows < d.ExistingRows
+}
+
+// ChangedColumnNames returns the columns that changed in any modified row, most frequently changed first
+func (d *ReportDiff) ChangedColumnNames() []string {
+	names := make([]string, 0, len(d.ChangedColumns))
+	for name := range d.ChangedColumns {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if d.ChangedColumns[names[i]] != d.ChangedColumns[names[j]] {
+			return d.ChangedColumns[names[i]] > d.ChangedColumns[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// reportRecord is one row of a report, keyed by column (or JSON field) name
+type reportRecord map[string]string
+
+// readCSVRecords parses a CSV report, written in the dialect, into records
+func readCSVRecords(in io.Reader, options CSVOptions) ([]reportRecord, error) {
+	rows, err := readCSV(in, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	records := make([]reportRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(reportRecord, len(row))
+		for i, value := range row {
+			if i < len(rows[0]) {
+				record[rows[0][i]] = value
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readJSONRecords parses a JSON report into records, with each value re-encoded as JSON so that nested values (such
+// as roles) can be compared
+func readJSONRecords(in io.Reader) ([]reportRecord, error) {
+	var values []map[string]json.RawMessage
+	if err := json.NewDecoder(in).Decode(&values); err != nil {
+		return nil, err
+	}
+	return jsonRecords(values), nil
+}
+
+// readNDJSONRecords parses an NDJSON report, one JSON object per line, into records as readJSONRecords does
+func readNDJSONRecords(in io.Reader) ([]reportRecord, error) {
+	var values []map[string]json.RawMessage
+	decoder := json.NewDecoder(in)
+	for {
+		var value map[string]json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return jsonRecords(values), nil
+}
+
+// jsonRecords converts decoded JSON objects into records
+func jsonRecords(values []map[string]json.RawMessage) []reportRecord {
+	records := make([]reportRecord, 0, len(values))
+	for _, value := range values {
+		record := make(reportRecord, len(value))
+		for key, field := range value {
+			var plain string
+			if json.Unmarshal(field, &plain) == nil {
+				record[key] = plain
+			} else {
+				record[key] = string(field)
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// recordKey identifies the user and team a row is for
+func recordKey(record reportRecord) string {
+	for _, fields := range [][2]string{{"Username", "Team Name"}, {"username", "team_name"}} {
+		if username, found := record[fields[0]]; found {
+			if team := record[fields[1]]; team != "" {
+				return username + " (" + team + ")"
+			}
+			return username
+		}
+	}
+	return ""
+}
+
+// DiffReport compares the users that would be written with the existing report at filePath, which must be in the same
+// format.  Only CSV, JSON and NDJSON reports can be compared.
+func DiffReport(filePath string, users []*User, output OutputOptions) (*ReportDiff, error) {
+
+	var read func(in io.Reader) ([]reportRecord, error)
+	switch output.Format {
+	case FormatCSV:
+		read = func(in io.Reader) ([]reportRecord, error) {
+			return readCSVRecords(in, output.CSV)
+		}
+	case FormatJSON:
+		read = readJSONRecords
+	case FormatNDJSON:
+		read = readNDJSONRecords
+	default:
+		return nil, ErrDiffUnsupported
+	}
+
+	existingFile, err := OpenReport(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer existingFile.Close()
+
+	existing, err := read(existingFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the existing report %s: %w", filePath, err)
+	}
+
+	writer, _ := LookupWriter(output.Format)
+	var rendered bytes.Buffer
+	if err := writer.WriteUsers(&rendered, users, output); err != nil {
+		return nil, err
+	}
+	updated, err := read(&rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ReportDiff{ExistingRows: len(existing), NewRows: len(updated), ChangedColumns: make(map[string]int)}
+
+	previous := make(map[string]reportRecord, len(existing))
+	for _, record := range existing {
+		previous[recordKey(record)] = record
+	}
+
+	current := make(map[string]bool, len(updated))
+	for _, record := range updated {
+		key := recordKey(record)
+		current[key] = true
+
+		old, found := previous[key]
+		if !found {
+			diff.Added = append(diff.Added, key)
+			continue
+		}
+
+		modified := false
+		for column, value := range record {
+			if !diffIgnoredFields[column] && old[column] != value {
+				diff.ChangedColumns[column]++
+				modified = true
+			}
+		}
+		if modified {
+			diff.Modified = append(diff.Modified, key)
+		}
+	}
+
+	for _, record := range existing {
+		if key := recordKey(record); !current[key] {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	return diff, nil
+}
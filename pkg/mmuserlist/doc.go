@@ -0,0 +1,12 @@
+// Package mmuserlist retrieves user lists from a Mattermost server and exports them, for embedding in other tools.
+//
+// A typical export creates a client with NewClient, fetches users with FetchTeamUsers, FetchUsersInTeams,
+// FetchUsersInAllTeams or FetchUsersWithoutTeam, enriches and filters them, and writes them with WriteUsers.  Output
+// formats are provided by Writer implementations, and further formats can be added with RegisterWriter.  For very
+// large servers, the Stream functions pass each page of users to a PageFunc as it arrives, for a StreamWriter to write.
+//
+// Each way of selecting users is also a UserSource, and sources can be combined with AllOf and AnyOf, or parsed from a
+// source expression with ParseUserSource.
+//
+// Messages are logged through LogMessage, which writes to stdout/stderr unless a Logger is installed.
+package mmuserlist
@@ -0,0 +1,177 @@
+package mmuserlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ParseDomainList splits a list of email domains separated by commas and/or spaces, as used by the server's
+// 'Restrict new system and team members to specified email domains' setting
+func ParseDomainList(value string) []string {
+	var domains []string
+	for _, domain := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// GetAllowedDomains returns the email domains that the server restricts new accounts to.  Reading the server
+// configuration needs a system admin token.
+func GetAllowedDomains(mmClient *model.Client4) ([]string, error) {
+
+	DebugPrint("In GetAllowedDomains")
+
+	config, response, err := mmClient.GetConfig(context.Background())
+
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetConfig(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetConfig()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	if config.TeamSettings.RestrictCreationToDomains == nil {
+		return nil, nil
+	}
+	return ParseDomainList(*config.TeamSettings.RestrictCreationToDomains), nil
+}
+
+// emailDomain returns the lower-cased domain part of an email address
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+// FilterDomainViolations keeps only the users whose email domain is not one of the allowed domains.  As with the
+// server's own check, domains must match exactly, so subdomains need to be listed separately.
+func FilterDomainViolations(users []*User, allowed []string) []*User {
+	allowedSet := make(map[string]bool)
+	for _, domain := range allowed {
+		allowedSet[domain] = true
+	}
+	return FilterUsers(users, func(user *User) bool {
+		return !allowedSet[emailDomain(user.Email)]
+	})
+}
+
+// FilterByEmailDomain keeps only the users whose email domain is one of the given domains or, if keep is false, only
+// those whose domain isn't.  Domains must match exactly, so subdomains need to be listed separately.
+func FilterByEmailDomain(users []*User, domains []string, keep bool) []*User {
+	domainSet := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		domainSet[domain] = true
+	}
+	return FilterUsers(users, func(user *User) bool {
+		return domainSet[emailDomain(user.Email)] == keep
+	})
+}
+
+// domainViolationTeam returns the team a violation is reported under, falling back to the default team for users
+// without a team name (as in a channel export)
+func domainViolationTeam(user *User, defaultTeam string) string {
+	if user.TeamName != "" {
+		return user.TeamName
+	}
+	return defaultTeam
+}
+
+// WriteDomainViolations writes the users outside the allowed domains grouped by team, in the output format, and logs
+// how many were found in each team
+func WriteDomainViolations(users []*User, filePath string, output OutputOptions, defaultTeam string) error {
+
+	DebugPrint("Writing email domain violations to: " + filePath)
+
+	sorted := make([]*User, len(users))
+	copy(sorted, users)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		teamI, teamJ := domainViolationTeam(sorted[i], defaultTeam), domainViolationTeam(sorted[j], defaultTeam)
+		if teamI != teamJ {
+			return teamI < teamJ
+		}
+		return sorted[i].Username < sorted[j].Username
+	})
+
+	file, err := CreateOutput(filePath, output.Compress)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to create file: "+filePath+" - "+err.Error())
+		return err
+	}
+	defer file.Close()
+
+	counts := make(map[string]int)
+	var teams []string
+	for _, user := range sorted {
+		team := domainViolationTeam(user, defaultTeam)
+		if counts[team] == 0 {
+			teams = append(teams, team)
+		}
+		counts[team]++
+	}
+	for _, team := range teams {
+		name := team
+		if name == "" {
+			name = "(no team)"
+		}
+		LogMessage(InfoLevel, fmt.Sprintf("Email domain violations - %s: %d users", name, counts[team]))
+	}
+
+	if output.Format == FormatJSON {
+		type jsonViolation struct {
+			TeamName      string `json:"team_name"`
+			UserID        string `json:"user_id"`
+			Username      string `json:"username"`
+			Email         string `json:"email"`
+			Domain        string `json:"domain"`
+			UserCreatedAt string `json:"user_created_at"`
+		}
+		records := make([]jsonViolation, 0, len(sorted))
+		for _, user := range sorted {
+			records = append(records, jsonViolation{
+				TeamName:      domainViolationTeam(user, defaultTeam),
+				UserID:        user.UserID,
+				Username:      user.Username,
+				Email:         user.Email,
+				Domain:        emailDomain(user.Email),
+				UserCreatedAt: output.Dates.timestamp(user.UserCreatedAt),
+			})
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return file.Commit()
+	}
+
+	writer := newCSVWriter(file, output.CSV)
+	writer.Write([]string{"Team Name", "Username", "Email", "Domain", "User Created Date"})
+	for _, user := range sorted {
+		writer.Write([]string{
+			domainViolationTeam(user, defaultTeam),
+			user.Username,
+			user.Email,
+			emailDomain(user.Email),
+			output.Dates.Format(user.UserCreatedAt),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
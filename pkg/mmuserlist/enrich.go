@@ -0,0 +1,246 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// StatusBatchSize is the number of users whose status is requested in a single API call
+const StatusBatchSize = 200
+
+// uniqueUserIDs returns the IDs of the supplied users, without duplicates (a user can appear once per team)
+func uniqueUserIDs(users []*User) []string {
+	seen := make(map[string]bool)
+	var userIDs []string
+	for _, user := range users {
+		if !seen[user.UserID] {
+			seen[user.UserID] = true
+			userIDs = append(userIDs, user.UserID)
+		}
+	}
+	return userIDs
+}
+
+// setLastActivity records a user's last activity time and the number of days since then.  Users with no recorded
+// activity are counted as inactive since their account was created.
+func setLastActivity(user *User, lastActivity time.Time) {
+	user.LastActivityAt = lastActivity
+	if lastActivity.IsZero() {
+		lastActivity = user.UserCreatedAt
+	}
+	user.DaysSinceLastActivity = int(time.Since(lastActivity).Hours() / 24)
+}
+
+// ApplyLastActivity populates each user's last activity from their status record, which the server updates as the
+// user actually uses Mattermost.  (The user record's UpdateAt only changes when the profile itself is edited.)
+func ApplyLastActivity(mmClient *model.Client4, users []*User) error {
+
+	DebugPrint(fmt.Sprintf("Retrieving last activity for %d users", len(users)))
+
+	ctx := context.Background()
+	lastActivity := make(map[string]int64)
+	userIDs := uniqueUserIDs(users)
+
+	for start := 0; start < len(userIDs); start += StatusBatchSize {
+		end := min(start+StatusBatchSize, len(userIDs))
+
+		statuses, response, err := mmClient.GetUsersStatusesByIds(ctx, userIDs[start:end])
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetUsersStatusesByIds(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetUsersStatusesByIds()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for _, status := range statuses {
+			lastActivity[status.UserId] = status.LastActivityAt
+		}
+	}
+
+	for _, user := range users {
+		var activityTime time.Time
+		if millis := lastActivity[user.UserID]; millis > 0 {
+			activityTime = time.UnixMilli(millis)
+		}
+		setLastActivity(user, activityTime)
+	}
+
+	return nil
+}
+
+// Channel roles, as reported for each member of a channel
+const (
+	ChannelRoleMember = "member"
+	ChannelRoleAdmin  = "admin"
+	ChannelRoleGuest  = "guest"
+)
+
+// channelRole summarises a channel member's roles
+func channelRole(member *model.ChannelMember) string {
+	switch {
+	case member.SchemeAdmin || strings.Contains(member.Roles, model.ChannelAdminRoleId):
+		return ChannelRoleAdmin
+	case member.SchemeGuest || strings.Contains(member.Roles, model.ChannelGuestRoleId):
+		return ChannelRoleGuest
+	default:
+		return ChannelRoleMember
+	}
+}
+
+// ApplyChannelMembership populates each user's role, last viewed time and message count in the named channel, from
+// the channel member records.  Users who aren't members of the channel are left blank.
+func ApplyChannelMembership(mmClient *model.Client4, users []*User, team string, channelName string) error {
+
+	members, err := GetChannelMembers(mmClient, team, channelName)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		member, found := members[user.UserID]
+		if !found {
+			continue
+		}
+		user.ChannelRole = channelRole(member)
+		if member.LastViewedAt > 0 {
+			user.ChannelLastViewedAt = time.UnixMilli(member.LastViewedAt)
+		}
+		user.ChannelMsgCount = member.MsgCount
+	}
+
+	return nil
+}
+
+// ApplyChannelCounts populates the number of public and private channels each user is a member of in their team: the
+// team on their row or, for rows without a team name (as in a channel export), defaultTeam.  Direct and group
+// messages aren't counted.  Each user's channels are requested once per team, however many rows they appear on.
+func ApplyChannelCounts(mmClient *model.Client4, users []*User, defaultTeam string) error {
+
+	teamUsers := make(map[string][]*User)
+	var teamNames []string
+	for _, user := range users {
+		team := user.TeamName
+		if team == "" {
+			team = defaultTeam
+		}
+		if team == "" {
+			continue
+		}
+		if _, found := teamUsers[team]; !found {
+			teamNames = append(teamNames, team)
+		}
+		teamUsers[team] = append(teamUsers[team], user)
+	}
+
+	ctx := context.Background()
+	for _, name := range teamNames {
+		team, err := ResolveTeam(mmClient, name)
+		if err != nil {
+			return err
+		}
+
+		DebugPrint(fmt.Sprintf("Retrieving channel counts for %d users in team %s", len(teamUsers[name]), name))
+
+		counts := make(map[string]int)
+		for _, userID := range uniqueUserIDs(teamUsers[name]) {
+			channels, response, err := mmClient.GetChannelsForTeamForUser(ctx, team.Id, userID, false, "")
+
+			if err != nil {
+				LogMessage(ErrorLevel, "Error returned from GetChannelsForTeamForUser(): "+err.Error())
+				return err
+			}
+			if response.StatusCode != 200 {
+				LogMessage(ErrorLevel, "Bad HTTP response returned from GetChannelsForTeamForUser()")
+				return errors.New("failed to retrieve data from Mattermost")
+			}
+
+			for _, channel := range channels {
+				if channel.Type == model.ChannelTypeOpen || channel.Type == model.ChannelTypePrivate {
+					counts[userID]++
+				}
+			}
+		}
+
+		noChannels := 0
+		for _, user := range teamUsers[name] {
+			user.ChannelCount = counts[user.UserID]
+			if user.ChannelCount == 0 {
+				noChannels++
+			}
+		}
+		if noChannels > 0 {
+			LogMessage(InfoLevel, fmt.Sprintf("Channel counts - %d members of team %s are in no channels", noChannels, name))
+		}
+	}
+
+	return nil
+}
+
+// Enrichment adds data to a list of users that isn't part of the user record.  Enrichments call the API for every
+// user (or batch of users), so they should be applied after the filters, to avoid fetching data for users that are
+// then dropped.
+type Enrichment struct {
+	Name  string
+	Apply func(mmClient *model.Client4, users []*User) error
+}
+
+// The standard enrichments
+var (
+	LastActivityEnrichment  = Enrichment{Name: "last activity", Apply: ApplyLastActivity}
+	ClientUsageEnrichment   = Enrichment{Name: "client usage", Apply: ApplyClientUsage}
+	RoleHistoryEnrichment   = Enrichment{Name: "role history", Apply: ApplyRoleHistory}
+	TeamRolesEnrichment     = Enrichment{Name: "team roles", Apply: ApplyTeamRoles}
+	GuestChannelsEnrichment = Enrichment{Name: "guest channels", Apply: ApplyGuestChannels}
+	SessionsEnrichment      = Enrichment{Name: "sessions", Apply: ApplySessions}
+)
+
+// ChannelMembershipEnrichment returns the enrichment that adds the users' membership details for the named channel
+func ChannelMembershipEnrichment(team string, channelName string) Enrichment {
+	return Enrichment{
+		Name: "channel membership",
+		Apply: func(mmClient *model.Client4, users []*User) error {
+			return ApplyChannelMembership(mmClient, users, team, channelName)
+		},
+	}
+}
+
+// UserTeamsEnrichment returns the enrichment that adds every team each user is a member of, by display name if
+// displayNames is set
+func UserTeamsEnrichment(displayNames bool) Enrichment {
+	return Enrichment{
+		Name: "team memberships",
+		Apply: func(mmClient *model.Client4, users []*User) error {
+			return ApplyUserTeams(mmClient, users, displayNames)
+		},
+	}
+}
+
+// ChannelCountEnrichment returns the enrichment that adds the number of channels each user is a member of in their
+// team, with defaultTeam used for rows without a team name
+func ChannelCountEnrichment(defaultTeam string) Enrichment {
+	return Enrichment{
+		Name: "channel counts",
+		Apply: func(mmClient *model.Client4, users []*User) error {
+			return ApplyChannelCounts(mmClient, users, defaultTeam)
+		},
+	}
+}
+
+// ApplyEnrichments applies each of the enrichments to the users, in order
+func ApplyEnrichments(mmClient *model.Client4, users []*User, enrichments []Enrichment) error {
+	for _, enrichment := range enrichments {
+		DebugPrint(fmt.Sprintf("Applying %s enrichment to %d users", enrichment.Name, len(users)))
+		if err := enrichment.Apply(mmClient, users); err != nil {
+			return fmt.Errorf("failed to retrieve %s: %w", enrichment.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package mmuserlist
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// A page of an offset crawl that keeps failing (e.g. timing out behind a load balancer) is retried PageRetries times,
+// waiting PageRetryDelay and then twice as long before each attempt.  If it still fails and PageFallback is set, the
+// page is split into smaller pages, and any that fail are split again, down to single users, so that the crawl can
+// continue around the users that can't be retrieved.  The users skipped are recorded in the crawl's PageGapLog.
+var (
+	PageRetries    = 3
+	PageRetryDelay = time.Second
+	PageFallback   = true
+)
+
+// PageGap describes a range of users that an offset crawl skipped because their page couldn't be retrieved
+type PageGap struct {
+	Crawl  string
+	Offset int
+	Count  int
+	Err    error
+}
+
+// PageGapLog records the ranges of users skipped by the crawls it is given to in CrawlOptions.Gaps, for an export to
+// report once it is complete.  It is safe for concurrent use.
+type PageGapLog struct {
+	mutex sync.Mutex
+	gaps  []PageGap
+}
+
+// Gaps returns the ranges of users skipped so far
+func (l *PageGapLog) Gaps() []PageGap {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]PageGap(nil), l.gaps...)
+}
+
+// recordPageGap logs a range of users that was skipped, and records it in gapLog if set
+func recordPageGap(gap PageGap, gapLog *PageGapLog) {
+	users := fmt.Sprintf("users %d to %d", gap.Offset+1, gap.Offset+gap.Count)
+	if gap.Count == 1 {
+		users = fmt.Sprintf("user %d", gap.Offset+1)
+	}
+	LogMessage(WarningLevel, fmt.Sprintf("INCOMPLETE DATA: %s of %s couldn't be retrieved and have been skipped: %s", users, gap.Crawl, gap.Err.Error()))
+
+	if gapLog == nil {
+		return
+	}
+	gapLog.mutex.Lock()
+	defer gapLog.mutex.Unlock()
+	gapLog.gaps = append(gapLog.gaps, gap)
+}
+
+// PageFetcher retrieves one page of an offset crawl, of the given size
+type PageFetcher func(page int, perPage int) ([]*model.User, error)
+
+// fetchPageWithRetry retrieves a page, retrying with exponential backoff if it fails
+func fetchPageWithRetry(fetch PageFetcher, page int, perPage int) ([]*model.User, error) {
+
+	users, err := fetch(page, perPage)
+	delay := PageRetryDelay
+	for attempt := 1; err != nil && attempt <= PageRetries; attempt++ {
+		LogMessage(WarningLevel, fmt.Sprintf("Page %d (%d users per page) failed - waiting %s before retrying (attempt %d of %d)", page, perPage, delay, attempt, PageRetries))
+		time.Sleep(delay)
+		delay *= 2
+		users, err = fetch(page, perPage)
+	}
+	return users, err
+}
+
+// smallerPageSize returns the page size a failing page is split into: the page size divided by its smallest factor, so
+// that the smaller pages line up exactly with the original one.  A page of one user can't be split.
+func smallerPageSize(perPage int) int {
+	for factor := 2; factor*factor <= perPage; factor++ {
+		if perPage%factor == 0 {
+			return perPage / factor
+		}
+	}
+	return 1
+}
+
+// fetchPageWithFallback retrieves a page of an offset crawl, retrying it and then splitting it into smaller pages if
+// it keeps failing.  It returns the users retrieved and the number skipped, which are recorded in gapLog.  If the page
+// can't be retrieved at all, even in part, the original error is returned instead, since the server is more likely
+// to be down than to be failing on particular users.
+func fetchPageWithFallback(fetch PageFetcher, crawl string, page int, perPage int, gapLog *PageGapLog) ([]*model.User, int, error) {
+
+	users, err := fetchPageWithRetry(fetch, page, perPage)
+	if err == nil || !PageFallback || perPage == 1 {
+		return users, 0, err
+	}
+
+	LogMessage(WarningLevel, fmt.Sprintf("Page %d of %s keeps failing - retrieving it in smaller pages", page, crawl))
+
+	var gaps []PageGap
+	users, ok := fetchSplitPage(fetch, crawl, page*perPage, perPage, &gaps)
+	if !ok {
+		return nil, 0, err
+	}
+
+	skipped := 0
+	for _, gap := range gaps {
+		recordPageGap(gap, gapLog)
+		skipped += gap.Count
+	}
+	return users, skipped, nil
+}
+
+// fetchSplitPage retrieves the users from offset onwards in smaller pages, splitting again any of those that fail, and
+// adds each range that can't be retrieved to gaps.  If every one of the smaller pages fails, the range isn't split
+// further and ok is false.
+func fetchSplitPage(fetch PageFetcher, crawl string, offset int, count int, gaps *[]PageGap) (users []*model.User, ok bool) {
+
+	perPage := smallerPageSize(count)
+	DebugPrint(fmt.Sprintf("Retrieving users %d to %d of %s in pages of %d", offset+1, offset+count, crawl, perPage))
+
+	failed := make(map[int]error)
+	parts := make([][]*model.User, count/perPage)
+	for i := range parts {
+		parts[i], failed[i] = fetch(offset/perPage+i, perPage)
+		if failed[i] == nil {
+			delete(failed, i)
+			ok = true
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	for i, part := range parts {
+		if partErr, partFailed := failed[i]; partFailed {
+			partOffset := offset + i*perPage
+			if perPage == 1 {
+				*gaps = append(*gaps, PageGap{Crawl: crawl, Offset: partOffset, Count: 1, Err: partErr})
+				continue
+			}
+			var retrieved bool
+			if part, retrieved = fetchSplitPage(fetch, crawl, partOffset, perPage, gaps); !retrieved {
+				*gaps = append(*gaps, PageGap{Crawl: crawl, Offset: partOffset, Count: perPage, Err: partErr})
+			}
+		}
+		users = append(users, part...)
+	}
+
+	return users, true
+}
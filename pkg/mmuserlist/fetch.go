@@ -0,0 +1,309 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// CrawlOptions controls how a crawl fetches the users
+type CrawlOptions struct {
+	IncludeBots bool
+	Pagination  string // one of the pagination modes; offset pagination if not set
+	// TeamDisplayNames records each user's team in TeamName by its display name rather than its URL name (see
+	// TeamLabel)
+	TeamDisplayNames bool
+	// VerifyCounts cross-checks the users returned by the crawl against the server's own statistics once the crawl is
+	// complete.  Offset pagination can silently skip users if team membership changes while the pages are being
+	// fetched, so a mismatch is logged as a warning rather than passing unnoticed.
+	VerifyCounts bool
+	// Concurrency is the number of pages requested at once with offset pagination; one if not set.  With
+	// AdaptiveConcurrency, the number falls when the server comes under pressure (a rate limited response, or a slow
+	// batch of pages) and climbs back up to Concurrency once it recovers.
+	Concurrency         int
+	AdaptiveConcurrency bool
+	// Progress, if set, is called with the number of users on each page as the crawl fetches it (before any users are
+	// filtered out), so that embedding tools can report progress
+	Progress func(users int)
+	// Gaps, if set, records the ranges of users the crawl had to skip (see PageFallback)
+	Gaps *PageGapLog
+}
+
+// PageFunc receives the users fetched from one page of results, as they arrive.  Returning an error stops the crawl.
+type PageFunc func(users []*User) error
+
+// collectPages returns a PageFunc that appends each page to the list, for the functions that return every user at once
+func collectPages(userList *[]*User) PageFunc {
+	return func(users []*User) error {
+		*userList = append(*userList, users...)
+		return nil
+	}
+}
+
+// FetchUsersWithoutTeam returns a list of all Mattermost users who are without a team assignment
+func FetchUsersWithoutTeam(mmClient *model.Client4, crawl CrawlOptions) ([]*User, error) {
+
+	var userList []*User
+	if err := StreamUsersWithoutTeam(mmClient, crawl, collectPages(&userList)); err != nil {
+		return nil, err
+	}
+
+	return userList, nil
+}
+
+// StreamUsersWithoutTeam passes each page of the Mattermost users who are without a team assignment to emit, as the
+// pages are fetched
+func StreamUsersWithoutTeam(mmClient *model.Client4, crawl CrawlOptions, emit PageFunc) error {
+
+	DebugPrint("In StreamUsersWithoutTeam")
+
+	counter := newCrawlCounter()
+	emitPage := counter.countPages(crawl.Progress, func(users []*model.User) error {
+		return emit(buildUserList(users, crawl.IncludeBots))
+	})
+	verify := func(err error) error {
+		if err == nil && crawl.VerifyCounts {
+			verifyNoTeamCount(mmClient, counter)
+		}
+		return err
+	}
+
+	if useCursor(crawl) {
+		err := streamUsersWithCursor(mmClient, "", true, emitPage)
+		if err == nil {
+			return verify(nil)
+		}
+		if !errors.Is(err, ErrCursorAPIUnavailable) || crawl.Pagination == PaginationCursor {
+			return err
+		}
+		DebugPrint("Cursor-based API unavailable - falling back to offset pagination")
+	}
+
+	ctx := context.Background()
+	perPage := PageSize
+	etag := ""
+
+	return verify(streamPages(crawl, perPage, "the users without a team", func(page int, perPage int) ([]*model.User, error) {
+		users, response, err := mmClient.GetUsersWithoutTeam(ctx, page, perPage, etag)
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetUsersWithoutTeam(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			errMsg := fmt.Sprintf("Bad HTTP response returned from GetUsersWithoutTeam() (page %d)", page)
+			LogMessage(ErrorLevel, errMsg)
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+		return users, nil
+	}, emitPage))
+}
+
+// FetchTeamUsers returns a list of all Mattermost users who are members of the named team, with each user's TeamName
+// set to the team
+func FetchTeamUsers(mmClient *model.Client4, team string, crawl CrawlOptions) ([]*User, error) {
+
+	DebugPrint("In FetchTeamUsers, for team: " + team)
+
+	// First we need the team ID
+	resolvedTeam, err := ResolveTeam(mmClient, team)
+	if err != nil {
+		return nil, err
+	}
+
+	return FetchUsersInTeamList(mmClient, []*model.Team{resolvedTeam}, crawl, false)
+}
+
+// FetchTeamUsersByID returns a list of all Mattermost users who are members of the team with the given ID
+func FetchTeamUsersByID(mmClient *model.Client4, teamID string, crawl CrawlOptions) ([]*User, error) {
+
+	var userList []*User
+	if err := StreamTeamUsersByID(mmClient, teamID, crawl, collectPages(&userList)); err != nil {
+		return nil, err
+	}
+
+	return userList, nil
+}
+
+// StreamTeamUsersByID passes each page of the members of the team with the given ID to emit, as the pages are fetched
+func StreamTeamUsersByID(mmClient *model.Client4, teamID string, crawl CrawlOptions, emit PageFunc) error {
+
+	DebugPrint("In StreamTeamUsersByID, for team: " + teamID)
+
+	ctx := context.Background()
+	perPage := PageSize
+	etag := ""
+
+	counter := newCrawlCounter()
+	emitPage := counter.countPages(crawl.Progress, func(users []*model.User) error {
+		return emit(buildUserList(users, crawl.IncludeBots))
+	})
+	verify := func(err error) error {
+		if err == nil && crawl.VerifyCounts {
+			verifyTeamCount(mmClient, teamID, counter)
+		}
+		return err
+	}
+
+	if useCursor(crawl) {
+		err := streamUsersWithCursor(mmClient, teamID, false, emitPage)
+		if err == nil {
+			return verify(nil)
+		}
+		if !errors.Is(err, ErrCursorAPIUnavailable) || crawl.Pagination == PaginationCursor {
+			return err
+		}
+		DebugPrint("Cursor-based API unavailable - falling back to offset pagination")
+	}
+
+	return verify(streamPages(crawl, perPage, "the members of team "+teamID, func(page int, perPage int) ([]*model.User, error) {
+		users, response, err := mmClient.GetUsersInTeam(ctx, teamID, page, perPage, etag)
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from FetchTeamUsers(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			errMsg := fmt.Sprintf("Bad HTTP response returned from GetUsersWithoutTeam() (page %d)", page)
+			LogMessage(ErrorLevel, errMsg)
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+		return users, nil
+	}, emitPage))
+}
+
+// streamPages retrieves successive pages with offset pagination until a short page marks the end of the list, passing
+// each page to emit.  With a Concurrency above one, that many pages are requested at once; the pages are still emitted
+// in order, so the result is the same as for a serial crawl.  Any pages requested beyond the end of the list come back
+// empty and are dropped.  A page that keeps failing is retried and then split up (see PageFallback), with description
+// naming the list in any warnings.
+func streamPages(crawl CrawlOptions, perPage int, description string, fetch PageFetcher, emit func(users []*model.User) error) error {
+
+	controller := newConcurrencyController(crawl.Concurrency, crawl.AdaptiveConcurrency)
+
+	for first := 0; ; {
+		workers := controller.workers()
+		started := time.Now()
+		pages := make([][]*model.User, workers)
+		skipped := make([]int, workers)
+		errs := make([]error, workers)
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				pages[i], skipped[i], errs[i] = fetchPageWithFallback(fetch, description, first+i, perPage, crawl.Gaps)
+			}(i)
+		}
+		wg.Wait()
+		controller.observe(time.Since(started))
+
+		for i := 0; i < workers; i++ {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			if err := emit(pages[i]); err != nil {
+				return err
+			}
+			if len(pages[i])+skipped[i] < perPage {
+				return nil
+			}
+		}
+		first += workers
+	}
+}
+
+// useCursor reports whether the cursor API should be tried for a crawl.  Cursor pages can only be fetched one after
+// another, so in 'auto' mode a concurrent crawl uses offset pagination instead.
+func useCursor(crawl CrawlOptions) bool {
+	return crawl.Pagination == PaginationCursor || (crawl.Pagination == PaginationAuto && crawl.Concurrency <= 1)
+}
+
+// FetchUsersWithCursor retrieves users via the user reporting API, which pages using a cursor rather than an offset.  This
+// avoids skipped or duplicated users when membership changes during a crawl.  A team ID restricts the results to that
+// team, while noTeam restricts them to users without a team.  ErrCursorAPIUnavailable is returned if the server doesn't
+// support the API, so that the caller can fall back to offset pagination.
+func FetchUsersWithCursor(mmClient *model.Client4, teamID string, noTeam bool) ([]*model.User, error) {
+
+	var allUsers []*model.User
+	err := streamUsersWithCursor(mmClient, teamID, noTeam, func(users []*model.User) error {
+		allUsers = append(allUsers, users...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return allUsers, nil
+}
+
+// streamUsersWithCursor is FetchUsersWithCursor, passing each page to emit as it is fetched.  ErrCursorAPIUnavailable
+// can only be returned before the first page is emitted.
+func streamUsersWithCursor(mmClient *model.Client4, teamID string, noTeam bool, emit func(users []*model.User) error) error {
+
+	DebugPrint("In streamUsersWithCursor")
+
+	ctx := context.Background()
+	options := &model.UserReportOptions{
+		ReportingBaseOptions: model.ReportingBaseOptions{
+			Direction:  "next",
+			PageSize:   model.ReportingMaxPageSize,
+			SortColumn: "Username",
+		},
+		Team:      teamID,
+		HasNoTeam: noTeam,
+	}
+
+	for {
+		reports, response, err := mmClient.GetUsersForReporting(ctx, options)
+
+		if err != nil {
+			// Only the very first request tells us whether the API exists.  Failures after that are real errors.
+			if options.FromId == "" && response != nil && CursorAPIUnsupported(response.StatusCode) {
+				DebugPrint(fmt.Sprintf("GetUsersForReporting() returned HTTP %d", response.StatusCode))
+				return ErrCursorAPIUnavailable
+			}
+			LogMessage(ErrorLevel, "Error returned from GetUsersForReporting(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetUsersForReporting()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		users := make([]*model.User, 0, len(reports))
+		for _, report := range reports {
+			user := report.User
+			users = append(users, &user)
+		}
+		if err := emit(users); err != nil {
+			return err
+		}
+
+		if len(reports) < options.PageSize {
+			break
+		}
+
+		last := reports[len(reports)-1]
+		options.FromColumnValue = last.Username
+		options.FromId = last.Id
+	}
+
+	return nil
+}
+
+// CursorAPIUnsupported reports whether an HTTP status indicates that the reporting API is missing (older servers) or
+// not enabled/licensed on this server
+func CursorAPIUnsupported(statusCode int) bool {
+	switch statusCode {
+	case http.StatusNotFound, http.StatusNotImplemented, http.StatusForbidden, http.StatusMethodNotAllowed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,316 @@
+package mmuserlist
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// FilterUsers returns only those users for which the keep function returns true
+func FilterUsers(users []*User, keep func(*User) bool) []*User {
+	var filtered []*User
+	for _, user := range users {
+		if keep(user) {
+			filtered = append(filtered, user)
+		}
+	}
+	return filtered
+}
+
+// GetGroupByName finds a Mattermost group by its name or, failing that, its display name (case-insensitive)
+func GetGroupByName(mmClient *model.Client4, groupName string) (*model.Group, error) {
+
+	DebugPrint("In GetGroupByName, for group: " + groupName)
+
+	ctx := context.Background()
+	groups, response, err := mmClient.GetGroups(ctx, model.GroupSearchOpts{
+		Q:        groupName,
+		PageOpts: &model.PageOpts{Page: 0, PerPage: PageSize},
+	})
+
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetGroups(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetGroups()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	var displayNameMatches []*model.Group
+	for _, group := range groups {
+		if group.Name != nil && strings.EqualFold(*group.Name, groupName) {
+			return group, nil
+		}
+		if strings.EqualFold(group.DisplayName, groupName) {
+			displayNameMatches = append(displayNameMatches, group)
+		}
+	}
+
+	switch len(displayNameMatches) {
+	case 0:
+		return nil, fmt.Errorf("group '%s' not found", groupName)
+	case 1:
+		return displayNameMatches[0], nil
+	default:
+		return nil, fmt.Errorf("group display name '%s' is ambiguous - please use the group name", groupName)
+	}
+}
+
+// GetGroupMemberIDs returns the set of IDs for all users who are members of the named group
+func GetGroupMemberIDs(mmClient *model.Client4, groupName string) (map[string]bool, error) {
+
+	group, err := GetGroupByName(mmClient, groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	DebugPrint("Retrieving members of group: " + group.Id)
+
+	ctx := context.Background()
+	page := 0
+	perPage := PageSize
+	etag := ""
+
+	members := make(map[string]bool)
+
+	for {
+		users, response, err := mmClient.GetUsersInGroup(ctx, group.Id, page, perPage, etag)
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetUsersInGroup(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			errMsg := fmt.Sprintf("Bad HTTP response returned from GetUsersInGroup() (page %d)", page)
+			LogMessage(ErrorLevel, errMsg)
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for _, user := range users {
+			members[user.Id] = true
+		}
+
+		if len(users) < perPage {
+			break
+		}
+
+		page++
+	}
+
+	return members, nil
+}
+
+// FilterByGroup keeps only the users who are (or, if inGroup is false, are not) members of the named group
+func FilterByGroup(mmClient *model.Client4, users []*User, groupName string, inGroup bool) ([]*User, error) {
+
+	members, err := GetGroupMemberIDs(mmClient, groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterUsers(users, func(user *User) bool {
+		return members[user.UserID] == inGroup
+	}), nil
+}
+
+// GetChannelMemberIDs returns the set of IDs for all members of the named channel in the given team
+func GetChannelMemberIDs(mmClient *model.Client4, team string, channelName string) (map[string]bool, error) {
+
+	channelMembers, err := GetChannelMembers(mmClient, team, channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]bool)
+	for userID := range channelMembers {
+		members[userID] = true
+	}
+
+	return members, nil
+}
+
+// GetChannelMembers returns the channel member records for all members of the named channel in the given team, by
+// user ID
+func GetChannelMembers(mmClient *model.Client4, team string, channelName string) (map[string]*model.ChannelMember, error) {
+
+	DebugPrint("In GetChannelMembers, for channel: " + team + "/" + channelName)
+
+	ctx := context.Background()
+	page := 0
+	perPage := PageSize
+	etag := ""
+
+	resolvedTeam, err := ResolveTeam(mmClient, team)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, response, err := mmClient.GetChannelByName(ctx, channelName, resolvedTeam.Id, etag)
+
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetChannelByName(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetChannelByName()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	members := make(map[string]*model.ChannelMember)
+
+	for {
+		channelMembers, response, err := mmClient.GetChannelMembers(ctx, channel.Id, page, perPage, etag)
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetChannelMembers(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			errMsg := fmt.Sprintf("Bad HTTP response returned from GetChannelMembers() (page %d)", page)
+			LogMessage(ErrorLevel, errMsg)
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for i := range channelMembers {
+			members[channelMembers[i].UserId] = &channelMembers[i]
+		}
+
+		if len(channelMembers) < perPage {
+			break
+		}
+
+		page++
+	}
+
+	return members, nil
+}
+
+// FilterByChannel keeps only the users who are (or, if inChannel is false, are not) members of the named channel
+func FilterByChannel(mmClient *model.Client4, users []*User, team string, channelName string, inChannel bool) ([]*User, error) {
+
+	members, err := GetChannelMemberIDs(mmClient, team, channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterUsers(users, func(user *User) bool {
+		return members[user.UserID] == inChannel
+	}), nil
+}
+
+// LoadExclusions reads a denylist file of usernames, email addresses and/or user IDs.  The file is read as CSV, so it
+// can be either a simple list with one entry per line or a previous export; every field is treated as a candidate
+// identifier.  Blank lines and lines starting with '#' are ignored.  Identifiers are matched case-insensitively.
+func LoadExclusions(filePath string) (map[string]bool, error) {
+	return loadIdentifiers(filePath, "exclusion")
+}
+
+// loadIdentifiers reads a file of usernames, email addresses and/or user IDs into a set of lower-cased entries.  The
+// kind of file is used in log messages.
+func loadIdentifiers(filePath string, kind string) (map[string]bool, error) {
+
+	DebugPrint("Loading " + kind + " entries from: " + filePath)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to open "+kind+" file: "+filePath+" - "+err.Error())
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	identifiers := make(map[string]bool)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			LogMessage(ErrorLevel, "Failed to read "+kind+" file: "+filePath+" - "+err.Error())
+			return nil, err
+		}
+		for _, field := range record {
+			field = strings.ToLower(strings.TrimSpace(field))
+			if field != "" {
+				identifiers[field] = true
+			}
+		}
+	}
+
+	DebugPrint(fmt.Sprintf("Loaded %d %s entries", len(identifiers), kind))
+
+	return identifiers, nil
+}
+
+// FilterExcluded drops any user whose username, email address or ID appears in the exclusion set
+func FilterExcluded(users []*User, exclusions map[string]bool) []*User {
+	return FilterUsers(users, func(user *User) bool {
+		return !exclusions[strings.ToLower(user.Username)] &&
+			!exclusions[strings.ToLower(user.Email)] &&
+			!exclusions[strings.ToLower(user.UserID)]
+	})
+}
+
+// FilterInactive keeps only the users whose last activity was more than the given number of days ago
+func FilterInactive(users []*User, days int) []*User {
+	return FilterUsers(users, func(user *User) bool {
+		return user.DaysSinceLastActivity > days
+	})
+}
+
+// FilterDeactivated removes the users whose accounts have been deactivated
+func FilterDeactivated(users []*User) []*User {
+	return FilterUsers(users, func(user *User) bool {
+		return user.DeactivatedAt.IsZero()
+	})
+}
+
+// FilterBots removes bot accounts.  Bots are normally dropped as the users are fetched, so this is only needed for a
+// list fetched with bots included.
+func FilterBots(users []*User) []*User {
+	return FilterUsers(users, func(user *User) bool {
+		return !user.IsBotAccount
+	})
+}
+
+// ParseNameList splits a comma-separated list of names, such as roles or auth methods, as given to a filter.  The
+// names are lower-cased, since they are matched case-insensitively.
+func ParseNameList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// FilterByAuthMethod keeps only the users who sign in with one of the named auth methods (see User.AuthMethod)
+func FilterByAuthMethod(users []*User, methods []string) []*User {
+	wanted := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		wanted[method] = true
+	}
+	return FilterUsers(users, func(user *User) bool {
+		return wanted[strings.ToLower(user.AuthMethod())]
+	})
+}
+
+// FilterByPattern keeps only the users for whom the chosen field (e.g. User.FullName) matches the regular expression
+func FilterByPattern(users []*User, field func(user *User) string, pattern *regexp.Regexp) []*User {
+	return FilterUsers(users, func(user *User) bool {
+		return pattern.MatchString(field(user))
+	})
+}
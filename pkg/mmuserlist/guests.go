@@ -0,0 +1,76 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// FilterGuests keeps only the guest accounts or, if guests is false, only the accounts that aren't guests
+func FilterGuests(users []*User, guests bool) []*User {
+	return FilterUsers(users, func(user *User) bool {
+		return user.IsGuest == guests
+	})
+}
+
+// ApplyGuestChannels records the channels each guest account is a member of, as <team>/<channel>, since guests can
+// only see the channels they have been added to.  Direct and group messages aren't included.  Users who aren't
+// guests are left blank, without any API calls.
+func ApplyGuestChannels(mmClient *model.Client4, users []*User) error {
+
+	var guests []*User
+	for _, user := range users {
+		if user.IsGuest {
+			guests = append(guests, user)
+		}
+	}
+
+	DebugPrint(fmt.Sprintf("Retrieving channels for %d guest accounts", len(guests)))
+
+	ctx := context.Background()
+	guestChannels := make(map[string][]string)
+
+	for _, userID := range uniqueUserIDs(guests) {
+		teams, response, err := mmClient.GetTeamsForUser(ctx, userID, "")
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetTeamsForUser(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetTeamsForUser()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		var channelNames []string
+		for _, team := range teams {
+			channels, response, err := mmClient.GetChannelsForTeamForUser(ctx, team.Id, userID, false, "")
+
+			if err != nil {
+				LogMessage(ErrorLevel, "Error returned from GetChannelsForTeamForUser(): "+err.Error())
+				return err
+			}
+			if response.StatusCode != 200 {
+				LogMessage(ErrorLevel, "Bad HTTP response returned from GetChannelsForTeamForUser()")
+				return errors.New("failed to retrieve data from Mattermost")
+			}
+
+			for _, channel := range channels {
+				if channel.Type == model.ChannelTypeOpen || channel.Type == model.ChannelTypePrivate {
+					channelNames = append(channelNames, team.Name+"/"+channel.Name)
+				}
+			}
+		}
+		sort.Strings(channelNames)
+		guestChannels[userID] = channelNames
+	}
+
+	for _, user := range users {
+		user.GuestChannels = guestChannels[user.UserID]
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package mmuserlist
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// htmlReport is a standalone page: the styling and the script that sorts the table are embedded, so the file can be
+// opened from an email or a file share with nothing else alongside it
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="generator" content="mm-user-list">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 14px; color: #222; margin: 2em; }
+header { display: flex; align-items: center; justify-content: space-between; margin-bottom: 1em; }
+header img { max-height: 48px; }
+header .branding { font-weight: bold; color: #5a5a5a; }
+h1 { font-size: 1.6em; margin-bottom: 0.2em; }
+h2 { font-size: 1.2em; border-bottom: 1px solid #ccc; padding-bottom: 0.2em; margin-top: 1.5em; }
+.generated { color: #666; font-size: 0.9em; margin-top: 0; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.3em 2em; }
+dt { font-weight: bold; }
+dd { margin: 0; }
+table.chart td { padding: 0.15em 0.5em 0.15em 0; white-space: nowrap; }
+table.chart td.bars { width: 100%; }
+.bar { display: inline-block; height: 0.9em; background: #4f81bd; margin-right: 0.5em; vertical-align: middle; }
+table.users { border-collapse: collapse; width: 100%; }
+table.users th, table.users td { border: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; }
+table.users th { background: #e6e6e6; cursor: pointer; position: sticky; top: 0; user-select: none; white-space: nowrap; }
+table.users th[aria-sort=ascending]::after { content: " \25B2"; }
+table.users th[aria-sort=descending]::after { content: " \25BC"; }
+table.users tbody tr:nth-child(even) { background: #f7f7f7; }
+footer { margin-top: 2em; color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+{{- if or .Logo .Branding}}
+<header>
+{{- if .Logo}}<img src="{{.Logo}}" alt="">{{else}}<span></span>{{end}}
+{{- if .Branding}}<span class="branding">{{.Branding}}</span>{{end}}
+</header>
+{{- end}}
+<h1>{{.Title}}</h1>
+<p class="generated">Generated {{.Generated}} by mm-user-list</p>
+<h2>Summary</h2>
+<dl>
+{{- range .Summary}}
+<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>
+{{- end}}
+</dl>
+{{- range .Charts}}
+<h2>{{.Heading}}</h2>
+<table class="chart">
+{{- range .Bars}}
+<tr><td>{{.Label}}</td><td class="bars"><span class="bar" style="width: {{.Percent}}%"></span>{{.Count}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<h2>Users ({{len .Rows}})</h2>
+<p class="generated">Click a column heading to sort by it.</p>
+<table class="users" id="users">
+<thead><tr>{{range .Header}}<th{{if .Numeric}} data-type="number"{{end}}>{{.Name}}</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range .}}<td{{if .Sort}} data-sort="{{.Sort}}"{{end}}>{{.Text}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+{{- if .Footer}}
+<footer>{{.Footer}}</footer>
+{{- end}}
+<script>
+(function () {
+  var headers = document.querySelectorAll("#users th");
+  var body = document.querySelector("#users tbody");
+  Array.prototype.forEach.call(headers, function (header, index) {
+    header.addEventListener("click", function () {
+      var ascending = header.getAttribute("aria-sort") !== "ascending";
+      var numeric = header.getAttribute("data-type") === "number";
+      var rows = Array.prototype.map.call(body.rows, function (row) {
+        var cell = row.cells[index];
+        var key = cell.textContent;
+        if (numeric) {
+          key = cell.hasAttribute("data-sort") ? Number(cell.getAttribute("data-sort")) : -Infinity;
+        }
+        return { row: row, key: key };
+      });
+      rows.sort(function (a, b) {
+        var order = numeric ? (a.key < b.key ? -1 : a.key > b.key ? 1 : 0) :
+          a.key.localeCompare(b.key, undefined, { numeric: true, sensitivity: "base" });
+        return ascending ? order : -order;
+      });
+      Array.prototype.forEach.call(headers, function (other) { other.removeAttribute("aria-sort"); });
+      header.setAttribute("aria-sort", ascending ? "ascending" : "descending");
+      rows.forEach(function (entry) { body.appendChild(entry.row); });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`))
+
+// htmlColumn is a heading of the HTML report's table.  Numeric columns, the counts and the dates, are sorted by
+// value rather than as text.
+type htmlColumn struct {
+	Name    string
+	Numeric bool
+}
+
+// htmlCell is a cell of the HTML report's table.  Sort, if set, is the value a numeric column is sorted by: the count,
+// or a date's timestamp in milliseconds.
+type htmlCell struct {
+	Text string
+	Sort string
+}
+
+// htmlBar is a bar of one of the HTML report's charts
+type htmlBar struct {
+	Label   string
+	Count   int
+	Percent int // of the largest bar
+}
+
+// htmlChart is a bar chart in the HTML report
+type htmlChart struct {
+	Heading string
+	Bars    []htmlBar
+}
+
+// newHTMLChart scales a chart's bars to the largest count
+func newHTMLChart(heading string, labels []string, counts []int) htmlChart {
+	largest := 0
+	for _, count := range counts {
+		largest = max(largest, count)
+	}
+	chart := htmlChart{Heading: heading}
+	for i, label := range labels {
+		bar := htmlBar{Label: label, Count: counts[i]}
+		if largest > 0 {
+			// Leave room for the count beside the longest bar
+			bar.Percent = 85 * counts[i] / largest
+		}
+		chart.Bars = append(chart.Bars, bar)
+	}
+	return chart
+}
+
+// WriteUsersToHTML writes the users as a standalone HTML page: the summary statistics and charts of the PDF report,
+// then every user in a table that sorts when a column heading is clicked.  Unlike the PDF report, the table has the
+// same columns as the CSV output.  With branding, the page carries the logo and title at the top and the footer text
+// at the bottom.
+func WriteUsersToHTML(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as HTML")
+
+	summary := summarizeUsers(users)
+	labels := make([]string, len(activityBands))
+	for i, band := range activityBands {
+		labels[i] = band.Label
+	}
+	charts := []htmlChart{newHTMLChart("Days Since Last Activity", labels, summary.bands)}
+	if summary.severalTeams() {
+		heading, teams, counts := summary.largestTeams()
+		charts = append(charts, newHTMLChart(heading, teams, counts))
+	}
+
+	columns := userColumns(users, output)
+	header := make([]htmlColumn, len(columns))
+	for i, column := range columns {
+		switch column.Value(&User{}).(type) {
+		case int, int64, time.Time:
+			header[i] = htmlColumn{Name: column.Header, Numeric: true}
+		default:
+			header[i] = htmlColumn{Name: column.Header}
+		}
+	}
+	rows := make([][]htmlCell, len(users))
+	for i, user := range users {
+		rows[i] = make([]htmlCell, len(columns))
+		for j, column := range columns {
+			value := column.Value(user)
+			cell := htmlCell{Text: cellText(value, output.Dates)}
+			switch value := value.(type) {
+			case int:
+				cell.Sort = strconv.Itoa(value)
+			case int64:
+				cell.Sort = strconv.FormatInt(value, 10)
+			case *int:
+				if value != nil {
+					cell.Sort = strconv.Itoa(*value)
+				}
+			case time.Time:
+				if !value.IsZero() {
+					cell.Sort = strconv.FormatInt(value.UnixMilli(), 10)
+				}
+			}
+			rows[i][j] = cell
+		}
+	}
+
+	page := struct {
+		Title     string
+		Generated string
+		Logo      template.URL
+		Branding  string
+		Footer    string
+		Summary   [][2]string
+		Charts    []htmlChart
+		Header    []htmlColumn
+		Rows      [][]htmlCell
+	}{
+		Title:     "Mattermost user report",
+		Generated: time.Now().In(output.Dates.location()).Format("2006-01-02 15:04 MST"),
+		Summary:   summary.fields(output),
+		Charts:    charts,
+		Header:    header,
+		Rows:      rows,
+	}
+	if branding := output.Branding; branding != nil {
+		page.Branding = branding.Title
+		page.Footer = branding.Footer
+		if branding.hasLogo() {
+			// The logo is embedded, so that the page stands alone
+			page.Logo = template.URL("data:" + http.DetectContentType(branding.logo) + ";base64," + base64.StdEncoding.EncodeToString(branding.logo))
+		}
+	}
+
+	if err := htmlReport.Execute(out, page); err != nil {
+		LogMessage(ErrorLevel, "Failed to render HTML output: "+err.Error())
+		return err
+	}
+	return nil
+}
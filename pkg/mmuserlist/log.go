@@ -0,0 +1,368 @@
+package mmuserlist
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is used to refer to the type of message that will be written using the logging code.
+type LogLevel string
+
+const (
+	DebugLevel   LogLevel = "DEBUG"
+	InfoLevel    LogLevel = "INFO"
+	WarningLevel LogLevel = "WARNING"
+	ErrorLevel   LogLevel = "ERROR"
+)
+
+// DebugMode enables the messages logged with DebugPrint, whatever the log level
+var DebugMode = false
+
+// Log formats: plain is the human-readable format, text is slog's key=value format and json writes one JSON object
+// per message, for log aggregators
+const (
+	LogFormatPlain = "plain"
+	LogFormatText  = "text"
+	LogFormatJSON  = "json"
+)
+
+// LogSettings describes how and where log messages are written.  Empty fields select the plain format, the info level
+// and the standard output streams.
+type LogSettings struct {
+	Format string
+	Level  string
+	File   string
+}
+
+// LogHandler receives every log message (after masking) as a slog record.  ConfigureLogging sets it from LogSettings,
+// and embedding tools may replace it with a handler of their own.  When nil, messages are written in the plain format.
+var LogHandler slog.Handler
+
+// logLevel is the least severe level of message that is logged
+var logLevel = new(slog.LevelVar)
+
+// The current settings, and the log file they opened, so that reconfiguring with the same settings changes nothing
+var (
+	logSettings LogSettings
+	logFile     *os.File
+	logMutex    sync.Mutex
+)
+
+// LogToStderr sends every log message to stderr, keeping stdout free for output written to it
+var LogToStderr = false
+
+// Logger, if set, receives every log message (after masking) in place of the standard log output, so that embedding
+// tools can route messages into their own logging
+var Logger func(level LogLevel, message string)
+
+// ANSI escape sequences used to highlight output on a terminal
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiCyan   = "\033[36m"
+)
+
+// ColorOutput is disabled by the NO_COLOR convention (https://no-color.org) or the '-no-color' parameter.  Even when
+// enabled, color is only used when the output stream is a terminal.
+var ColorOutput = os.Getenv("NO_COLOR") == ""
+
+// slogLevels maps each log level to its slog equivalent
+var slogLevels = map[LogLevel]slog.Level{
+	DebugLevel:   slog.LevelDebug,
+	InfoLevel:    slog.LevelInfo,
+	WarningLevel: slog.LevelWarn,
+	ErrorLevel:   slog.LevelError,
+}
+
+// levelColors maps each log level to the color used when highlighting it
+var levelColors = map[LogLevel]string{
+	DebugLevel:   ansiCyan,
+	InfoLevel:    ansiGreen,
+	WarningLevel: ansiYellow,
+	ErrorLevel:   ansiBold + ansiRed,
+}
+
+// LogSensitive disables the masking of auth tokens and email addresses in log output.  It is off by default, so that
+// logs (particularly debug logs captured from scheduled runs) never contain credentials or personal data.
+var LogSensitive = false
+
+// secrets holds values - such as auth tokens - that must never appear in log output
+var secrets []string
+var secretsMutex sync.Mutex
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// RegisterSecret records a value that should be masked wherever it appears in log output.  A value already registered
+// is only recorded once, so a long-running process can register each token it loads.
+func RegisterSecret(value string) {
+	if value == "" {
+		return
+	}
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	if !slices.Contains(secrets, value) {
+		secrets = append(secrets, value)
+	}
+}
+
+// maskEmail hides the local part of an email address, keeping only its first character and the domain
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return email
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// sanitizeLogMessage masks any registered secrets and email addresses in a log message
+func sanitizeLogMessage(message string) string {
+	if LogSensitive {
+		return message
+	}
+
+	secretsMutex.Lock()
+	for _, secret := range secrets {
+		message = strings.ReplaceAll(message, secret, Redact(secret))
+	}
+	secretsMutex.Unlock()
+
+	return emailPattern.ReplaceAllStringFunc(message, maskEmail)
+}
+
+// isTerminal reports whether the file refers to an interactive terminal rather than a pipe or regular file
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ParseLogLevel converts a level name - debug, info, warning (or warn) or error - to its slog level.  An empty name is
+// the info level.
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warning", "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level '%s' - use 'debug', 'info', 'warning' or 'error'", name)
+}
+
+// ConfigureLogging sets the format, level and destination of log messages.  A log file is appended to, and closed when
+// the logging is next configured with a different file.
+func ConfigureLogging(settings LogSettings) error {
+
+	level, err := ParseLogLevel(settings.Level)
+	if err != nil {
+		return err
+	}
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	logLevel.Set(level)
+	if settings.Format == logSettings.Format && settings.File == logSettings.File {
+		logSettings = settings
+		return nil
+	}
+
+	var newHandler func(out io.Writer) slog.Handler
+	options := &slog.HandlerOptions{Level: logLevel}
+	switch settings.Format {
+	case "", LogFormatPlain:
+	case LogFormatText:
+		newHandler = func(out io.Writer) slog.Handler { return slog.NewTextHandler(out, options) }
+	case LogFormatJSON:
+		newHandler = func(out io.Writer) slog.Handler { return slog.NewJSONHandler(out, options) }
+	default:
+		return fmt.Errorf("unknown log format '%s' - use 'plain', 'text' or 'json'", settings.Format)
+	}
+
+	var file *os.File
+	if settings.File != "" {
+		file, err = os.OpenFile(settings.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open the log file: %w", err)
+		}
+	}
+
+	var handler slog.Handler
+	switch {
+	case newHandler == nil:
+		handler = plainHandler{file: file}
+	case file != nil:
+		handler = newHandler(file)
+	default:
+		handler = streamHandler{stdout: newHandler(os.Stdout), stderr: newHandler(os.Stderr)}
+	}
+
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+	logSettings = settings
+	LogHandler = handler
+	return nil
+}
+
+// plainHandler writes messages in the plain format: the time, the level in brackets and the message, highlighted when
+// writing to a terminal.  Without a file, errors go to stderr and everything else to stdout, unless LogToStderr is set.
+type plainHandler struct {
+	file  *os.File
+	attrs []slog.Attr
+}
+
+func (h plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (h plainHandler) Handle(_ context.Context, record slog.Record) error {
+
+	output := h.file
+	if output == nil {
+		output = os.Stdout
+		if record.Level >= slog.LevelError || LogToStderr {
+			output = os.Stderr
+		}
+	}
+
+	level := DebugLevel
+	switch {
+	case record.Level >= slog.LevelError:
+		level = ErrorLevel
+	case record.Level >= slog.LevelWarn:
+		level = WarningLevel
+	case record.Level >= slog.LevelInfo:
+		level = InfoLevel
+	}
+
+	message := record.Message
+	for _, attr := range h.attrs {
+		message += " " + attr.String()
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		message += " " + attr.String()
+		return true
+	})
+
+	tag := "[" + string(level) + "]"
+	if ColorOutput && isTerminal(output) {
+		tag = levelColors[level] + tag + ansiReset
+		if level == ErrorLevel || level == WarningLevel {
+			message = levelColors[level] + message + ansiReset
+		}
+	}
+
+	_, err := fmt.Fprintf(output, "%s %s %s\n", record.Time.Format("2006/01/02 15:04:05"), tag, message)
+	return err
+}
+
+func (h plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return plainHandler{file: h.file, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+func (h plainHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// streamHandler sends errors, or every message if LogToStderr is set, to the stderr handler and the rest to stdout's
+type streamHandler struct {
+	stdout slog.Handler
+	stderr slog.Handler
+}
+
+func (h streamHandler) handler(level slog.Level) slog.Handler {
+	if level >= slog.LevelError || LogToStderr {
+		return h.stderr
+	}
+	return h.stdout
+}
+
+func (h streamHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler(level).Enabled(ctx, level)
+}
+
+func (h streamHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler(record.Level).Handle(ctx, record)
+}
+
+func (h streamHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return streamHandler{stdout: h.stdout.WithAttrs(attrs), stderr: h.stderr.WithAttrs(attrs)}
+}
+
+func (h streamHandler) WithGroup(name string) slog.Handler {
+	return streamHandler{stdout: h.stdout.WithGroup(name), stderr: h.stderr.WithGroup(name)}
+}
+
+// LogMessage logs a message at the given level, through LogHandler (or Logger, if set), once any secrets and email
+// addresses have been masked
+func LogMessage(level LogLevel, message string) {
+	if Logger != nil {
+		Logger(level, sanitizeLogMessage(message))
+		return
+	}
+
+	// Debug messages are enabled by DebugMode as well as by the log level
+	slogLevel := slogLevels[level]
+	if slogLevel < logLevel.Level() && !(level == DebugLevel && DebugMode) {
+		return
+	}
+
+	handler := LogHandler
+	if handler == nil {
+		handler = plainHandler{}
+	}
+	record := slog.NewRecord(time.Now(), slogLevel, sanitizeLogMessage(message), 0)
+	if err := handler.Handle(context.Background(), record); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write a log message: "+err.Error())
+	}
+}
+
+// LogSummary logs an informational message that should stand out from the surrounding output, such as the final
+// result of a run
+func LogSummary(message string) {
+	output := os.Stdout
+	if LogToStderr {
+		output = os.Stderr
+	}
+	if _, plain := LogHandler.(plainHandler); (LogHandler == nil || plain) && logFile == nil && ColorOutput && isTerminal(output) {
+		message = ansiBold + message + ansiReset
+	}
+	LogMessage(InfoLevel, message)
+}
+
+// DebugPrint allows us to add debug messages into our code, which are only printed if we're running in debug more.
+// Note that the command line parameter '-debug' (or '-log-level=debug') can be used to enable this at runtime.
+func DebugPrint(message string) {
+	if DebugMode || logLevel.Level() <= slog.LevelDebug {
+		LogMessage(DebugLevel, message)
+	}
+}
+
+// Redact masks a sensitive value, leaving only enough of it visible to tell two values apart
+func Redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return strings.Repeat("*", len(value)-4) + value[len(value)-4:]
+}
@@ -0,0 +1,44 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// FilterNeverLoggedIn keeps only the users who have never logged in since their account was created, such as
+// accounts provisioned in advance that were never taken up.  A user counts as having logged in if they have a last
+// login time (reported with cursor pagination), any recorded activity or any session.  The users' last activity must
+// already have been retrieved with ApplyLastActivity; sessions are only looked up for the users with neither.
+func FilterNeverLoggedIn(mmClient *model.Client4, users []*User) ([]*User, error) {
+
+	candidates := FilterUsers(users, func(user *User) bool {
+		return user.LastLoginAt.IsZero() && user.LastActivityAt.IsZero()
+	})
+
+	DebugPrint(fmt.Sprintf("Checking sessions for %d users with no recorded login or activity", len(candidates)))
+
+	ctx := context.Background()
+	loggedIn := make(map[string]bool)
+
+	for _, userID := range uniqueUserIDs(candidates) {
+		sessions, response, err := mmClient.GetSessions(ctx, userID, "")
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetSessions(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetSessions()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+
+		loggedIn[userID] = len(sessions) > 0
+	}
+
+	return FilterUsers(candidates, func(user *User) bool {
+		return !loggedIn[user.UserID]
+	}), nil
+}
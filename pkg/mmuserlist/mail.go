@@ -0,0 +1,206 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SMTP TLS modes: STARTTLS upgrades a plain connection (normally on port 587), TLS connects over TLS from the start
+// (normally port 465), and none sends the mail unencrypted, for a relay on a trusted network
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNoTLS    = "none"
+)
+
+// DefaultSMTPPort is the port used when the SMTP host doesn't include one, the mail submission port
+const DefaultSMTPPort = "587"
+
+// smtpTimeout limits how long connecting to the mail server may take
+const smtpTimeout = 30 * time.Second
+
+// SMTPSettings describes the mail server a report is sent through and who it is sent to
+type SMTPSettings struct {
+	Host               string
+	Username           string
+	Password           string
+	From               string
+	To                 []string
+	TLSMode            string
+	InsecureSkipVerify bool
+}
+
+// address returns the host and port of the mail server, adding the default port if none was given
+func (settings SMTPSettings) address() string {
+	if _, _, err := net.SplitHostPort(settings.Host); err == nil {
+		return settings.Host
+	}
+	return net.JoinHostPort(settings.Host, DefaultSMTPPort)
+}
+
+// buildReportMessage composes a MIME message with a plain text body and the report file attached
+func buildReportMessage(settings SMTPSettings, subject string, body string, attachmentPath string) ([]byte, error) {
+
+	attachment, err := os.ReadFile(attachmentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	writer := multipart.NewWriter(&message)
+
+	headers := []string{
+		"From: " + settings.From,
+		"To: " + strings.Join(settings.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + writer.Boundary(),
+	}
+	message.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	text := quotedprintable.NewWriter(part)
+	if _, err := text.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := text.Close(); err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(attachmentPath)
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Base64 lines are limited to 76 characters
+	encoded := base64.StdEncoding.EncodeToString(attachment)
+	var lines strings.Builder
+	for len(encoded) > 76 {
+		lines.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	lines.WriteString(encoded + "\r\n")
+	if _, err := part.Write([]byte(lines.String())); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
+
+// dialSMTP connects to the mail server, over TLS or upgrading with STARTTLS as the settings require
+func dialSMTP(settings SMTPSettings) (*smtp.Client, error) {
+
+	address := settings.address()
+	host, _, _ := net.SplitHostPort(address)
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: settings.InsecureSkipVerify}
+
+	if settings.TLSMode == SMTPTLS {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", address, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return smtp.NewClient(conn, host)
+	}
+
+	conn, err := net.DialTimeout("tcp", address, smtpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if settings.TLSMode == SMTPStartTLS {
+		if supported, _ := client.Extension("STARTTLS"); !supported {
+			client.Close()
+			return nil, errors.New("the mail server doesn't support STARTTLS - use an SMTP TLS mode of 'tls' or 'none'")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// EmailReport sends a report file as an attachment, with the subject and a plain text body, through the mail server
+func EmailReport(settings SMTPSettings, subject string, body string, attachmentPath string) error {
+
+	DebugPrint(fmt.Sprintf("Emailing %s to %s through %s", attachmentPath, strings.Join(settings.To, ", "), settings.address()))
+
+	message, err := buildReportMessage(settings, subject, body, attachmentPath)
+	if err != nil {
+		return fmt.Errorf("failed to attach the report: %w", err)
+	}
+
+	client, err := dialSMTP(settings)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the mail server %s: %w", settings.address(), err)
+	}
+	defer client.Close()
+
+	if settings.Username != "" {
+		host, _, _ := net.SplitHostPort(settings.address())
+		if err := client.Auth(smtp.PlainAuth("", settings.Username, settings.Password, host)); err != nil {
+			return fmt.Errorf("the mail server rejected the login: %w", err)
+		}
+	}
+
+	if err := client.Mail(settings.From); err != nil {
+		return fmt.Errorf("the mail server rejected the sender %s: %w", settings.From, err)
+	}
+	for _, recipient := range settings.To {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("the mail server rejected the recipient %s: %w", recipient, err)
+		}
+	}
+
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message); err != nil {
+		data.Close()
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return fmt.Errorf("the mail server rejected the message: %w", err)
+	}
+
+	return client.Quit()
+}
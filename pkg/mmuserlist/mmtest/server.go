@@ -0,0 +1,747 @@
+// Package mmtest provides a fake Mattermost server, built on httptest, that answers the API calls made by an export:
+// users by team or without a team (with offset and cursor pagination), teams, channels and their members, statuses
+// and statistics.  It can also rate limit requests or fail chosen pages or users, so that the pagination and retry
+// logic can be exercised without a live server.
+//
+// Direct messages posted through the server are recorded, and can be read back with DirectMessages.
+//
+// The server holds its data in memory.  Populate it with AddTeam, AddUser and AddChannel, then point a client at URL.
+package mmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// AdminUserID is the ID of the account the fake server treats every request as coming from.  It isn't one of the
+// server's users, so it never appears in an export.
+const AdminUserID = "adminadminadminadminadmin0"
+
+// Server is a fake Mattermost server
+type Server struct {
+	*httptest.Server
+
+	// Cursor enables the cursor-based user reporting API.  Without it, the API answers 404 as on older servers.
+	Cursor bool
+	// RateLimitEvery, if above zero, rejects every nth request with 429 Too Many Requests
+	RateLimitEvery int
+	// HideJoinTimes leaves the join time out of team member records, as most servers do.  The join times can then
+	// only be found from the join messages in each team's town-square channel.
+	HideJoinTimes bool
+
+	mu             sync.Mutex
+	users          []*model.User
+	teams          []*model.Team
+	teamMembers    map[string][]string
+	joinTimes      map[string]int64
+	channels       []*model.Channel
+	channelMembers map[string][]string
+	lastActivity   map[string]int64
+	sessions       map[string][]*model.Session
+	posts          map[string][]int64
+	directChannels map[string][2]string
+	directMessages map[string][]string
+	failPages      map[[2]int]int
+	failUsers      map[string]bool
+	requests       int
+	nextID         int
+}
+
+// NewServer starts an empty fake server
+func NewServer() *Server {
+	s := &Server{
+		teamMembers:    make(map[string][]string),
+		joinTimes:      make(map[string]int64),
+		channelMembers: make(map[string][]string),
+		lastActivity:   make(map[string]int64),
+		sessions:       make(map[string][]*model.Session),
+		posts:          make(map[string][]int64),
+		directChannels: make(map[string][2]string),
+		directMessages: make(map[string][]string),
+		failPages:      make(map[[2]int]int),
+		failUsers:      make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// newID returns a unique ID in the format Mattermost uses
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%026d", s.nextID)
+}
+
+// AddTeam adds a team
+func (s *Server) AddTeam(name string) *model.Team {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	team := &model.Team{Id: s.newID(), Name: name, DisplayName: strings.ToUpper(name[:1]) + name[1:], Type: model.TeamOpen}
+	s.teams = append(s.teams, team)
+	return team
+}
+
+// AddUser adds a user as a member of the given teams, which they joined a day after their account was created.  The
+// user's last activity is recorded as lastActivity milliseconds since the epoch, or none at all if it is zero.
+func (s *Server) AddUser(username string, lastActivity int64, teams ...*model.Team) *model.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := &model.User{
+		Id:        s.newID(),
+		Username:  username,
+		Email:     username + "@example.com",
+		FirstName: strings.ToUpper(username[:1]) + username[1:],
+		LastName:  "Test",
+		Roles:     model.SystemUserRoleId,
+		CreateAt:  1700000000000,
+	}
+	s.users = append(s.users, user)
+	sort.Slice(s.users, func(i, j int) bool { return s.users[i].Username < s.users[j].Username })
+
+	for _, team := range teams {
+		s.teamMembers[team.Id] = append(s.teamMembers[team.Id], user.Id)
+		s.joinTimes[team.Id+"/"+user.Id] = user.CreateAt + 24*60*60*1000
+	}
+	if lastActivity > 0 {
+		s.lastActivity[user.Id] = lastActivity
+	}
+	return user
+}
+
+// AddChannel adds a public channel to a team, with the given members
+func (s *Server) AddChannel(team *model.Team, name string, members ...*model.User) *model.Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := &model.Channel{Id: s.newID(), TeamId: team.Id, Name: name, DisplayName: name, Type: model.ChannelTypeOpen}
+	s.channels = append(s.channels, channel)
+	for _, member := range members {
+		s.channelMembers[channel.Id] = append(s.channelMembers[channel.Id], member.Id)
+	}
+	return channel
+}
+
+// AddSession gives a user a session, as if they had logged in
+func (s *Server) AddSession(user *model.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addSession(user, nil)
+}
+
+// AddClientSession gives a user a session created by a client, described by the session props the server records
+// (e.g. model.SessionPropOs and model.SessionPropBrowser)
+func (s *Server) AddClientSession(user *model.User, props map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addSession(user, props)
+}
+
+// addSession records a new session for a user
+func (s *Server) addSession(user *model.User, props map[string]string) {
+	now := model.GetMillis()
+	session := &model.Session{Id: s.newID(), UserId: user.Id, CreateAt: now, LastActivityAt: now, Props: props}
+	s.sessions[user.Id] = append(s.sessions[user.Id], session)
+}
+
+// AddPosts records posts by a user, made at the given times in milliseconds since the epoch, for the post statistics
+// of the user reporting API and for post searches
+func (s *Server) AddPosts(user *model.User, times ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.posts[user.Id] = append(s.posts[user.Id], times...)
+}
+
+// RemoveUser deletes a user outright, as if they had left part way through a crawl
+func (s *Server) RemoveUser(user *model.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, candidate := range s.users {
+		if candidate.Id == user.Id {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			break
+		}
+	}
+}
+
+// Users returns the users, in username order
+func (s *Server) Users() []*model.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*model.User(nil), s.users...)
+}
+
+// DirectMessages returns the messages sent to each user by direct message, keyed by username
+func (s *Server) DirectMessages() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make(map[string][]string, len(s.directMessages))
+	for _, user := range s.users {
+		if sent, found := s.directMessages[user.Id]; found {
+			messages[user.Username] = append([]string(nil), sent...)
+		}
+	}
+	return messages
+}
+
+// FailPage makes the next count requests for the given offset page of users, at the given page size, fail with 500
+// Internal Server Error
+func (s *Server) FailPage(page int, perPage int, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failPages[[2]int{page, perPage}] = count
+}
+
+// FailUser makes every offset page of users that includes the user fail with 500 Internal Server Error, as if their
+// record couldn't be loaded
+func (s *Server) FailUser(user *model.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failUsers[user.Id] = true
+}
+
+// Requests returns the number of requests the server has received
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// serve routes a request to its handler
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	if s.RateLimitEvery > 0 && s.requests%s.RateLimitEvery == 0 {
+		w.Header().Set("X-RateLimit-Reset", "1")
+		writeError(w, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v4"), "/"), "/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "users" && path[1] == "me":
+		writeJSON(w, &model.User{Id: AdminUserID, Username: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId})
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "users":
+		s.getUsers(w, query)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "users" && path[1] == "ids":
+		s.getUsersByIDs(w, r)
+	case r.Method == http.MethodPost && len(path) == 3 && path[0] == "users" && path[1] == "status" && path[2] == "ids":
+		s.getStatuses(w, r)
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "users" && path[1] == "stats":
+		writeJSON(w, &model.UsersStats{TotalUsersCount: int64(len(s.users))})
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "users" && path[2] == "sessions":
+		s.getSessions(w, path[1])
+	case r.Method == http.MethodGet && len(path) == 5 && path[0] == "users" && path[2] == "teams" && path[4] == "channels":
+		s.getChannelsForTeamForUser(w, path[1], path[3])
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "users" && path[2] == "teams":
+		s.getTeamsForUser(w, path[1])
+	case r.Method == http.MethodGet && len(path) == 4 && path[0] == "users" && path[2] == "teams" && path[3] == "members":
+		s.getTeamMembersForUser(w, path[1])
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "reports" && path[1] == "users":
+		s.getUserReports(w, query)
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "teams":
+		s.getTeams(w, query)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "teams" && path[1] == "name":
+		s.getTeam(w, func(team *model.Team) bool { return team.Name == path[2] })
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "teams":
+		s.getTeam(w, func(team *model.Team) bool { return team.Id == path[1] })
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "teams" && path[2] == "stats":
+		s.getTeamStats(w, path[1])
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "teams" && path[2] == "members":
+		s.getTeamMembers(w, path[1], query)
+	case r.Method == http.MethodGet && len(path) == 5 && path[0] == "teams" && path[2] == "channels" && path[3] == "name":
+		s.getChannelByName(w, path[1], path[4])
+	case r.Method == http.MethodPost && len(path) == 4 && path[0] == "teams" && path[2] == "posts" && path[3] == "search":
+		s.searchPosts(w, r)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "channels" && path[2] == "members":
+		s.getChannelMembers(w, path[1], query)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "channels" && path[2] == "posts":
+		s.getChannelPosts(w, path[1], query)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "channels" && path[1] == "direct":
+		s.createDirectChannel(w, r)
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "posts":
+		s.createPost(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "no fake handler for "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// getUsers answers an offset page of the members of a team, or of the users without a team
+func (s *Server) getUsers(w http.ResponseWriter, query map[string][]string) {
+
+	page, _ := strconv.Atoi(first(query["page"]))
+	perPage, _ := strconv.Atoi(first(query["per_page"]))
+	if perPage <= 0 {
+		perPage = 60
+	}
+
+	if key := [2]int{page, perPage}; s.failPages[key] > 0 {
+		s.failPages[key]--
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("page %d failed", page))
+		return
+	}
+
+	var matching []*model.User
+	switch {
+	case first(query["in_team"]) != "":
+		members := s.memberSet(s.teamMembers[first(query["in_team"])])
+		for _, user := range s.users {
+			if members[user.Id] {
+				matching = append(matching, user)
+			}
+		}
+	case first(query["without_team"]) != "":
+		for _, user := range s.users {
+			if !s.inAnyTeam(user.Id) {
+				matching = append(matching, user)
+			}
+		}
+	default:
+		matching = s.users
+	}
+
+	start := min(page*perPage, len(matching))
+	end := min(start+perPage, len(matching))
+	for _, user := range matching[start:end] {
+		if s.failUsers[user.Id] {
+			writeError(w, http.StatusInternalServerError, "failed to load user "+user.Id)
+			return
+		}
+	}
+	writeJSON(w, matching[start:end])
+}
+
+// getUserReports answers a page of the cursor-based user reporting API, ordered by username
+func (s *Server) getUserReports(w http.ResponseWriter, query map[string][]string) {
+
+	if !s.Cursor {
+		writeError(w, http.StatusNotFound, "reporting API not available")
+		return
+	}
+
+	pageSize, _ := strconv.Atoi(first(query["page_size"]))
+	if pageSize <= 0 {
+		pageSize = model.ReportingMaxPageSize
+	}
+	team := first(query["team_filter"])
+	noTeam := first(query["has_no_team"]) == "true"
+	from := first(query["from_column_value"])
+
+	startAt, endAt := model.GetReportDateRange(first(query["date_range"]), time.Now())
+
+	var members map[string]bool
+	if team != "" {
+		members = s.memberSet(s.teamMembers[team])
+	}
+
+	reports := []*model.UserReport{}
+	for _, user := range s.users {
+		if (members != nil && !members[user.Id]) || (noTeam && s.inAnyTeam(user.Id)) || (from != "" && user.Username <= from) {
+			continue
+		}
+		reports = append(reports, &model.UserReport{User: *user, UserPostStats: s.postStats(user.Id, startAt, endAt)})
+		if len(reports) == pageSize {
+			break
+		}
+	}
+	writeJSON(w, reports)
+}
+
+// postStats summarises a user's posts between startAt and endAt (either of which can be zero, for no limit) as the
+// user reporting API does.  A user who made no posts in the range has no statistics.
+func (s *Server) postStats(userID string, startAt int64, endAt int64) model.UserPostStats {
+
+	var stats model.UserPostStats
+	for _, posted := range s.posts[userID] {
+		if posted < startAt || (endAt > 0 && posted >= endAt) {
+			continue
+		}
+		if stats.TotalPosts == nil {
+			stats.TotalPosts = model.NewPointer(0)
+			stats.LastPostDate = model.NewPointer(int64(0))
+		}
+		*stats.TotalPosts++
+		*stats.LastPostDate = max(*stats.LastPostDate, posted)
+	}
+	return stats
+}
+
+// searchPosts answers a page of the posts matching a search for 'from:<username> after:<date>', newest first.  The
+// posts are in no particular channel, so the same posts are found by a search in any team.
+func (s *Server) searchPosts(w http.ResponseWriter, r *http.Request) {
+
+	var params model.SearchParameter
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Terms == nil {
+		writeError(w, http.StatusBadRequest, "invalid search")
+		return
+	}
+
+	var username string
+	var startAt int64
+	for _, term := range strings.Fields(*params.Terms) {
+		switch name, value, _ := strings.Cut(term, ":"); name {
+		case "from":
+			username = value
+		case "after":
+			after, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid date "+value)
+				return
+			}
+			startAt = after.AddDate(0, 0, 1).UnixMilli()
+		}
+	}
+
+	var posts []*model.Post
+	for _, user := range s.users {
+		if user.Username != username {
+			continue
+		}
+		for i, posted := range s.posts[user.Id] {
+			if posted >= startAt {
+				posts = append(posts, &model.Post{Id: fmt.Sprintf("post%s%d", user.Id, i), UserId: user.Id, CreateAt: posted})
+			}
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].CreateAt > posts[j].CreateAt })
+
+	page, perPage := 0, 60
+	if params.Page != nil {
+		page = *params.Page
+	}
+	if params.PerPage != nil && *params.PerPage > 0 {
+		perPage = *params.PerPage
+	}
+	start := min(page*perPage, len(posts))
+	end := min(start+perPage, len(posts))
+
+	list := model.NewPostList()
+	for _, post := range posts[start:end] {
+		list.AddPost(post)
+		list.AddOrder(post.Id)
+	}
+	writeJSON(w, list)
+}
+
+// getUsersByIDs answers the users with the requested IDs
+func (s *Server) getUsersByIDs(w http.ResponseWriter, r *http.Request) {
+
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	wanted := s.memberSet(ids)
+
+	users := []*model.User{}
+	for _, user := range s.users {
+		if wanted[user.Id] {
+			users = append(users, user)
+		}
+	}
+	writeJSON(w, users)
+}
+
+// getStatuses answers the statuses, with last activity times, of the requested users
+func (s *Server) getStatuses(w http.ResponseWriter, r *http.Request) {
+
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	statuses := make([]*model.Status, 0, len(ids))
+	for _, id := range ids {
+		statuses = append(statuses, &model.Status{UserId: id, Status: model.StatusOffline, LastActivityAt: s.lastActivity[id]})
+	}
+	writeJSON(w, statuses)
+}
+
+// getSessions answers a user's sessions
+func (s *Server) getSessions(w http.ResponseWriter, userID string) {
+
+	sessions := append([]*model.Session{}, s.sessions[userID]...)
+	writeJSON(w, sessions)
+}
+
+// getTeamMembersForUser answers a user's team memberships
+func (s *Server) getTeamMembersForUser(w http.ResponseWriter, userID string) {
+
+	members := []*model.TeamMember{}
+	for _, team := range s.teams {
+		if s.memberSet(s.teamMembers[team.Id])[userID] {
+			members = append(members, &model.TeamMember{TeamId: team.Id, UserId: userID, SchemeUser: true})
+		}
+	}
+	writeJSON(w, members)
+}
+
+// getTeamsForUser answers the teams a user is a member of
+func (s *Server) getTeamsForUser(w http.ResponseWriter, userID string) {
+
+	teams := []*model.Team{}
+	for _, team := range s.teams {
+		if s.memberSet(s.teamMembers[team.Id])[userID] {
+			teams = append(teams, team)
+		}
+	}
+	writeJSON(w, teams)
+}
+
+// getChannelsForTeamForUser answers the channels of a team that a user is a member of
+func (s *Server) getChannelsForTeamForUser(w http.ResponseWriter, userID string, teamID string) {
+
+	channels := []*model.Channel{}
+	for _, channel := range s.channels {
+		if channel.TeamId == teamID && s.memberSet(s.channelMembers[channel.Id])[userID] {
+			channels = append(channels, channel)
+		}
+	}
+	writeJSON(w, channels)
+}
+
+// getTeams answers a page of the teams
+func (s *Server) getTeams(w http.ResponseWriter, query map[string][]string) {
+
+	page, _ := strconv.Atoi(first(query["page"]))
+	perPage, _ := strconv.Atoi(first(query["per_page"]))
+	if perPage <= 0 {
+		perPage = 60
+	}
+
+	start := min(page*perPage, len(s.teams))
+	end := min(start+perPage, len(s.teams))
+	writeJSON(w, s.teams[start:end])
+}
+
+// getTeam answers the first team that matches
+func (s *Server) getTeam(w http.ResponseWriter, match func(team *model.Team) bool) {
+
+	for _, team := range s.teams {
+		if match(team) {
+			writeJSON(w, team)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "team not found")
+}
+
+// getTeamStats answers a team's member counts.  Every fake user is active.
+func (s *Server) getTeamStats(w http.ResponseWriter, teamID string) {
+
+	count := 0
+	for _, user := range s.users {
+		if s.memberSet(s.teamMembers[teamID])[user.Id] {
+			count++
+		}
+	}
+	writeJSON(w, &model.TeamStats{TeamId: teamID, TotalMemberCount: int64(count), ActiveMemberCount: int64(count)})
+}
+
+// getTeamMembers answers a page of a team's member records, with the time each member joined unless HideJoinTimes is
+// set
+func (s *Server) getTeamMembers(w http.ResponseWriter, teamID string, query map[string][]string) {
+
+	page, _ := strconv.Atoi(first(query["page"]))
+	perPage, _ := strconv.Atoi(first(query["per_page"]))
+	if perPage <= 0 {
+		perPage = 60
+	}
+
+	ids := s.teamMembers[teamID]
+	start := min(page*perPage, len(ids))
+	end := min(start+perPage, len(ids))
+
+	// model.TeamMember doesn't encode the join time, so the records are written as maps
+	members := []map[string]interface{}{}
+	for _, id := range ids[start:end] {
+		member := map[string]interface{}{"team_id": teamID, "user_id": id, "roles": "", "delete_at": 0, "scheme_user": true}
+		if !s.HideJoinTimes {
+			member["create_at"] = s.joinTimes[teamID+"/"+id]
+		}
+		members = append(members, member)
+	}
+	writeJSON(w, members)
+}
+
+// getChannelPosts answers a page of a channel's posts, newest first.  Only town-square has any posts: a join message
+// for each member of its team.
+func (s *Server) getChannelPosts(w http.ResponseWriter, channelID string, query map[string][]string) {
+
+	page, _ := strconv.Atoi(first(query["page"]))
+	perPage, _ := strconv.Atoi(first(query["per_page"]))
+	if perPage <= 0 {
+		perPage = 60
+	}
+
+	var posts []*model.Post
+	for _, channel := range s.channels {
+		if channel.Id != channelID || channel.Name != model.DefaultChannelName {
+			continue
+		}
+		for _, userID := range s.teamMembers[channel.TeamId] {
+			posts = append(posts, &model.Post{
+				Id:        "post" + userID,
+				ChannelId: channelID,
+				UserId:    userID,
+				Type:      model.PostTypeJoinTeam,
+				CreateAt:  s.joinTimes[channel.TeamId+"/"+userID],
+			})
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].CreateAt > posts[j].CreateAt })
+
+	start := min(page*perPage, len(posts))
+	end := min(start+perPage, len(posts))
+
+	list := model.NewPostList()
+	for _, post := range posts[start:end] {
+		list.AddPost(post)
+		list.AddOrder(post.Id)
+	}
+	writeJSON(w, list)
+}
+
+// getChannelByName answers a channel in a team
+func (s *Server) getChannelByName(w http.ResponseWriter, teamID string, name string) {
+
+	for _, channel := range s.channels {
+		if channel.TeamId == teamID && channel.Name == name {
+			writeJSON(w, channel)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "channel not found")
+}
+
+// getChannelMembers answers a page of a channel's members
+func (s *Server) getChannelMembers(w http.ResponseWriter, channelID string, query map[string][]string) {
+
+	page, _ := strconv.Atoi(first(query["page"]))
+	perPage, _ := strconv.Atoi(first(query["per_page"]))
+	if perPage <= 0 {
+		perPage = 60
+	}
+
+	ids := s.channelMembers[channelID]
+	start := min(page*perPage, len(ids))
+	end := min(start+perPage, len(ids))
+
+	members := model.ChannelMembers{}
+	for _, id := range ids[start:end] {
+		members = append(members, model.ChannelMember{ChannelId: channelID, UserId: id, SchemeUser: true})
+	}
+	writeJSON(w, members)
+}
+
+// createDirectChannel answers the direct message channel between two users, creating it on first use
+func (s *Server) createDirectChannel(w http.ResponseWriter, r *http.Request) {
+
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil || len(ids) != 2 {
+		writeError(w, http.StatusBadRequest, "a direct channel needs two user IDs")
+		return
+	}
+
+	name := model.GetDMNameFromIds(ids[0], ids[1])
+	for channelID, members := range s.directChannels {
+		if model.GetDMNameFromIds(members[0], members[1]) == name {
+			writeStatusJSON(w, http.StatusCreated, &model.Channel{Id: channelID, Name: name, Type: model.ChannelTypeDirect})
+			return
+		}
+	}
+
+	channelID := s.newID()
+	s.directChannels[channelID] = [2]string{ids[0], ids[1]}
+	writeStatusJSON(w, http.StatusCreated, &model.Channel{Id: channelID, Name: name, Type: model.ChannelTypeDirect})
+}
+
+// createPost records a post.  Only posts in direct message channels are accepted, and are kept as messages to the
+// other member of the channel.
+func (s *Server) createPost(w http.ResponseWriter, r *http.Request) {
+
+	var post model.Post
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	members, found := s.directChannels[post.ChannelId]
+	if !found {
+		writeError(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	recipient := members[0]
+	if recipient == AdminUserID {
+		recipient = members[1]
+	}
+	s.directMessages[recipient] = append(s.directMessages[recipient], post.Message)
+
+	post.Id = s.newID()
+	post.UserId = AdminUserID
+	writeStatusJSON(w, http.StatusCreated, &post)
+}
+
+// memberSet converts a list of user IDs to a set
+func (s *Server) memberSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// inAnyTeam reports whether a user is a member of any team
+func (s *Server) inAnyTeam(userID string) bool {
+	for _, members := range s.teamMembers {
+		if s.memberSet(members)[userID] {
+			return true
+		}
+	}
+	return false
+}
+
+// first returns the first value of a query parameter, or an empty string
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// writeJSON writes a successful JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeStatusJSON writes a JSON response with the given status, such as 201 Created
+func writeStatusJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error response in the form the Mattermost client expects
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(model.NewAppError("mmtest", "mmtest.error", nil, message, status))
+}
@@ -0,0 +1,134 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// DefaultNotificationTemplate is the direct message sent to inactive users when no template file is supplied
+const DefaultNotificationTemplate = `Hi {{.Username}}, you haven't used Mattermost for {{.DaysSinceLastActivity}} days.  ` +
+	`Inactive accounts may be deactivated, so if you still need yours, please sign in soon.`
+
+// ParseNotificationTemplate parses a direct message template, which is executed with each recipient's User record
+// (e.g. {{.Username}}, {{.FullName}} or {{.DaysSinceLastActivity}}).  The template is tried against an empty record,
+// so that a misspelt field is reported before any messages are sent.
+func ParseNotificationTemplate(text string) (*template.Template, error) {
+
+	message, err := template.New("message").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := message.Execute(io.Discard, &User{}); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// LoadNotificationTemplate reads and parses a direct message template file
+func LoadNotificationTemplate(filePath string) (*template.Template, error) {
+
+	DebugPrint("Loading message template from: " + filePath)
+
+	text, err := os.ReadFile(filePath)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to open message template file: "+filePath+" - "+err.Error())
+		return nil, err
+	}
+
+	return ParseNotificationTemplate(string(text))
+}
+
+// sendDirectMessage posts a message in the direct message channel between two users, creating the channel if needed
+func sendDirectMessage(mmClient *model.Client4, senderID string, recipientID string, message string) error {
+
+	ctx := context.Background()
+
+	channel, response, err := mmClient.CreateDirectChannel(ctx, senderID, recipientID)
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from CreateDirectChannel(): "+err.Error())
+		return err
+	}
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from CreateDirectChannel()")
+		return errors.New("failed to send data to Mattermost")
+	}
+
+	_, response, err = mmClient.CreatePost(ctx, &model.Post{ChannelId: channel.Id, Message: message})
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from CreatePost(): "+err.Error())
+		return err
+	}
+	if response.StatusCode != http.StatusCreated {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from CreatePost()")
+		return errors.New("failed to send data to Mattermost")
+	}
+
+	return nil
+}
+
+// NotifyUsers sends each of the users a direct message, rendered from the template with their User record, from the
+// account the client is logged in as.  With dryRun set, the messages are logged but not sent.  Deactivated users, bots
+// and the sender are skipped.  It returns the number of users messaged (or, for a dry run, that would have been).
+func NotifyUsers(mmClient *model.Client4, users []*User, message *template.Template, dryRun bool) (int, error) {
+
+	DebugPrint(fmt.Sprintf("In NotifyUsers, for %d users", len(users)))
+
+	sender, response, err := mmClient.GetMe(context.Background(), "")
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetMe(): "+err.Error())
+		return 0, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetMe()")
+		return 0, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	seen := make(map[string]bool)
+	notified := 0
+	errorCount := 0
+
+	for _, user := range users {
+		if seen[user.UserID] || !user.DeactivatedAt.IsZero() || user.IsBotAccount || user.UserID == sender.Id {
+			continue
+		}
+		seen[user.UserID] = true
+
+		description := fmt.Sprintf("'%s' (%s), inactive for %d days", user.Username, user.UserID, user.DaysSinceLastActivity)
+
+		var text bytes.Buffer
+		if err := message.Execute(&text, user); err != nil {
+			LogMessage(ErrorLevel, "Failed to render the message for user "+description+" - "+err.Error())
+			return notified, err
+		}
+
+		if dryRun {
+			LogMessage(InfoLevel, "Dry run - would message user "+description+": "+strings.TrimSpace(text.String()))
+			notified++
+			continue
+		}
+
+		if err := sendDirectMessage(mmClient, sender.Id, user.UserID, text.String()); err != nil {
+			LogMessage(WarningLevel, "Failed to message user "+description+" - "+err.Error())
+			errorCount++
+			if errorCount > maxErrors {
+				LogMessage(ErrorLevel, "Too many errors messaging users.  Aborting.")
+				return notified, err
+			}
+			continue
+		}
+
+		LogMessage(InfoLevel, "Messaged user "+description)
+		notified++
+	}
+
+	return notified, nil
+}
@@ -0,0 +1,348 @@
+package mmuserlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// OffboardPacket gathers everything about a single departing user that an offboarding checklist needs
+type OffboardPacket struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	User        OffboardProfile   `json:"user"`
+	Teams       []OffboardTeam    `json:"teams"`
+	Channels    []OffboardChannel `json:"channels"`
+	OwnedBots   []OffboardBot     `json:"owned_bots"`
+	Sessions    []OffboardSession `json:"sessions"`
+	Notes       []string          `json:"notes,omitempty"`
+}
+
+// OffboardProfile is the user's profile, as recorded on the server
+type OffboardProfile struct {
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	Email          string `json:"email"`
+	FirstName      string `json:"first_name"`
+	LastName       string `json:"last_name"`
+	Nickname       string `json:"nickname"`
+	Position       string `json:"position"`
+	Roles          string `json:"roles"`
+	AuthService    string `json:"auth_service"`
+	IsBotAccount   bool   `json:"is_bot_account"`
+	CreatedAt      string `json:"created_at"`
+	DeactivatedAt  string `json:"deactivated_at,omitempty"`
+	LastActivityAt string `json:"last_activity_at,omitempty"`
+}
+
+// OffboardTeam is one of the user's team memberships
+type OffboardTeam struct {
+	TeamID      string `json:"team_id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Role        string `json:"role"`
+}
+
+// OffboardChannel is one of the user's channel memberships
+type OffboardChannel struct {
+	ChannelID    string `json:"channel_id"`
+	Team         string `json:"team"`
+	Name         string `json:"name"`
+	DisplayName  string `json:"display_name"`
+	Type         string `json:"type"`
+	Role         string `json:"role"`
+	LastViewedAt string `json:"last_viewed_at,omitempty"`
+}
+
+// OffboardBot is a bot account owned by the user, which will need a new owner
+type OffboardBot struct {
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Deactivated bool   `json:"deactivated"`
+}
+
+// OffboardSession is one of the user's sessions, which should be revoked.  Session tokens are never included.
+type OffboardSession struct {
+	SessionID      string `json:"session_id"`
+	Client         string `json:"client"`
+	Platform       string `json:"platform"`
+	CreatedAt      string `json:"created_at"`
+	LastActivityAt string `json:"last_activity_at"`
+	ExpiresAt      string `json:"expires_at,omitempty"`
+}
+
+// channelTypeNames describes each kind of channel for the packet
+var channelTypeNames = map[model.ChannelType]string{
+	model.ChannelTypeOpen:    "public",
+	model.ChannelTypePrivate: "private",
+	model.ChannelTypeDirect:  "direct message",
+	model.ChannelTypeGroup:   "group message",
+}
+
+// ResolveUser finds a user by ID, username or email address
+func ResolveUser(mmClient *model.Client4, identifier string) (*model.User, error) {
+
+	DebugPrint("In ResolveUser, for user: " + identifier)
+
+	ctx := context.Background()
+	var user *model.User
+	var response *model.Response
+	var err error
+
+	switch {
+	case strings.Contains(identifier, "@"):
+		user, response, err = mmClient.GetUserByEmail(ctx, identifier, "")
+	case model.IsValidId(identifier):
+		user, response, err = mmClient.GetUser(ctx, identifier, "")
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			user, response, err = mmClient.GetUserByUsername(ctx, identifier, "")
+		}
+	default:
+		user, response, err = mmClient.GetUserByUsername(ctx, identifier, "")
+	}
+
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("user '%s' not found", identifier)
+	}
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned while retrieving user: "+err.Error())
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetOffboardPacket gathers the profile, team and channel memberships, owned bots and sessions of a single user.
+// Reading sessions needs the system admin permission; if the token is refused, the packet notes that the sessions
+// couldn't be listed rather than failing.  Times are rendered with the date options.
+func GetOffboardPacket(mmClient *model.Client4, identifier string, dates DateOptions) (*OffboardPacket, error) {
+
+	mmUser, err := ResolveUser(mmClient, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	DebugPrint("Gathering offboarding packet for: " + mmUser.Id)
+
+	ctx := context.Background()
+	users := buildUserList([]*model.User{mmUser}, true)
+	if err := ApplyLastActivity(mmClient, users); err != nil {
+		return nil, err
+	}
+	user := users[0]
+
+	packet := &OffboardPacket{
+		GeneratedAt: time.Now().UTC(),
+		User: OffboardProfile{
+			UserID:         user.UserID,
+			Username:       user.Username,
+			Email:          user.Email,
+			FirstName:      user.FirstName,
+			LastName:       user.LastName,
+			Nickname:       user.Nickname,
+			Position:       mmUser.Position,
+			Roles:          mmUser.Roles,
+			AuthService:    user.AuthService,
+			IsBotAccount:   user.IsBotAccount,
+			CreatedAt:      dates.timestamp(user.UserCreatedAt),
+			DeactivatedAt:  dates.timestamp(user.DeactivatedAt),
+			LastActivityAt: dates.timestamp(user.LastActivityAt),
+		},
+		Teams:     []OffboardTeam{},
+		Channels:  []OffboardChannel{},
+		OwnedBots: []OffboardBot{},
+		Sessions:  []OffboardSession{},
+	}
+
+	// Teams and channels
+	teams, response, err := mmClient.GetTeamsForUser(ctx, mmUser.Id, "")
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetTeamsForUser(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetTeamsForUser()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+	teamMembers, _, err := mmClient.GetTeamMembersForUser(ctx, mmUser.Id, "")
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetTeamMembersForUser(): "+err.Error())
+		return nil, err
+	}
+	teamRoles := make(map[string]string, len(teamMembers))
+	for _, member := range teamMembers {
+		role := "member"
+		if member.SchemeAdmin || strings.Contains(member.Roles, model.TeamAdminRoleId) {
+			role = "admin"
+		} else if member.SchemeGuest || strings.Contains(member.Roles, model.TeamGuestRoleId) {
+			role = "guest"
+		}
+		teamRoles[member.TeamId] = role
+	}
+
+	seenChannels := make(map[string]bool)
+	for _, team := range teams {
+		packet.Teams = append(packet.Teams, OffboardTeam{
+			TeamID:      team.Id,
+			Name:        team.Name,
+			DisplayName: team.DisplayName,
+			Role:        teamRoles[team.Id],
+		})
+
+		channels, err := getUserChannels(mmClient, mmUser.Id, team, dates)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			if !seenChannels[channel.ChannelID] {
+				seenChannels[channel.ChannelID] = true
+				packet.Channels = append(packet.Channels, channel)
+			}
+		}
+	}
+
+	// Bots owned by the user
+	for page := 0; ; page++ {
+		bots, response, err := mmClient.GetBotsIncludeDeleted(ctx, page, PageSize, "")
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetBotsIncludeDeleted(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetBotsIncludeDeleted()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+		for _, bot := range bots {
+			if bot.OwnerId == mmUser.Id {
+				packet.OwnedBots = append(packet.OwnedBots, OffboardBot{
+					UserID:      bot.UserId,
+					Username:    bot.Username,
+					DisplayName: bot.DisplayName,
+					Deactivated: bot.DeleteAt > 0,
+				})
+			}
+		}
+		if len(bots) < PageSize {
+			break
+		}
+	}
+
+	// Sessions
+	sessions, response, err := mmClient.GetSessions(ctx, mmUser.Id, "")
+	if response != nil && response.StatusCode == http.StatusForbidden {
+		LogMessage(WarningLevel, "The auth token does not permit reading user sessions - they will not be listed")
+		packet.Notes = append(packet.Notes, "Sessions could not be listed: the auth token does not permit reading them")
+	} else if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetSessions(): "+err.Error())
+		return nil, err
+	} else {
+		for _, session := range sessions {
+			usage, _ := classifySession(session)
+			entry := OffboardSession{
+				SessionID:      session.Id,
+				Client:         strings.TrimSpace(usage.Client + " " + usage.Version),
+				Platform:       usage.Platform,
+				CreatedAt:      dates.timestamp(time.UnixMilli(session.CreateAt)),
+				LastActivityAt: dates.timestamp(time.UnixMilli(session.LastActivityAt)),
+			}
+			if session.ExpiresAt > 0 {
+				entry.ExpiresAt = dates.timestamp(time.UnixMilli(session.ExpiresAt))
+			}
+			packet.Sessions = append(packet.Sessions, entry)
+		}
+	}
+
+	return packet, nil
+}
+
+// getUserChannels returns a user's channel memberships in a team, including their direct and group messages
+func getUserChannels(mmClient *model.Client4, userID string, team *model.Team, dates DateOptions) ([]OffboardChannel, error) {
+
+	ctx := context.Background()
+
+	channels, response, err := mmClient.GetChannelsForTeamForUser(ctx, team.Id, userID, false, "")
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetChannelsForTeamForUser(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetChannelsForTeamForUser()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	members, _, err := mmClient.GetChannelMembersForUser(ctx, userID, team.Id, "")
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetChannelMembersForUser(): "+err.Error())
+		return nil, err
+	}
+	memberships := make(map[string]*model.ChannelMember, len(members))
+	for i := range members {
+		memberships[members[i].ChannelId] = &members[i]
+	}
+
+	var result []OffboardChannel
+	for _, channel := range channels {
+		entry := OffboardChannel{
+			ChannelID:   channel.Id,
+			Team:        team.Name,
+			Name:        channel.Name,
+			DisplayName: channel.DisplayName,
+			Type:        channelTypeNames[channel.Type],
+			Role:        ChannelRoleMember,
+		}
+		if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+			entry.Team = ""
+		}
+		if member, found := memberships[channel.Id]; found {
+			entry.Role = channelRole(member)
+			if member.LastViewedAt > 0 {
+				entry.LastViewedAt = dates.timestamp(time.UnixMilli(member.LastViewedAt))
+			}
+		}
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// WriteOffboardPacket writes the packet as JSON or as a PDF document, in the output format.  The output's branding, if
+// any, is applied to the PDF.
+func WriteOffboardPacket(packet *OffboardPacket, filePath string, output OutputOptions) error {
+
+	DebugPrint("Writing offboarding packet to: " + filePath)
+
+	if output.Format == FormatPDF {
+		data, err := renderOffboardPDF(packet, output)
+		if err != nil {
+			LogMessage(ErrorLevel, "Failed to render PDF output: "+err.Error())
+			return err
+		}
+		return WriteOutputFile(filePath, data, output.Compress)
+	}
+
+	file, err := CreateOutput(filePath, output.Compress)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to create file: "+filePath+" - "+err.Error())
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(packet); err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
@@ -0,0 +1,757 @@
+package mmuserlist
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Output formats
+const (
+	FormatCSV     = "csv"
+	FormatJSON    = "json"
+	FormatXLSX    = "xlsx"
+	FormatPDF     = "pdf" // for reports that are read rather than processed
+	FormatSQLite  = "sqlite"
+	FormatParquet = "parquet"
+	FormatHTML    = "html" // a standalone page for reading in a browser
+	FormatNDJSON  = "ndjson"
+)
+
+// User props export modes.  Props are free-form key/value pairs set by the server and plugins (e.g. customStatus).
+const (
+	PropsNone    = ""
+	PropsColumns = "columns"
+	PropsJSON    = "json"
+	propsPrefix  = "Prop: "
+	tagsPrefix   = "Tag: "
+)
+
+// OutputOptions controls which optional columns are written alongside the standard user fields.  Columns, if set,
+// replaces the standard and optional columns with those named (see ColumnNames), in the order given.  Dates sets how
+// dates are rendered.  Template is the template for FormatTemplate output (see ParseUserTemplate).  Existing is the file being replaced, which
+// FormatSQLite output adds its rows to; WriteUsers sets it.  Append, for FormatCSV, adds the rows to the existing file
+// too, with a CSVRunColumn recording when they were exported, rather than replacing it.
+type OutputOptions struct {
+	Format      string
+	Columns     []string
+	PropsMode   string
+	ClientUsage bool
+	Sessions    bool
+	Roles       bool
+	AuthMethod  bool
+	Guests      bool
+	TeamJoined  bool
+	Teams       bool
+	Channels    bool
+	LastPost    bool
+	PostCount   bool
+	Channel     bool
+	RoleHistory bool
+	Deactivated bool
+	Tags        map[string]string
+	Dates       DateOptions
+	CSV         CSVOptions
+	Branding    *Branding
+	Template    *template.Template
+	Existing    string
+	Append      bool
+	Compress    bool
+}
+
+// jsonUser is the representation of a user in JSON output, with typed fields and RFC3339 timestamps
+type jsonUser struct {
+	UserID                string            `json:"user_id"`
+	Username              string            `json:"username"`
+	Email                 string            `json:"email"`
+	FirstName             string            `json:"first_name"`
+	LastName              string            `json:"last_name"`
+	Nickname              string            `json:"nickname"`
+	IsBotAccount          bool              `json:"is_bot_account"`
+	UserCreatedAt         string            `json:"user_created_at"`
+	Deactivated           *bool             `json:"deactivated,omitempty"`
+	DeactivatedAt         string            `json:"deactivated_at,omitempty"`
+	LastActivityAt        string            `json:"last_activity_at,omitempty"`
+	DaysSinceLastActivity int               `json:"days_since_last_activity"`
+	LastPostAt            string            `json:"last_post_at,omitempty"`
+	DaysSinceLastPost     *int              `json:"days_since_last_post,omitempty"`
+	TotalPosts            *int              `json:"total_posts,omitempty"`
+	TeamName              string            `json:"team_name"`
+	TeamJoinedAt          string            `json:"team_joined_at,omitempty"`
+	Teams                 *[]string         `json:"teams,omitempty"`
+	ChannelCount          *int              `json:"channel_count,omitempty"`
+	Roles                 []string          `json:"roles,omitempty"`
+	AuthMethod            string            `json:"auth_method,omitempty"`
+	IsGuest               *bool             `json:"is_guest,omitempty"`
+	GuestChannels         []string          `json:"guest_channels,omitempty"`
+	LastClient            string            `json:"last_client,omitempty"`
+	LastClientVersion     string            `json:"last_client_version,omitempty"`
+	LastClientPlatform    string            `json:"last_client_platform,omitempty"`
+	ActiveSessions        *int              `json:"active_sessions,omitempty"`
+	LastSessionAt         string            `json:"last_session_at,omitempty"`
+	SessionClients        []string          `json:"session_clients,omitempty"`
+	ChannelRole           string            `json:"channel_role,omitempty"`
+	ChannelLastViewedAt   string            `json:"channel_last_viewed_at,omitempty"`
+	ChannelMsgCount       *int64            `json:"channel_msg_count,omitempty"`
+	RolesChangedAt        string            `json:"roles_changed_at,omitempty"`
+	RolesChangedBy        string            `json:"roles_changed_by,omitempty"`
+	RolesChangedTo        string            `json:"roles_changed_to,omitempty"`
+	Props                 map[string]string `json:"props,omitempty"`
+	Tags                  map[string]string `json:"tags,omitempty"`
+}
+
+// Date layouts for DateOptions
+const (
+	DefaultDateLayout     = "2006-01-02"
+	DateLayoutEpochMillis = "epoch_ms" // milliseconds since the Unix epoch, as Mattermost stores them
+)
+
+// DateOptions sets how dates are rendered.  Layout is the Go time layout used in text output (CSV, PDF and
+// templates), or DateLayoutEpochMillis, and Location is the time zone dates and timestamps are rendered in.  The zero
+// value renders dates in DefaultDateLayout, in UTC.
+type DateOptions struct {
+	Layout   string
+	Location *time.Location
+}
+
+// location returns the time zone to render dates in
+func (d DateOptions) location() *time.Location {
+	if d.Location == nil {
+		return time.UTC
+	}
+	return d.Location
+}
+
+// ParseDateLayout checks a date layout for DateOptions.  A layout without any element of Go's reference time
+// (Mon Jan 2 15:04:05 MST 2006), such as 'YYYY-MM-DD', would render every date as the same text, so it is rejected.
+func ParseDateLayout(layout string) (string, error) {
+	if layout == DateLayoutEpochMillis {
+		return layout, nil
+	}
+	first := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	second := time.Date(2017, time.November, 28, 9, 38, 41, 0, time.UTC)
+	if first.Format(layout) == second.Format(layout) {
+		return "", fmt.Errorf("'%s' is not a Go time layout - write the date Mon Jan 2 15:04:05 MST 2006 in the form wanted, e.g. 02/01/2006", layout)
+	}
+	return layout, nil
+}
+
+// Format renders a date for output, leaving it blank if it was never recorded
+func (d DateOptions) Format(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if d.Layout == DateLayoutEpochMillis {
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	layout := d.Layout
+	if layout == "" {
+		layout = DefaultDateLayout
+	}
+	return t.In(d.location()).Format(layout)
+}
+
+// timestamp renders an RFC3339 timestamp for output, leaving it blank if it was never recorded
+func (d DateOptions) timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(d.location()).Format(time.RFC3339)
+}
+
+// StdoutPath is the output file name that selects standard output, for use in shell pipelines
+const StdoutPath = "-"
+
+// GzipSuffix ends the name of an output file that is written gzip-compressed
+const GzipSuffix = ".gz"
+
+// fileNameConversions are the strftime-style conversions that can be used in an output file name, as time layouts.
+// Those that give characters not allowed in Windows file names, such as %T, aren't supported.
+var fileNameConversions = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'H': "15",
+	'M': "04",
+	'S': "05",
+	'j': "002",
+	'b': "Jan",
+	'F': "2006-01-02",
+}
+
+// ExpandFileName replaces the strftime-style conversions in an output file name with the given time, e.g.
+// users-%Y%m%d.csv becomes users-20240108.csv.  %% gives a literal %, and unknown conversions are left as they are.
+func ExpandFileName(name string, t time.Time) string {
+	var expanded strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' || i == len(name)-1 {
+			expanded.WriteByte(name[i])
+			continue
+		}
+		if layout, found := fileNameConversions[name[i+1]]; found {
+			expanded.WriteString(t.Format(layout))
+		} else if name[i+1] == '%' {
+			expanded.WriteByte('%')
+		} else {
+			expanded.WriteString(name[i : i+2])
+		}
+		i++
+	}
+	return expanded.String()
+}
+
+// OutputFile is an output being written.  A file is written under a temporary name alongside the target and only
+// replaces the target when Commit is called, so a failed or interrupted write never leaves a truncated report behind,
+// and any previous file is preserved.  Closing without committing discards the temporary file.
+type OutputFile struct {
+	io.Writer
+	file      *os.File
+	gzip      *gzip.Writer
+	path      string
+	committed bool
+}
+
+// CreateOutput opens the named output file for writing, or standard output if the name is StdoutPath.  The output is
+// gzip-compressed if compress is set or the name ends in GzipSuffix.
+func CreateOutput(filePath string, compress bool) (*OutputFile, error) {
+	if filePath == StdoutPath {
+		output := &OutputFile{Writer: os.Stdout}
+		if compress {
+			output.compress()
+		}
+		return output, nil
+	}
+
+	// The temporary file must be in the same directory for the rename to be atomic
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the permissions of the file being replaced, otherwise use the usual permissions for a new file
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	output := &OutputFile{Writer: file, file: file, path: filePath}
+	if compress || strings.HasSuffix(filePath, GzipSuffix) {
+		output.compress()
+	}
+	return output, nil
+}
+
+// compress sends everything written to the output through a gzip writer
+func (o *OutputFile) compress() {
+	o.gzip = gzip.NewWriter(o.Writer)
+	o.Writer = o.gzip
+}
+
+// Commit completes the output, replacing the target file with the one just written
+func (o *OutputFile) Commit() error {
+	if o.committed {
+		return nil
+	}
+	if o.gzip != nil {
+		// Closing the gzip writer writes the end of the compressed stream, but not to the file itself
+		if err := o.gzip.Close(); err != nil {
+			if o.file != nil {
+				o.committed = true
+				o.discard()
+			}
+			return err
+		}
+		o.gzip = nil
+	}
+	if o.file == nil {
+		return nil
+	}
+	o.committed = true
+
+	if err := o.file.Sync(); err != nil {
+		o.discard()
+		return err
+	}
+	if err := o.file.Close(); err != nil {
+		os.Remove(o.file.Name())
+		return err
+	}
+	if err := os.Rename(o.file.Name(), o.path); err != nil {
+		os.Remove(o.file.Name())
+		return err
+	}
+
+	DebugPrint("Output committed to: " + o.path)
+	return nil
+}
+
+// Close discards the output unless it has been committed
+func (o *OutputFile) Close() error {
+	if o.file == nil || o.committed {
+		return nil
+	}
+	o.committed = true
+
+	LogMessage(WarningLevel, "Output not completed - "+o.path+" has been left unchanged")
+	return o.discard()
+}
+
+// discard closes and removes the temporary file
+func (o *OutputFile) discard() error {
+	o.file.Close()
+	return os.Remove(o.file.Name())
+}
+
+// OpenReport opens an existing output file for reading, decompressing it if its name ends in GzipSuffix
+func OpenReport(filePath string) (io.ReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filePath, GzipSuffix) {
+		return file, nil
+	}
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return readCloser{Reader: decompressed, close: file.Close}, nil
+}
+
+// readCloser combines a reader with the function that closes its source
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+// Close implements io.Closer
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// WriteOutputFile writes data to the named output file (or standard output) in one go, with the same guarantee as
+// CreateOutput
+func WriteOutputFile(filePath string, data []byte, compress bool) error {
+	file, err := CreateOutput(filePath, compress)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Commit()
+}
+
+// Writer writes a list of users in one output format.  The standard formats are registered automatically, and
+// embedding tools can add their own with RegisterWriter.
+type Writer interface {
+	WriteUsers(out io.Writer, users []*User, output OutputOptions) error
+}
+
+// WriterFunc allows an ordinary function to be used as a Writer
+type WriterFunc func(out io.Writer, users []*User, output OutputOptions) error
+
+// WriteUsers implements Writer
+func (f WriterFunc) WriteUsers(out io.Writer, users []*User, output OutputOptions) error {
+	return f(out, users, output)
+}
+
+var writers = map[string]Writer{
+	FormatCSV:      WriterFunc(WriteUsersToCSV),
+	FormatJSON:     WriterFunc(WriteUsersToJSON),
+	FormatXLSX:     WriterFunc(WriteUsersToXLSX),
+	FormatPDF:      WriterFunc(WriteUsersToPDF),
+	FormatTemplate: WriterFunc(WriteUsersWithTemplate),
+	FormatSQLite:   WriterFunc(WriteUsersToSQLite),
+	FormatParquet:  WriterFunc(WriteUsersToParquet),
+	FormatHTML:     WriterFunc(WriteUsersToHTML),
+	FormatNDJSON:   WriterFunc(WriteUsersToNDJSON),
+}
+var writersMutex sync.RWMutex
+
+// RegisterWriter makes a Writer available for the named output format, replacing any existing writer for it
+func RegisterWriter(format string, writer Writer) {
+	writersMutex.Lock()
+	defer writersMutex.Unlock()
+	writers[format] = writer
+}
+
+// LookupWriter returns the Writer registered for the named output format
+func LookupWriter(format string) (Writer, bool) {
+	writersMutex.RLock()
+	defer writersMutex.RUnlock()
+	writer, found := writers[format]
+	return writer, found
+}
+
+// WriteUsers writes the users to a file, using the writer registered for the requested format
+func WriteUsers(users []*User, filePath string, output OutputOptions) error {
+
+	writer, found := LookupWriter(output.Format)
+	if !found {
+		return fmt.Errorf("unknown output format '%s'", output.Format)
+	}
+
+	file, err := CreateOutput(filePath, output.Compress)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to create file: "+filePath+" - "+err.Error())
+		return err
+	}
+	defer file.Close()
+
+	output.Existing = filePath
+	if err := writer.WriteUsers(file, users, output); err != nil {
+		return err
+	}
+	return file.Commit()
+}
+
+// tagKeys returns the tag keys in alphabetical order
+func tagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// propKeys returns the sorted set of prop keys used across all of the supplied users
+func propKeys(users []*User) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, user := range users {
+		for key := range user.Props {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// column is one column of the tabular output formats.  Value returns a string, bool, int or time.Time, so that each
+// format can render it appropriately (a zero time is an empty cell).
+type column struct {
+	Header string
+	Value  func(user *User) interface{}
+}
+
+// userColumns returns the columns for the tabular output formats: those chosen, or the standard columns and the
+// optional columns requested, followed by any props and tags
+func userColumns(users []*User, output OutputOptions) []column {
+
+	var columns []column
+	if len(output.Columns) > 0 {
+		columns = selectedColumns(output.Columns)
+	} else {
+		columns = standardColumns(output)
+	}
+
+	switch output.PropsMode {
+	case PropsColumns:
+		for _, key := range propKeys(users) {
+			key := key
+			columns = append(columns, column{propsPrefix + key, func(user *User) interface{} { return user.Props[key] }})
+		}
+	case PropsJSON:
+		columns = append(columns, column{"Props", func(user *User) interface{} {
+			if len(user.Props) == 0 {
+				return "{}"
+			}
+			encoded, err := json.Marshal(user.Props)
+			if err != nil {
+				LogMessage(WarningLevel, "Failed to encode props for user '"+user.Username+"'")
+				return "{}"
+			}
+			return string(encoded)
+		}})
+	}
+
+	for _, key := range tagKeys(output.Tags) {
+		value := output.Tags[key]
+		columns = append(columns, column{tagsPrefix + key, func(user *User) interface{} { return value }})
+	}
+
+	return columns
+}
+
+// standardColumns returns the standard columns, and the optional columns requested
+func standardColumns(output OutputOptions) []column {
+
+	columns := []column{
+		{"Username", func(user *User) interface{} { return user.Username }},
+		{"Email", func(user *User) interface{} { return user.Email }},
+		{"First Name", func(user *User) interface{} { return user.FirstName }},
+		{"Last Name", func(user *User) interface{} { return user.LastName }},
+		{"Nickname", func(user *User) interface{} { return user.Nickname }},
+		{"Is Bot Account", func(user *User) interface{} { return user.IsBotAccount }},
+		{"User Created Date", func(user *User) interface{} { return user.UserCreatedAt }},
+		{"Last Activity Date", func(user *User) interface{} { return user.LastActivityAt }},
+		{"Days Since Last Activity", func(user *User) interface{} { return user.DaysSinceLastActivity }},
+		{"Team Name", func(user *User) interface{} { return user.TeamName }},
+	}
+
+	if output.LastPost {
+		columns = append(columns,
+			column{"Last Post Date", func(user *User) interface{} { return user.LastPostAt }},
+			column{"Days Since Last Post", func(user *User) interface{} { return user.DaysSinceLastPost }})
+	}
+	if output.PostCount {
+		columns = append(columns, column{"Total Posts", func(user *User) interface{} { return user.TotalPosts }})
+	}
+	if output.TeamJoined {
+		columns = append(columns, column{"Team Join Date", func(user *User) interface{} { return user.TeamJoinedAt }})
+	}
+	if output.Teams {
+		columns = append(columns, column{"Teams", func(user *User) interface{} { return strings.Join(user.Teams, ", ") }})
+	}
+	if output.Channels {
+		columns = append(columns, column{"Channel Count", func(user *User) interface{} { return user.ChannelCount }})
+	}
+	if output.Deactivated {
+		columns = append(columns,
+			column{"Deactivated", func(user *User) interface{} { return !user.DeactivatedAt.IsZero() }},
+			column{"Deactivated Date", func(user *User) interface{} { return user.DeactivatedAt }})
+	}
+	if output.Roles {
+		columns = append(columns, column{"Roles", func(user *User) interface{} { return strings.Join(user.Roles, ", ") }})
+	}
+	if output.AuthMethod {
+		columns = append(columns, column{"Auth Method", func(user *User) interface{} { return user.AuthMethod() }})
+	}
+	if output.Guests {
+		columns = append(columns,
+			column{"Is Guest", func(user *User) interface{} { return user.IsGuest }},
+			column{"Guest Channels", func(user *User) interface{} { return strings.Join(user.GuestChannels, ", ") }})
+	}
+	if output.ClientUsage {
+		columns = append(columns,
+			column{"Last Client", func(user *User) interface{} { return user.LastClient }},
+			column{"Last Client Version", func(user *User) interface{} { return user.LastClientVersion }},
+			column{"Last Client Platform", func(user *User) interface{} { return user.LastClientPlatform }})
+	}
+	if output.Sessions {
+		columns = append(columns,
+			column{"Active Sessions", func(user *User) interface{} { return user.SessionCount }},
+			column{"Last Session Date", func(user *User) interface{} { return user.LastSessionAt }},
+			column{"Session Clients", func(user *User) interface{} { return strings.Join(user.SessionClients, ", ") }})
+	}
+	if output.Channel {
+		columns = append(columns,
+			column{"Channel Role", func(user *User) interface{} { return user.ChannelRole }},
+			column{"Channel Last Viewed Date", func(user *User) interface{} { return user.ChannelLastViewedAt }},
+			column{"Channel Message Count", func(user *User) interface{} { return user.ChannelMsgCount }})
+	}
+	if output.RoleHistory {
+		columns = append(columns,
+			column{"Roles Last Changed Date", func(user *User) interface{} { return user.RolesChangedAt }},
+			column{"Roles Changed By", func(user *User) interface{} { return user.RolesChangedBy }},
+			column{"Roles Changed To", func(user *User) interface{} { return user.RolesChangedTo }})
+	}
+
+	return columns
+}
+
+// cellText renders a column value as text
+func cellText(value interface{}, dates DateOptions) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case time.Time:
+		return dates.Format(value)
+	case *int:
+		if value == nil {
+			return ""
+		}
+		return strconv.Itoa(*value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// WriteUsersToCSV writes the users as CSV, with a header row.  With output.Append, the users are added to the rows of
+// the existing file instead, with a column recording when they were exported.
+func WriteUsersToCSV(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as CSV")
+
+	columns := csvColumns(users, output)
+
+	// Create a CSV writer, and write the CSV header
+	writer, err := startCSV(out, columns, output)
+	if err != nil {
+		return err
+	}
+
+	// Iterate over the user data and write each record to the CSV file
+	errorCount := 0
+	for _, user := range users {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = cellText(column.Value(user), output.Dates)
+		}
+
+		// Write the record to the CSV file
+		if err := writer.Write(record); err != nil {
+			LogMessage(WarningLevel, "Failed to write record for user '"+user.Username+"' to CSV file")
+			errorCount++
+			if errorCount > maxErrors {
+				LogMessage(ErrorLevel, "Too many errors writing to CSV file.  Aborting.")
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// jsonOutput builds the JSON representation of a user: the chosen columns, if any, otherwise the standard record
+func jsonOutput(user *User, output OutputOptions) interface{} {
+	if len(output.Columns) > 0 {
+		return newSelectedRecord(user, output)
+	}
+	return jsonRecord(user, output)
+}
+
+// jsonRecord builds the JSON representation of a user, with the optional fields selected by the output options
+func jsonRecord(user *User, output OutputOptions) jsonUser {
+	record := jsonUser{
+		UserID:                user.UserID,
+		Username:              user.Username,
+		Email:                 user.Email,
+		FirstName:             user.FirstName,
+		LastName:              user.LastName,
+		Nickname:              user.Nickname,
+		IsBotAccount:          user.IsBotAccount,
+		UserCreatedAt:         output.Dates.timestamp(user.UserCreatedAt),
+		LastActivityAt:        output.Dates.timestamp(user.LastActivityAt),
+		DaysSinceLastActivity: user.DaysSinceLastActivity,
+		TeamName:              user.TeamName,
+	}
+	if output.LastPost {
+		record.LastPostAt = output.Dates.timestamp(user.LastPostAt)
+		record.DaysSinceLastPost = user.DaysSinceLastPost
+	}
+	if output.PostCount {
+		totalPosts := user.TotalPosts
+		record.TotalPosts = &totalPosts
+	}
+	if output.TeamJoined {
+		record.TeamJoinedAt = output.Dates.timestamp(user.TeamJoinedAt)
+	}
+	if output.Teams {
+		// An empty list is kept, to show that the user is in no team
+		teams := append([]string{}, user.Teams...)
+		record.Teams = &teams
+	}
+	if output.Channels {
+		channelCount := user.ChannelCount
+		record.ChannelCount = &channelCount
+	}
+	if output.Deactivated {
+		deactivated := !user.DeactivatedAt.IsZero()
+		record.Deactivated = &deactivated
+		record.DeactivatedAt = output.Dates.timestamp(user.DeactivatedAt)
+	}
+	if output.Roles {
+		record.Roles = user.Roles
+	}
+	if output.AuthMethod {
+		record.AuthMethod = user.AuthMethod()
+	}
+	if output.Guests {
+		isGuest := user.IsGuest
+		record.IsGuest = &isGuest
+		record.GuestChannels = user.GuestChannels
+	}
+	if output.ClientUsage {
+		record.LastClient = user.LastClient
+		record.LastClientVersion = user.LastClientVersion
+		record.LastClientPlatform = user.LastClientPlatform
+	}
+	if output.Sessions {
+		sessionCount := user.SessionCount
+		record.ActiveSessions = &sessionCount
+		record.LastSessionAt = output.Dates.timestamp(user.LastSessionAt)
+		record.SessionClients = user.SessionClients
+	}
+	if output.Channel {
+		msgCount := user.ChannelMsgCount
+		record.ChannelRole = user.ChannelRole
+		record.ChannelLastViewedAt = output.Dates.timestamp(user.ChannelLastViewedAt)
+		record.ChannelMsgCount = &msgCount
+	}
+	if output.RoleHistory {
+		record.RolesChangedAt = output.Dates.timestamp(user.RolesChangedAt)
+		record.RolesChangedBy = user.RolesChangedBy
+		record.RolesChangedTo = user.RolesChangedTo
+	}
+	if output.PropsMode != PropsNone {
+		record.Props = user.Props
+	}
+	if len(output.Tags) > 0 {
+		record.Tags = output.Tags
+	}
+	return record
+}
+
+// WriteUsersToJSON writes the users as a JSON array.  Any props are included as a nested object, since JSON
+// has no need to flatten them.
+func WriteUsersToJSON(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as JSON")
+
+	records := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		records = append(records, jsonOutput(user, output))
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(records); err != nil {
+		LogMessage(ErrorLevel, "Failed to write JSON output: "+err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// WriteUsersToNDJSON writes the users as newline-delimited JSON: one object per line, with the same fields as
+// WriteUsersToJSON, so that the output can be piped into jq or a log shipper a record at a time
+func WriteUsersToNDJSON(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as NDJSON")
+
+	buffered := bufio.NewWriter(out)
+	encoder := json.NewEncoder(buffered)
+	for _, user := range users {
+		if err := encoder.Encode(jsonOutput(user, output)); err != nil {
+			LogMessage(ErrorLevel, "Failed to write NDJSON output: "+err.Error())
+			return err
+		}
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,108 @@
+package mmuserlist
+
+import (
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetCreatedBy is recorded in the file as the application that wrote it
+const parquetCreatedBy = "mm-user-list"
+
+// parquetField is a column of the Parquet schema
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f parquetField) Name() string { return f.name }
+
+func (f parquetField) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// parquetGroup is the root of the Parquet schema.  A parquet.Group sorts its columns by name, so the fields are kept
+// in a slice instead, and the file has its columns in the same order as the other formats.
+type parquetGroup struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+func (g parquetGroup) Fields() []parquet.Field { return g.fields }
+
+// parquetNode returns the schema node of a column, typed from an example of its values.  Every column is optional, so
+// that a date that was never recorded can be null.
+func parquetNode(example interface{}) parquet.Node {
+	switch example.(type) {
+	case bool:
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	case int, int64, *int:
+		return parquet.Optional(parquet.Int(64))
+	case time.Time:
+		return parquet.Optional(parquet.Timestamp(parquet.Millisecond))
+	default:
+		return parquet.Optional(parquet.String())
+	}
+}
+
+// parquetValue converts a column value for the file: a bool, an int64 or a string as the column's type requires, or
+// null for a date never recorded, or a count that doesn't apply
+func parquetValue(value interface{}, column int) parquet.Value {
+	var converted parquet.Value
+	switch value := value.(type) {
+	case bool:
+		converted = parquet.BooleanValue(value)
+	case int:
+		converted = parquet.Int64Value(int64(value))
+	case int64:
+		converted = parquet.Int64Value(value)
+	case *int:
+		if value == nil {
+			return parquet.NullValue().Level(0, 0, column)
+		}
+		converted = parquet.Int64Value(int64(*value))
+	case time.Time:
+		if value.IsZero() {
+			return parquet.NullValue().Level(0, 0, column)
+		}
+		converted = parquet.Int64Value(value.UnixMilli())
+	default:
+		converted = parquet.ByteArrayValue([]byte(cellText(value, DateOptions{})))
+	}
+	return converted.Level(0, 1, column)
+}
+
+// WriteUsersToParquet writes the users as a Parquet file, with one typed column per output column: strings as UTF-8,
+// booleans, integers, and dates as UTC timestamps in milliseconds, or null if never recorded.  The columns are named
+// as for SQLite output, e.g. last_activity_date.  The file has a single GZIP-compressed row group, which every Parquet
+// reader (Athena, Spark, DuckDB, pandas) can load.
+func WriteUsersToParquet(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as Parquet")
+
+	columns := userColumns(users, output)
+	names := columnIdentifiers(columns)
+	root := parquetGroup{Group: parquet.Group{}}
+	for i, column := range columns {
+		node := parquetNode(column.Value(&User{}))
+		root.Group[names[i]] = node
+		root.fields = append(root.fields, parquetField{Node: node, name: names[i]})
+	}
+	schema := parquet.NewSchema("users", root)
+
+	rows := make([]parquet.Row, len(users))
+	for i, user := range users {
+		rows[i] = make(parquet.Row, len(columns))
+		for j, column := range columns {
+			rows[i][j] = parquetValue(column.Value(user), j)
+		}
+	}
+
+	writer := parquet.NewGenericWriter[any](out, schema, parquet.Compression(&parquet.Gzip), parquet.CreatedBy(parquetCreatedBy, "", ""))
+	if _, err := writer.WriteRows(rows); err != nil {
+		return err
+	}
+	return writer.Close()
+}
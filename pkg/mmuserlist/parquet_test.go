@@ -0,0 +1,141 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// readParquet reads back a file written by WriteUsersToParquet, returning its column names and rows
+func readParquet(t *testing.T, data []byte) ([]string, []parquet.Row, *parquet.File) {
+	t.Helper()
+
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("the file can't be opened by a Parquet reader: %v", err)
+	}
+
+	var names []string
+	for _, field := range file.Schema().Fields() {
+		names = append(names, field.Name())
+	}
+
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+	var rows []parquet.Row
+	for {
+		batch := make([]parquet.Row, 10)
+		n, err := reader.ReadRows(batch)
+		for _, row := range batch[:n] {
+			rows = append(rows, row.Clone())
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read the rows: %v", err)
+		}
+	}
+	return names, rows, file
+}
+
+func TestWriteUsersToParquetRoundTrip(t *testing.T) {
+
+	created := time.Date(2024, 3, 1, 9, 30, 15, 250*int(time.Millisecond), time.UTC)
+	posted := time.Date(2024, 6, 2, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	days := 12
+	users := []*User{
+		{Username: "alice", IsBotAccount: false, UserCreatedAt: created, LastPostAt: posted, DaysSinceLastPost: &days, TotalPosts: 42},
+		{Username: "bot", IsBotAccount: true, UserCreatedAt: created},
+	}
+	output := OutputOptions{Columns: []string{"username", "is_bot_account", "created_at", "last_post", "days_since_post", "total_posts"}}
+
+	var out bytes.Buffer
+	if err := WriteUsersToParquet(&out, users, output); err != nil {
+		t.Fatalf("WriteUsersToParquet() returned an error: %v", err)
+	}
+	names, rows, file := readParquet(t, out.Bytes())
+
+	// The columns keep the order they were chosen in, rather than being sorted by name
+	expected := []string{"username", "is_bot_account", "user_created_date", "last_post_date", "days_since_last_post", "total_posts"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected the columns %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected the columns %v, got %v", expected, names)
+		}
+	}
+
+	types := []parquet.Kind{parquet.ByteArray, parquet.Boolean, parquet.Int64, parquet.Int64, parquet.Int64, parquet.Int64}
+	for i, field := range file.Schema().Fields() {
+		if !field.Optional() {
+			t.Errorf("expected the column %s to be optional", field.Name())
+		}
+		if field.Type().Kind() != types[i] {
+			t.Errorf("expected the column %s to be %v, got %v", field.Name(), types[i], field.Type().Kind())
+		}
+	}
+	for _, i := range []int{2, 3} {
+		if got := file.Schema().Fields()[i].Type().LogicalType().String(); got != "TIMESTAMP(isAdjustedToUTC=true,unit=MILLIS)" {
+			t.Errorf("expected the column %s to hold UTC timestamps in milliseconds, got %s", names[i], got)
+		}
+	}
+
+	if len(rows) != len(users) {
+		t.Fatalf("expected %d rows, got %d", len(users), len(rows))
+	}
+
+	alice := rows[0]
+	if got := alice[0].String(); got != "alice" {
+		t.Errorf("expected the username alice, got %q", got)
+	}
+	if alice[1].Boolean() {
+		t.Errorf("expected alice not to be a bot")
+	}
+	if got := alice[2].Int64(); got != created.UnixMilli() {
+		t.Errorf("expected the created date %d, got %d", created.UnixMilli(), got)
+	}
+	if got := alice[3].Int64(); got != posted.UnixMilli() {
+		t.Errorf("expected the last post date %d, got %d", posted.UnixMilli(), got)
+	}
+	if got := alice[4].Int64(); got != int64(days) {
+		t.Errorf("expected %d days since the last post, got %d", days, got)
+	}
+	if got := alice[5].Int64(); got != 42 {
+		t.Errorf("expected 42 posts, got %d", got)
+	}
+
+	// A user who has never posted has null rather than zero dates and counts
+	bot := rows[1]
+	if !bot[1].Boolean() {
+		t.Errorf("expected bot to be a bot")
+	}
+	if !bot[3].IsNull() {
+		t.Errorf("expected a null last post date, got %v", bot[3])
+	}
+	if !bot[4].IsNull() {
+		t.Errorf("expected a null number of days since the last post, got %v", bot[4])
+	}
+	if bot[5].IsNull() || bot[5].Int64() != 0 {
+		t.Errorf("expected 0 posts, got %v", bot[5])
+	}
+}
+
+func TestWriteUsersToParquetNoUsers(t *testing.T) {
+
+	var out bytes.Buffer
+	if err := WriteUsersToParquet(&out, nil, OutputOptions{}); err != nil {
+		t.Fatalf("WriteUsersToParquet() returned an error: %v", err)
+	}
+	names, rows, _ := readParquet(t, out.Bytes())
+	if len(names) == 0 {
+		t.Errorf("expected the standard columns, even with no users")
+	}
+	if len(rows) != 0 {
+		t.Errorf("expected no rows, got %d", len(rows))
+	}
+}
@@ -0,0 +1,434 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// PDF layout, in millimetres on A4 paper
+const (
+	pdfMargin      = 15.0
+	pdfLineHeight  = 6.0
+	pdfPageWidth   = 210.0
+	pdfLogoHeight  = 12.0
+	pdfBrandingGap = 4.0
+	pdfChartLabel  = 45.0
+	pdfChartCount  = 15.0
+	pdfChartTeams  = 10
+)
+
+// activityBands are the ranges of days since last activity counted in the PDF report's summary
+var activityBands = []struct {
+	Label string
+	Days  int
+}{
+	{"Up to 30 days", 30},
+	{"31 - 90 days", 90},
+	{"91 - 180 days", 180},
+	{"181 - 365 days", 365},
+	{"Over a year", -1},
+}
+
+// userSummary holds the statistics shown at the head of the PDF and HTML reports
+type userSummary struct {
+	users       int // each user is counted once, however many teams they are listed under
+	bots        int
+	deactivated int
+	bands       []int // the users in each of the activityBands
+	teamCounts  map[string]int
+}
+
+// summarizeUsers counts the users, bots and deactivated accounts, the users in each activity band, and the users in
+// each team
+func summarizeUsers(users []*User) userSummary {
+
+	summary := userSummary{bands: make([]int, len(activityBands)), teamCounts: make(map[string]int)}
+	seen := make(map[string]bool)
+	for _, user := range users {
+		for _, team := range strings.Split(user.TeamName, ", ") {
+			if team != "" {
+				summary.teamCounts[team]++
+			}
+		}
+		if seen[user.UserID] {
+			continue
+		}
+		seen[user.UserID] = true
+		summary.users++
+		if user.IsBotAccount {
+			summary.bots++
+		}
+		if !user.DeactivatedAt.IsZero() {
+			summary.deactivated++
+		}
+		for i, band := range activityBands {
+			if band.Days < 0 || user.DaysSinceLastActivity <= band.Days {
+				summary.bands[i]++
+				break
+			}
+		}
+	}
+	return summary
+}
+
+// fields returns the summary as label/value pairs, with the deactivated accounts counted only if they were exported,
+// followed by any tags
+func (summary userSummary) fields(output OutputOptions) [][2]string {
+	fields := [][2]string{
+		{"Users", strconv.Itoa(summary.users)},
+		{"Bot accounts", strconv.Itoa(summary.bots)},
+	}
+	if output.Deactivated {
+		fields = append(fields, [2]string{"Deactivated accounts", strconv.Itoa(summary.deactivated)})
+	}
+	if len(summary.teamCounts) > 0 {
+		fields = append(fields, [2]string{"Teams", strconv.Itoa(len(summary.teamCounts))})
+	}
+	for _, key := range tagKeys(output.Tags) {
+		fields = append(fields, [2]string{"Tag: " + key, output.Tags[key]})
+	}
+	return fields
+}
+
+// severalTeams reports whether the users are from more than one team, in which case the reports chart the teams and
+// show each user's team
+func (summary userSummary) severalTeams() bool {
+	return len(summary.teamCounts) > 1
+}
+
+// largestTeams returns the teams with the most users, largest first, with their counts and a heading for the chart
+func (summary userSummary) largestTeams() (string, []string, []int) {
+	teams := make([]string, 0, len(summary.teamCounts))
+	for team := range summary.teamCounts {
+		teams = append(teams, team)
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if summary.teamCounts[teams[i]] != summary.teamCounts[teams[j]] {
+			return summary.teamCounts[teams[i]] > summary.teamCounts[teams[j]]
+		}
+		return teams[i] < teams[j]
+	})
+	heading := "Users by Team"
+	if len(teams) > pdfChartTeams {
+		heading = fmt.Sprintf("Users by Team (largest %d)", pdfChartTeams)
+		teams = teams[:pdfChartTeams]
+	}
+	counts := make([]int, len(teams))
+	for i, team := range teams {
+		counts[i] = summary.teamCounts[team]
+	}
+	return heading, teams, counts
+}
+
+// pdfDocument wraps an fpdf document with the headings and tables used by the PDF reports.  The core fonts only
+// cover Latin-1, so text is translated from UTF-8 as it is written.
+type pdfDocument struct {
+	pdf       *fpdf.Fpdf
+	translate func(string) string
+}
+
+// newPDFDocument starts a portrait A4 document with a title on its first page.  With branding, each page carries the
+// organization's logo and title at the top and its footer text at the bottom.
+func newPDFDocument(title string, subtitle string, branding *Branding) *pdfDocument {
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("mm-user-list", true)
+
+	doc := &pdfDocument{pdf: pdf, translate: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	if branding != nil && (branding.Title != "" || branding.hasLogo()) {
+		if branding.hasLogo() {
+			pdf.RegisterImageOptionsReader("logo", fpdf.ImageOptions{ImageType: branding.logoExtension[1:]}, bytes.NewReader(branding.logo))
+		}
+		pdf.SetHeaderFunc(func() {
+			if branding.hasLogo() {
+				pdf.ImageOptions("logo", pdfMargin, pdfMargin, 0, pdfLogoHeight, false, fpdf.ImageOptions{}, 0, "")
+			}
+			if branding.Title != "" {
+				pdf.SetFont("Helvetica", "B", 10)
+				pdf.SetTextColor(90, 90, 90)
+				pdf.SetXY(pdfMargin, pdfMargin)
+				pdf.CellFormat(0, pdfLogoHeight, doc.translate(branding.Title), "", 0, "R", false, 0, "")
+				pdf.SetTextColor(0, 0, 0)
+			}
+			pdf.SetY(pdfMargin + pdfLogoHeight + pdfBrandingGap)
+		})
+	}
+
+	footer := ""
+	if branding != nil {
+		footer = branding.Footer
+	}
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin)
+		pdf.SetFont("Helvetica", "I", 8)
+		if footer != "" {
+			pdf.CellFormat(0, pdfLineHeight, doc.translate(footer), "", 0, "L", false, 0, "")
+			pdf.SetX(pdfMargin)
+			pdf.CellFormat(0, pdfLineHeight, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "R", false, 0, "")
+			return
+		}
+		pdf.CellFormat(0, pdfLineHeight, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, doc.translate(title), "", 1, "L", false, 0, "")
+	if subtitle != "" {
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(0, pdfLineHeight, doc.translate(subtitle), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	return doc
+}
+
+// heading starts a new section
+func (doc *pdfDocument) heading(text string) {
+	doc.pdf.Ln(2)
+	doc.pdf.SetFont("Helvetica", "B", 12)
+	doc.pdf.CellFormat(0, 8, doc.translate(text), "B", 1, "L", false, 0, "")
+	doc.pdf.Ln(1)
+}
+
+// note writes a line of plain text
+func (doc *pdfDocument) note(text string) {
+	doc.pdf.SetFont("Helvetica", "", 9)
+	doc.pdf.MultiCell(0, pdfLineHeight-1, doc.translate(text), "", "L", false)
+}
+
+// fields writes label/value pairs, one per line
+func (doc *pdfDocument) fields(pairs [][2]string) {
+	for _, pair := range pairs {
+		doc.pdf.SetFont("Helvetica", "B", 9)
+		doc.pdf.CellFormat(45, pdfLineHeight, doc.translate(pair[0]), "", 0, "L", false, 0, "")
+		doc.pdf.SetFont("Helvetica", "", 9)
+		doc.pdf.CellFormat(0, pdfLineHeight, doc.translate(pair[1]), "", 1, "L", false, 0, "")
+	}
+}
+
+// table writes rows under a header, with the column widths scaled to fill the page.  The header is repeated at the
+// top of each new page.  Cells too long for their column are truncated.
+func (doc *pdfDocument) table(header []string, widths []float64, rows [][]string) {
+
+	if len(rows) == 0 {
+		doc.note("None")
+		return
+	}
+
+	total := 0.0
+	for _, width := range widths {
+		total += width
+	}
+	scale := (pdfPageWidth - 2*pdfMargin) / total
+	scaled := make([]float64, len(widths))
+	for i, width := range widths {
+		scaled[i] = width * scale
+	}
+
+	writeHeader := func() {
+		doc.pdf.SetFont("Helvetica", "B", 8)
+		doc.pdf.SetFillColor(230, 230, 230)
+		for i, text := range header {
+			doc.pdf.CellFormat(scaled[i], pdfLineHeight, doc.translate(text), "1", 0, "L", true, 0, "")
+		}
+		doc.pdf.Ln(-1)
+		doc.pdf.SetFont("Helvetica", "", 8)
+	}
+
+	writeHeader()
+	_, pageHeight := doc.pdf.GetPageSize()
+	for _, row := range rows {
+		if doc.pdf.GetY()+pdfLineHeight > pageHeight-pdfMargin-pdfLineHeight {
+			doc.pdf.AddPage()
+			writeHeader()
+		}
+		for i, text := range row {
+			doc.pdf.CellFormat(scaled[i], pdfLineHeight, doc.fit(doc.translate(text), scaled[i]), "1", 0, "L", false, 0, "")
+		}
+		doc.pdf.Ln(-1)
+	}
+}
+
+// barChart draws a horizontal bar chart, one labelled bar per count, scaled to the largest count
+func (doc *pdfDocument) barChart(labels []string, counts []int) {
+
+	largest := 0
+	for _, count := range counts {
+		largest = max(largest, count)
+	}
+	barArea := pdfPageWidth - 2*pdfMargin - pdfChartLabel - pdfChartCount
+
+	doc.pdf.SetFont("Helvetica", "", 9)
+	doc.pdf.SetFillColor(79, 129, 189)
+	for i, label := range labels {
+		doc.pdf.CellFormat(pdfChartLabel, pdfLineHeight, doc.fit(doc.translate(label), pdfChartLabel), "", 0, "L", false, 0, "")
+		x, y := doc.pdf.GetXY()
+		width := 0.0
+		if largest > 0 {
+			width = barArea * float64(counts[i]) / float64(largest)
+		}
+		if width > 0 {
+			doc.pdf.Rect(x, y+1, width, pdfLineHeight-2, "F")
+		}
+		doc.pdf.SetX(x + width + 2)
+		doc.pdf.CellFormat(pdfChartCount, pdfLineHeight, strconv.Itoa(counts[i]), "", 1, "L", false, 0, "")
+	}
+}
+
+// fit shortens text to fit a column, marking the cut with an ellipsis
+func (doc *pdfDocument) fit(text string, width float64) string {
+	limit := width - 2
+	if doc.pdf.GetStringWidth(text) <= limit {
+		return text
+	}
+	for len(text) > 0 && doc.pdf.GetStringWidth(text+"...") > limit {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+// bytes renders the finished document
+func (doc *pdfDocument) bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := doc.pdf.Output(&buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// renderOffboardPDF lays out an offboarding packet as a PDF document
+func renderOffboardPDF(packet *OffboardPacket, output OutputOptions) ([]byte, error) {
+
+	user := packet.User
+	doc := newPDFDocument("Offboarding packet: "+user.Username, "Generated "+packet.GeneratedAt.In(output.Dates.location()).Format("2006-01-02 15:04 MST")+" by mm-user-list", output.Branding)
+
+	deactivated := "No"
+	if user.DeactivatedAt != "" {
+		deactivated = user.DeactivatedAt
+	}
+
+	doc.heading("Profile")
+	doc.fields([][2]string{
+		{"User ID", user.UserID},
+		{"Username", user.Username},
+		{"Email", user.Email},
+		{"Name", user.FirstName + " " + user.LastName},
+		{"Nickname", user.Nickname},
+		{"Position", user.Position},
+		{"Roles", user.Roles},
+		{"Auth Service", user.AuthService},
+		{"Created", user.CreatedAt},
+		{"Last Activity", user.LastActivityAt},
+		{"Deactivated", deactivated},
+	})
+
+	doc.heading(fmt.Sprintf("Teams (%d)", len(packet.Teams)))
+	var rows [][]string
+	for _, team := range packet.Teams {
+		rows = append(rows, []string{team.Name, team.DisplayName, team.Role})
+	}
+	doc.table([]string{"Team", "Display Name", "Role"}, []float64{2, 3, 1}, rows)
+
+	doc.heading(fmt.Sprintf("Channels (%d)", len(packet.Channels)))
+	rows = nil
+	for _, channel := range packet.Channels {
+		rows = append(rows, []string{channel.Team, channel.DisplayName, channel.Type, channel.Role, channel.LastViewedAt})
+	}
+	doc.table([]string{"Team", "Channel", "Type", "Role", "Last Viewed"}, []float64{2, 4, 2, 1, 2.5}, rows)
+
+	doc.heading(fmt.Sprintf("Owned Bots (%d)", len(packet.OwnedBots)))
+	rows = nil
+	for _, bot := range packet.OwnedBots {
+		status := "Active"
+		if bot.Deactivated {
+			status = "Deactivated"
+		}
+		rows = append(rows, []string{bot.Username, bot.DisplayName, status})
+	}
+	doc.table([]string{"Bot", "Display Name", "Status"}, []float64{2, 3, 1}, rows)
+
+	doc.heading(fmt.Sprintf("Sessions (%d)", len(packet.Sessions)))
+	rows = nil
+	for _, session := range packet.Sessions {
+		rows = append(rows, []string{session.Client, session.Platform, session.CreatedAt, session.LastActivityAt, session.ExpiresAt})
+	}
+	doc.table([]string{"Client", "Platform", "Created", "Last Activity", "Expires"}, []float64{2.5, 1.5, 2, 2, 2}, rows)
+
+	for _, note := range packet.Notes {
+		doc.note(note)
+	}
+
+	return doc.bytes()
+}
+
+// WriteUsersToPDF writes the users as a PDF report: summary statistics, charts of the users by days since their last
+// activity and by team, and then the user list as a table.  The table has a fixed set of columns, since a page has
+// no room for the optional ones.
+func WriteUsersToPDF(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as PDF")
+
+	doc := newPDFDocument("Mattermost user report", "Generated "+time.Now().In(output.Dates.location()).Format("2006-01-02 15:04 MST")+" by mm-user-list", output.Branding)
+
+	summary := summarizeUsers(users)
+
+	doc.heading("Summary")
+	doc.fields(summary.fields(output))
+
+	doc.heading("Days Since Last Activity")
+	labels := make([]string, len(activityBands))
+	for i, band := range activityBands {
+		labels[i] = band.Label
+	}
+	doc.barChart(labels, summary.bands)
+
+	// Only the largest teams are charted, to keep the chart readable
+	if summary.severalTeams() {
+		heading, teams, counts := summary.largestTeams()
+		doc.heading(heading)
+		doc.barChart(teams, counts)
+	}
+
+	doc.heading(fmt.Sprintf("Users (%d)", len(users)))
+	header := []string{"Username", "Email", "Name", "Created", "Last Activity", "Days Inactive"}
+	widths := []float64{2.5, 4, 3, 1.8, 1.8, 1.4}
+	if summary.severalTeams() {
+		header = append(header, "Team Name")
+		widths = append(widths, 2.5)
+	}
+	var rows [][]string
+	for _, user := range users {
+		row := []string{
+			user.Username,
+			user.Email,
+			strings.TrimSpace(user.FirstName + " " + user.LastName),
+			output.Dates.Format(user.UserCreatedAt),
+			output.Dates.Format(user.LastActivityAt),
+			strconv.Itoa(user.DaysSinceLastActivity),
+		}
+		if summary.severalTeams() {
+			row = append(row, user.TeamName)
+		}
+		rows = append(rows, row)
+	}
+	doc.table(header, widths, rows)
+
+	data, err := doc.bytes()
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to render PDF output: "+err.Error())
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
@@ -0,0 +1,229 @@
+package mmuserlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Name policy rules, as used in a rules file and reported against each violation
+const (
+	ruleEmptyFullName     = "empty-full-name"
+	ruleEmojiOnlyNickname = "emoji-only-nickname"
+	ruleReservedWord      = "reserved-word"
+	rulePattern           = "pattern"
+)
+
+// defaultReservedWords are the words most often used to impersonate administrators or support staff
+var defaultReservedWords = []string{"admin", "administrator", "root", "support", "helpdesk", "security", "system"}
+
+// NamePolicy describes the rules that user names and nicknames are checked against
+type NamePolicy struct {
+	RequireFullName     bool
+	NoEmojiOnlyNickname bool
+	ReservedWords       []string
+	Patterns            []*regexp.Regexp
+}
+
+// NameViolation records a single rule broken by a user
+type NameViolation struct {
+	User   *User
+	Rule   string
+	Detail string
+}
+
+// DefaultNamePolicy returns the policy used when no rules file is supplied: every built-in rule, with the default
+// reserved words
+func DefaultNamePolicy() *NamePolicy {
+	return &NamePolicy{
+		RequireFullName:     true,
+		NoEmojiOnlyNickname: true,
+		ReservedWords:       defaultReservedWords,
+	}
+}
+
+// LoadNamePolicy reads a rules file.  Each line holds one rule, optionally followed by its argument:
+//
+//	empty-full-name
+//	emoji-only-nickname
+//	reserved-word <word>
+//	pattern <regular expression>
+//
+// Blank lines and lines starting with '#' are ignored.  Only the listed rules are applied.
+func LoadNamePolicy(filePath string) (*NamePolicy, error) {
+
+	DebugPrint("Loading name policy from: " + filePath)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to open name policy file: "+filePath+" - "+err.Error())
+		return nil, err
+	}
+	defer file.Close()
+
+	policy := &NamePolicy{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, argument, _ := strings.Cut(line, " ")
+		argument = strings.TrimSpace(argument)
+
+		switch rule {
+		case ruleEmptyFullName:
+			policy.RequireFullName = true
+		case ruleEmojiOnlyNickname:
+			policy.NoEmojiOnlyNickname = true
+		case ruleReservedWord:
+			if argument == "" {
+				return nil, fmt.Errorf("%s line %d: '%s' needs a word", filePath, lineNumber, rule)
+			}
+			policy.ReservedWords = append(policy.ReservedWords, strings.ToLower(argument))
+		case rulePattern:
+			pattern, err := regexp.Compile(argument)
+			if err != nil || argument == "" {
+				return nil, fmt.Errorf("%s line %d: invalid pattern '%s'", filePath, lineNumber, argument)
+			}
+			policy.Patterns = append(policy.Patterns, pattern)
+		default:
+			return nil, fmt.Errorf("%s line %d: unknown rule '%s'", filePath, lineNumber, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		LogMessage(ErrorLevel, "Failed to read name policy file: "+filePath+" - "+err.Error())
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// isEmojiOnly reports whether a value is made up entirely of symbols (and the joiners, modifiers and spaces used
+// to build emoji sequences), with no letters or digits
+func isEmojiOnly(value string) bool {
+	symbols := 0
+	for _, r := range value {
+		switch {
+		case unicode.Is(unicode.So, r):
+			symbols++
+		case unicode.Is(unicode.Sk, r), unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r), unicode.IsSpace(r), r == '\u200d':
+		default:
+			return false
+		}
+	}
+	return symbols > 0
+}
+
+// containsWord reports whether the word appears in the value, case-insensitively, without letters either side of it
+// (so 'admin' matches 'it-admin' and 'admin2', but not 'badminton')
+func containsWord(value string, word string) bool {
+	value = strings.ToLower(value)
+	for start := 0; ; {
+		index := strings.Index(value[start:], word)
+		if index < 0 {
+			return false
+		}
+		index += start
+		end := index + len(word)
+		previous, _ := utf8.DecodeLastRuneInString(value[:index])
+		next, _ := utf8.DecodeRuneInString(value[end:])
+		before := index == 0 || !unicode.IsLetter(previous)
+		after := end == len(value) || !unicode.IsLetter(next)
+		if before && after {
+			return true
+		}
+		start = index + 1
+	}
+}
+
+// Check returns every rule broken by each of the users
+func (policy *NamePolicy) Check(users []*User) []NameViolation {
+
+	var violations []NameViolation
+
+	for _, user := range users {
+		fullName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+
+		if policy.RequireFullName && fullName == "" {
+			violations = append(violations, NameViolation{User: user, Rule: ruleEmptyFullName, Detail: "First and last name are both empty"})
+		}
+		if policy.NoEmojiOnlyNickname && isEmojiOnly(user.Nickname) {
+			violations = append(violations, NameViolation{User: user, Rule: ruleEmojiOnlyNickname, Detail: "Nickname '" + user.Nickname + "' has no letters or digits"})
+		}
+
+		names := map[string]string{"username": user.Username, "nickname": user.Nickname, "full name": fullName}
+		for _, field := range []string{"username", "nickname", "full name"} {
+			for _, word := range policy.ReservedWords {
+				if containsWord(names[field], word) {
+					violations = append(violations, NameViolation{User: user, Rule: ruleReservedWord, Detail: fmt.Sprintf("The %s contains '%s'", field, word)})
+				}
+			}
+			for _, pattern := range policy.Patterns {
+				if names[field] != "" && pattern.MatchString(names[field]) {
+					violations = append(violations, NameViolation{User: user, Rule: rulePattern, Detail: fmt.Sprintf("The %s matches '%s'", field, pattern.String())})
+				}
+			}
+		}
+	}
+
+	return violations
+}
+
+// WriteNameViolations writes the remediation list, with one row per rule broken, in the requested format
+func WriteNameViolations(violations []NameViolation, filePath string, output OutputOptions) error {
+
+	DebugPrint("Writing name policy violations to: " + filePath)
+
+	file, err := CreateOutput(filePath, output.Compress)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to create file: "+filePath+" - "+err.Error())
+		return err
+	}
+	defer file.Close()
+
+	if output.Format == FormatJSON {
+		type jsonViolation struct {
+			UserID    string `json:"user_id"`
+			Username  string `json:"username"`
+			Email     string `json:"email"`
+			FirstName string `json:"first_name"`
+			LastName  string `json:"last_name"`
+			Nickname  string `json:"nickname"`
+			Rule      string `json:"rule"`
+			Detail    string `json:"detail"`
+		}
+		records := make([]jsonViolation, 0, len(violations))
+		for _, violation := range violations {
+			user := violation.User
+			records = append(records, jsonViolation{user.UserID, user.Username, user.Email, user.FirstName, user.LastName, user.Nickname, violation.Rule, violation.Detail})
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return file.Commit()
+	}
+
+	writer := newCSVWriter(file, output.CSV)
+	writer.Write([]string{"Username", "Email", "First Name", "Last Name", "Nickname", "Rule", "Detail"})
+	for _, violation := range violations {
+		user := violation.User
+		writer.Write([]string{user.Username, user.Email, user.FirstName, user.LastName, user.Nickname, violation.Rule, violation.Detail})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
@@ -0,0 +1,309 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ErrPostStatsUnavailable is returned when the server can't report post statistics: the user reporting API they come
+// from is missing (before Mattermost 9.5) or the token isn't permitted to use it
+var ErrPostStatsUnavailable = errors.New("post statistics need the user reporting API of Mattermost 9.5 or later, and a token permitted to read the System Console user list")
+
+// PostPeriods are the periods the server's post statistics cover, for ApplyPostCounts.  A start date, in
+// PostDateLayout, can be given instead, but those posts are counted by searching.
+var PostPeriods = []string{
+	model.ReportDurationAllTime,
+	model.ReportDurationLast30Days,
+	model.ReportDurationPreviousMonth,
+	model.ReportDurationLast6Months,
+}
+
+// PostDateLayout is the layout of a start date for ApplyPostCounts
+const PostDateLayout = "2006-01-02"
+
+// postSearchPageSize is the number of posts requested per page when searching for a user's posts
+const postSearchPageSize = 100
+
+// ParsePostPeriod checks a period for ApplyPostCounts: one of the PostPeriods, which can also be written with hyphens
+// (e.g. last-30-days), or a start date such as 2024-01-01
+func ParsePostPeriod(value string) (string, error) {
+	period := strings.ReplaceAll(strings.ToLower(value), "-", "_")
+	if slices.Contains(PostPeriods, period) {
+		return period, nil
+	}
+	if _, err := time.Parse(PostDateLayout, value); err == nil {
+		return value, nil
+	}
+	return "", fmt.Errorf("'%s' is neither a date such as 2024-01-01 nor one of '%s'", value, strings.Join(PostPeriods, "', '"))
+}
+
+// postPeriodStart returns the start date of a period, if it is one rather than one of the PostPeriods
+func postPeriodStart(period string) (time.Time, bool) {
+	since, err := time.Parse(PostDateLayout, period)
+	return since, err == nil
+}
+
+// GetPostStats returns the post statistics of every user over the date range (one of the model.ReportDuration
+// values), keyed by user ID.  They come from the user reporting API, a page of 100 users at a time, which is far
+// cheaper than searching each user's posts, and which (unlike a search) covers every channel.  Users who haven't
+// posted in the range have no statistics.
+func GetPostStats(mmClient *model.Client4, dateRange string) (map[string]model.UserPostStats, error) {
+
+	DebugPrint("Retrieving post statistics for " + dateRange)
+
+	ctx := context.Background()
+	options := &model.UserReportOptions{
+		ReportingBaseOptions: model.ReportingBaseOptions{
+			Direction:  "next",
+			PageSize:   model.ReportingMaxPageSize,
+			SortColumn: "Username",
+			DateRange:  dateRange,
+		},
+	}
+
+	stats := make(map[string]model.UserPostStats)
+	for {
+		reports, response, err := mmClient.GetUsersForReporting(ctx, options)
+
+		if err != nil {
+			if response != nil && CursorAPIUnsupported(response.StatusCode) {
+				DebugPrint(fmt.Sprintf("GetUsersForReporting() returned HTTP %d", response.StatusCode))
+				return nil, ErrPostStatsUnavailable
+			}
+			LogMessage(ErrorLevel, "Error returned from GetUsersForReporting(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetUsersForReporting()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for _, report := range reports {
+			stats[report.Id] = report.UserPostStats
+		}
+
+		if len(reports) < options.PageSize {
+			break
+		}
+
+		last := reports[len(reports)-1]
+		options.FromColumnValue = last.Username
+		options.FromId = last.Id
+	}
+
+	return stats, nil
+}
+
+// setLastPost records a user's last post time and the number of days since then.  Users who have never posted have
+// no number of days, rather than one counted from some other date, so they can't be mistaken for users who posted then.
+func setLastPost(user *User, lastPost time.Time) {
+	user.LastPostAt = lastPost
+	user.DaysSinceLastPost = nil
+	if !lastPost.IsZero() {
+		days := int(time.Since(lastPost).Hours() / 24)
+		user.DaysSinceLastPost = &days
+	}
+}
+
+// applyLastPosts populates each user's last post from the post statistics
+func applyLastPosts(users []*User, stats map[string]model.UserPostStats) {
+	for _, user := range users {
+		var lastPost time.Time
+		if posted := stats[user.UserID].LastPostDate; posted != nil && *posted > 0 {
+			lastPost = time.UnixMilli(*posted)
+		}
+		setLastPost(user, lastPost)
+	}
+}
+
+// ApplyLastPost populates the time of each user's most recent post, in any channel, and the number of days since.  The
+// server gathers the statistics once a day, so a post made since then may not be counted yet.
+func ApplyLastPost(mmClient *model.Client4, users []*User) error {
+
+	stats, err := GetPostStats(mmClient, model.ReportDurationAllTime)
+	if err != nil {
+		return err
+	}
+
+	applyLastPosts(users, stats)
+	return nil
+}
+
+// ApplyPostCounts populates the number of posts each user made over the period, one of the PostPeriods or a start
+// date.  As for ApplyLastPost, posts made since the server last gathered its statistics may not be counted yet.  For
+// a start date, the posts are counted by ApplyPostCountsSince instead.
+func ApplyPostCounts(mmClient *model.Client4, users []*User, period string) error {
+
+	if since, isDate := postPeriodStart(period); isDate {
+		return ApplyPostCountsSince(mmClient, users, since)
+	}
+
+	stats, err := GetPostStats(mmClient, period)
+	if err != nil {
+		return err
+	}
+
+	applyPostCounts(users, stats)
+	return nil
+}
+
+// applyPostCounts populates each user's post count from the post statistics
+func applyPostCounts(users []*User, stats map[string]model.UserPostStats) {
+	for _, user := range users {
+		user.TotalPosts = 0
+		if total := stats[user.UserID].TotalPosts; total != nil {
+			user.TotalPosts = *total
+		}
+	}
+}
+
+// ApplyPostCountsSince populates the number of posts each user has made since the start of the day (in UTC), by
+// searching each of the teams they belong to for their posts, as 'from:' and 'after:' would in Mattermost.  Unlike the
+// post statistics this takes any date and includes today's posts, but a search only finds posts in channels the
+// exporting account is a member of, and it takes a request per team and page of posts for every user.
+func ApplyPostCountsSince(mmClient *model.Client4, users []*User, since time.Time) error {
+
+	DebugPrint(fmt.Sprintf("Counting posts since %s for %d users", since.Format(PostDateLayout), len(users)))
+
+	ctx := context.Background()
+	usernames := make(map[string]string)
+	for _, user := range users {
+		usernames[user.UserID] = user.Username
+	}
+
+	counts := make(map[string]int)
+	for _, userID := range uniqueUserIDs(users) {
+		teams, response, err := mmClient.GetTeamsForUser(ctx, userID, "")
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetTeamsForUser(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetTeamsForUser()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		// Direct and group messages are found by a search in any team, so posts are counted once by their ID
+		posts := make(map[string]bool)
+		for _, team := range teams {
+			if team.DeleteAt != 0 {
+				continue
+			}
+			if err := searchUserPosts(mmClient, team.Id, userID, usernames[userID], since, posts); err != nil {
+				return err
+			}
+		}
+		counts[userID] = len(posts)
+	}
+
+	for _, user := range users {
+		user.TotalPosts = counts[user.UserID]
+	}
+
+	return nil
+}
+
+// searchUserPosts adds the IDs of the posts a user has made in a team since a time, a page at a time, to posts
+func searchUserPosts(mmClient *model.Client4, teamID string, userID string, username string, since time.Time, posts map[string]bool) error {
+
+	// after: excludes the day given, so the search starts from the day before
+	terms := fmt.Sprintf("from:%s after:%s", username, since.AddDate(0, 0, -1).Format(PostDateLayout))
+	isOrSearch, includeDeleted, offset, perPage := false, true, 0, postSearchPageSize
+	params := &model.SearchParameter{
+		Terms:                  &terms,
+		IsOrSearch:             &isOrSearch,
+		TimeZoneOffset:         &offset,
+		PerPage:                &perPage,
+		IncludeDeletedChannels: &includeDeleted,
+	}
+
+	ctx := context.Background()
+	for page := 0; ; page++ {
+		params.Page = &page
+		list, response, err := mmClient.SearchPostsWithParams(ctx, teamID, params)
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from SearchPostsWithParams(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from SearchPostsWithParams()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for _, postID := range list.Order {
+			if post := list.Posts[postID]; post != nil && post.UserId == userID && post.CreateAt >= since.UnixMilli() {
+				posts[postID] = true
+			}
+		}
+
+		if len(list.Order) < perPage {
+			return nil
+		}
+	}
+}
+
+// cachedPostStats returns a function that fetches the post statistics over the period the first time it is called,
+// and then returns the same statistics, so that a streamed export fetches them once rather than for every page
+func cachedPostStats(period string) func(mmClient *model.Client4) (map[string]model.UserPostStats, error) {
+	var stats map[string]model.UserPostStats
+	return func(mmClient *model.Client4) (map[string]model.UserPostStats, error) {
+		if stats == nil {
+			var err error
+			if stats, err = GetPostStats(mmClient, period); err != nil {
+				return nil, err
+			}
+		}
+		return stats, nil
+	}
+}
+
+// LastPostEnrichment returns the enrichment that adds each user's last post.  The statistics are fetched once, however
+// many times it is applied.
+func LastPostEnrichment() Enrichment {
+	postStats := cachedPostStats(model.ReportDurationAllTime)
+	return Enrichment{
+		Name: "last post dates",
+		Apply: func(mmClient *model.Client4, users []*User) error {
+			stats, err := postStats(mmClient)
+			if err != nil {
+				return err
+			}
+			applyLastPosts(users, stats)
+			return nil
+		},
+	}
+}
+
+// PostCountEnrichment returns the enrichment that adds the number of posts each user made over the period.  The
+// statistics are fetched once, however many times it is applied; posts since a start date are searched for each page
+// of users instead.
+func PostCountEnrichment(period string) Enrichment {
+	if since, isDate := postPeriodStart(period); isDate {
+		return Enrichment{
+			Name: "post counts",
+			Apply: func(mmClient *model.Client4, users []*User) error {
+				return ApplyPostCountsSince(mmClient, users, since)
+			},
+		}
+	}
+	postStats := cachedPostStats(period)
+	return Enrichment{
+		Name: "post counts",
+		Apply: func(mmClient *model.Client4, users []*User) error {
+			stats, err := postStats(mmClient)
+			if err != nil {
+				return err
+			}
+			applyPostCounts(users, stats)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package mmuserlist
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Limits on waiting out the server's rate limiting.  A request that is still rate limited after the retries have
+// been used up fails as it would have done without them.
+const (
+	maxRateLimitRetries  = 5
+	defaultRateLimitWait = 1 * time.Second
+	maxRateLimitWait     = 60 * time.Second
+)
+
+// rateLimitCount is the number of rate limited responses seen, which adaptive concurrency watches for server pressure
+var rateLimitCount atomic.Int64
+
+// rateLimitTransport wraps an HTTP transport so that a request rejected with 429 Too Many Requests is retried once
+// the server says the limit has reset, rather than failing the export part way through
+type rateLimitTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	for attempt := 1; ; attempt++ {
+		response, err := t.base.RoundTrip(req)
+		if err != nil || response.StatusCode != http.StatusTooManyRequests || attempt > maxRateLimitRetries {
+			return response, err
+		}
+
+		// A request body can only be replayed if it can be recreated
+		if req.Body != nil && req.GetBody == nil {
+			return response, nil
+		}
+
+		rateLimitCount.Add(1)
+		wait := rateLimitWait(response.Header, time.Now())
+		response.Body.Close()
+		LogMessage(WarningLevel, fmt.Sprintf("Mattermost is rate limiting requests - waiting %s before retrying (attempt %d of %d)", wait, attempt, maxRateLimitRetries))
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retry.Body = body
+		}
+		req = retry
+	}
+}
+
+// rateLimitWait works out how long to wait from the headers of a 429 response.  Retry-After holds either a number
+// of seconds or an HTTP date; Mattermost's X-RateLimit-Reset holds the number of seconds until the limit resets.
+func rateLimitWait(header http.Header, now time.Time) time.Duration {
+
+	wait := defaultRateLimitWait
+
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(value); err == nil {
+			wait = date.Sub(now)
+		}
+	} else if value := strings.TrimSpace(header.Get("X-RateLimit-Reset")); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if wait <= 0 {
+		wait = defaultRateLimitWait
+	}
+	if wait > maxRateLimitWait {
+		wait = maxRateLimitWait
+	}
+	return wait
+}
+
+// enableRateLimitRetry installs the rate limit transport on the client's HTTP client
+func enableRateLimitRetry(mmClient *model.Client4) {
+	base := mmClient.HTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	mmClient.HTTPClient.Transport = &rateLimitTransport{
+		base: base,
+	}
+}
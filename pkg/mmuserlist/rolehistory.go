@@ -0,0 +1,127 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// AuditPageSize is the number of audit log entries requested in a single API call
+const AuditPageSize = 200
+
+// roleChangeAction matches the audit log action recorded when a user's system roles are updated
+var roleChangeAction = regexp.MustCompile(`/users/([a-z0-9]{26})/roles$`)
+
+// roleChangeInfo matches the new roles in the details recorded with a role change, e.g. "roles=system_user system_admin"
+var roleChangeInfo = regexp.MustCompile(`roles=(.*)$`)
+
+// ErrAuditLogUnavailable is returned by ApplyRoleHistory when the server doesn't provide the audit log API
+var ErrAuditLogUnavailable = errors.New("the audit log API is not available on this server, or the token lacks the permission to read it")
+
+// roleChange is the most recent change to a user's system roles found in the audit log
+type roleChange struct {
+	At    time.Time
+	By    string
+	Roles string
+}
+
+// ApplyRoleHistory populates when each user's system roles last changed, who changed them and the roles they were
+// given, from the server's audit log.  The log is read newest first, and reading stops once every user has been
+// found.  Users whose roles haven't changed since the oldest entry kept in the log are left blank.
+func ApplyRoleHistory(mmClient *model.Client4, users []*User) error {
+
+	DebugPrint(fmt.Sprintf("Retrieving role history for %d users", len(users)))
+
+	ctx := context.Background()
+	wanted := make(map[string]bool)
+	for _, id := range uniqueUserIDs(users) {
+		wanted[id] = true
+	}
+	changes := make(map[string]*roleChange)
+
+	for page := 0; len(changes) < len(wanted); page++ {
+		audits, response, err := mmClient.GetAudits(ctx, page, AuditPageSize, "")
+
+		if response != nil && (response.StatusCode == 403 || response.StatusCode == 404 || response.StatusCode == 501) {
+			LogMessage(ErrorLevel, "The audit log couldn't be read - it needs the audit log API and a token with the 'manage_system' permission")
+			return ErrAuditLogUnavailable
+		}
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetAudits(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, fmt.Sprintf("Bad HTTP response returned from GetAudits() (page %d)", page))
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for _, audit := range audits {
+			userID, roles, found := parseRoleChange(audit)
+			if !found || !wanted[userID] || changes[userID] != nil {
+				continue
+			}
+			changes[userID] = &roleChange{At: time.UnixMilli(audit.CreateAt), By: audit.UserId, Roles: roles}
+		}
+
+		if len(audits) < AuditPageSize {
+			break
+		}
+	}
+
+	DebugPrint(fmt.Sprintf("Found role changes for %d users", len(changes)))
+
+	// Changes are attributed to the username of whoever made them, where the account still exists
+	actorIDs := make(map[string]bool)
+	for _, change := range changes {
+		if change.By != "" {
+			actorIDs[change.By] = true
+		}
+	}
+	ids := make([]string, 0, len(actorIDs))
+	for id := range actorIDs {
+		ids = append(ids, id)
+	}
+	actors, err := getUsersByIDs(mmClient, ids)
+	if err != nil {
+		return err
+	}
+	usernames := make(map[string]string, len(actors))
+	for _, actor := range actors {
+		usernames[actor.Id] = actor.Username
+	}
+
+	for _, user := range users {
+		change, found := changes[user.UserID]
+		if !found {
+			continue
+		}
+		user.RolesChangedAt = change.At
+		user.RolesChangedBy = change.By
+		if username, found := usernames[change.By]; found {
+			user.RolesChangedBy = username
+		}
+		user.RolesChangedTo = change.Roles
+	}
+
+	return nil
+}
+
+// parseRoleChange returns the user whose roles an audit log entry changed, and the roles they were given
+func parseRoleChange(audit model.Audit) (userID string, roles string, found bool) {
+
+	match := roleChangeAction.FindStringSubmatch(audit.Action)
+	if match == nil {
+		return "", "", false
+	}
+
+	if info := roleChangeInfo.FindStringSubmatch(audit.ExtraInfo); info != nil {
+		roles = strings.TrimSpace(info[1])
+	}
+
+	return match[1], roles, true
+}
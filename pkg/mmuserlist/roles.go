@@ -0,0 +1,88 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// teamMemberRoles returns the roles a team membership grants, including those granted by the team's scheme
+func teamMemberRoles(member *model.TeamMember) []string {
+	roles := strings.Fields(member.Roles)
+	if member.SchemeGuest {
+		roles = append(roles, model.TeamGuestRoleId)
+	}
+	if member.SchemeUser {
+		roles = append(roles, model.TeamUserRoleId)
+	}
+	if member.SchemeAdmin {
+		roles = append(roles, model.TeamAdminRoleId)
+	}
+	return roles
+}
+
+// ApplyTeamRoles adds each user's team roles (e.g. team_admin) to the system roles taken from their user record.  A
+// role held in several teams is listed once, after the system roles.
+func ApplyTeamRoles(mmClient *model.Client4, users []*User) error {
+
+	DebugPrint(fmt.Sprintf("Retrieving team roles for %d users", len(users)))
+
+	ctx := context.Background()
+	teamRoles := make(map[string][]string)
+
+	for _, userID := range uniqueUserIDs(users) {
+		members, response, err := mmClient.GetTeamMembersForUser(ctx, userID, "")
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetTeamMembersForUser(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetTeamMembersForUser()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		seen := make(map[string]bool)
+		var roles []string
+		for _, member := range members {
+			if member.DeleteAt > 0 {
+				continue
+			}
+			for _, role := range teamMemberRoles(member) {
+				if !seen[role] {
+					seen[role] = true
+					roles = append(roles, role)
+				}
+			}
+		}
+		sort.Strings(roles)
+		teamRoles[userID] = roles
+	}
+
+	for _, user := range users {
+		user.Roles = append(strings.Fields(user.SystemRoles), teamRoles[user.UserID]...)
+	}
+
+	return nil
+}
+
+// FilterByRole keeps only the users who hold at least one of the named system or team roles.  The users' team roles
+// must already have been retrieved with ApplyTeamRoles.
+func FilterByRole(users []*User, roles []string) []*User {
+	wanted := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		wanted[role] = true
+	}
+	return FilterUsers(users, func(user *User) bool {
+		for _, role := range user.Roles {
+			if wanted[strings.ToLower(role)] {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,128 @@
+package mmuserlist
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3 defaults, used when neither the environment nor the AWS config file says otherwise
+const (
+	DefaultS3Region = "us-east-1"
+	s3Scheme        = "s3://"
+)
+
+// S3Location is the bucket and object key an output file is uploaded to
+type S3Location struct {
+	Bucket string
+	Key    string // empty, or ending in '/', to upload under the file's own name
+}
+
+// ParseS3URL parses a destination such as s3://bucket/reports/ or s3://bucket/reports/users.csv
+func ParseS3URL(value string) (S3Location, error) {
+	if !strings.HasPrefix(value, s3Scheme) {
+		return S3Location{}, fmt.Errorf("'%s' is not an S3 URL - it must start with %s", value, s3Scheme)
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(value, s3Scheme), "/")
+	if bucket == "" {
+		return S3Location{}, fmt.Errorf("'%s' doesn't name a bucket", value)
+	}
+	return S3Location{Bucket: bucket, Key: key}, nil
+}
+
+// objectKey returns the key of the object a file is uploaded to
+func (location S3Location) objectKey(filePath string) string {
+	key := location.Key
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += filepath.Base(filePath)
+	}
+	return key
+}
+
+// S3Settings describes the S3 service a file is uploaded to.  Endpoint is the address of an S3-compatible service,
+// such as MinIO, which is addressed with the bucket in the path; when empty, AWS S3 is used.  Config holds the region
+// and credentials, as loaded by LoadS3Settings.
+type S3Settings struct {
+	Endpoint string
+	Config   aws.Config
+}
+
+// LoadS3Settings finds the region and credentials as the AWS tools do, with the SDK's default chain: the environment
+// (AWS_ACCESS_KEY_ID, AWS_REGION and so on), the shared credentials and config files for the AWS_PROFILE profile,
+// including SSO and credential_process profiles, and finally the role of the container or EC2 instance.  The
+// credentials are retrieved straight away, so that missing ones are reported before an export rather than after it.
+func LoadS3Settings(endpoint string) (S3Settings, error) {
+
+	ctx := context.Background()
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return S3Settings{}, fmt.Errorf("failed to load the AWS configuration: %w", err)
+	}
+	if awsConfig.Region == "" {
+		awsConfig.Region = DefaultS3Region
+	}
+
+	credentials, err := awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return S3Settings{}, fmt.Errorf("no AWS credentials were found - set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or choose a profile with AWS_PROFILE: %w", err)
+	}
+
+	RegisterSecret(credentials.SecretAccessKey)
+	RegisterSecret(credentials.SessionToken)
+	DebugPrint("Using AWS credentials from " + credentials.Source + " and the region " + awsConfig.Region)
+	return S3Settings{Endpoint: endpoint, Config: awsConfig}, nil
+}
+
+// client returns an S3 client for the settings.  AWS S3 is addressed with the bucket in the host name, unless the
+// name has dots, which the certificate wouldn't match; an S3-compatible endpoint has the bucket in the path.
+func (settings S3Settings) client(bucket string) *s3.Client {
+	return s3.NewFromConfig(settings.Config, func(options *s3.Options) {
+		options.UsePathStyle = settings.Endpoint != "" || strings.Contains(bucket, ".")
+		if settings.Endpoint != "" {
+			endpoint := settings.Endpoint
+			if !strings.Contains(endpoint, "://") {
+				endpoint = "https://" + endpoint
+			}
+			options.BaseEndpoint = aws.String(strings.TrimSuffix(endpoint, "/"))
+		}
+	})
+}
+
+// UploadToS3 uploads a file to S3, returning the location of the object.  A large file is uploaded in parts, several
+// at a time.
+func UploadToS3(settings S3Settings, location S3Location, filePath string) (string, error) {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	key := location.objectKey(filePath)
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	DebugPrint(fmt.Sprintf("Uploading %s to %s%s/%s", filePath, s3Scheme, location.Bucket, key))
+	uploader := manager.NewUploader(settings.client(location.Bucket))
+	_, err = uploader.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(location.Bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("the upload failed: %w", err)
+	}
+	return s3Scheme + location.Bucket + "/" + key, nil
+}
@@ -0,0 +1,191 @@
+package mmuserlist
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// isolateAWSConfig points the SDK's default chain at the given credentials alone, so that the tests don't pick up the
+// environment, config files or instance role of the machine they run on
+func isolateAWSConfig(t *testing.T, accessKey string, secretKey string) {
+	t.Helper()
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_REGION", "eu-west-2")
+	t.Setenv("AWS_ACCESS_KEY_ID", accessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", secretKey)
+}
+
+// s3Request is a request received by the fake S3 service
+type s3Request struct {
+	method        string
+	path          string
+	authorization string
+	contentType   string
+	body          string
+}
+
+// newFakeS3 starts a server that records the requests made to it and answers them with the given status
+func newFakeS3(t *testing.T, status int) (*httptest.Server, func() []s3Request) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var requests []s3Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, s3Request{
+			method:        r.Method,
+			path:          r.URL.Path,
+			authorization: r.Header.Get("Authorization"),
+			contentType:   r.Header.Get("Content-Type"),
+			body:          string(body),
+		})
+		mutex.Unlock()
+
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(status)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []s3Request {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]s3Request(nil), requests...)
+	}
+}
+
+func TestParseS3URL(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		bucket   string
+		key      string
+		filePath string
+		object   string
+	}{
+		{"s3://reports", "reports", "", "/tmp/users.csv", "users.csv"},
+		{"s3://reports/", "reports", "", "/tmp/users.csv", "users.csv"},
+		{"s3://reports/daily/", "reports", "daily/", "/tmp/users.csv", "daily/users.csv"},
+		{"s3://reports/daily/latest.csv", "reports", "daily/latest.csv", "/tmp/users.csv", "daily/latest.csv"},
+	}
+	for _, test := range tests {
+		location, err := ParseS3URL(test.value)
+		if err != nil {
+			t.Errorf("ParseS3URL(%q) returned an error: %v", test.value, err)
+			continue
+		}
+		if location.Bucket != test.bucket || location.Key != test.key {
+			t.Errorf("ParseS3URL(%q) = %+v, expected the bucket %q and key %q", test.value, location, test.bucket, test.key)
+		}
+		if object := location.objectKey(test.filePath); object != test.object {
+			t.Errorf("the object key for %q is %q, expected %q", test.value, object, test.object)
+		}
+	}
+
+	for _, value := range []string{"reports/daily/", "https://reports/", "s3://", "s3:///daily/"} {
+		if _, err := ParseS3URL(value); err == nil {
+			t.Errorf("ParseS3URL(%q) should have returned an error", value)
+		}
+	}
+}
+
+func TestLoadS3SettingsWithoutCredentials(t *testing.T) {
+
+	isolateAWSConfig(t, "", "")
+	if _, err := LoadS3Settings(""); err == nil || !strings.Contains(err.Error(), "no AWS credentials") {
+		t.Errorf("expected missing credentials to be reported, got %v", err)
+	}
+}
+
+func TestUploadToS3(t *testing.T) {
+
+	isolateAWSConfig(t, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	server, requests := newFakeS3(t, http.StatusOK)
+
+	settings, err := LoadS3Settings(server.URL)
+	if err != nil {
+		t.Fatalf("LoadS3Settings() returned an error: %v", err)
+	}
+	if settings.Config.Region != "eu-west-2" {
+		t.Errorf("expected the region from AWS_REGION, got %q", settings.Config.Region)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "users.csv")
+	contents := "Username,Email\nalice,alice@example.com\n"
+	if err := os.WriteFile(filePath, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded, err := UploadToS3(settings, S3Location{Bucket: "reports", Key: "daily/"}, filePath)
+	if err != nil {
+		t.Fatalf("UploadToS3() returned an error: %v", err)
+	}
+	if uploaded != "s3://reports/daily/users.csv" {
+		t.Errorf("expected the object s3://reports/daily/users.csv, got %s", uploaded)
+	}
+
+	received := requests()
+	if len(received) != 1 {
+		t.Fatalf("expected a single PUT request, got %d requests", len(received))
+	}
+	request := received[0]
+	if request.method != http.MethodPut || request.path != "/reports/daily/users.csv" {
+		t.Errorf("expected PUT /reports/daily/users.csv with the bucket in the path, got %s %s", request.method, request.path)
+	}
+	if request.contentType != "text/csv; charset=utf-8" {
+		t.Errorf("expected the content type of a CSV file, got %q", request.contentType)
+	}
+	if !strings.Contains(request.body, contents) {
+		t.Errorf("expected the file's contents to be uploaded, got %q", request.body)
+	}
+
+	// The request is signed with Signature Version 4, for the region and the S3 service
+	for _, part := range []string{"AWS4-HMAC-SHA256 ", "Credential=AKIDEXAMPLE/", "/eu-west-2/s3/aws4_request", "SignedHeaders=", "Signature="} {
+		if !strings.Contains(request.authorization, part) {
+			t.Errorf("expected the Authorization header to contain %q, got %q", part, request.authorization)
+		}
+	}
+	if strings.Contains(request.authorization, "wJalrXUtnFEMI") {
+		t.Errorf("the secret key was sent in the Authorization header")
+	}
+}
+
+func TestUploadToS3Rejected(t *testing.T) {
+
+	isolateAWSConfig(t, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	server, _ := newFakeS3(t, http.StatusForbidden)
+
+	settings, err := LoadS3Settings(server.URL)
+	if err != nil {
+		t.Fatalf("LoadS3Settings() returned an error: %v", err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(filePath, []byte("Username\nalice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = UploadToS3(settings, S3Location{Bucket: "reports"}, filePath)
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("expected the service's error to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package mmuserlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scatter plot layout, in SVG user units
+const (
+	plotWidth    = 800
+	plotHeight   = 600
+	plotMargin   = 60
+	plotLegendW  = 160
+	plotTicks    = 5
+	plotPointR   = 3
+	noTeamLegend = "(no team)"
+)
+
+// plotColors is the palette used for each team in the scatter plot, in order of first appearance
+var plotColors = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// scatterPoint is one row of the account age vs activity dataset
+type scatterPoint struct {
+	DaysSinceCreated      int    `json:"days_since_created"`
+	DaysSinceLastActivity int    `json:"days_since_last_activity"`
+	Team                  string `json:"team"`
+}
+
+// BuildScatterData derives the account age vs activity dataset from the user list.  Users without a team name (as
+// in a channel export) are given the default team.
+func BuildScatterData(users []*User, defaultTeam string) []scatterPoint {
+	points := make([]scatterPoint, 0, len(users))
+	for _, user := range users {
+		team := user.TeamName
+		if team == "" {
+			team = defaultTeam
+		}
+		points = append(points, scatterPoint{
+			DaysSinceCreated:      int(time.Since(user.UserCreatedAt).Hours() / 24),
+			DaysSinceLastActivity: user.DaysSinceLastActivity,
+			Team:                  team,
+		})
+	}
+	return points
+}
+
+// WriteScatterData writes the dataset to a file in the requested format
+func WriteScatterData(points []scatterPoint, filePath string, output OutputOptions) error {
+
+	DebugPrint("Writing scatter dataset to: " + filePath)
+
+	file, err := CreateOutput(filePath, output.Compress)
+	if err != nil {
+		LogMessage(ErrorLevel, "Failed to create file: "+filePath+" - "+err.Error())
+		return err
+	}
+	defer file.Close()
+
+	if output.Format == FormatJSON {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(points); err != nil {
+			return err
+		}
+		return file.Commit()
+	}
+
+	writer := newCSVWriter(file, output.CSV)
+	writer.Write([]string{"Days Since Created", "Days Since Last Activity", "Team Name"})
+	for _, point := range points {
+		writer.Write([]string{
+			strconv.Itoa(point.DaysSinceCreated),
+			strconv.Itoa(point.DaysSinceLastActivity),
+			point.Team,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+// niceCeiling rounds an axis maximum up to a value that divides evenly into the tick count
+func niceCeiling(value int) int {
+	if value <= 0 {
+		return plotTicks
+	}
+	step := int(math.Pow(10, math.Floor(math.Log10(float64(value)/plotTicks))))
+	if step < 1 {
+		step = 1
+	}
+	tick := ((value + plotTicks*step - 1) / (plotTicks * step)) * step
+	return tick * plotTicks
+}
+
+// WriteScatterPlot renders the dataset as an SVG scatter plot, with one color per team
+func WriteScatterPlot(points []scatterPoint, filePath string) error {
+
+	DebugPrint("Writing scatter plot to: " + filePath)
+
+	maxX, maxY := 0, 0
+	var teams []string
+	teamColor := make(map[string]string)
+	for _, point := range points {
+		maxX = max(maxX, point.DaysSinceCreated)
+		maxY = max(maxY, point.DaysSinceLastActivity)
+		for _, team := range scatterTeams(point.Team) {
+			if _, found := teamColor[team]; !found {
+				teamColor[team] = plotColors[len(teams)%len(plotColors)]
+				teams = append(teams, team)
+			}
+		}
+	}
+	maxX, maxY = niceCeiling(maxX), niceCeiling(maxY)
+
+	left, top := float64(plotMargin), float64(plotMargin)/2
+	width := float64(plotWidth - plotMargin - plotLegendW)
+	height := float64(plotHeight - plotMargin*3/2)
+	scaleX := func(days int) float64 { return left + float64(days)/float64(maxX)*width }
+	scaleY := func(days int) float64 { return top + height - float64(days)/float64(maxY)*height }
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", plotWidth, plotHeight)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="white"/>`+"\n", plotWidth, plotHeight)
+
+	// Axes, ticks and labels
+	fmt.Fprintf(&svg, `<path d="M%.1f %.1f V%.1f H%.1f" stroke="black" fill="none"/>`+"\n", left, top, top+height, left+width)
+	for i := 0; i <= plotTicks; i++ {
+		xDays, yDays := maxX*i/plotTicks, maxY*i/plotTicks
+		x, y := scaleX(xDays), scaleY(yDays)
+		fmt.Fprintf(&svg, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`, x, top+height, x, top+height+5)
+		fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="middle">%d</text>`+"\n", x, top+height+20, xDays)
+		fmt.Fprintf(&svg, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`, left-5, y, left, y)
+		fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="end" dominant-baseline="middle">%d</text>`+"\n", left-8, y, yDays)
+	}
+	fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="middle">Days since account created</text>`+"\n", left+width/2, top+height+45)
+	fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="middle" transform="rotate(-90 %.1f %.1f)">Days since last activity</text>`+"\n", left-45, top+height/2, left-45, top+height/2)
+
+	// Points, drawn once per team so that merged team lists show in every team's color
+	for _, point := range points {
+		for _, team := range scatterTeams(point.Team) {
+			fmt.Fprintf(&svg, `<circle cx="%.1f" cy="%.1f" r="%d" fill="%s" fill-opacity="0.6"/>`+"\n",
+				scaleX(point.DaysSinceCreated), scaleY(point.DaysSinceLastActivity), plotPointR, teamColor[team])
+		}
+	}
+
+	// Legend
+	sort.SliceStable(teams, func(i, j int) bool { return teams[i] < teams[j] })
+	legendX := left + width + 20
+	for i, team := range teams {
+		y := top + float64(i*18)
+		fmt.Fprintf(&svg, `<circle cx="%.1f" cy="%.1f" r="5" fill="%s"/>`, legendX, y, teamColor[team])
+		fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" dominant-baseline="middle">%s</text>`+"\n", legendX+10, y, html.EscapeString(team))
+	}
+
+	svg.WriteString("</svg>\n")
+
+	if err := WriteOutputFile(filePath, []byte(svg.String()), false); err != nil {
+		LogMessage(ErrorLevel, "Failed to write scatter plot: "+filePath+" - "+err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// scatterTeams splits a (possibly merged) team name into the teams used for coloring
+func scatterTeams(teamName string) []string {
+	if teamName == "" {
+		return []string{noTeamLegend}
+	}
+	return strings.Split(teamName, ", ")
+}
@@ -0,0 +1,61 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// SearchUsers uses the server's user search API to find users whose username, name, nickname or email matches the
+// term, optionally limited to the members of a team.  The server returns at most model.UserSearchMaxLimit matches.
+func SearchUsers(mmClient *model.Client4, term string, team string, includeBots bool) ([]*User, error) {
+
+	userList, err := searchUsers(mmClient, term, team, includeBots)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ApplyLastActivity(mmClient, userList); err != nil {
+		return nil, err
+	}
+
+	return userList, nil
+}
+
+// searchUsers runs a user search, without retrieving the last activity of the matches
+func searchUsers(mmClient *model.Client4, term string, team string, includeBots bool) ([]*User, error) {
+
+	DebugPrint("In searchUsers, for term: " + term)
+
+	search := &model.UserSearch{
+		Term:  term,
+		Limit: model.UserSearchMaxLimit,
+	}
+
+	if team != "" {
+		resolvedTeam, err := ResolveTeam(mmClient, team)
+		if err != nil {
+			return nil, err
+		}
+		search.TeamId = resolvedTeam.Id
+	}
+
+	users, response, err := mmClient.SearchUsers(context.Background(), search)
+
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from SearchUsers(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from SearchUsers()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	if len(users) == model.UserSearchMaxLimit {
+		LogMessage(WarningLevel, fmt.Sprintf("The search returned the maximum of %d users - refine the term to see all matches", model.UserSearchMaxLimit))
+	}
+
+	return buildUserList(users, includeBots), nil
+}
@@ -0,0 +1,247 @@
+package mmuserlist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP defaults
+const (
+	DefaultSFTPPort = "22"
+	sftpScheme      = "sftp://"
+	sftpTimeout     = 30 * time.Second
+	sftpPosixRename = "posix-rename@openssh.com"
+)
+
+// SFTPLocation is the server and path an output file is uploaded to.  Path is relative to the user's home directory
+// unless it is given as an absolute path, e.g. sftp://host//srv/drop/.
+type SFTPLocation struct {
+	User string
+	Host string // host:port
+	Path string // empty, or ending in '/', to upload under the file's own name
+}
+
+// ParseSFTPURL parses a destination such as sftp://user@host/drop/ or sftp://user@host:2222//srv/drop/users.csv.  The
+// user defaults to the current user, as for the ssh command.
+func ParseSFTPURL(value string) (SFTPLocation, error) {
+	if !strings.HasPrefix(value, sftpScheme) {
+		return SFTPLocation{}, fmt.Errorf("'%s' is not an SFTP URL - it must start with %s", value, sftpScheme)
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return SFTPLocation{}, err
+	}
+	if parsed.Hostname() == "" {
+		return SFTPLocation{}, fmt.Errorf("'%s' doesn't name a server", value)
+	}
+	if _, found := parsed.User.Password(); found {
+		return SFTPLocation{}, errors.New("only key-based authentication is supported, so the URL can't include a password")
+	}
+
+	location := SFTPLocation{
+		User: parsed.User.Username(),
+		Host: parsed.Host,
+		Path: strings.TrimPrefix(parsed.Path, "/"),
+	}
+	if parsed.Port() == "" {
+		location.Host = net.JoinHostPort(parsed.Hostname(), DefaultSFTPPort)
+	}
+	if location.User == "" {
+		if current, err := user.Current(); err == nil {
+			location.User = current.Username
+		}
+	}
+	return location, nil
+}
+
+// remotePath returns the path a file is uploaded to
+func (location SFTPLocation) remotePath(filePath string) string {
+	remote := location.Path
+	if remote == "" || strings.HasSuffix(remote, "/") {
+		remote += filepath.Base(filePath)
+	}
+	return remote
+}
+
+// SFTPSettings are the keys used to log in to the SFTP server and to check its identity.  KeyFile is a private key
+// without a passphrase; keys held by the SSH agent (SSH_AUTH_SOCK) are also tried.  KnownHostsFile lists the
+// servers' host keys, as for the ssh command.
+type SFTPSettings struct {
+	KeyFile        string
+	KnownHostsFile string
+}
+
+// DefaultSSHFile returns the path of a file in ~/.ssh
+func DefaultSSHFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", name)
+}
+
+// sshConfig prepares the SSH client configuration: the key file, or the default keys, and any agent's keys to log
+// in with, and the known hosts to check the server against
+func (settings SFTPSettings) sshConfig(username string) (*ssh.ClientConfig, io.Closer, error) {
+
+	var signers []ssh.Signer
+	var locked []string
+	keyFiles := []string{settings.KeyFile}
+	if settings.KeyFile == "" {
+		keyFiles = []string{DefaultSSHFile("id_ed25519"), DefaultSSHFile("id_ecdsa"), DefaultSSHFile("id_rsa")}
+	}
+	for _, keyFile := range keyFiles {
+		key, err := os.ReadFile(keyFile)
+		if errors.Is(err, os.ErrNotExist) && settings.KeyFile == "" {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			locked = append(locked, keyFile)
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to read the key %s: %w", keyFile, err)
+		}
+		signers = append(signers, signer)
+	}
+
+	var closer io.Closer
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if connection, err := net.Dial("unix", socket); err == nil {
+			if agentSigners, err := agent.NewClient(connection).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+			closer = connection
+		}
+	}
+	if len(signers) == 0 && len(locked) > 0 {
+		return nil, closer, fmt.Errorf("%s needs a passphrase - add it to the SSH agent to use it", strings.Join(locked, ", "))
+	} else if len(signers) == 0 {
+		return nil, closer, errors.New("no SSH key was found - give one with 'sftp-key', or add it to the SSH agent")
+	}
+
+	hostKeys, err := knownhosts.New(settings.KnownHostsFile)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, nil, fmt.Errorf("failed to read the known hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+		Timeout:         sftpTimeout,
+	}, closer, nil
+}
+
+// CheckSFTPSettings checks that the keys and known hosts can be read, so that a problem with them is reported before
+// the export starts
+func CheckSFTPSettings(settings SFTPSettings, location SFTPLocation) error {
+	_, closer, err := settings.sshConfig(location.User)
+	if closer != nil {
+		closer.Close()
+	}
+	return err
+}
+
+// UploadToSFTP uploads a file to an SFTP server, returning the location it was written to.  The file is written under
+// a temporary name and renamed once complete, so that whoever collects it never sees it half written.
+func UploadToSFTP(settings SFTPSettings, location SFTPLocation, filePath string) (string, error) {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	config, agentConnection, err := settings.sshConfig(location.User)
+	if agentConnection != nil {
+		defer agentConnection.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	DebugPrint("Connecting to " + location.User + "@" + location.Host)
+	client, err := ssh.Dial("tcp", location.Host, config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		return "", fmt.Errorf("the server doesn't offer SFTP: %w", err)
+	}
+	defer sftpClient.Close()
+
+	remote := location.remotePath(filePath)
+	temporary := path.Join(path.Dir(remote), "."+path.Base(remote)+".tmp")
+	if err := uploadSFTPFile(sftpClient, file, temporary); err != nil {
+		sftpClient.Remove(temporary)
+		return "", err
+	}
+	if err := renameSFTPFile(sftpClient, temporary, remote); err != nil {
+		sftpClient.Remove(temporary)
+		return "", err
+	}
+
+	return sftpScheme + location.User + "@" + location.Host + "/" + remote, nil
+}
+
+// uploadSFTPFile writes the contents of a file to a new remote file, keeping several writes in flight so that the
+// transfer isn't held up by the round trip to the server
+func uploadSFTPFile(client *sftp.Client, file io.Reader, remote string) error {
+
+	remoteFile, err := client.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", remote, err)
+	}
+	if err := remoteFile.Chmod(0644); err != nil {
+		remoteFile.Close()
+		return fmt.Errorf("failed to create %s: %w", remote, err)
+	}
+	if _, err := remoteFile.ReadFrom(file); err != nil {
+		remoteFile.Close()
+		return fmt.Errorf("failed to write %s: %w", remote, err)
+	}
+	if err := remoteFile.Close(); err != nil {
+		return fmt.Errorf("failed to complete %s: %w", remote, err)
+	}
+	return nil
+}
+
+// renameSFTPFile moves the uploaded file into place, replacing any file already there.  The standard rename fails if
+// the target exists, so OpenSSH's POSIX rename is used where it is offered.
+func renameSFTPFile(client *sftp.Client, from string, to string) error {
+	var err error
+	if _, found := client.HasExtension(sftpPosixRename); found {
+		err = client.PosixRename(from, to)
+	} else {
+		client.Remove(to)
+		err = client.Rename(from, to)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", from, to, err)
+	}
+	return nil
+}
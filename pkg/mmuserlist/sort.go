@@ -0,0 +1,57 @@
+package mmuserlist
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fields the users can be sorted by
+const (
+	SortByUsername     = "username"
+	SortByEmail        = "email"
+	SortByLastActivity = "last_activity"
+	SortByDaysInactive = "days_inactive"
+	SortByCreatedAt    = "created_at"
+)
+
+// SortFields lists the fields the users can be sorted by, in the order they are documented
+var SortFields = []string{SortByUsername, SortByEmail, SortByLastActivity, SortByDaysInactive, SortByCreatedAt}
+
+// sortOrders compares two users by each sort field, returning true if the first comes before the second
+var sortOrders = map[string]func(a *User, b *User) bool{
+	SortByUsername: func(a *User, b *User) bool {
+		return strings.ToLower(a.Username) < strings.ToLower(b.Username)
+	},
+	SortByEmail: func(a *User, b *User) bool {
+		return strings.ToLower(a.Email) < strings.ToLower(b.Email)
+	},
+	SortByLastActivity: func(a *User, b *User) bool {
+		return a.LastActivityAt.Before(b.LastActivityAt)
+	},
+	SortByDaysInactive: func(a *User, b *User) bool {
+		return a.DaysSinceLastActivity < b.DaysSinceLastActivity
+	},
+	SortByCreatedAt: func(a *User, b *User) bool {
+		return a.UserCreatedAt.Before(b.UserCreatedAt)
+	},
+}
+
+// SortUsers sorts the users in place by one of the SortFields, in ascending order unless descending is set.  Users
+// with the same value keep their order, so a list of several teams stays grouped by team within each value.
+func SortUsers(users []*User, field string, descending bool) error {
+
+	less, found := sortOrders[field]
+	if !found {
+		return fmt.Errorf("unknown sort field '%s'", field)
+	}
+
+	DebugPrint(fmt.Sprintf("Sorting %d users by %s (descending: %t)", len(users), field, descending))
+	sort.SliceStable(users, func(i, j int) bool {
+		if descending {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+	return nil
+}
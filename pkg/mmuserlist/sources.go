@@ -0,0 +1,392 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// UserSource is a way of discovering users: the members of a team or channel, the users without a team, an explicit
+// list or the results of a search.  Sources can be combined with AllOf and AnyOf.
+type UserSource interface {
+	// Users fetches the users from the source.  Last activity is not retrieved.
+	Users(mmClient *model.Client4) ([]*User, error)
+	// String describes the source, as it would be written in a source expression
+	String() string
+}
+
+// TeamSource is the members of one team or, for a comma-separated list of teams, of each team in the list
+type TeamSource struct {
+	Team  string
+	Crawl CrawlOptions
+	Merge bool
+}
+
+// Users fetches the members of the team(s), with the team name on each user
+func (s TeamSource) Users(mmClient *model.Client4) ([]*User, error) {
+	teamNames := SplitTeamNames(s.Team)
+	if len(teamNames) > 1 {
+		return FetchUsersInTeams(mmClient, teamNames, s.Crawl, s.Merge)
+	}
+	return FetchTeamUsers(mmClient, s.Team, s.Crawl)
+}
+
+func (s TeamSource) String() string {
+	return "team:" + s.Team
+}
+
+// AllTeamsSource is the members of every team, with the team name on each user
+type AllTeamsSource struct {
+	Crawl CrawlOptions
+	Merge bool
+}
+
+// Users fetches the members of every team
+func (s AllTeamsSource) Users(mmClient *model.Client4) ([]*User, error) {
+	return FetchUsersInAllTeams(mmClient, s.Crawl, s.Merge)
+}
+
+func (s AllTeamsSource) String() string {
+	return "all-teams"
+}
+
+// NoTeamSource is the users who are not members of any team
+type NoTeamSource struct {
+	Crawl CrawlOptions
+}
+
+// Users fetches the users who are not members of any team
+func (s NoTeamSource) Users(mmClient *model.Client4) ([]*User, error) {
+	return FetchUsersWithoutTeam(mmClient, s.Crawl)
+}
+
+func (s NoTeamSource) String() string {
+	return "no-team"
+}
+
+// ChannelSource is the members of a channel in a team
+type ChannelSource struct {
+	Team        string
+	Channel     string
+	IncludeBots bool
+}
+
+// Users fetches the members of the channel
+func (s ChannelSource) Users(mmClient *model.Client4) ([]*User, error) {
+
+	members, err := GetChannelMembers(mmClient, s.Team, s.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	users, err := getUsersByIDs(mmClient, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildUserList(users, s.IncludeBots), nil
+}
+
+func (s ChannelSource) String() string {
+	return "channel:" + s.Team + "/" + s.Channel
+}
+
+// ListSource is the users named in a file of usernames, email addresses and/or user IDs, in the same layout as an
+// exclusion file.  Entries that don't match a user are logged and skipped.
+type ListSource struct {
+	File string
+}
+
+// Users looks up each user named in the file
+func (s ListSource) Users(mmClient *model.Client4) ([]*User, error) {
+
+	identifiers, err := loadIdentifiers(s.File, "user list")
+	if err != nil {
+		return nil, err
+	}
+
+	var ids, usernames, emails []string
+	for identifier := range identifiers {
+		switch {
+		case strings.Contains(identifier, "@"):
+			emails = append(emails, identifier)
+		case model.IsValidId(identifier):
+			ids = append(ids, identifier)
+		default:
+			usernames = append(usernames, identifier)
+		}
+	}
+	sort.Strings(ids)
+	sort.Strings(usernames)
+	sort.Strings(emails)
+
+	ctx := context.Background()
+	var found []*model.User
+
+	byID, err := getUsersByIDs(mmClient, ids)
+	if err != nil {
+		return nil, err
+	}
+	found = append(found, byID...)
+
+	// An identifier that looks like an ID may still be a username
+	matched := make(map[string]bool, len(byID))
+	for _, user := range byID {
+		matched[user.Id] = true
+	}
+	for _, id := range ids {
+		if !matched[id] {
+			usernames = append(usernames, id)
+		}
+	}
+
+	for first := 0; first < len(usernames); first += PageSize {
+		batch := usernames[first:min(first+PageSize, len(usernames))]
+		users, response, err := mmClient.GetUsersByUsernames(ctx, batch)
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetUsersByUsernames(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetUsersByUsernames()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+		found = append(found, users...)
+	}
+
+	for _, email := range emails {
+		user, response, err := mmClient.GetUserByEmail(ctx, email, "")
+		if response != nil && response.StatusCode == 404 {
+			continue
+		}
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetUserByEmail(): "+err.Error())
+			return nil, err
+		}
+		found = append(found, user)
+	}
+
+	userList := buildUserList(found, true)
+	userList = uniqueUsers(userList)
+	if missing := len(identifiers) - len(userList); missing > 0 {
+		LogMessage(WarningLevel, fmt.Sprintf("%d entries in the user list %s didn't match a user", missing, s.File))
+	}
+
+	return userList, nil
+}
+
+func (s ListSource) String() string {
+	return "list:" + s.File
+}
+
+// SearchSource is the users matched by a search of their username, name, nickname or email, optionally limited to
+// the members of a team
+type SearchSource struct {
+	Term        string
+	Team        string
+	IncludeBots bool
+}
+
+// Users runs the search
+func (s SearchSource) Users(mmClient *model.Client4) ([]*User, error) {
+	return searchUsers(mmClient, s.Term, s.Team, s.IncludeBots)
+}
+
+func (s SearchSource) String() string {
+	return "search:" + s.Term
+}
+
+// AllOf is the users found by every one of its sources, in the order of the first source
+type AllOf []UserSource
+
+// Users fetches each source and keeps the users found by all of them
+func (sources AllOf) Users(mmClient *model.Client4) ([]*User, error) {
+
+	var users []*User
+	for i, source := range sources {
+		DebugPrint("Fetching users from source: " + source.String())
+		found, err := source.Users(mmClient)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			users = found
+			continue
+		}
+		ids := make(map[string]bool, len(found))
+		for _, user := range found {
+			ids[user.UserID] = true
+		}
+		users = FilterUsers(users, func(user *User) bool {
+			return ids[user.UserID]
+		})
+	}
+
+	return users, nil
+}
+
+func (sources AllOf) String() string {
+	return joinSources(sources, " and ")
+}
+
+// AnyOf is the users found by at least one of its sources.  A user found by several sources is listed once, as found
+// by the first.
+type AnyOf []UserSource
+
+// Users fetches each source and combines the users found
+func (sources AnyOf) Users(mmClient *model.Client4) ([]*User, error) {
+
+	var users []*User
+	for _, source := range sources {
+		DebugPrint("Fetching users from source: " + source.String())
+		found, err := source.Users(mmClient)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, found...)
+	}
+
+	return uniqueUsers(users), nil
+}
+
+func (sources AnyOf) String() string {
+	return joinSources(sources, " or ")
+}
+
+// joinSources describes a list of sources with the given operator between them
+func joinSources(sources []UserSource, operator string) string {
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		names[i] = source.String()
+	}
+	return strings.Join(names, operator)
+}
+
+// uniqueUsers drops every repeat of a user after the first
+func uniqueUsers(users []*User) []*User {
+	seen := make(map[string]bool, len(users))
+	return FilterUsers(users, func(user *User) bool {
+		if seen[user.UserID] {
+			return false
+		}
+		seen[user.UserID] = true
+		return true
+	})
+}
+
+// getUsersByIDs fetches the users with the given IDs, a page at a time.  IDs that don't match a user are skipped.
+func getUsersByIDs(mmClient *model.Client4, ids []string) ([]*model.User, error) {
+
+	ctx := context.Background()
+	var users []*model.User
+
+	for first := 0; first < len(ids); first += PageSize {
+		batch := ids[first:min(first+PageSize, len(ids))]
+		found, response, err := mmClient.GetUsersByIds(ctx, batch)
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetUsersByIds(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetUsersByIds()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+		users = append(users, found...)
+	}
+
+	return users, nil
+}
+
+// Source expression operators, which are matched as whole words in either case
+var (
+	orOperator  = regexp.MustCompile(`(?i)\s+or\s+`)
+	andOperator = regexp.MustCompile(`(?i)\s+and\s+`)
+)
+
+// ParseUserSource parses a source expression such as "team:sales and channel:sales/town-square or list:vips.csv".
+// 'and' binds more tightly than 'or'.  The sources are:
+//
+//	team:<team>[,<team>...]   the members of the team(s)
+//	all-teams                 the members of every team
+//	no-team                   the users who are not members of any team
+//	channel:<team>/<channel>  the members of a channel
+//	list:<file>               the users named in a file of usernames, emails and/or IDs
+//	search:<term>             the users matching a search term
+//
+// The team sources are crawled with the crawl options, and the bots are included in the others if they are.
+func ParseUserSource(expression string, crawl CrawlOptions) (UserSource, error) {
+
+	var anyOf AnyOf
+	for _, alternative := range orOperator.Split(strings.TrimSpace(expression), -1) {
+		var allOf AllOf
+		for _, term := range andOperator.Split(alternative, -1) {
+			source, err := parseSourceTerm(strings.TrimSpace(term), crawl)
+			if err != nil {
+				return nil, err
+			}
+			allOf = append(allOf, source)
+		}
+		if len(allOf) == 1 {
+			anyOf = append(anyOf, allOf[0])
+		} else {
+			anyOf = append(anyOf, allOf)
+		}
+	}
+
+	if len(anyOf) == 1 {
+		return anyOf[0], nil
+	}
+	return anyOf, nil
+}
+
+// parseSourceTerm parses a single source of a source expression
+func parseSourceTerm(term string, crawl CrawlOptions) (UserSource, error) {
+
+	kind, value, _ := strings.Cut(term, ":")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	value = strings.TrimSpace(value)
+
+	switch kind {
+	case "all-teams":
+		return AllTeamsSource{Crawl: crawl}, nil
+	case "no-team":
+		return NoTeamSource{Crawl: crawl}, nil
+	}
+
+	if value == "" {
+		return nil, invalidSource(term)
+	}
+
+	switch kind {
+	case "team":
+		return TeamSource{Team: value, Crawl: crawl}, nil
+	case "channel":
+		team, channel, found := strings.Cut(value, "/")
+		if !found || team == "" || channel == "" {
+			return nil, fmt.Errorf("invalid source '%s' - a channel must be given as channel:<team>/<channel>", term)
+		}
+		return ChannelSource{Team: team, Channel: channel, IncludeBots: crawl.IncludeBots}, nil
+	case "list":
+		return ListSource{File: value}, nil
+	case "search":
+		return SearchSource{Term: value, IncludeBots: crawl.IncludeBots}, nil
+	}
+
+	return nil, invalidSource(term)
+}
+
+// invalidSource reports a source expression term that isn't one of the known sources
+func invalidSource(term string) error {
+	return fmt.Errorf("invalid source '%s' - expected one of team:<team>, all-teams, no-team, channel:<team>/<channel>, list:<file> or search:<term>", term)
+}
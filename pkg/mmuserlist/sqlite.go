@@ -0,0 +1,260 @@
+package mmuserlist
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// The pure Go SQLite driver, so that the tool stays a single static binary
+	_ "modernc.org/sqlite"
+)
+
+// Names in the SQLite output
+const (
+	SQLiteUsersTable = "users"
+	SQLiteRunColumn  = "run_timestamp"
+)
+
+// WriteUsersToSQLite writes the users as rows of the users table of a SQLite database, one column per output column
+// and a run_timestamp column recording when the export ran.  If output.Existing is a database written by an earlier
+// export, the users are inserted after its rows, so that one file holds the history of every run.  Columns that
+// weren't in the earlier exports are added to the table, and are NULL for the earlier rows.  Anything else in the
+// database, such as other tables, indexes or views, is kept, and a database in write-ahead-log mode stays in it.
+//
+// The rows are added to a copy of the database, which is then written to out, so that the existing file is only
+// replaced once the export is complete.
+func WriteUsersToSQLite(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as SQLite")
+
+	dir, err := os.MkdirTemp("", "mm-user-list-sqlite")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	databasePath := filepath.Join(dir, "users.db")
+	if output.Existing != "" && output.Existing != StdoutPath {
+		err := copySQLiteDatabase(output.Existing, databasePath)
+		if err == nil {
+			DebugPrint("Adding to the rows already in " + output.Existing)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read the existing database %s: %w", output.Existing, err)
+		}
+	}
+
+	if err := insertSQLiteUsers(databasePath, users, output); err != nil {
+		if output.Existing != "" && output.Existing != StdoutPath {
+			return fmt.Errorf("failed to add the users to the database %s: %w", output.Existing, err)
+		}
+		return err
+	}
+
+	database, err := os.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	_, err = io.Copy(out, database)
+	return err
+}
+
+// copySQLiteDatabase copies a database so that rows can be added to the copy.  A database in write-ahead-log mode is
+// checkpointed first, so that the rows still in its log are in the file copied, and the log left behind is empty
+// rather than holding pages that would be replayed onto the file that replaces it.
+func copySQLiteDatabase(source string, destination string) error {
+
+	if !strings.HasSuffix(source, GzipSuffix) {
+		if _, err := os.Stat(source); err != nil {
+			return err
+		}
+		if err := checkpointSQLite(source); err != nil {
+			return err
+		}
+	}
+	return copyReport(source, destination)
+}
+
+// checkpointSQLite moves everything in a database's write-ahead log into the database file, and empties the log
+func checkpointSQLite(databasePath string) error {
+
+	db, err := sql.Open("sqlite", databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+// copyReport copies a report to a new file, decompressing it if it was written gzip-compressed
+func copyReport(source string, destination string) error {
+
+	in, err := OpenReport(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// insertSQLiteUsers inserts the users into the users table of the database at databasePath, creating the table, or
+// adding any columns it lacks, first
+func insertSQLiteUsers(databasePath string, users []*User, output OutputOptions) error {
+
+	db, err := sql.Open("sqlite", databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	columns := userColumns(users, output)
+	names := append(columnIdentifiers(columns, SQLiteRunColumn), SQLiteRunColumn)
+	types := make([]string, len(names))
+	for i, column := range columns {
+		types[i] = sqliteColumnType(column.Value(&User{}))
+	}
+	types[len(columns)] = "TEXT"
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := prepareSQLiteTable(tx, names, types); err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = sqliteQuote(name)
+	}
+	insert, err := tx.Prepare("INSERT INTO " + sqliteQuote(SQLiteUsersTable) + " (" + strings.Join(quoted, ", ") + ") VALUES (?" + strings.Repeat(", ?", len(names)-1) + ")")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	runAt := output.Dates.timestamp(time.Now())
+	for _, user := range users {
+		values := make([]interface{}, 0, len(names))
+		for _, column := range columns {
+			values = append(values, sqliteValue(column.Value(user), output.Dates))
+		}
+		values = append(values, runAt)
+		if _, err := insert.Exec(values...); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Anything in the write-ahead log is moved into the database file, which is all that is written out
+	_, err = db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+// prepareSQLiteTable creates the users table with the named columns, or adds those it doesn't have yet
+func prepareSQLiteTable(tx *sql.Tx, names []string, types []string) error {
+
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", SQLiteUsersTable)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(existing) == 0 {
+		definitions := make([]string, len(names))
+		for i, name := range names {
+			definitions[i] = sqliteQuote(name) + " " + types[i]
+		}
+		_, err := tx.Exec("CREATE TABLE " + sqliteQuote(SQLiteUsersTable) + " (" + strings.Join(definitions, ", ") + ")")
+		return err
+	}
+
+	for i, name := range names {
+		if existing[name] {
+			continue
+		}
+		DebugPrint("Adding the column " + name + " to the " + SQLiteUsersTable + " table")
+		if _, err := tx.Exec("ALTER TABLE " + sqliteQuote(SQLiteUsersTable) + " ADD COLUMN " + sqliteQuote(name) + " " + types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteQuote quotes an identifier for a SQL statement
+func sqliteQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqliteColumnType returns the declared type of a column, from an example of its values
+func sqliteColumnType(value interface{}) string {
+	switch value.(type) {
+	case bool, int, int64, *int:
+		return "INTEGER"
+	default:
+		return "TEXT"
+	}
+}
+
+// sqliteValue converts a column value for the database.  Booleans are stored as 0 or 1, and times as RFC3339 text,
+// which SQLite's date functions understand, or NULL if never recorded, as is a count that doesn't apply.
+func sqliteValue(value interface{}, dates DateOptions) interface{} {
+	switch value := value.(type) {
+	case string:
+		return value
+	case bool:
+		if value {
+			return int64(1)
+		}
+		return int64(0)
+	case int:
+		return int64(value)
+	case int64:
+		return value
+	case *int:
+		if value == nil {
+			return nil
+		}
+		return int64(*value)
+	case time.Time:
+		if value.IsZero() {
+			return nil
+		}
+		return dates.timestamp(value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
@@ -0,0 +1,174 @@
+package mmuserlist
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSQLiteFile writes the users to a database file, adding to the rows already there, as an export does
+func writeSQLiteFile(t *testing.T, path string, users []*User, output OutputOptions) {
+	t.Helper()
+
+	output.Existing = path
+	out, err := os.CreateTemp(filepath.Dir(path), "users-*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteUsersToSQLite(out, users, output); err != nil {
+		out.Close()
+		t.Fatalf("WriteUsersToSQLite() returned an error: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(out.Name(), path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openSQLiteFile opens a database written by WriteUsersToSQLite, with the SQLite driver rather than the writer's code
+func openSQLiteFile(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestWriteUsersToSQLite(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "users.db")
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	days := 3
+	users := []*User{
+		{Username: "alice", Email: "alice@example.com", UserCreatedAt: created, LastPostAt: created, DaysSinceLastPost: &days},
+		{Username: "bot", IsBotAccount: true, UserCreatedAt: created},
+	}
+	output := OutputOptions{Columns: []string{"username", "is_bot_account", "created_at", "last_post", "days_since_post"}}
+	writeSQLiteFile(t, path, users, output)
+
+	db := openSQLiteFile(t, path)
+	rows, err := db.Query("SELECT username, is_bot_account, user_created_date, last_post_date, days_since_last_post, run_timestamp FROM users ORDER BY rowid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	type row struct {
+		username  string
+		bot       int64
+		created   string
+		lastPost  sql.NullString
+		days      sql.NullInt64
+		timestamp string
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.username, &r.bot, &r.created, &r.lastPost, &r.days, &r.timestamp); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(got))
+	}
+	if got[0].username != "alice" || got[0].bot != 0 || got[0].created != "2024-03-01T09:30:00Z" {
+		t.Errorf("unexpected row for alice: %+v", got[0])
+	}
+	if !got[0].lastPost.Valid || !got[0].days.Valid || got[0].days.Int64 != 3 {
+		t.Errorf("expected alice's last post to be recorded: %+v", got[0])
+	}
+	if got[1].username != "bot" || got[1].bot != 1 {
+		t.Errorf("unexpected row for bot: %+v", got[1])
+	}
+	if got[1].lastPost.Valid || got[1].days.Valid {
+		t.Errorf("expected a user who has never posted to have NULL post columns: %+v", got[1])
+	}
+	if _, err := time.Parse(time.RFC3339, got[0].timestamp); err != nil {
+		t.Errorf("expected an RFC3339 run timestamp, got %q", got[0].timestamp)
+	}
+}
+
+func TestWriteUsersToSQLiteAddsToExistingDatabase(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "users.db")
+	users := []*User{{Username: "alice"}, {Username: "bob"}}
+	writeSQLiteFile(t, path, users, OutputOptions{Columns: []string{"username"}})
+
+	// Tables, indexes and views of the reader's own are kept, as is write-ahead-log mode.  The database is left open
+	// while the next run adds to it, so that the note is still in the log rather than the database file.
+	db := openSQLiteFile(t, path)
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA wal_autocheckpoint=0",
+		"CREATE TABLE notes (note TEXT)",
+		"INSERT INTO notes VALUES ('kept')",
+		"CREATE INDEX users_by_name ON users (username)",
+		"CREATE VIEW names AS SELECT DISTINCT username FROM users",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	// A later run with another column adds it to the table
+	writeSQLiteFile(t, path, []*User{{Username: "carol", Email: "carol@example.com"}}, OutputOptions{Columns: []string{"username", "email"}})
+	db.Close()
+
+	db = openSQLiteFile(t, path)
+	var count, withEmail, names int
+	var note, journal string
+	for _, query := range []struct {
+		sql    string
+		target interface{}
+	}{
+		{"SELECT COUNT(*) FROM users", &count},
+		{"SELECT COUNT(email) FROM users", &withEmail},
+		{"SELECT COUNT(*) FROM names", &names},
+		{"SELECT note FROM notes", &note},
+		{"PRAGMA journal_mode", &journal},
+	} {
+		if err := db.QueryRow(query.sql).Scan(query.target); err != nil {
+			t.Fatalf("%s: %v", query.sql, err)
+		}
+	}
+
+	if count != 3 {
+		t.Errorf("expected the new row to follow the 2 already there, got %d rows", count)
+	}
+	if withEmail != 1 {
+		t.Errorf("expected the email column to be NULL for the earlier rows, got %d with an email", withEmail)
+	}
+	if names != 3 || note != "kept" {
+		t.Errorf("expected the other tables and views to be kept, got %d names and the note %q", names, note)
+	}
+	if journal != "wal" {
+		t.Errorf("expected the database to stay in WAL mode, got %q", journal)
+	}
+}
+
+func TestWriteUsersToSQLiteRejectsOtherFiles(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "users.db")
+	if err := os.WriteFile(path, []byte("Username,Email\nalice,alice@example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output := OutputOptions{Columns: []string{"username"}, Existing: path}
+	if err := WriteUsersToSQLite(io.Discard, []*User{{Username: "bob"}}, output); err == nil {
+		t.Errorf("expected a file that isn't a database to be rejected")
+	}
+}
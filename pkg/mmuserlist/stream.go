@@ -0,0 +1,149 @@
+package mmuserlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrNotStreamable is returned by NewStreamWriter for output that can't be written a page at a time
+var ErrNotStreamable = errors.New("only CSV, JSON, NDJSON and template output, without prop columns, can be streamed")
+
+// StreamWriter writes users a page at a time, as they are fetched, so that the whole user list never has to be held
+// in memory.  The output is the same as WriteUsersToCSV, WriteUsersToJSON, WriteUsersToNDJSON or
+// WriteUsersWithTemplate would write for the same users.  Prop columns depend on the props of every user, so props
+// can only be streamed as a single JSON column.
+type StreamWriter struct {
+	out     io.Writer
+	output  OutputOptions
+	csv     *csvWriter
+	columns []column
+	count   int
+	errors  int
+}
+
+// NewStreamWriter starts streamed output in the format given by the output options
+func NewStreamWriter(out io.Writer, output OutputOptions) (*StreamWriter, error) {
+
+	if (output.Format != FormatCSV && output.Format != FormatJSON && output.Format != FormatNDJSON && output.Format != FormatTemplate) || output.PropsMode == PropsColumns {
+		return nil, ErrNotStreamable
+	}
+
+	stream := &StreamWriter{out: out, output: output}
+
+	if output.Format == FormatCSV {
+		DebugPrint("Streaming data as CSV")
+		stream.columns = csvColumns(nil, output)
+		writer, err := startCSV(out, stream.columns, output)
+		if err != nil {
+			return nil, err
+		}
+		stream.csv = writer
+	} else if output.Format == FormatTemplate {
+		DebugPrint("Streaming data with a template")
+		if output.Template == nil {
+			return nil, errors.New("no template was supplied for template output")
+		}
+	} else if output.Format == FormatNDJSON {
+		DebugPrint("Streaming data as NDJSON")
+	} else {
+		DebugPrint("Streaming data as JSON")
+	}
+
+	return stream, nil
+}
+
+// WriteUsers writes a page of users.  CSV rows are flushed after every page, so the output file grows as the crawl
+// goes on.
+func (s *StreamWriter) WriteUsers(users []*User) error {
+
+	if s.csv != nil {
+		for _, user := range users {
+			record := make([]string, len(s.columns))
+			for i, column := range s.columns {
+				record[i] = cellText(column.Value(user), s.output.Dates)
+			}
+			if err := s.csv.Write(record); err != nil {
+				LogMessage(WarningLevel, "Failed to write record for user '"+user.Username+"' to CSV file")
+				s.errors++
+				if s.errors > maxErrors {
+					LogMessage(ErrorLevel, "Too many errors writing to CSV file.  Aborting.")
+					return err
+				}
+			}
+		}
+		s.count += len(users)
+		s.csv.Flush()
+		return s.csv.Error()
+	}
+
+	if s.output.Format == FormatTemplate {
+		var buffer bytes.Buffer
+		for _, user := range users {
+			if err := writeTemplateLine(&buffer, user, s.output); err != nil {
+				return err
+			}
+		}
+		s.count += len(users)
+		_, err := s.out.Write(buffer.Bytes())
+		return err
+	}
+
+	if s.output.Format == FormatNDJSON {
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		for _, user := range users {
+			if err := encoder.Encode(jsonOutput(user, s.output)); err != nil {
+				LogMessage(ErrorLevel, "Failed to write NDJSON output: "+err.Error())
+				return err
+			}
+		}
+		s.count += len(users)
+		_, err := s.out.Write(buffer.Bytes())
+		return err
+	}
+
+	// Each record is indented as an element of the array, to match the output of WriteUsersToJSON
+	var buffer bytes.Buffer
+	for _, user := range users {
+		encoded, err := json.MarshalIndent(jsonOutput(user, s.output), "  ", "  ")
+		if err != nil {
+			LogMessage(ErrorLevel, "Failed to write JSON output: "+err.Error())
+			return err
+		}
+		if s.count == 0 {
+			buffer.WriteString("[\n  ")
+		} else {
+			buffer.WriteString(",\n  ")
+		}
+		buffer.Write(encoded)
+		s.count++
+	}
+	_, err := s.out.Write(buffer.Bytes())
+	return err
+}
+
+// Count returns the number of users written so far
+func (s *StreamWriter) Count() int {
+	return s.count
+}
+
+// Close completes the output.  It doesn't close the underlying writer.
+func (s *StreamWriter) Close() error {
+
+	if s.csv != nil {
+		s.csv.Flush()
+		return s.csv.Error()
+	}
+	if s.output.Format == FormatTemplate || s.output.Format == FormatNDJSON {
+		return nil
+	}
+
+	closing := "\n]\n"
+	if s.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(s.out, closing)
+	return err
+}
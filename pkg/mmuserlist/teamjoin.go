@@ -0,0 +1,198 @@
+package mmuserlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// teamMemberRecord is the part of a team member record needed for the join date.  The model's TeamMember doesn't
+// decode create_at, so the records are read directly.
+type teamMemberRecord struct {
+	UserID   string `json:"user_id"`
+	CreateAt int64  `json:"create_at"`
+	DeleteAt int64  `json:"delete_at"`
+}
+
+// joinPostsPerPage is the number of posts requested per page when looking for team join messages
+const joinPostsPerPage = 200
+
+// getTeamMemberJoinTimes returns the join time recorded on each team member record, keyed by user ID.  Servers that
+// don't include the time in the records give an empty map.
+func getTeamMemberJoinTimes(mmClient *model.Client4, teamID string) (map[string]time.Time, error) {
+
+	ctx := context.Background()
+	joined := make(map[string]time.Time)
+
+	for page := 0; ; page++ {
+		response, err := mmClient.DoAPIGet(ctx, fmt.Sprintf("/teams/%s/members?page=%d&per_page=%d", teamID, page, PageSize), "")
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetTeamMembers(): "+err.Error())
+			return nil, err
+		}
+
+		var members []teamMemberRecord
+		err = json.NewDecoder(response.Body).Decode(&members)
+		response.Body.Close()
+		if err != nil {
+			LogMessage(ErrorLevel, "Failed to decode the team members returned by GetTeamMembers(): "+err.Error())
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for _, member := range members {
+			if member.DeleteAt == 0 && member.CreateAt > 0 {
+				joined[member.UserID] = time.UnixMilli(member.CreateAt)
+			}
+		}
+		if len(members) < PageSize {
+			return joined, nil
+		}
+	}
+}
+
+// getTeamJoinPostTimes finds when each of the wanted users joined the team from the messages posted in the team's
+// default channel as they joined or were added.  The channel is read from the newest post back, so the most recent
+// join is found for anyone who left and rejoined, and the search stops once every wanted user has been found.
+func getTeamJoinPostTimes(mmClient *model.Client4, teamID string, wanted map[string]bool) (map[string]time.Time, error) {
+
+	ctx := context.Background()
+	joined := make(map[string]time.Time)
+
+	channel, response, err := mmClient.GetChannelByName(ctx, model.DefaultChannelName, teamID, "")
+	if err != nil {
+		LogMessage(ErrorLevel, "Error returned from GetChannelByName(): "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetChannelByName()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+
+	for page := 0; len(joined) < len(wanted); page++ {
+		posts, response, err := mmClient.GetPostsForChannel(ctx, channel.Id, page, joinPostsPerPage, "", false, false)
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetPostsForChannel(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetPostsForChannel()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+
+		for _, postID := range posts.Order {
+			post := posts.Posts[postID]
+			userID := ""
+			switch post.Type {
+			case model.PostTypeJoinTeam:
+				userID = post.UserId
+			case model.PostTypeAddToTeam:
+				userID, _ = post.GetProp(model.PostPropsAddedUserId).(string)
+			}
+			if _, found := joined[userID]; wanted[userID] && !found {
+				joined[userID] = time.UnixMilli(post.CreateAt)
+			}
+		}
+		if len(posts.Order) < joinPostsPerPage {
+			break
+		}
+	}
+
+	return joined, nil
+}
+
+// GetTeamJoinTimes returns when each of the users joined the team with the given ID, keyed by user ID.  The time is
+// taken from the team member record where the server includes it, otherwise from the message posted in the team's
+// default channel when the user joined or was added.  Users with neither are left out.
+func GetTeamJoinTimes(mmClient *model.Client4, teamID string, userIDs []string) (map[string]time.Time, error) {
+
+	DebugPrint(fmt.Sprintf("Retrieving team join dates for %d members of team %s", len(userIDs), teamID))
+
+	joined, err := getTeamMemberJoinTimes(mmClient, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[string]bool)
+	for _, userID := range userIDs {
+		if _, found := joined[userID]; !found {
+			missing[userID] = true
+		}
+	}
+	if len(missing) == 0 {
+		return joined, nil
+	}
+
+	DebugPrint(fmt.Sprintf("Team member records give no join date for %d users - searching the team's join messages", len(missing)))
+	posted, err := getTeamJoinPostTimes(mmClient, teamID, missing)
+	if err != nil {
+		return nil, err
+	}
+	for userID, joinedAt := range posted {
+		joined[userID] = joinedAt
+	}
+
+	return joined, nil
+}
+
+// ApplyTeamJoinDates populates the date each user joined their team: the team on their row or, for rows without a
+// team name (as in a channel export), defaultTeam.  Users whose join date can't be found are left blank.
+func ApplyTeamJoinDates(mmClient *model.Client4, users []*User, defaultTeam string) error {
+
+	teamUsers := make(map[string][]*User)
+	var teamNames []string
+	for _, user := range users {
+		team := user.TeamName
+		if team == "" {
+			team = defaultTeam
+		}
+		if team == "" {
+			continue
+		}
+		if _, found := teamUsers[team]; !found {
+			teamNames = append(teamNames, team)
+		}
+		teamUsers[team] = append(teamUsers[team], user)
+	}
+
+	for _, name := range teamNames {
+		team, err := ResolveTeam(mmClient, name)
+		if err != nil {
+			return err
+		}
+
+		joined, err := GetTeamJoinTimes(mmClient, team.Id, uniqueUserIDs(teamUsers[name]))
+		if err != nil {
+			return err
+		}
+
+		unknown := 0
+		for _, user := range teamUsers[name] {
+			user.TeamJoinedAt = joined[user.UserID]
+			if user.TeamJoinedAt.IsZero() {
+				unknown++
+			}
+		}
+		if unknown > 0 {
+			LogMessage(WarningLevel, fmt.Sprintf("The date %d members joined team %s couldn't be found - their Team Join Date is blank", unknown, name))
+		}
+	}
+
+	return nil
+}
+
+// TeamJoinDateEnrichment returns the enrichment that adds the date each user joined their team, with defaultTeam
+// used for rows without a team name
+func TeamJoinDateEnrichment(defaultTeam string) Enrichment {
+	return Enrichment{
+		Name: "team join dates",
+		Apply: func(mmClient *model.Client4, users []*User) error {
+			return ApplyTeamJoinDates(mmClient, users, defaultTeam)
+		},
+	}
+}
@@ -0,0 +1,354 @@
+package mmuserlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const maxTeamSuggestions = 5
+
+// TeamLabel returns the name a team is recorded under in each user's TeamName: its name as used in the URL, e.g.
+// sales-emea, or with displayName its display name, e.g. "Sales EMEA"
+func TeamLabel(team *model.Team, displayName bool) string {
+	if displayName && team.DisplayName != "" {
+		return team.DisplayName
+	}
+	return team.Name
+}
+
+// ResolveTeam finds a team from a user-supplied identifier.  The identifier is tried as the team name (URL slug)
+// first, then as a raw team ID, and finally as a case-insensitive display name.  If nothing matches, the error lists
+// the closest team names to help the user correct the parameter.
+func ResolveTeam(mmClient *model.Client4, team string) (*model.Team, error) {
+
+	DebugPrint("In ResolveTeam, for team: " + team)
+
+	ctx := context.Background()
+	etag := ""
+
+	found, response, err := mmClient.GetTeamByName(ctx, team, etag)
+	if err == nil && response.StatusCode == 200 {
+		return found, nil
+	}
+	if err == nil {
+		LogMessage(ErrorLevel, "Bad HTTP response returned from GetTeamByName()")
+		return nil, errors.New("failed to retrieve data from Mattermost")
+	}
+	if !teamNotFound(response, err) {
+		LogMessage(ErrorLevel, "Error returned from GetTeamByName(): "+err.Error())
+		return nil, err
+	}
+
+	if model.IsValidId(team) {
+		DebugPrint("Team name not found - trying as a team ID")
+		found, response, err = mmClient.GetTeam(ctx, team, etag)
+		if err == nil && response.StatusCode == 200 {
+			return found, nil
+		}
+		if err == nil {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetTeam()")
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+		if !teamNotFound(response, err) {
+			LogMessage(ErrorLevel, "Error returned from GetTeam(): "+err.Error())
+			return nil, err
+		}
+	}
+
+	DebugPrint("Team ID not found - searching team display names")
+	allTeams, err := GetAllTeams(mmClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*model.Team
+	for _, candidate := range allTeams {
+		if strings.EqualFold(candidate.DisplayName, team) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		suggestions := closestTeams(allTeams, team)
+		if len(suggestions) > 0 {
+			return nil, fmt.Errorf("team '%s' not found.  Did you mean: %s", team, strings.Join(suggestions, ", "))
+		}
+		return nil, fmt.Errorf("team '%s' not found", team)
+	default:
+		var names []string
+		for _, match := range matches {
+			names = append(names, match.Name)
+		}
+		return nil, fmt.Errorf("team display name '%s' is ambiguous - use one of the team names: %s", team, strings.Join(names, ", "))
+	}
+}
+
+// teamNotFound reports whether a failed team lookup simply means that no such team exists
+func teamNotFound(response *model.Response, err error) bool {
+	return err != nil && response != nil && response.StatusCode == http.StatusNotFound
+}
+
+// GetAllTeams returns every team visible to the authenticated user
+func GetAllTeams(mmClient *model.Client4) ([]*model.Team, error) {
+
+	DebugPrint("In GetAllTeams")
+
+	ctx := context.Background()
+	page := 0
+	perPage := PageSize
+	etag := ""
+
+	var allTeams []*model.Team
+
+	for {
+		teams, response, err := mmClient.GetAllTeams(ctx, etag, page, perPage)
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetAllTeams(): "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			errMsg := fmt.Sprintf("Bad HTTP response returned from GetAllTeams() (page %d)", page)
+			LogMessage(ErrorLevel, errMsg)
+			return nil, errors.New("failed to retrieve data from Mattermost")
+		}
+
+		allTeams = append(allTeams, teams...)
+
+		if len(teams) < perPage {
+			break
+		}
+
+		page++
+	}
+
+	return allTeams, nil
+}
+
+// closestTeams returns the names of the teams whose name or display name most closely resembles the supplied value
+func closestTeams(teams []*model.Team, value string) []string {
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	value = strings.ToLower(value)
+	var candidates []candidate
+
+	for _, team := range teams {
+		distance := editDistance(value, strings.ToLower(team.Name))
+		if displayDistance := editDistance(value, strings.ToLower(team.DisplayName)); displayDistance < distance {
+			distance = displayDistance
+		}
+		contains := strings.Contains(strings.ToLower(team.Name), value) || strings.Contains(strings.ToLower(team.DisplayName), value)
+
+		// Only suggest teams that are a plausible typo or a partial match
+		if contains || distance <= len(value)/2 {
+			candidates = append(candidates, candidate{name: fmt.Sprintf("%s (%s)", team.Name, team.DisplayName), distance: distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	var names []string
+	for i := 0; i < len(candidates) && i < maxTeamSuggestions; i++ {
+		names = append(names, candidates[i].name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+// SplitTeamNames splits a comma-separated list of team identifiers, dropping blanks
+func SplitTeamNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ResolveTeams resolves each of the supplied team identifiers, ignoring any that refer to a team already listed
+func ResolveTeams(mmClient *model.Client4, names []string) ([]*model.Team, error) {
+	seen := make(map[string]bool)
+	var teams []*model.Team
+	for _, name := range names {
+		team, err := ResolveTeam(mmClient, name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[team.Id] {
+			seen[team.Id] = true
+			teams = append(teams, team)
+		}
+	}
+	return teams, nil
+}
+
+// FetchUsersInAllTeams returns the members of every team.  See FetchUsersInTeamList for how the team names are recorded.
+func FetchUsersInAllTeams(mmClient *model.Client4, crawl CrawlOptions, merge bool) ([]*User, error) {
+
+	DebugPrint("In FetchUsersInAllTeams")
+
+	teams, err := GetAllTeams(mmClient)
+	if err != nil {
+		return nil, err
+	}
+
+	DebugPrint(fmt.Sprintf("Found %d teams", len(teams)))
+
+	return FetchUsersInTeamList(mmClient, teams, crawl, merge)
+}
+
+// FetchUsersInTeams returns the members of each of the named teams.  See FetchUsersInTeamList for how the team names
+// are recorded.
+func FetchUsersInTeams(mmClient *model.Client4, names []string, crawl CrawlOptions, merge bool) ([]*User, error) {
+
+	DebugPrint("In FetchUsersInTeams, for teams: " + strings.Join(names, ", "))
+
+	teams, err := ResolveTeams(mmClient, names)
+	if err != nil {
+		return nil, err
+	}
+
+	return FetchUsersInTeamList(mmClient, teams, crawl, merge)
+}
+
+// FetchUsersInTeamList returns the members of the supplied teams, with each user's TeamName set to the team they were
+// found in.  By default a user in several teams appears once per team.  If merge is set, each user appears once, with
+// TeamName holding a comma-separated list of all of their teams.
+func FetchUsersInTeamList(mmClient *model.Client4, teams []*model.Team, crawl CrawlOptions, merge bool) ([]*User, error) {
+
+	var userList []*User
+	merged := make(map[string]*User)
+
+	for _, team := range teams {
+		users, err := FetchTeamUsersByID(mmClient, team.Id, crawl)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			if !merge {
+				user.TeamName = TeamLabel(team, crawl.TeamDisplayNames)
+				userList = append(userList, user)
+				continue
+			}
+
+			if existing, found := merged[user.UserID]; found {
+				existing.TeamName += ", " + TeamLabel(team, crawl.TeamDisplayNames)
+				continue
+			}
+			user.TeamName = TeamLabel(team, crawl.TeamDisplayNames)
+			merged[user.UserID] = user
+			userList = append(userList, user)
+		}
+	}
+
+	return userList, nil
+}
+
+// StreamUsersInTeamList passes each page of the members of the supplied teams to emit, as the pages are fetched, with
+// each user's TeamName set to the team they were found in.  A user in several teams is emitted once per team, since
+// merging the teams would mean holding every user until the last team had been fetched.
+func StreamUsersInTeamList(mmClient *model.Client4, teams []*model.Team, crawl CrawlOptions, emit PageFunc) error {
+
+	for _, team := range teams {
+		teamName := TeamLabel(team, crawl.TeamDisplayNames)
+		err := StreamTeamUsersByID(mmClient, team.Id, crawl, func(users []*User) error {
+			for _, user := range users {
+				user.TeamName = teamName
+			}
+			return emit(users)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyUserTeams records every team each user is a member of, whichever team they were exported from, named as by
+// TeamLabel.  Users in no team are given an empty list.
+func ApplyUserTeams(mmClient *model.Client4, users []*User, displayNames bool) error {
+
+	DebugPrint(fmt.Sprintf("Retrieving team memberships for %d users", len(users)))
+
+	ctx := context.Background()
+	userTeams := make(map[string][]string)
+
+	for _, userID := range uniqueUserIDs(users) {
+		teams, response, err := mmClient.GetTeamsForUser(ctx, userID, "")
+
+		if err != nil {
+			LogMessage(ErrorLevel, "Error returned from GetTeamsForUser(): "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(ErrorLevel, "Bad HTTP response returned from GetTeamsForUser()")
+			return errors.New("failed to retrieve data from Mattermost")
+		}
+
+		names := []string{}
+		for _, team := range teams {
+			if team.DeleteAt == 0 {
+				names = append(names, TeamLabel(team, displayNames))
+			}
+		}
+		sort.Strings(names)
+		userTeams[userID] = names
+	}
+
+	noTeam, severalTeams := 0, 0
+	for _, teams := range userTeams {
+		switch {
+		case len(teams) == 0:
+			noTeam++
+		case len(teams) > 1:
+			severalTeams++
+		}
+	}
+	LogMessage(InfoLevel, fmt.Sprintf("Team memberships - %d users in no team, %d users in more than one team", noTeam, severalTeams))
+
+	for _, user := range users {
+		user.Teams = userTeams[user.UserID]
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package mmuserlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// FormatTemplate is the output format of a user template, set in OutputOptions.Template, which writes one line per
+// user rather than a table
+const FormatTemplate = "template"
+
+// templateFunctions returns the functions available to user templates, in addition to the text/template built-ins,
+// with dates rendered as in the other outputs
+func templateFunctions(dates DateOptions) template.FuncMap {
+	return template.FuncMap{
+		"join":  strings.Join,
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"date":  dates.Format,
+		"json": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}
+}
+
+// ParseUserTemplate parses a template for FormatTemplate output, which is executed with each user's User record (e.g.
+// {{.Username}}, {{.FullName}} or {{date .LastActivityAt}}).  The escapes \t and \n may be typed literally, as a
+// shell won't expand them.  As with message templates, the template is tried against an empty record, so that a
+// misspelt field is reported before the export starts.  The date function renders dates with the date options.
+func ParseUserTemplate(text string, dates DateOptions) (*template.Template, error) {
+
+	text = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(text)
+	line, err := template.New("user").Funcs(templateFunctions(dates)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := line.Execute(io.Discard, &User{}); err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// writeTemplateLine writes the template's output for one user, followed by a newline.  Nothing is written for a user
+// for whom the template renders nothing, so a template can also choose which users to list.
+func writeTemplateLine(out io.Writer, user *User, output OutputOptions) error {
+
+	var line strings.Builder
+	if err := output.Template.Execute(&line, user); err != nil {
+		LogMessage(ErrorLevel, "Failed to apply the template for user '"+user.Username+"': "+err.Error())
+		return err
+	}
+	if line.Len() == 0 {
+		return nil
+	}
+	text := line.String()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err := io.WriteString(out, text)
+	return err
+}
+
+// WriteUsersWithTemplate writes one line per user, rendered from the template in the output options
+func WriteUsersWithTemplate(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data with a template")
+
+	if output.Template == nil {
+		return errors.New("no template was supplied for template output")
+	}
+
+	buffered := bufio.NewWriter(out)
+	for _, user := range users {
+		if err := writeTemplateLine(buffered, user, output); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,65 @@
+package mmuserlist
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions describes how the server's certificate is checked, and the client certificate (if any) presented to it
+type TLSOptions struct {
+	// CACertFile is a PEM bundle of CA certificates trusted in addition to the system roots, e.g. an internal CA
+	CACertFile string
+	// ClientCertFile and ClientKeyFile are a PEM certificate and key presented to servers that require mutual TLS
+	ClientCertFile string
+	ClientKeyFile  string
+	// InsecureSkipVerify accepts any server certificate.  It should only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// LoadTLSConfig builds the TLS configuration for the options.  It returns nil if the options leave the defaults in
+// place.
+func LoadTLSConfig(options TLSOptions) (*tls.Config, error) {
+
+	if options == (TLSOptions{}) {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+
+	if options.CACertFile != "" {
+		DebugPrint("Loading CA certificates from: " + options.CACertFile)
+		pem, err := os.ReadFile(options.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", options.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (options.ClientCertFile == "") != (options.ClientKeyFile == "") {
+		return nil, errors.New("a client certificate and its key must be supplied together")
+	}
+	if options.ClientCertFile != "" {
+		DebugPrint("Loading client certificate from: " + options.ClientCertFile)
+		certificate, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if options.InsecureSkipVerify {
+		LogMessage(WarningLevel, "TLS certificate verification is disabled - the server's identity is not being checked")
+	}
+
+	return config, nil
+}
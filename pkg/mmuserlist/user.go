@@ -0,0 +1,124 @@
+package mmuserlist
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// User is a Mattermost user, as exported.  Fields beyond the basic profile are only populated by the corresponding
+// enrichment (e.g. ApplyLastActivity or ApplyClientUsage).
+type User struct {
+	UserID                string
+	Username              string
+	Email                 string
+	FirstName             string
+	LastName              string
+	Nickname              string
+	Position              string
+	Locale                string
+	Timezone              string
+	IsBotAccount          bool
+	IsGuest               bool
+	AuthService           string
+	AuthData              string
+	FailedAttempts        int
+	SystemRoles           string
+	Roles                 []string
+	UserCreatedAt         time.Time
+	DeactivatedAt         time.Time
+	LastActivityAt        time.Time
+	LastLoginAt           time.Time
+	DaysSinceLastActivity int
+	LastPostAt            time.Time
+	DaysSinceLastPost     *int
+	TotalPosts            int
+	TeamName              string
+	TeamJoinedAt          time.Time
+	Teams                 []string
+	GuestChannels         []string
+	LastClient            string
+	LastClientVersion     string
+	LastClientPlatform    string
+	SessionCount          int
+	LastSessionAt         time.Time
+	SessionClients        []string
+	ChannelRole           string
+	ChannelLastViewedAt   time.Time
+	ChannelMsgCount       int64
+	ChannelCount          int
+	RolesChangedAt        time.Time
+	RolesChangedBy        string
+	RolesChangedTo        string
+	Props                 map[string]string
+}
+
+// AuthMethodEmail is the auth method of accounts that sign in with an email address (or username) and password, which
+// have no auth service recorded
+const AuthMethodEmail = "email"
+
+// AuthMethod returns how the user signs in: 'email' for a password account, otherwise the SSO service (e.g. ldap,
+// saml or gitlab)
+func (u *User) AuthMethod() string {
+	if u.AuthService == "" {
+		return AuthMethodEmail
+	}
+	return u.AuthService
+}
+
+// FullName returns the user's first and last names, separated by a space
+func (u *User) FullName() string {
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// buildUserList converts the users retrieved from Mattermost into the records used for output, optionally dropping bots.
+// Deactivated users are included, with DeactivatedAt set; FilterDeactivated removes them.
+// Last activity isn't part of the user record, so it is populated separately by ApplyLastActivity.
+func buildUserList(allUsers []*model.User, includeBots bool) []*User {
+
+	var userList []*User
+
+	for _, mmUser := range allUsers {
+		if mmUser.IsBot && !includeBots {
+			continue
+		}
+		userCreatedTime := time.Unix(0, mmUser.CreateAt*int64(time.Millisecond))
+		var deactivatedTime time.Time
+		if mmUser.DeleteAt > 0 {
+			deactivatedTime = time.UnixMilli(mmUser.DeleteAt)
+		}
+		// The last login is only reported by the user reporting API, used for cursor pagination
+		var lastLoginTime time.Time
+		if mmUser.LastLogin > 0 {
+			lastLoginTime = time.UnixMilli(mmUser.LastLogin)
+		}
+
+		user := &User{
+			UserID:         mmUser.Id,
+			Username:       mmUser.Username,
+			Email:          mmUser.Email,
+			FirstName:      mmUser.FirstName,
+			LastName:       mmUser.LastName,
+			Nickname:       mmUser.Nickname,
+			Position:       mmUser.Position,
+			Locale:         mmUser.Locale,
+			Timezone:       model.GetPreferredTimezone(mmUser.Timezone),
+			IsBotAccount:   mmUser.IsBot,
+			IsGuest:        mmUser.IsGuest(),
+			AuthService:    mmUser.AuthService,
+			AuthData:       model.SafeDereference(mmUser.AuthData),
+			FailedAttempts: mmUser.FailedAttempts,
+			SystemRoles:    mmUser.Roles,
+			UserCreatedAt:  userCreatedTime,
+			DeactivatedAt:  deactivatedTime,
+			LastLoginAt:    lastLoginTime,
+			TeamName:       "",
+			Props:          mmUser.Props,
+		}
+
+		userList = append(userList, user)
+	}
+
+	return userList
+}
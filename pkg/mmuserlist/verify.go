@@ -0,0 +1,89 @@
+package mmuserlist
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// crawlCounter tallies the users returned by a crawl, before any bots or deactivated users are dropped
+type crawlCounter struct {
+	seen       map[string]bool
+	active     int64
+	duplicates int
+}
+
+func newCrawlCounter() *crawlCounter {
+	return &crawlCounter{seen: make(map[string]bool)}
+}
+
+// count records a page of users.  A user returned on more than one page is counted once, and noted as a duplicate.
+func (c *crawlCounter) count(users []*model.User) {
+	for _, user := range users {
+		if c.seen[user.Id] {
+			c.duplicates++
+			continue
+		}
+		c.seen[user.Id] = true
+		if user.DeleteAt == 0 {
+			c.active++
+		}
+	}
+}
+
+// countPages wraps a page function so that each page is counted, and reported to progress if set, before it is passed
+// on
+func (c *crawlCounter) countPages(progress func(users int), emit func(users []*model.User) error) func(users []*model.User) error {
+	return func(users []*model.User) error {
+		c.count(users)
+		if progress != nil {
+			progress(len(users))
+		}
+		return emit(users)
+	}
+}
+
+// warnDuplicates reports users returned more than once by a crawl, which shows that pages shifted under it
+func (c *crawlCounter) warnDuplicates(description string) {
+	if c.duplicates > 0 {
+		LogMessage(WarningLevel, fmt.Sprintf("INCOMPLETE DATA? %d users were returned more than once while fetching %s - membership changed during the crawl, so other users may have been skipped.  Re-run the export, or use cursor pagination", c.duplicates, description))
+	}
+}
+
+// verifyTeamCount compares the active members found by a team crawl with the team's active member count.  If the
+// statistics can't be read, the check is skipped with a warning, since the crawl itself succeeded.
+func verifyTeamCount(mmClient *model.Client4, teamID string, counter *crawlCounter) {
+
+	description := "the members of team " + teamID
+	counter.warnDuplicates(description)
+
+	stats, response, err := mmClient.GetTeamStats(context.Background(), teamID, "")
+	if err != nil || response.StatusCode != 200 {
+		LogMessage(WarningLevel, "Couldn't read the statistics for team "+teamID+" - the user count can't be verified")
+		return
+	}
+
+	DebugPrint(fmt.Sprintf("Verifying team %s: fetched %d active members, server reports %d", teamID, counter.active, stats.ActiveMemberCount))
+	if counter.active != stats.ActiveMemberCount {
+		LogMessage(WarningLevel, fmt.Sprintf("INCOMPLETE DATA? Fetched %d active members of team %s, but the server reports %d.  Users may have been skipped (or counted twice) while membership changed during the crawl.  Re-run the export, or use cursor pagination", counter.active, teamID, stats.ActiveMemberCount))
+	}
+}
+
+// verifyNoTeamCount checks a crawl of the users without a team.  The server keeps no statistics for those users, so
+// the only check possible is that the crawl didn't find more active users than the server has in total.
+func verifyNoTeamCount(mmClient *model.Client4, counter *crawlCounter) {
+
+	counter.warnDuplicates("the users without a team")
+
+	stats, response, err := mmClient.GetTotalUsersStats(context.Background(), "")
+	if err != nil || response.StatusCode != 200 {
+		LogMessage(WarningLevel, "Couldn't read the server's user statistics - the user count can't be verified")
+		return
+	}
+
+	DebugPrint(fmt.Sprintf("Verifying users without a team: fetched %d active users, server reports %d in total", counter.active, stats.TotalUsersCount))
+	if counter.active > stats.TotalUsersCount {
+		LogMessage(WarningLevel, fmt.Sprintf("INCOMPLETE DATA? Fetched %d active users without a team, but the server reports only %d active users in total.  The pages shifted during the crawl.  Re-run the export, or use cursor pagination", counter.active, stats.TotalUsersCount))
+	}
+}
@@ -0,0 +1,181 @@
+package mmuserlist
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Workbook layout
+const (
+	xlsxSheetName  = "Users"
+	xlsxDateFormat = "yyyy-mm-dd"
+	xlsxColWidth   = 18
+
+	// With branding, the logo and title take the first row, above a blank row
+	xlsxBrandingRows   = 2
+	xlsxLogoHeight     = 48.0 // pixels
+	xlsxTitleRowHeight = 38.0 // points
+)
+
+// WriteUsersToXLSX writes the users as an Excel workbook, with the same columns as the CSV output.  Dates are stored
+// as date cells, so they aren't reinterpreted by the locale of whoever opens the file, and the header row is frozen
+// with an auto-filter applied.  Branding puts the organization's logo and title above the header row and its footer
+// text in the printed page footer.
+func WriteUsersToXLSX(out io.Writer, users []*User, output OutputOptions) error {
+
+	DebugPrint("Writing data as XLSX")
+
+	columns := userColumns(users, output)
+
+	workbook := excelize.NewFile()
+	defer workbook.Close()
+
+	if err := workbook.SetSheetName("Sheet1", xlsxSheetName); err != nil {
+		return err
+	}
+
+	headerStyle, err := workbook.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#D9E1F2"}},
+	})
+	if err != nil {
+		return err
+	}
+	dateFormat := xlsxDateFormat
+	dateStyle, err := workbook.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return err
+	}
+
+	headerRow := 1
+	if output.Branding != nil && (output.Branding.Title != "" || output.Branding.hasLogo()) {
+		if err := writeXLSXBranding(workbook, output.Branding); err != nil {
+			return err
+		}
+		headerRow += xlsxBrandingRows
+	}
+	if output.Branding != nil && output.Branding.Footer != "" {
+		// '&' introduces a control code in header and footer text
+		footer := strings.ReplaceAll(output.Branding.Footer, "&", "&&")
+		if err := workbook.SetHeaderFooter(xlsxSheetName, &excelize.HeaderFooterOptions{OddFooter: "&L" + footer + "&RPage &P of &N"}); err != nil {
+			return err
+		}
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column.Header
+	}
+	headerCell, _ := excelize.CoordinatesToCellName(1, headerRow)
+	if err := workbook.SetSheetRow(xlsxSheetName, headerCell, &header); err != nil {
+		return err
+	}
+
+	for rowIndex, user := range users {
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			value := column.Value(user)
+			if n, isOptional := value.(*int); isOptional {
+				value = nil
+				if n != nil {
+					value = *n
+				}
+			} else if t, isTime := value.(time.Time); isTime && t.IsZero() {
+				value = nil
+			} else if isTime {
+				// Excel dates have no time zone, so the date is written as it reads in the output's time zone
+				local := t.In(output.Dates.location())
+				value = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+			}
+			row[i] = value
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, headerRow+rowIndex+1)
+		if err := workbook.SetSheetRow(xlsxSheetName, cell, &row); err != nil {
+			LogMessage(ErrorLevel, "Failed to write record for user '"+user.Username+"' to XLSX file")
+			return err
+		}
+	}
+
+	lastColumn, _ := excelize.ColumnNumberToName(len(columns))
+	lastRow := headerRow + len(users)
+	firstCell, _ := excelize.CoordinatesToCellName(1, headerRow)
+	lastCell, _ := excelize.CoordinatesToCellName(len(columns), lastRow)
+
+	// Number formats and widths are applied per column after the data, since time values get a default
+	// date-time format as they are written
+	for i, column := range columns {
+		name, _ := excelize.ColumnNumberToName(i + 1)
+		if len(users) > 0 {
+			if _, isTime := column.Value(users[0]).(time.Time); isTime {
+				if err := workbook.SetCellStyle(xlsxSheetName, name+strconv.Itoa(headerRow+1), name+strconv.Itoa(lastRow), dateStyle); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := workbook.SetColWidth(xlsxSheetName, "A", lastColumn, xlsxColWidth); err != nil {
+		return err
+	}
+	if err := workbook.SetCellStyle(xlsxSheetName, firstCell, lastColumn+strconv.Itoa(headerRow), headerStyle); err != nil {
+		return err
+	}
+
+	if err := workbook.SetPanes(xlsxSheetName, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      headerRow,
+		TopLeftCell: "A" + strconv.Itoa(headerRow+1),
+		ActivePane:  "bottomLeft",
+	}); err != nil {
+		return err
+	}
+	if err := workbook.AutoFilter(xlsxSheetName, firstCell+":"+lastCell, nil); err != nil {
+		return err
+	}
+
+	return workbook.Write(out)
+}
+
+// writeXLSXBranding puts the logo, scaled to the height of the first row, and the title at the top of the sheet
+func writeXLSXBranding(workbook *excelize.File, branding *Branding) error {
+
+	if err := workbook.SetRowHeight(xlsxSheetName, 1, xlsxTitleRowHeight); err != nil {
+		return err
+	}
+
+	titleCell := "A1"
+	if branding.hasLogo() {
+		scale := xlsxLogoHeight / float64(branding.logoHeight)
+		if err := workbook.AddPictureFromBytes(xlsxSheetName, "A1", &excelize.Picture{
+			Extension: branding.logoExtension,
+			File:      branding.logo,
+			Format:    &excelize.GraphicOptions{ScaleX: scale, ScaleY: scale, OffsetX: 2, OffsetY: 1, Positioning: "oneCell"},
+		}); err != nil {
+			return err
+		}
+		// Leave enough columns clear of the logo for the title
+		logoColumns := int(float64(branding.logoWidth)*scale/(xlsxColWidth*7)) + 1
+		titleCell, _ = excelize.CoordinatesToCellName(logoColumns+1, 1)
+	}
+
+	if branding.Title != "" {
+		titleStyle, err := workbook.NewStyle(&excelize.Style{
+			Font:      &excelize.Font{Bold: true, Size: 16},
+			Alignment: &excelize.Alignment{Vertical: "center"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := workbook.SetCellStr(xlsxSheetName, titleCell, branding.Title); err != nil {
+			return err
+		}
+		if err := workbook.SetCellStyle(xlsxSheetName, titleCell, titleCell, titleStyle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
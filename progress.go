@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// progressVersion identifies the format of the progress events.  Fields may be added, but it is incremented if an
+// existing field changes meaning or is removed.
+const progressVersion = 1
+
+// Progress events are written whenever the stage changes, as pages arrive (at most once a second) and otherwise every
+// few seconds, so that a long lookup isn't mistaken for a stalled export
+const (
+	progressPageInterval      = time.Second
+	progressHeartbeatInterval = 5 * time.Second
+)
+
+// The stages an export passes through, as reported in the progress events
+const (
+	stageStarting     = "starting"
+	stageFetching     = "fetching"
+	stageFiltering    = "filtering"
+	stageEnriching    = "enriching"
+	stageWriting      = "writing"
+	stageDeactivating = "deactivating"
+	stageNotifying    = "notifying"
+	stageDone         = "done"
+	stageFailed       = "failed"
+)
+
+// progressEvent is one line of the progress stream.  Every field is always present, with null for values not yet
+// known, so that consumers can rely on the shape of each event.
+type progressEvent struct {
+	Version        int      `json:"version"`
+	Time           string   `json:"time"`
+	Stage          string   `json:"stage"`
+	PagesDone      int      `json:"pages_done"`
+	UsersFetched   int      `json:"users_fetched"`
+	UsersExpected  *int64   `json:"users_expected"`
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+	ETASeconds     *float64 `json:"eta_seconds"`
+	ExitCode       *int     `json:"exit_code"`
+}
+
+// progressReporter writes progress events for an export.  A nil reporter, used when 'progress-json' isn't set,
+// ignores every call.
+type progressReporter struct {
+	mutex        sync.Mutex
+	closer       io.Closer
+	encoder      *json.Encoder
+	started      time.Time
+	fetchStarted time.Time
+	lastEvent    time.Time
+	stage        string
+	pages        int
+	users        int
+	expected     int64
+	stop         chan struct{}
+}
+
+// openProgressOutput opens the destination for the progress events: an inherited file descriptor if the target is a
+// number, otherwise a file (or named pipe).  Only a file opened here needs closing afterwards.
+func openProgressOutput(target string) (io.Writer, io.Closer, error) {
+	if fd, err := strconv.Atoi(target); err == nil {
+		file := os.NewFile(uintptr(fd), "progress")
+		if file == nil {
+			return nil, nil, os.ErrInvalid
+		}
+		if _, err := file.Stat(); err != nil {
+			return nil, nil, err
+		}
+		return file, nil, nil
+	}
+
+	file, err := os.Create(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file, nil
+}
+
+// newProgressReporter starts writing progress events to the 'progress-json' target, beginning with a 'starting' event
+func newProgressReporter(target string) (*progressReporter, error) {
+
+	output, closer, err := openProgressOutput(target)
+	if err != nil {
+		return nil, err
+	}
+
+	reporter := &progressReporter{
+		closer:   closer,
+		encoder:  json.NewEncoder(output),
+		started:  time.Now(),
+		stage:    stageStarting,
+		expected: -1,
+		stop:     make(chan struct{}),
+	}
+
+	reporter.mutex.Lock()
+	reporter.emit()
+	reporter.mutex.Unlock()
+
+	go reporter.heartbeat()
+	return reporter, nil
+}
+
+// heartbeat writes an event every few seconds if nothing else has been written, until the reporter is finished
+func (p *progressReporter) heartbeat() {
+	ticker := time.NewTicker(progressHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mutex.Lock()
+			if time.Since(p.lastEvent) >= progressHeartbeatInterval {
+				p.emit()
+			}
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// event describes the current state.  The caller must hold the mutex.
+func (p *progressReporter) event() progressEvent {
+	now := time.Now()
+	event := progressEvent{
+		Version:        progressVersion,
+		Time:           now.UTC().Format(time.RFC3339),
+		Stage:          p.stage,
+		PagesDone:      p.pages,
+		UsersFetched:   p.users,
+		ElapsedSeconds: now.Sub(p.started).Seconds(),
+	}
+	if p.expected >= 0 {
+		expected := p.expected
+		event.UsersExpected = &expected
+	}
+	if eta, known := p.eta(now); known {
+		event.ETASeconds = &eta
+	}
+	return event
+}
+
+// emit writes an event for the current state.  The caller must hold the mutex.
+func (p *progressReporter) emit() {
+	p.write(p.event())
+}
+
+// write writes an event.  The caller must hold the mutex.  A failure to write is logged once, after which the stream
+// is abandoned, since progress reporting mustn't stop the export.
+func (p *progressReporter) write(event progressEvent) {
+	if p.encoder == nil {
+		return
+	}
+	if err := p.encoder.Encode(event); err != nil {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "Failed to write a progress event - no more will be written: "+err.Error())
+		p.encoder = nil
+	}
+	p.lastEvent = time.Now()
+}
+
+// eta estimates the seconds left to fetch the remaining users, from the rate at which users have been fetched so far.
+// It is only known while fetching, once at least one user has been fetched, and when the number expected is known.
+func (p *progressReporter) eta(now time.Time) (float64, bool) {
+	if p.stage != stageFetching || p.expected < 0 || p.users == 0 {
+		return 0, false
+	}
+	remaining := p.expected - int64(p.users)
+	if remaining <= 0 {
+		return 0, true
+	}
+	perUser := now.Sub(p.fetchStarted).Seconds() / float64(p.users)
+	return perUser * float64(remaining), true
+}
+
+// setExpected records the number of users the crawl is expected to fetch, so that an ETA can be given
+func (p *progressReporter) setExpected(users int64) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.expected = users
+}
+
+// setStage moves the export on to a new stage, writing an event straight away
+func (p *progressReporter) setStage(stage string) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if stage == stageFetching && p.fetchStarted.IsZero() {
+		p.fetchStarted = time.Now()
+	}
+	p.stage = stage
+	p.emit()
+}
+
+// page records a page of users fetched by the crawl, writing an event unless one was written very recently
+func (p *progressReporter) page(users int) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.pages++
+	p.users += users
+	if time.Since(p.lastEvent) >= progressPageInterval {
+		p.emit()
+	}
+}
+
+// finish writes the final event, with the export's exit code, and stops the reporter
+func (p *progressReporter) finish(exitCode int) {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stage = stageDone
+	if exitCode != 0 {
+		p.stage = stageFailed
+	}
+	event := p.event()
+	event.ExitCode = &exitCode
+	p.write(event)
+
+	if p.closer != nil {
+		p.closer.Close()
+	}
+}
+
+// expectedCrawlUsers returns the number of users a crawl of the requested teams should fetch, from the teams'
+// statistics, or -1 if it can't be known in advance (as for users without a team, channels and source expressions)
+func expectedCrawlUsers(mmClient *model.Client4, opts *cliOptions) int64 {
+
+	if opts.Channel != "" || opts.Source != "" || opts.NotInTeam {
+		return -1
+	}
+
+	var teams []*model.Team
+	var err error
+	if opts.AllTeams {
+		teams, err = mmuserlist.GetAllTeams(mmClient)
+	} else {
+		teams, err = mmuserlist.ResolveTeams(mmClient, mmuserlist.SplitTeamNames(opts.MattermostTeam))
+	}
+	if err != nil {
+		mmuserlist.DebugPrint("Couldn't resolve the teams, so no ETA can be given: " + err.Error())
+		return -1
+	}
+
+	var expected int64
+	for _, team := range teams {
+		stats, response, err := mmClient.GetTeamStats(context.Background(), team.Id, "")
+		if err != nil || response.StatusCode != 200 {
+			mmuserlist.DebugPrint("Couldn't read the statistics for team " + team.Name + ", so no ETA can be given")
+			return -1
+		}
+		expected += stats.TotalMemberCount
+	}
+	return expected
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// cronField is the set of values one field of a cron expression matches
+type cronField map[int]bool
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minutes  cronField
+	hours    cronField
+	days     cronField
+	months   cronField
+	weekdays cronField
+
+	// Standard cron matches either day field when both are restricted, rather than requiring both
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+// cronMacros are the shorthand schedules accepted in place of a full expression
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+var cronWeekdayNames = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// parseCronValue parses a single value, which may be one of the names (e.g. MON or JAN), numbered from offset
+func parseCronValue(value string, names []string, offset int) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return i + offset, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a number", value)
+	}
+	return number, nil
+}
+
+// parseCronField parses one field of a cron expression: '*', a value, a range such as 1-5, any of those with a step
+// such as */15, or a comma-separated list of them
+func parseCronField(field string, minimum int, maximum int, names []string, offset int) (cronField, error) {
+
+	values := make(cronField)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("'%s' has an invalid step", part)
+			}
+		}
+
+		first, last := minimum, maximum
+		if rangePart != "*" {
+			start, end, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if first, err = parseCronValue(start, names, offset); err != nil {
+				return nil, err
+			}
+			last = first
+			if isRange {
+				if last, err = parseCronValue(end, names, offset); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				last = maximum
+			}
+		}
+		if first < minimum || last > maximum || first > last {
+			return nil, fmt.Errorf("'%s' is outside %d-%d", part, minimum, maximum)
+		}
+
+		for value := first; value <= last; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// parseCronSchedule parses a cron expression of five fields (minute, hour, day of month, month and day of week), such
+// as '0 6 * * MON', or one of the macros such as '@daily'.  Sunday is day 0 or 7.
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+
+	if macro, found := cronMacros[strings.ToLower(strings.TrimSpace(expression))]; found {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New("a schedule needs five fields: minute, hour, day of month, month and day of week")
+	}
+
+	schedule := &cronSchedule{
+		daysRestricted:     fields[2] != "*",
+		weekdaysRestricted: fields[4] != "*",
+	}
+	parsers := []struct {
+		name     string
+		target   *cronField
+		min, max int
+		names    []string
+		offset   int
+	}{
+		{"minute", &schedule.minutes, 0, 59, nil, 0},
+		{"hour", &schedule.hours, 0, 23, nil, 0},
+		{"day of month", &schedule.days, 1, 31, nil, 0},
+		{"month", &schedule.months, 1, 12, cronMonthNames, 1},
+		{"day of week", &schedule.weekdays, 0, 7, cronWeekdayNames, 0},
+	}
+	for i, parser := range parsers {
+		values, err := parseCronField(fields[i], parser.min, parser.max, parser.names, parser.offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", parser.name, err)
+		}
+		*parser.target = values
+	}
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+
+	return schedule, nil
+}
+
+// matchesDay reports whether the schedule runs on the given date
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[int(t.Weekday())]
+	if s.daysRestricted && s.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// Next returns the first time after t at which the schedule runs, in t's time zone.  A schedule that can never run
+// (such as 30 February) returns the zero time.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+
+	next := t.Truncate(time.Minute).Add(time.Minute)
+
+	// Five years covers every leap year combination, so a schedule that hasn't run by then never will
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		if !s.months[int(next.Month())] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !s.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !s.hours[next.Hour()] {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if !s.minutes[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+// scheduleTimestampFormat is added to the output file name of each scheduled export.  It sorts in date order and
+// avoids characters, such as ':', that aren't allowed in Windows file names.
+const scheduleTimestampFormat = "2006-01-02-1504"
+
+// timestampedPath adds a timestamp to a file name, before its extension, e.g. users.csv becomes
+// users-2024-01-08-0600.csv, or users.csv.gz becomes users-2024-01-08-0600.csv.gz
+func timestampedPath(path string, t time.Time, format string) string {
+	extension := filepath.Ext(path)
+	if extension == mmuserlist.GzipSuffix {
+		extension = filepath.Ext(strings.TrimSuffix(path, extension)) + extension
+	}
+	return strings.TrimSuffix(path, extension) + "-" + t.Format(format) + extension
+}
+
+// commandLineSettings returns the parameters given on the command line, other than the one named, so that each
+// scheduled or served export can start from a fresh flag set and resolve the config file and environment again
+func commandLineSettings(fs *flag.FlagSet, except string) map[string]string {
+	settings := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != except {
+			settings[f.Name] = f.Value.String()
+		}
+	})
+	return settings
+}
+
+// newExportFlagSet prepares the flag set and options for one scheduled or served export
+func newExportFlagSet(name string, settings map[string]string) (*flag.FlagSet, *cliOptions, error) {
+	var opts cliOptions
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	registerFlags(fs, &opts)
+	fs.Usage = func() {}
+
+	for name, value := range settings {
+		if err := fs.Set(name, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid value '%s' for '%s': %w", value, name, err)
+		}
+	}
+	return fs, &opts, nil
+}
+
+// validateSchedule checks that an export can be repeated unattended: nothing may wait for an answer at the terminal,
+// and the output must go to a file that can be given a timestamp.  It returns the resolved options.
+func validateSchedule(settings map[string]string) (*cliOptions, error) {
+
+	fs, opts, err := newExportFlagSet("schedule", settings)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := resolveConfig(fs); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	if opts.CSVFile == "" || opts.CSVFile == mmuserlist.StdoutPath {
+		problems = append(problems, "an output 'file' is required, since each export is written to a timestamped copy of it")
+	}
+	if opts.TokenFile == mmuserlist.StdoutPath {
+		problems = append(problems, "the token can't be read from standard input more than once")
+	}
+	if opts.LoginID != "" && opts.Password == "" {
+		problems = append(problems, "'login-id' needs a 'password', since there's no one to prompt")
+	}
+	if opts.ConfirmDiff {
+		problems = append(problems, "'confirm-diff' would wait for an answer")
+	}
+	if (opts.DeactivateAfter >= 0 || opts.NotifyInactive >= 0) && !opts.AssumeYes && !opts.DryRun {
+		problems = append(problems, "'deactivate-after' and 'notify-inactive' need 'yes' or 'dry-run', since there's no one to confirm")
+	}
+	if opts.Estimate {
+		problems = append(problems, "'estimate' doesn't produce a report")
+	}
+	if opts.TUI {
+		problems = append(problems, "'tui' would wait for someone to quit it")
+	}
+	if len(problems) > 0 {
+		return nil, errors.New("the export can't be scheduled: " + strings.Join(problems, "; "))
+	}
+	return opts, nil
+}
+
+// runScheduled implements 'schedule': the process stays resident and runs the export described by the rest of the
+// command line at each time the cron expression matches, writing each report to a timestamped copy of the output
+// file.  A failed export is logged and the schedule carries on.  It returns the process exit code once interrupted.
+func runScheduled(fs *flag.FlagSet, expression string) int {
+
+	schedule, err := parseCronSchedule(expression)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'schedule' is not valid: "+err.Error())
+		return 1
+	}
+
+	settings := commandLineSettings(fs, "schedule")
+	opts, err := validateSchedule(settings)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	if err := applyLoggingOptions(opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	outputFile := opts.CSVFile
+
+	if opts.MetricsAddress != "" {
+		metrics, err := newMetricsCollector(settings, opts)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+			return 1
+		}
+		stopMetrics, err := serveMetrics(opts.MetricsAddress, metrics)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to serve the metrics on "+opts.MetricsAddress+": "+err.Error())
+			return 1
+		}
+		defer stopMetrics()
+	}
+
+	// An interrupt only stops the schedule between exports, so that a report is never left half written
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Running on the schedule '%s' - Version: %s", expression, Version))
+
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The schedule '"+expression+"' never runs")
+			return 1
+		}
+		mmuserlist.LogMessage(mmuserlist.InfoLevel, "Next export at "+next.Format("Mon 2006-01-02 15:04 MST"))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			mmuserlist.LogMessage(mmuserlist.InfoLevel, "Schedule stopped")
+			return 0
+		case <-timer.C:
+		}
+
+		// The settings are applied to a fresh flag set each time, so that the config file and environment are read
+		// again and any change to them is picked up by the next export
+		runSettings := make(map[string]string, len(settings)+1)
+		for name, value := range settings {
+			runSettings[name] = value
+		}
+		// A SQLite database or an appended CSV file keeps the history of every run, so it is added to rather than
+		// written anew, and a file name with a date pattern already names each run's file
+		runFile := outputFile
+		if opts.Format != mmuserlist.FormatSQLite && !opts.Append && mmuserlist.ExpandFileName(outputFile, next) == outputFile {
+			runFile = timestampedPath(outputFile, next, scheduleTimestampFormat)
+		}
+		runSettings["file"] = runFile
+
+		runFS, opts, err := newExportFlagSet("schedule", runSettings)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Scheduled export not run: "+err.Error())
+			continue
+		}
+
+		opts.sharedLogging = true
+
+		started := time.Now()
+		exitCode := runExport(runFS, opts)
+		if exitCode != 0 {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, fmt.Sprintf("Scheduled export to %s failed with exit code %d", runFile, exitCode))
+		} else {
+			mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Scheduled export to %s finished in %s", runFile, time.Since(started).Round(time.Second)))
+		}
+
+		select {
+		case <-stop:
+			mmuserlist.LogMessage(mmuserlist.InfoLevel, "Schedule stopped")
+			return 0
+		default:
+		}
+	}
+}
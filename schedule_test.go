@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronScheduleNext(t *testing.T) {
+
+	// A Monday
+	start := time.Date(2024, 1, 8, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 8, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 8, 10, 30, 0, 0, time.UTC)},
+		{"0 6 * * *", time.Date(2024, 1, 9, 6, 0, 0, 0, time.UTC)},
+		{"0 6 * * MON", time.Date(2024, 1, 15, 6, 0, 0, 0, time.UTC)},
+		{"0 6 * * fri", time.Date(2024, 1, 12, 6, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, 1, 9, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 JUN *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 8, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+
+		// With both day fields restricted, either matching is enough, as in standard cron
+		{"0 0 20 * MON", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+
+		// A schedule that can never run
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		schedule, err := parseCronSchedule(test.expression)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q) returned an error: %v", test.expression, err)
+			continue
+		}
+		if next := schedule.Next(start); !next.Equal(test.expected) {
+			t.Errorf("parseCronSchedule(%q).Next() = %v, expected %v", test.expression, next, test.expected)
+		}
+	}
+}
+
+func TestCronScheduleNextKeepsTimeZone(t *testing.T) {
+
+	zone := time.FixedZone("EST", -5*60*60)
+	schedule, err := parseCronSchedule("0 6 * * *")
+	if err != nil {
+		t.Fatalf("parseCronSchedule() returned an error: %v", err)
+	}
+
+	next := schedule.Next(time.Date(2024, 1, 8, 7, 0, 0, 0, zone))
+	if expected := time.Date(2024, 1, 9, 6, 0, 0, 0, zone); !next.Equal(expected) || next.Location() != zone {
+		t.Errorf("Next() = %v, expected %v", next, expected)
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * FOO *",
+		"@fortnightly",
+	} {
+		if _, err := parseCronSchedule(expression); err == nil {
+			t.Errorf("parseCronSchedule(%q) should have returned an error", expression)
+		}
+	}
+}
+
+func TestTimestampedPath(t *testing.T) {
+
+	at := time.Date(2024, 1, 8, 6, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"users.csv":           "users-2024-01-08-0600.csv",
+		"users.csv.gz":        "users-2024-01-08-0600.csv.gz",
+		"reports/users.jsonl": "reports/users-2024-01-08-0600.jsonl",
+		"users":               "users-2024-01-08-0600",
+	}
+	for path, expected := range tests {
+		if got := timestampedPath(path, at, scheduleTimestampFormat); got != expected {
+			t.Errorf("timestampedPath(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+}
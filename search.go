@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// printUserTable writes a compact, human-readable list of users to stdout, with dates rendered with the date options
+func printUserTable(users []*mmuserlist.User, dates mmuserlist.DateOptions) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "USERNAME\tEMAIL\tNAME\tLAST ACTIVITY\tDAYS INACTIVE")
+	for _, user := range users {
+		fmt.Fprintf(writer, "%s\t%s\t%s %s\t%s\t%d\n",
+			user.Username,
+			user.Email,
+			user.FirstName,
+			user.LastName,
+			dates.Format(user.LastActivityAt),
+			user.DaysSinceLastActivity)
+	}
+	writer.Flush()
+}
+
+// runUsersCommand implements the 'users' subcommand and returns the process exit code
+func runUsersCommand(args []string) int {
+
+	if len(args) == 0 || args[0] != "search" {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Usage: mm-user-list users search [options] <term>")
+		return 1
+	}
+
+	var opts cliOptions
+
+	fs := flag.NewFlagSet("users search", flag.ExitOnError)
+	registerFlags(fs, &opts)
+	fs.Parse(args[1:])
+
+	if _, err := resolveConfig(fs); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	if err := applyLoggingOptions(&opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+
+	cliErrors := !opts.validateConnection()
+	if fs.NArg() != 1 {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Exactly one search term must be supplied")
+		cliErrors = true
+	}
+	if err := applyDateOptions(&opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		cliErrors = true
+	}
+	applyFileName(&opts)
+	applyCompression(&opts)
+	if err := applyCSVOptions(&opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		cliErrors = true
+	}
+	if err := opts.loadBranding(); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The report branding could not be loaded: "+err.Error())
+		cliErrors = true
+	}
+	if cliErrors {
+		fs.Usage()
+		return 1
+	}
+
+	mmClient, err := opts.connect()
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to connect to Mattermost.  Error: "+err.Error())
+		return 2
+	}
+
+	users, err := mmuserlist.SearchUsers(mmClient, fs.Arg(0), opts.MattermostTeam, opts.IncludeBots)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Search failed.  Error: "+err.Error())
+		return 2
+	}
+
+	if len(users) == 0 {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "No users matched the search term")
+		return 0
+	}
+
+	// Without an output file the matches are simply listed, which suits quick lookups
+	if opts.CSVFile == "" {
+		printUserTable(users, opts.dates)
+		return 0
+	}
+
+	if err := mmuserlist.WriteUsers(users, opts.CSVFile, opts.output()); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+		return 4
+	}
+	mmuserlist.LogSummary(fmt.Sprintf("Search complete - %d users written to %s", len(users), opts.outputName()))
+
+	return 0
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// serveShutdownTimeout limits how long an interrupted server waits for a request in progress to finish
+const serveShutdownTimeout = 5 * time.Minute
+
+// serveQueryParameters are the parameters a request may set in its query string, with '_' in place of '-' (e.g.
+// inactive_days=90).  They choose and describe the users returned; anything that acts on the server, writes files or
+// changes the connection can only be set when the server is started.
+var serveQueryParameters = map[string]bool{
+	"format":                true,
+	"merge-teams":           true,
+	"include-bots":          true,
+	"include-deactivated":   true,
+	"in-group":              true,
+	"not-in-group":          true,
+	"member-of-channel":     true,
+	"not-member-of-channel": true,
+	"channel-details":       true,
+	"inactive-days":         true,
+	"never-logged-in":       true,
+	"pagination":            true,
+	"props":                 true,
+	"columns":               true,
+	"template":              true,
+	"sort-by":               true,
+	"sort-desc":             true,
+	"client-usage":          true,
+	"with-sessions":         true,
+	"roles":                 true,
+	"role":                  true,
+	"auth-method":           true,
+	"auth-service":          true,
+	"guests":                true,
+	"team-join-date":        true,
+	"with-teams":            true,
+	"with-channel-counts":   true,
+	"with-last-post":        true,
+	"with-post-count":       true,
+	"posts-since":           true,
+	"guests-only":           true,
+	"exclude-guests":        true,
+	"email-domain":          true,
+	"exclude-email-domain":  true,
+	"match-username":        true,
+	"match-email":           true,
+	"match-name":            true,
+	"role-history":          true,
+	"no-defaults":           true,
+}
+
+// serveContentTypes are the media types of the output formats
+var serveContentTypes = map[string]string{
+	mmuserlist.FormatCSV:      "text/csv; charset=utf-8",
+	mmuserlist.FormatJSON:     "application/json",
+	mmuserlist.FormatXLSX:     "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	mmuserlist.FormatPDF:      "application/pdf",
+	mmuserlist.FormatTemplate: "text/plain; charset=utf-8",
+	mmuserlist.FormatSQLite:   "application/vnd.sqlite3",
+	mmuserlist.FormatParquet:  "application/vnd.apache.parquet",
+	mmuserlist.FormatHTML:     "text/html; charset=utf-8",
+	mmuserlist.FormatNDJSON:   "application/x-ndjson",
+}
+
+// serveStatuses translate the exit code of a failed export into the HTTP status of the response
+var serveStatuses = map[int]int{
+	1: http.StatusBadRequest,
+	2: http.StatusBadGateway,
+	4: http.StatusInternalServerError,
+}
+
+// userServer answers requests for user lists by running an export for each one
+type userServer struct {
+	settings map[string]string
+	token    string
+	dir      string
+	metrics  *metricsCollector
+}
+
+// validateServe checks that the exports can be run on demand: nothing may wait for an answer at the terminal, and
+// options that act on the server or write files of their own aren't available.  It returns the resolved options.
+func validateServe(settings map[string]string) (*cliOptions, error) {
+
+	fs, opts, err := newExportFlagSet("serve", settings)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := resolveConfig(fs); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	if opts.Schedule != "" {
+		problems = append(problems, "only one of 'serve' and 'schedule' can be used")
+	}
+	for _, name := range []string{"team", "channel", "source", "not-in-team", "all-teams"} {
+		if _, found := settings[name]; found {
+			problems = append(problems, fmt.Sprintf("'%s' is chosen by the path of each request", name))
+		}
+	}
+	if opts.TokenFile == mmuserlist.StdoutPath {
+		problems = append(problems, "the token can't be read from standard input more than once")
+	}
+	if opts.LoginID != "" && opts.Password == "" {
+		problems = append(problems, "'login-id' needs a 'password', since there's no one to prompt")
+	}
+	if opts.DeactivateAfter >= 0 || opts.NotifyInactive >= 0 {
+		problems = append(problems, "'deactivate-after' and 'notify-inactive' can't be run by a request")
+	}
+	if opts.NameAudit || opts.DomainAudit || opts.AuthAudit || opts.Scatter || opts.Estimate {
+		problems = append(problems, "only user lists are served, not the 'name-audit', 'domain-audit', 'auth-audit', 'scatter' or 'estimate' reports")
+	}
+	if opts.PreviewDiff || opts.ConfirmDiff || opts.Append || opts.Manifest || opts.EmailReport || opts.Upload != "" || opts.ProgressJSON != "" {
+		problems = append(problems, "'preview-diff', 'confirm-diff', 'append', 'manifest', 'email-report', 'upload' and 'progress-json' need an output file")
+	}
+	if opts.TUI {
+		problems = append(problems, "'tui' needs someone at a terminal")
+	}
+	if opts.Compress {
+		problems = append(problems, "'compress' only applies to output files, not to responses")
+	}
+	if opts.MetricsAddress != "" {
+		problems = append(problems, "the metrics are served on the 'serve' address, so 'metrics-address' isn't needed")
+	}
+	if len(problems) > 0 {
+		return nil, errors.New("the exports can't be served: " + strings.Join(problems, "; "))
+	}
+	return opts, nil
+}
+
+// authorized reports whether a request presents the bearer token, when one is required
+func (s *userServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// requestSettings combines the server's settings with the request's query string and the users chosen by its path
+func (s *userServer) requestSettings(r *http.Request, selection map[string]string) (map[string]string, error) {
+
+	settings := make(map[string]string, len(s.settings)+len(selection)+8)
+	for name, value := range s.settings {
+		settings[name] = value
+	}
+
+	// Every way of choosing users is set, so that one in the config file can't conflict with the request's path
+	for _, name := range []string{"team", "channel", "source"} {
+		settings[name] = ""
+	}
+	for _, name := range []string{"not-in-team", "all-teams"} {
+		settings[name] = "false"
+	}
+
+	for key, values := range r.URL.Query() {
+		name := strings.ReplaceAll(key, "_", "-")
+		if !serveQueryParameters[name] {
+			return nil, fmt.Errorf("unknown query parameter '%s'", key)
+		}
+		value := values[len(values)-1]
+		if value == "" {
+			value = "true"
+		}
+		settings[name] = value
+	}
+
+	for name, value := range selection {
+		settings[name] = value
+	}
+	return settings, nil
+}
+
+// checkAuthorized rejects a request that doesn't present the bearer token, returning false if it was rejected
+func (s *userServer) checkAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.authorized(r) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+	return false
+}
+
+// serveUsers runs an export for the users chosen by the request's path and writes the report as the response
+func (s *userServer) serveUsers(w http.ResponseWriter, r *http.Request, selection map[string]string) {
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("%s %s from %s", r.Method, r.URL.RequestURI(), r.RemoteAddr))
+
+	if !s.checkAuthorized(w, r) {
+		return
+	}
+
+	settings, err := s.requestSettings(r, selection)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Requests are answered concurrently, so each export writes its report in a directory of its own
+	dir, err := os.MkdirTemp(s.dir, "request")
+	if err != nil {
+		http.Error(w, "the report could not be written - see the server log", http.StatusInternalServerError)
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create a working directory: "+err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	outputFile := filepath.Join(dir, "users")
+	settings["file"] = outputFile
+
+	fs, opts, err := newExportFlagSet("serve", settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.sharedLogging = true
+
+	if exitCode := runExport(fs, opts); exitCode != 0 {
+		status, found := serveStatuses[exitCode]
+		if !found {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, fmt.Sprintf("the export failed with exit code %d - see the server log", exitCode), status)
+		return
+	}
+
+	if contentType, found := serveContentTypes[opts.Format]; found {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	// No output file is written when no users match, so an empty report is returned instead
+	report, err := os.Open(outputFile)
+	if errors.Is(err, os.ErrNotExist) {
+		writer, _ := mmuserlist.LookupWriter(opts.Format)
+		if err := writer.WriteUsers(w, nil, opts.output()); err != nil {
+			mmuserlist.LogMessage(mmuserlist.WarningLevel, "Failed to send the response: "+err.Error())
+		}
+		return
+	}
+	if err != nil {
+		http.Error(w, "the report could not be read - see the server log", http.StatusInternalServerError)
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to read the report: "+err.Error())
+		return
+	}
+	defer report.Close()
+
+	if _, err := io.Copy(w, report); err != nil {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "Failed to send the response: "+err.Error())
+	}
+}
+
+// handler routes the API's endpoints
+func (s *userServer) handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /teams/{team}/users", func(w http.ResponseWriter, r *http.Request) {
+		s.serveUsers(w, r, map[string]string{"team": r.PathValue("team")})
+	})
+	mux.HandleFunc("GET /teams/{team}/channels/{channel}/users", func(w http.ResponseWriter, r *http.Request) {
+		s.serveUsers(w, r, map[string]string{"channel": r.PathValue("team") + "/" + r.PathValue("channel")})
+	})
+	mux.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		s.serveUsers(w, r, map[string]string{"all-teams": "true"})
+	})
+	mux.HandleFunc("GET /users/without-team", func(w http.ResponseWriter, r *http.Request) {
+		s.serveUsers(w, r, map[string]string{"not-in-team": "true"})
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		if s.checkAuthorized(w, r) {
+			s.metrics.ServeHTTP(w, r)
+		}
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// runServe implements 'serve': the process stays resident and answers HTTP requests for user lists, running the
+// export described by the rest of the command line, the request's path and its query string for each one.  It
+// returns the process exit code once interrupted.
+func runServe(fs *flag.FlagSet, address string) int {
+
+	settings := commandLineSettings(fs, "serve")
+	opts, err := validateServe(settings)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	if err := applyLoggingOptions(opts); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	metrics, err := newMetricsCollector(settings, opts)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 1
+	}
+	if opts.ServeToken == "" {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "No 'serve-token' is set, so anyone who can reach "+address+" can list the users")
+	}
+
+	dir, err := os.MkdirTemp("", "mm-user-list-serve")
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create a working directory: "+err.Error())
+		return 2
+	}
+	defer os.RemoveAll(dir)
+
+	users := &userServer{settings: settings, token: opts.ServeToken, dir: dir, metrics: metrics}
+	server := &http.Server{
+		Addr:              address,
+		Handler:           users.handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, fmt.Sprintf("Serving user lists on %s - Version: %s", address, Version))
+
+	select {
+	case err := <-failed:
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to serve on "+address+": "+err.Error())
+		return 1
+	case <-stop:
+	}
+
+	// A request in progress is allowed to finish, so that its export isn't cut off half way through
+	ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "Stopped without waiting for a request to finish: "+err.Error())
+	}
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, "Server stopped")
+	return 0
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// streamIncompatible returns the requested options that need the whole user list at once, and so can't be combined
+// with 'stream'
+func (opts *cliOptions) streamIncompatible() []string {
+	var incompatible []string
+	checks := []struct {
+		name      string
+		requested bool
+	}{
+		{"'merge-teams'", opts.MergeTeams},
+		{"'channel'", opts.Channel != ""},
+		{"'source'", opts.Source != ""},
+		{"'name-audit'", opts.NameAudit},
+		{"'domain-audit'", opts.DomainAudit},
+		{"'auth-audit'", opts.AuthAudit},
+		{"'scatter'", opts.Scatter},
+		{"'deactivate-after'", opts.DeactivateAfter >= 0},
+		{"'notify-inactive'", opts.NotifyInactive >= 0},
+		{"'channel-details'", opts.ChannelDetails},
+		{"'role-history'", opts.RoleHistory},
+		{"'team-join-date'", opts.TeamJoinDate},
+		{"'preview-diff'", opts.PreviewDiff},
+		{"'confirm-diff'", opts.ConfirmDiff},
+		{"'sort-by'", opts.SortBy != ""},
+		{"'format xlsx'", opts.Format == mmuserlist.FormatXLSX},
+		{"'format pdf'", opts.Format == mmuserlist.FormatPDF},
+		{"'format sqlite'", opts.Format == mmuserlist.FormatSQLite},
+		{"'format parquet'", opts.Format == mmuserlist.FormatParquet},
+		{"'format html'", opts.Format == mmuserlist.FormatHTML},
+		{"'props columns'", opts.PropsMode == mmuserlist.PropsColumns},
+	}
+	for _, check := range checks {
+		if check.requested {
+			incompatible = append(incompatible, check.name)
+		}
+	}
+	return incompatible
+}
+
+// streamUsers fetches the requested users a page at a time, passing each page to emit
+func streamUsers(mmClient *model.Client4, opts *cliOptions, emit mmuserlist.PageFunc) error {
+
+	if opts.NotInTeam {
+		return mmuserlist.StreamUsersWithoutTeam(mmClient, opts.crawl(), emit)
+	}
+
+	var teams []*model.Team
+	var err error
+	if opts.AllTeams {
+		teams, err = mmuserlist.GetAllTeams(mmClient)
+	} else {
+		teams, err = mmuserlist.ResolveTeams(mmClient, mmuserlist.SplitTeamNames(opts.MattermostTeam))
+	}
+	if err != nil {
+		return err
+	}
+
+	return mmuserlist.StreamUsersInTeamList(mmClient, teams, opts.crawl(), emit)
+}
+
+// runStreamedExport carries out a user list export with 'stream': each page of users is filtered, enriched and
+// written as soon as it has been fetched, so memory use stays flat however many users the server has.  It returns the
+// process exit code.
+func runStreamedExport(fs *flag.FlagSet, mmClient *model.Client4, opts *cliOptions, started time.Time) int {
+
+	filter, err := newUserFilter(mmClient, opts)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Processing failed.  Error: "+err.Error())
+		return 2
+	}
+
+	output := opts.output()
+	file, err := mmuserlist.CreateOutput(opts.CSVFile, output.Compress)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+		return 4
+	}
+	defer file.Close()
+
+	output.Existing = opts.CSVFile
+	stream, err := mmuserlist.NewStreamWriter(file, output)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+		return 4
+	}
+
+	enrichments := opts.enrichments()
+	err = streamUsers(mmClient, opts, func(users []*mmuserlist.User) error {
+		users, err := filter(users)
+		if err == nil {
+			err = mmuserlist.ApplyEnrichments(mmClient, users, enrichments)
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.WriteUsers(users); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		mmuserlist.DebugPrint(fmt.Sprintf("Streamed %d users so far", stream.Count()))
+		return nil
+	})
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Processing failed.  Error: "+err.Error())
+		return 2
+	}
+
+	opts.progress.setStage(stageWriting)
+	err = stream.Close()
+	if err == nil {
+		err = file.Commit()
+	}
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to create output file: "+err.Error())
+		return 4
+	}
+
+	if stream.Count() == 0 {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "No users found to write to the output file!")
+	} else {
+		mmuserlist.LogSummary(fmt.Sprintf("Processing complete - %d users written to %s", stream.Count(), opts.outputName()))
+		warnPageGaps(opts.gaps)
+	}
+
+	if err := recordRun(fs, opts, started, stream.Count()); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 4
+	}
+	if opts.EmailReport && stream.Count() > 0 {
+		if err := emailReport(fs, opts, started, stream.Count()); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to email the report: "+err.Error())
+			return 4
+		}
+	}
+	if opts.Upload != "" && stream.Count() > 0 {
+		if err := uploadReport(opts); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "Failed to upload the report: "+err.Error())
+			return 4
+		}
+	}
+
+	return 0
+}
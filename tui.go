@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+	"golang.org/x/term"
+)
+
+// tuiDefaultExportFile is offered as the file name when the current view is exported, unless a 'file' was given
+const tuiDefaultExportFile = "users-view.csv"
+
+// ANSI escape sequences used to draw the browser
+const (
+	tuiAlternateScreen = "\033[?1049h"
+	tuiMainScreen      = "\033[?1049l"
+	tuiHideCursor      = "\033[?25l"
+	tuiShowCursor      = "\033[?25h"
+	tuiClearScreen     = "\033[H\033[2J"
+	tuiReverse         = "\033[7m"
+	tuiBold            = "\033[1m"
+	tuiReset           = "\033[0m"
+)
+
+// Keys, as decoded from the terminal's input
+const (
+	keyNone = iota
+	keyRune
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyEscape
+	keyBackspace
+	keyQuit
+)
+
+// browserColumn is one column of the user table
+type browserColumn struct {
+	title string
+	width int
+	value func(user *mmuserlist.User, dates mmuserlist.DateOptions) string
+	less  func(a *mmuserlist.User, b *mmuserlist.User) bool
+}
+
+// browserColumns are the columns of the user table, selected for sorting by the keys 1 to 6
+var browserColumns = []browserColumn{
+	{"Username", 20, func(u *mmuserlist.User, dates mmuserlist.DateOptions) string { return u.Username }, nil},
+	{"Name", 22, func(u *mmuserlist.User, dates mmuserlist.DateOptions) string { return u.FullName() }, nil},
+	{"Email", 30, func(u *mmuserlist.User, dates mmuserlist.DateOptions) string { return u.Email }, nil},
+	{"Team", 16, func(u *mmuserlist.User, dates mmuserlist.DateOptions) string { return u.TeamName }, nil},
+	{"Last Activity", 17, func(u *mmuserlist.User, dates mmuserlist.DateOptions) string { return dates.Format(u.LastActivityAt) },
+		func(a *mmuserlist.User, b *mmuserlist.User) bool { return a.LastActivityAt.Before(b.LastActivityAt) }},
+	{"Days Inactive", 17, func(u *mmuserlist.User, dates mmuserlist.DateOptions) string {
+		return strconv.Itoa(u.DaysSinceLastActivity)
+	},
+		func(a *mmuserlist.User, b *mmuserlist.User) bool {
+			return a.DaysSinceLastActivity < b.DaysSinceLastActivity
+		}},
+}
+
+// Input modes of the browser: browsing the table, or typing a filter or a file name on the status line
+const (
+	modeBrowse = iota
+	modeFilter
+	modeExport
+)
+
+// userBrowser is the state of the interactive user table
+type userBrowser struct {
+	users      []*mmuserlist.User
+	view       []*mmuserlist.User
+	output     mmuserlist.OutputOptions
+	exportFile string
+
+	// sortColumn is the index of the column the view is sorted by, or -1 to keep the order of the export ('sort-by')
+	sortColumn int
+	descending bool
+	filter     string
+	mode       int
+	input      string
+	cursor     int
+	top        int
+	status     string
+}
+
+// tuiIncompatible returns the requested options that write or act on the users, or produce a report other than the
+// user list, and so can't be combined with 'tui'
+func (opts *cliOptions) tuiIncompatible() []string {
+	var incompatible []string
+	checks := []struct {
+		name      string
+		requested bool
+	}{
+		{"'stream'", opts.Stream},
+		{"'estimate'", opts.Estimate},
+		{"'name-audit'", opts.NameAudit},
+		{"'domain-audit'", opts.DomainAudit},
+		{"'auth-audit'", opts.AuthAudit},
+		{"'scatter'", opts.Scatter},
+		{"'deactivate-after'", opts.DeactivateAfter >= 0},
+		{"'notify-inactive'", opts.NotifyInactive >= 0},
+		{"'preview-diff'", opts.PreviewDiff},
+		{"'confirm-diff'", opts.ConfirmDiff},
+		{"'email-report'", opts.EmailReport},
+		{"'progress-json'", opts.ProgressJSON != ""},
+	}
+	for _, check := range checks {
+		if check.requested {
+			incompatible = append(incompatible, check.name)
+		}
+	}
+	return incompatible
+}
+
+// applyView filters and sorts the users into the current view, keeping the cursor within it
+func (b *userBrowser) applyView() {
+
+	filter := strings.ToLower(b.filter)
+	b.view = b.view[:0]
+	for _, user := range b.users {
+		if filter == "" || strings.Contains(strings.ToLower(user.Username+"\x00"+user.FullName()+"\x00"+user.Email+"\x00"+user.TeamName), filter) {
+			b.view = append(b.view, user)
+		}
+	}
+
+	if b.sortColumn >= 0 {
+		column := browserColumns[b.sortColumn]
+		less := column.less
+		if less == nil {
+			less = func(x *mmuserlist.User, y *mmuserlist.User) bool {
+				return strings.ToLower(column.value(x, b.output.Dates)) < strings.ToLower(column.value(y, b.output.Dates))
+			}
+		}
+		sort.SliceStable(b.view, func(i, j int) bool {
+			if b.descending {
+				return less(b.view[j], b.view[i])
+			}
+			return less(b.view[i], b.view[j])
+		})
+	}
+
+	b.cursor = max(0, min(b.cursor, len(b.view)-1))
+}
+
+// fit pads or truncates a value to the width of its column
+func fit(value string, width int) string {
+	runes := []rune(value)
+	if len(runes) > width {
+		return string(runes[:width-1]) + "~"
+	}
+	return value + strings.Repeat(" ", width-len(runes))
+}
+
+// truncate cuts a line of plain text to the width of the terminal
+func truncate(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
+
+// render draws the table and status line to fit the terminal
+func (b *userBrowser) render(width int, height int) string {
+
+	rows := max(1, height-3)
+	if b.cursor < b.top {
+		b.top = b.cursor
+	}
+	if b.cursor >= b.top+rows {
+		b.top = b.cursor - rows + 1
+	}
+
+	var screen strings.Builder
+	screen.WriteString(tuiClearScreen)
+
+	var header strings.Builder
+	for i, column := range browserColumns {
+		title := fmt.Sprintf("%d %s", i+1, column.title)
+		if i == b.sortColumn {
+			title += map[bool]string{false: " ^", true: " v"}[b.descending]
+		}
+		header.WriteString(fit(title, column.width) + " ")
+	}
+	screen.WriteString(tuiBold + truncate(header.String(), width) + tuiReset + "\r\n")
+
+	for row := b.top; row < b.top+rows; row++ {
+		if row < len(b.view) {
+			var line strings.Builder
+			for _, column := range browserColumns {
+				line.WriteString(fit(column.value(b.view[row], b.output.Dates), column.width) + " ")
+			}
+			text := truncate(line.String(), width)
+			if row == b.cursor {
+				text = tuiReverse + text + tuiReset
+			}
+			screen.WriteString(text)
+		}
+		screen.WriteString("\r\n")
+	}
+
+	summary := fmt.Sprintf("%d of %d users", len(b.view), len(b.users))
+	if b.filter != "" {
+		summary += fmt.Sprintf(" matching '%s'", b.filter)
+	}
+	if b.status != "" {
+		summary += " - " + b.status
+	}
+	screen.WriteString(truncate(summary, width) + "\r\n")
+
+	switch b.mode {
+	case modeFilter:
+		screen.WriteString(truncate("Filter: "+b.input, width))
+	case modeExport:
+		screen.WriteString(truncate("Export to: "+b.input, width))
+	default:
+		screen.WriteString(tuiReverse + truncate("Up/Down move  1-6 sort  / filter  e export view to CSV  q quit", width) + tuiReset)
+	}
+
+	return screen.String()
+}
+
+// exportView writes the users in the current view, in their current order, to a CSV file
+func (b *userBrowser) exportView(path string) {
+	output := b.output
+	output.Format = mmuserlist.FormatCSV
+	if err := mmuserlist.WriteUsers(b.view, path, output); err != nil {
+		b.status = "Export failed: " + err.Error()
+		return
+	}
+	b.status = fmt.Sprintf("Exported %d users to %s", len(b.view), path)
+}
+
+// handleKey updates the browser for a key press, returning false when the browser should close
+func (b *userBrowser) handleKey(key int, r rune, rows int) bool {
+
+	if key == keyQuit {
+		return false
+	}
+
+	if b.mode != modeBrowse {
+		switch key {
+		case keyEscape:
+			b.mode = modeBrowse
+		case keyBackspace:
+			if runes := []rune(b.input); len(runes) > 0 {
+				b.input = string(runes[:len(runes)-1])
+			}
+		case keyRune:
+			b.input += string(r)
+		case keyEnter:
+			if b.mode == modeFilter {
+				b.filter = b.input
+				b.applyView()
+			} else if path := strings.TrimSpace(b.input); path == mmuserlist.StdoutPath {
+				b.status = "The view can't be exported to standard output while it is shown"
+			} else if path != "" {
+				b.exportFile = path
+				b.exportView(path)
+			}
+			b.mode = modeBrowse
+		}
+		return true
+	}
+
+	switch key {
+	case keyUp:
+		b.cursor = max(0, b.cursor-1)
+	case keyDown:
+		b.cursor = max(0, min(len(b.view)-1, b.cursor+1))
+	case keyPageUp:
+		b.cursor = max(0, b.cursor-rows)
+	case keyPageDown:
+		b.cursor = max(0, min(len(b.view)-1, b.cursor+rows))
+	case keyHome:
+		b.cursor = 0
+	case keyEnd:
+		b.cursor = max(0, len(b.view)-1)
+	case keyEscape:
+		b.filter = ""
+		b.applyView()
+	case keyRune:
+		switch {
+		case r == 'q':
+			return false
+		case r == 'j':
+			return b.handleKey(keyDown, 0, rows)
+		case r == 'k':
+			return b.handleKey(keyUp, 0, rows)
+		case r == 'g':
+			return b.handleKey(keyHome, 0, rows)
+		case r == 'G':
+			return b.handleKey(keyEnd, 0, rows)
+		case r == '/':
+			b.mode = modeFilter
+			b.input = b.filter
+		case r == 'e':
+			b.mode = modeExport
+			b.input = b.exportFile
+		case r >= '1' && int(r-'1') < len(browserColumns):
+			column := int(r - '1')
+			b.descending = column == b.sortColumn && !b.descending
+			b.sortColumn = column
+			b.applyView()
+		}
+	}
+	return true
+}
+
+// keySequences are the escape sequences sent by the keys the browser uses
+var keySequences = map[string]int{
+	"\033[A": keyUp, "\033OA": keyUp,
+	"\033[B": keyDown, "\033OB": keyDown,
+	"\033[5~": keyPageUp, "\033[6~": keyPageDown,
+	"\033[H": keyHome, "\033OH": keyHome, "\033[1~": keyHome,
+	"\033[F": keyEnd, "\033OF": keyEnd, "\033[4~": keyEnd,
+}
+
+// keyPress is a key decoded from the terminal's input and, for a printable key, its rune
+type keyPress struct {
+	key  int
+	char rune
+}
+
+// decodeKeys decodes the bytes of one read from the terminal, which may hold several key presses when they are typed
+// quickly or pasted
+func decodeKeys(input []byte) []keyPress {
+	var keys []keyPress
+	for len(input) > 0 {
+		if input[0] == 27 {
+			decoded := false
+			for sequence, key := range keySequences {
+				if bytes.HasPrefix(input, []byte(sequence)) {
+					keys = append(keys, keyPress{key: key})
+					input = input[len(sequence):]
+					decoded = true
+					break
+				}
+			}
+			if decoded {
+				continue
+			}
+			if len(input) == 1 || input[1] == 27 {
+				keys = append(keys, keyPress{key: keyEscape})
+				input = input[1:]
+				continue
+			}
+			// The rest of the input is a sequence the browser doesn't use
+			break
+		}
+
+		r, size := utf8.DecodeRune(input)
+		input = input[size:]
+		switch {
+		case r == 3 || r == 4:
+			keys = append(keys, keyPress{key: keyQuit})
+		case r == '\r' || r == '\n':
+			keys = append(keys, keyPress{key: keyEnter})
+		case r == 127 || r == 8:
+			keys = append(keys, keyPress{key: keyBackspace})
+		case r >= 32 && r != utf8.RuneError:
+			keys = append(keys, keyPress{key: keyRune, char: r})
+		}
+	}
+	return keys
+}
+
+// browseUsers implements 'tui': the users are shown in a table that can be scrolled, sorted and filtered, and the
+// current view exported to a CSV file, until the user quits.  It returns the process exit code.
+func browseUsers(users []*mmuserlist.User, opts *cliOptions) int {
+
+	browser := &userBrowser{users: users, output: opts.output(), exportFile: tuiDefaultExportFile, sortColumn: -1}
+	if opts.CSVFile != "" && opts.CSVFile != mmuserlist.StdoutPath {
+		browser.exportFile = opts.CSVFile
+	}
+
+	if err := browser.run(); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, err.Error())
+		return 2
+	}
+	return 0
+}
+
+// run puts the terminal into raw mode and handles key presses until the user quits.  The terminal and the logger are
+// restored however it returns.
+func (b *userBrowser) run() error {
+
+	input := int(os.Stdin.Fd())
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to read the size of the terminal: %w", err)
+	}
+	state, err := term.MakeRaw(input)
+	if err != nil {
+		return fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+	defer term.Restore(input, state)
+
+	// Log messages would overwrite the table, so the latest is shown on the status line instead
+	logger := mmuserlist.Logger
+	mmuserlist.Logger = func(level mmuserlist.LogLevel, message string) {
+		if level != mmuserlist.DebugLevel {
+			b.status = message
+		}
+	}
+	defer func() { mmuserlist.Logger = logger }()
+
+	fmt.Print(tuiAlternateScreen + tuiHideCursor)
+	defer fmt.Print(tuiShowCursor + tuiMainScreen)
+
+	b.applyView()
+	buffer := make([]byte, 32)
+	for {
+		if w, h, sizeErr := term.GetSize(int(os.Stdout.Fd())); sizeErr == nil {
+			width, height = w, h
+		}
+		fmt.Print(b.render(width, height))
+
+		n, err := os.Stdin.Read(buffer)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the keyboard: %w", err)
+		}
+		for _, press := range decodeKeys(buffer[:n]) {
+			if !b.handleKey(press.key, press.char, max(1, height-3)) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/jlandells/mm-user-list/pkg/mmuserlist"
+)
+
+// sftpUpload reports whether the 'upload' destination is an SFTP server rather than S3
+func (opts *cliOptions) sftpUpload() bool {
+	return strings.HasPrefix(opts.Upload, "sftp://")
+}
+
+// validateUpload checks the 'upload' parameters and finds the AWS credentials or SSH keys, logging each problem, and
+// reports whether they are valid
+func (opts *cliOptions) validateUpload() bool {
+
+	if (opts.Upload == "" || opts.sftpUpload()) && opts.S3Endpoint != "" {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "'s3-endpoint' is only used with an S3 'upload'")
+	}
+	if !opts.sftpUpload() && (opts.SFTPKey != "" || opts.SFTPKnownHosts != "") {
+		mmuserlist.LogMessage(mmuserlist.WarningLevel, "'sftp-key' and 'sftp-known-hosts' are only used with an SFTP 'upload'")
+	}
+	if opts.Upload == "" {
+		return true
+	}
+
+	valid := true
+	if opts.CSVFile == mmuserlist.StdoutPath {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The report can't be uploaded when it is written to standard output")
+		valid = false
+	}
+
+	// The credentials are found before the export starts, so that a long export isn't wasted
+	if opts.sftpUpload() {
+		opts.sftp = mmuserlist.SFTPSettings{KeyFile: opts.SFTPKey, KnownHostsFile: opts.SFTPKnownHosts}
+		if opts.sftp.KnownHostsFile == "" {
+			opts.sftp.KnownHostsFile = mmuserlist.DefaultSSHFile("known_hosts")
+		}
+		location, err := mmuserlist.ParseSFTPURL(opts.Upload)
+		if err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'upload' destination is not valid: "+err.Error())
+			return false
+		}
+		if err := mmuserlist.CheckSFTPSettings(opts.sftp, location); err != nil {
+			mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The report can't be uploaded: "+err.Error())
+			valid = false
+		}
+		return valid
+	}
+
+	if _, err := mmuserlist.ParseS3URL(opts.Upload); err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The 'upload' destination is not valid: "+err.Error())
+		valid = false
+	}
+	settings, err := mmuserlist.LoadS3Settings(opts.S3Endpoint)
+	if err != nil {
+		mmuserlist.LogMessage(mmuserlist.ErrorLevel, "The report can't be uploaded: "+err.Error())
+		valid = false
+	}
+	opts.s3 = settings
+	return valid
+}
+
+// uploadReport uploads the output file to the 'upload' destination
+func uploadReport(opts *cliOptions) error {
+
+	var uploaded string
+	if opts.sftpUpload() {
+		location, err := mmuserlist.ParseSFTPURL(opts.Upload)
+		if err != nil {
+			return err
+		}
+		if uploaded, err = mmuserlist.UploadToSFTP(opts.sftp, location, opts.CSVFile); err != nil {
+			return err
+		}
+	} else {
+		location, err := mmuserlist.ParseS3URL(opts.Upload)
+		if err != nil {
+			return err
+		}
+		if uploaded, err = mmuserlist.UploadToS3(opts.s3, location, opts.CSVFile); err != nil {
+			return err
+		}
+	}
+
+	mmuserlist.LogMessage(mmuserlist.InfoLevel, "Report uploaded to "+uploaded)
+	return nil
+}